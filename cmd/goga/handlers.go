@@ -0,0 +1,211 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package main
+
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"goga/configs"
+	"goga/internal/admin"
+	"goga/internal/gateway"
+	"goga/internal/health"
+	"goga/internal/metrics"
+	"goga/internal/middleware"
+	"goga/internal/security"
+	"goga/internal/server"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// gatewayHandlers 是按同一份配置构建的全部处理器。重新加载配置时整体替换，
+// 已经开始处理的请求和已劫持的 WebSocket 连接继续使用构建它们的那一份，不受影响。
+type gatewayHandlers struct {
+	config configs.Config
+	routes *gateway.RouteTable // 使用完毕后需调用 Stop 停止上游健康检查
+	serve  http.Handler        // serve 监听器的处理器
+	admin  http.Handler        // admin 监听器的处理器
+}
+
+// buildHandlers 按配置构建 serve 和 admin 监听器的处理器。任何一步失败都返回错误并释放已创建的资源，
+// 重新加载时据此拒绝无效的配置。
+func buildHandlers(config configs.Config, keyCacher security.KeyStore, runtimeSampler *metrics.RuntimeSampler) (*gatewayHandlers, error) {
+	// 如果加密功能启用 (全局或任一路由)，则为注入的脚本动态生成 SRI 哈希
+	if config.Encryption.Enabled || anyRouteEncryptionEnabled(config.Routes) {
+		newScriptContent, err := updateScriptContentWithSRI(config.ScriptInjection.ScriptContent)
+		if err != nil {
+			return nil, fmt.Errorf("无法为注入脚本生成 SRI 哈希: %w", err)
+		}
+		// 在内存中更新配置
+		config.ScriptInjection.ScriptContent = newScriptContent
+		slog.Info("已成功为注入脚本生成并应用 SRI 哈希")
+	}
+
+	// 1. 创建专用于 API 和特定静态文件的路由器
+	apiRouter, err := gateway.NewRouter(&config, keyCacher)
+	if err != nil {
+		return nil, fmt.Errorf("无法创建 API 路由: %w", err)
+	}
+
+	// 2. 构建路由表，HTTP 代理和 WebSocket 代理共享同一张路由表
+	routes, err := gateway.NewRouteTable(&config)
+	if err != nil {
+		return nil, fmt.Errorf("无法构建路由表: %w", err)
+	}
+	built := false
+	defer func() {
+		if !built {
+			routes.Stop()
+		}
+	}()
+
+	// 3. 创建反向代理处理器，用于处理所有其他流量。解密中间件按路由的加密配置在代理内部应用
+	proxyHandler, err := gateway.NewProxy(&config, routes, keyCacher)
+	if err != nil {
+		return nil, fmt.Errorf("无法创建反向代理: %w", err)
+	}
+	if !config.Encryption.Enabled {
+		slog.Warn("全局加密功能已禁用，未单独启用加密的路由将作为纯反向代理运行。")
+	}
+
+	trustedProxies, err := middleware.NewTrustedProxies(config.Server.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("无法解析受信任的代理列表: %w", err)
+	}
+
+	// Prometheus 指标接口，仅限本机或 admin 监听器访问。内存等运行时指标在后台定期采样，不在请求路径上读取
+	metricsHandler := middleware.LocalOnly(metrics.Handler(
+		middleware.CollectMetrics,
+		gateway.NewMetricsCollector(routes, keyCacher),
+		server.CollectMetrics,
+		runtimeSampler.Collect,
+	))
+
+	// 存活和就绪探针，就绪检查包括密钥缓存和每个上游池
+	healthHandler, err := health.NewHandler(config.Health, readinessChecks(config.Health, routes, keyCacher)...)
+	if err != nil {
+		return nil, err
+	}
+
+	// 4. 创建主路由器，并组合 API 路由和反向代理
+	mainMux := http.NewServeMux()
+	mainMux.Handle("/goga/", apiRouter)                                                  // /goga/api/v1/key 等请求
+	mainMux.Handle("/goga.min.js", apiRouter)                                            // 静态脚本
+	mainMux.Handle(gateway.UpstreamStatusPath, gateway.NewUpstreamStatusHandler(routes)) // 上游池状态，仅限本机访问
+	mainMux.Handle(server.CertificateStatusPath, server.NewCertificateStatusHandler())   // TLS 证书有效期，仅限本机访问
+	mainMux.Handle(metrics.Path, metricsHandler)                                         // Prometheus 指标，仅限本机访问
+	health.Register(mainMux, healthHandler)                                              // 存活和就绪探针
+	mainMux.Handle("/", proxyHandler)                                                    // 所有其他请求都由反向代理处理
+	var coreHandler http.Handler = mainMux
+
+	// 5. 应用其他通用中间件
+	handler := middleware.Recovery(middleware.SecurityHeadersMiddleware(middleware.Logging(middleware.HealthCheck(coreHandler))))
+
+	// 6. 将 WebSocket 代理包裹在外层
+	wsHandler := gateway.NewWebsocketProxy(handler, &config, routes)
+
+	// 7. 最外层按受信任的代理解析真实客户端 IP，日志、健康检查和 WebSocket 代理都依赖该结果。
+	// 请求 ID 和服务端 span 在 WebSocket 代理之外创建，WebSocket 连接同样带有请求 ID 并被追踪
+	rootHandler := middleware.ClientIP(trustedProxies)(middleware.RequestID(middleware.Tracing(wsHandler)))
+
+	// 8. admin 监听器只提供健康检查、上游状态、证书状态和指标等运维接口
+	adminMux := http.NewServeMux()
+	adminMux.Handle(gateway.UpstreamStatusPath, gateway.NewUpstreamStatusHandler(routes))
+	adminMux.Handle(server.CertificateStatusPath, server.NewCertificateStatusHandler())
+	adminMux.Handle(metrics.Path, metricsHandler)
+	adminMux.Handle("/metrics", metricsHandler) // Prometheus 默认的抓取路径
+	health.Register(adminMux, healthHandler)
+	// 配置了管理令牌时，在 admin 监听器上提供查询和吊销令牌的 admin API
+	if config.Admin.Token != "" {
+		admin.Register(adminMux, admin.NewHandler(config.Admin.Token, keyCacher))
+	}
+	adminHandler := middleware.ClientIP(trustedProxies)(middleware.Recovery(middleware.RequestID(middleware.Logging(middleware.HealthCheck(adminMux)))))
+
+	built = true
+	return &gatewayHandlers{config: config, routes: routes, serve: rootHandler, admin: adminHandler}, nil
+}
+
+// readinessChecks 返回就绪探针的依赖检查：密钥缓存 (Redis 时为 PING) 和每个上游池的可达性。
+// 上游池默认归为一组，只有全部不可达时才不就绪；配置 health.require_upstreams 后每个上游池都必须可达
+func readinessChecks(cfg configs.HealthConfig, routes *gateway.RouteTable, keyCacher security.KeyStore) []health.Check {
+	group := "upstreams"
+	if cfg.RequireUpstreams {
+		group = ""
+	}
+	checks := []health.Check{{Name: "key_cache", Run: keyCacher.Ping}}
+	for _, b := range routes.Backends() {
+		checks = append(checks, health.Check{
+			Name:  "upstream:" + b.Name,
+			Group: group,
+			Run:   func(ctx context.Context) error { return routes.CheckBackend(ctx, b) },
+		})
+	}
+	return checks
+}
+
+// updateScriptContentWithSRI 为注入的脚本标签动态添加子资源完整性 (SRI) 哈希。
+// 该函数在服务启动时执行一次，计算脚本文件的哈希并将其嵌入到脚本标签中。
+// 这样可以确保即使服务器上的 JS 文件在运行时被篡改，客户端也会因为哈希不匹配而拒绝加载脚本。
+func updateScriptContentWithSRI(scriptTag string) (string, error) {
+	// 1. 从 script 标签中解析出 src 属性
+	// 正则表达式匹配 <script ... src="<path>" ...>
+	re := regexp.MustCompile(`src="([^"]+)"`)
+	matches := re.FindStringSubmatch(scriptTag)
+	if len(matches) < 2 {
+		return "", fmt.Errorf("在 script_content 配置中未找到 src 属性: %s", scriptTag)
+	}
+	scriptURLPath := matches[1]
+
+	// 2. 将 URL 路径映射到本地文件系统路径
+	// 假设 /goga.min.js -> static/goga.min.js
+	// 这种映射关系是基于 NewRouter 中静态文件服务的实现
+	localPath := strings.TrimPrefix(scriptURLPath, "/")
+	if !strings.HasPrefix(localPath, "static/") {
+		localPath = filepath.Join("static", localPath)
+	}
+
+	// 3. 读取脚本文件内容
+	fileContent, err := os.ReadFile(localPath)
+	if err != nil {
+		return "", fmt.Errorf("无法读取脚本文件 %s: %w", localPath, err)
+	}
+
+	// 4. 计算 SHA-384 哈希值
+	hash := sha512.Sum384(fileContent)
+	// 对哈希值进行 Base64 编码
+	hashBase64 := base64.StdEncoding.EncodeToString(hash[:])
+	sriHash := fmt.Sprintf("sha384-%s", hashBase64)
+
+	// 5. 构建新的 script 标签
+	// 找到第一个 > 的位置，将 integrity 和 crossorigin 属性插入到它前面
+	insertionPoint := strings.Index(scriptTag, ">")
+	if insertionPoint == -1 {
+		return "", fmt.Errorf("无效的 script 标签格式: %s", scriptTag)
+	}
+
+	newTag := fmt.Sprintf(`%s integrity="%s" crossorigin="anonymous"%s`,
+		scriptTag[:insertionPoint],
+		sriHash,
+		scriptTag[insertionPoint:],
+	)
+
+	return newTag, nil
+}
+
+// anyRouteEncryptionEnabled 判断是否有路由单独启用了加密。
+func anyRouteEncryptionEnabled(routes []configs.RouteConfig) bool {
+	for _, route := range routes {
+		if route.Encryption != nil && route.Encryption.Enabled != nil && *route.Encryption.Enabled {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"goga/internal/health"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readyz 请求 /readyz，返回状态码和解码后的响应体
+func readyz(t *testing.T, url string) (int, health.Report) {
+	t.Helper()
+	resp, err := http.Get(url + health.ReadyzPath)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var report health.Report
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+	return resp.StatusCode, report
+}
+
+func TestReadyz_UpstreamPools(t *testing.T) {
+	shop := newNamedBackend(t, "shop")
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	content := fmt.Sprintf(`upstream:
+  backends:
+    - name: shop
+      url: %q
+    - name: api
+      url: %q
+routes:
+  - path_prefix: /api
+    upstream: api
+  - upstream: shop
+`, shop.URL, down.URL)
+
+	// 一个上游池不可达时仍然就绪，其检查结果体现在响应体中
+	r, srv, _ := newTestReloader(t, content)
+	code, report := readyz(t, srv.URL)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "degraded", report.Status)
+	assert.Equal(t, "ok", report.Checks["upstream:shop"].Status)
+	assert.Equal(t, "fail", report.Checks["upstream:api"].Status)
+
+	// 要求所有上游池可达时不就绪
+	writeConfig(t, content+"health:\n  require_upstreams: true\n")
+	require.NoError(t, r.reload("test"))
+	code, report = readyz(t, srv.URL)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "fail", report.Status)
+
+	// 所有上游池都不可达时不就绪
+	shop.Close()
+	writeConfig(t, content)
+	require.NoError(t, r.reload("test"))
+	code, _ = readyz(t, srv.URL)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package main
+
+import (
+	"goga/configs"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// parseLogLevel 将配置中的日志级别转换为 slog.Level，无法识别时使用 info
+func parseLogLevel(level string) slog.Level {
+	switch level {
+	case "debug":
+		return slog.LevelDebug
+	case "info":
+		return slog.LevelInfo
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// setupLogger 按配置设置日志输出并替换默认的 logger。level 可以在运行时调整，输出路径只在启动时生效
+func setupLogger(cfg configs.LogConfig, level *slog.LevelVar) {
+	// 根据配置设置日志输出
+	var writers []io.Writer
+	if len(cfg.OutputPaths) == 0 {
+		// 如果未配置任何输出，则默认输出到 stdout
+		writers = append(writers, os.Stdout)
+	} else {
+		for _, path := range cfg.OutputPaths {
+			switch path {
+			case "stdout":
+				writers = append(writers, os.Stdout)
+			case "stderr":
+				writers = append(writers, os.Stderr)
+			default:
+				// 认为是文件路径
+				file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+				if err != nil {
+					slog.Error("无法打开日志文件", "path", path, "error", err)
+					// 即使某个文件打开失败，也继续尝试其他输出
+					continue
+				}
+				writers = append(writers, file)
+				// 注意：这里没有立即 defer file.Close()，因为 logger 需要在整个应用生命周期内持有文件句柄。
+				// 在一个需要优雅关闭的真实生产应用中，可能需要一个集中的资源清理机制。
+			}
+		}
+	}
+
+	logWriter := io.MultiWriter(writers...)
+
+	logger := slog.New(slog.NewTextHandler(logWriter, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			// 仅格式化 'time' 属性
+			if a.Key == slog.TimeKey {
+				return slog.String(a.Key, a.Value.Time().Format("2006-01-02 15:04:05"))
+			}
+			return a
+		},
+	}))
+	slog.SetDefault(logger)
+}
@@ -7,73 +7,24 @@ package main
 
 import (
 	"context"
-	"crypto/sha512"
-	"encoding/base64"
 	"fmt"
 	"goga/configs"
 	"goga/internal/gateway"
-	"goga/internal/middleware"
-	"io"
+	"goga/internal/health"
+	"goga/internal/metrics"
+	"goga/internal/server"
+	"goga/internal/systemd"
+	"goga/internal/tracing"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
-	"path/filepath"
-	"regexp"
-	"strings"
+	"slices"
+	"sync"
 	"syscall"
 	"time"
 )
 
-// updateScriptContentWithSRI 为注入的脚本标签动态添加子资源完整性 (SRI) 哈希。
-// 该函数在服务启动时执行一次，计算脚本文件的哈希并将其嵌入到脚本标签中。
-// 这样可以确保即使服务器上的 JS 文件在运行时被篡改，客户端也会因为哈希不匹配而拒绝加载脚本。
-func updateScriptContentWithSRI(scriptTag string) (string, error) {
-	// 1. 从 script 标签中解析出 src 属性
-	// 正则表达式匹配 <script ... src="<path>" ...>
-	re := regexp.MustCompile(`src="([^"]+)"`)
-	matches := re.FindStringSubmatch(scriptTag)
-	if len(matches) < 2 {
-		return "", fmt.Errorf("在 script_content 配置中未找到 src 属性: %s", scriptTag)
-	}
-	scriptURLPath := matches[1]
-
-	// 2. 将 URL 路径映射到本地文件系统路径
-	// 假设 /goga.min.js -> static/goga.min.js
-	// 这种映射关系是基于 NewRouter 中静态文件服务的实现
-	localPath := strings.TrimPrefix(scriptURLPath, "/")
-	if !strings.HasPrefix(localPath, "static/") {
-		localPath = filepath.Join("static", localPath)
-	}
-
-	// 3. 读取脚本文件内容
-	fileContent, err := os.ReadFile(localPath)
-	if err != nil {
-		return "", fmt.Errorf("无法读取脚本文件 %s: %w", localPath, err)
-	}
-
-	// 4. 计算 SHA-384 哈希值
-	hash := sha512.Sum384(fileContent)
-	// 对哈希值进行 Base64 编码
-	hashBase64 := base64.StdEncoding.EncodeToString(hash[:])
-	sriHash := fmt.Sprintf("sha384-%s", hashBase64)
-
-	// 5. 构建新的 script 标签
-	// 找到第一个 > 的位置，将 integrity 和 crossorigin 属性插入到它前面
-	insertionPoint := strings.Index(scriptTag, ">")
-	if insertionPoint == -1 {
-		return "", fmt.Errorf("无效的 script 标签格式: %s", scriptTag)
-	}
-
-	newTag := fmt.Sprintf(`%s integrity="%s" crossorigin="anonymous"%s`,
-		scriptTag[:insertionPoint],
-		sriHash,
-		scriptTag[insertionPoint:],
-	)
-
-	return newTag, nil
-}
-
 func main() {
 	// 加载配置
 	config, err := configs.LoadConfig()
@@ -82,61 +33,10 @@ func main() {
 		os.Exit(1)
 	}
 
-	// 初始化分级日志系统
-	var level slog.Level
-	switch config.Log.LogLevel {
-	case "debug":
-		level = slog.LevelDebug
-	case "info":
-		level = slog.LevelInfo
-	case "warn":
-		level = slog.LevelWarn
-	case "error":
-		level = slog.LevelError
-	default:
-		level = slog.LevelInfo
-	}
-
-	// 根据配置设置日志输出
-	var writers []io.Writer
-	if len(config.Log.OutputPaths) == 0 {
-		// 如果未配置任何输出，则默认输出到 stdout
-		writers = append(writers, os.Stdout)
-	} else {
-		for _, path := range config.Log.OutputPaths {
-			switch path {
-			case "stdout":
-				writers = append(writers, os.Stdout)
-			case "stderr":
-				writers = append(writers, os.Stderr)
-			default:
-				// 认为是文件路径
-				file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-				if err != nil {
-					slog.Error("无法打开日志文件", "path", path, "error", err)
-					// 即使某个文件打开失败，也继续尝试其他输出
-					continue
-				}
-				writers = append(writers, file)
-				// 注意：这里没有立即 defer file.Close()，因为 logger 需要在整个应用生命周期内持有文件句柄。
-				// 在一个需要优雅关闭的真实生产应用中，可能需要一个集中的资源清理机制。
-			}
-		}
-	}
-
-	logWriter := io.MultiWriter(writers...)
-
-	logger := slog.New(slog.NewTextHandler(logWriter, &slog.HandlerOptions{
-		Level: level,
-		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
-			// 仅格式化 'time' 属性
-			if a.Key == slog.TimeKey {
-				return slog.String(a.Key, a.Value.Time().Format("2006-01-02 15:04:05"))
-			}
-			return a
-		},
-	}))
-	slog.SetDefault(logger)
+	// 初始化分级日志系统。日志级别可以在重新加载配置时调整
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(parseLogLevel(config.Log.LogLevel))
+	setupLogger(config.Log, logLevel)
 
 	slog.Info("日志系统初始化完成", "level", config.Log.LogLevel, "outputs", config.Log.OutputPaths)
 
@@ -144,19 +44,12 @@ func main() {
 	// 注意：这可能会记录敏感信息（如密码），只应在受控的调试环境中使用。
 	slog.Debug("加载的完整配置", "config", fmt.Sprintf("%+v", config))
 
-	// -- START: SRI 哈希生成 --
-	// 如果加密功能启用，则为注入的脚本动态生成 SRI 哈希
-	if config.Encryption.Enabled {
-		newScriptContent, err := updateScriptContentWithSRI(config.ScriptInjection.ScriptContent)
-		if err != nil {
-			slog.Error("致命错误：无法为注入脚本生成 SRI 哈希，服务将退出", "error", err)
-			os.Exit(1)
-		}
-		// 在内存中更新配置
-		config.ScriptInjection.ScriptContent = newScriptContent
-		slog.Info("已成功为注入脚本生成并应用 SRI 哈希")
+	// 初始化 OpenTelemetry 链路追踪，未启用时不记录 span，但仍会向后端传播 traceparent
+	shutdownTracing, err := tracing.Setup(context.Background(), config.Tracing)
+	if err != nil {
+		slog.Error("无法初始化链路追踪", "error", err)
+		os.Exit(1)
 	}
-	// -- END: SRI 哈希生成 --
 
 	// 根据配置初始化密钥缓存 (内存或 Redis)
 	keyCacher, err := gateway.NewKeyCacherFactory(config.KeyCache)
@@ -166,98 +59,158 @@ func main() {
 	}
 	defer keyCacher.Stop() // 确保程序退出时停止后台任务或关闭连接
 
-	// --- 处理器和路由设置 ---
+	// 运行时指标在后台定期采样，不在请求路径上读取
+	runtimeSampler := metrics.StartRuntimeSampler(metrics.RuntimeSampleInterval)
+	defer runtimeSampler.Stop()
 
-	// 1. 创建专用于 API 和特定静态文件的路由器
-	apiRouter, err := gateway.NewRouter(&config, keyCacher)
+	// --- 处理器和路由设置 ---
+	handlers, err := buildHandlers(config, keyCacher, runtimeSampler)
 	if err != nil {
-		slog.Error("无法创建 API 路由", "error", err)
+		slog.Error("无法创建处理器", "error", err)
 		os.Exit(1)
 	}
+	// 监听器通过 reloader 使用当前生效的处理器，重新加载配置时原子替换
+	reloader := newReloader(handlers, keyCacher, runtimeSampler, logLevel)
 
-	// 2. 创建反向代理处理器，用于处理所有其他流量
-	proxyHandler, err := gateway.NewProxy(&config)
+	// --- 服务器创建和启动 ---
+	// 所有监听器在启动前创建，端口被占用或 PROXY protocol 配置错误时立即退出
+	servers, err := server.New(config.Server, server.Handlers{Serve: reloader.serveHandler(), Admin: reloader.adminHandler()})
 	if err != nil {
-		slog.Error("无法创建反向代理", "error", err)
+		slog.Error("无法创建监听器", "error", err)
 		os.Exit(1)
 	}
 
-	// 3. 创建主路由器，并组合 API 路由和反向代理
-	mainMux := http.NewServeMux()
-	mainMux.Handle("/goga/", apiRouter) // /goga/api/v1/key 等请求
-	mainMux.Handle("/goga.min.js", apiRouter) // 静态脚本
-	mainMux.Handle("/", proxyHandler) // 所有其他请求都由反向代理处理
-
-	// 4. 将解密中间件包裹在主路由器上
-	var coreHandler http.Handler = mainMux
-	if config.Encryption.Enabled {
-		slog.Info("加密功能已启用，应用解密中间件。")
-		decryptionHandler := middleware.DecryptionMiddleware(keyCacher, config.Encryption)
-		coreHandler = decryptionHandler(coreHandler)
-	} else {
-		slog.Warn("加密功能已禁用，服务将作为纯反向代理运行。")
+	if config.Admin.Token != "" && !slices.ContainsFunc(servers, func(srv *server.Server) bool { return srv.Config.Role == server.RoleAdmin }) {
+		slog.Warn("已配置 admin.token，但没有 admin 角色的监听器，admin API 不可用")
 	}
 
-	// 5. 应用其他通用中间件
-	handler := middleware.Recovery(middleware.SecurityHeadersMiddleware(middleware.RequestID(middleware.Logging(middleware.HealthCheck(coreHandler)))))
-
-	// 6. 将 WebSocket 代理包裹在最外层
-	wsHandler := gateway.NewWebsocketProxy(handler, &config)
+	// 在开始服务和通知就绪之前注册信号，就绪后立即到达的信号 (例如 systemctl reload 或平滑升级) 不会按默认动作终止进程。
+	// SIGHUP 触发重新加载配置
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	// 监听 SIGINT (Ctrl+C) 和 SIGTERM 信号，以及触发平滑升级的 SIGUSR2 信号
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR2)
+
+	// 每个监听器在各自的 goroutine 中启动，这样它们就不会阻塞主线程
+	for _, srv := range servers {
+		go func() {
+			startMsg := "GoGa Gateway 开始启动 (HTTP)"
+			if srv.TLS() {
+				startMsg = "GoGa Gateway 开始启动 (HTTPS)"
+			}
+			slog.Info(startMsg, "listener", srv.Config.Name, "role", srv.Config.Role, "address", srv.Addr(),
+				"proxy_protocol", srv.Config.ProxyProtocol.Enabled, "h2c", srv.Config.H2C)
 
-	// --- 服务器创建和启动 ---
-	addr := ":" + config.Server.Port
-	server := &http.Server{
-		Addr:    addr,
-		Handler: wsHandler, // 使用最终的、包含了所有逻辑的处理器
+			// http.ErrServerClosed 是在调用 Shutdown() 后发生的正常错误，不应视为致命错误
+			if err := srv.Serve(); err != nil && err != http.ErrServerClosed {
+				slog.Error("服务器意外关闭", "listener", srv.Config.Name, "error", err)
+				os.Exit(1) // 如果服务器因错误而停止，则退出程序
+			}
+		}()
 	}
 
+	// 收到 SIGHUP 或配置文件变更时重新加载配置，已建立的连接和已劫持的 WebSocket 不受影响
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	go reloader.watch(backgroundCtx, hup, configs.ConfigFile())
+	if timeout := systemd.WatchdogInterval(); timeout > 0 {
+		go runWatchdog(backgroundCtx, timeout, keyCacher)
+	}
 
-	// 在一个 goroutine 中启动服务器，这样它就不会阻塞主线程
-	go func() {
-		var startMsg string
-		var err error
-
-		if config.Server.TLSCertPath != "" && config.Server.TLSKeyPath != "" {
-			startMsg = "GoGa Gateway 开始启动 (HTTPS)"
-			err = server.ListenAndServeTLS(config.Server.TLSCertPath, config.Server.TLSKeyPath)
-		} else {
-			startMsg = "GoGa Gateway 开始启动 (HTTP)"
-			err = server.ListenAndServe()
-		}
-
-		slog.Info(startMsg, "address", addr)
-
-		// http.ErrServerClosed 是在调用 Shutdown() 后发生的正常错误，不应视为致命错误
-		if err != nil && err != http.ErrServerClosed {
-			slog.Error("服务器意外关闭", "error", err)
-			os.Exit(1) // 如果服务器因错误而停止，则退出程序
+	// 监听器已经开始接受连接。写入 PID 文件并通知 systemd，由平滑升级启动时通知旧进程退出。
+	// 先通知 systemd 当前进程是新的主进程，再让旧进程退出
+	if config.Server.PIDFile != "" {
+		if err := server.WritePIDFile(config.Server.PIDFile); err != nil {
+			slog.Error("无法写入 PID 文件", "file", config.Server.PIDFile, "error", err)
 		}
-	}()
+		defer server.RemovePIDFile(config.Server.PIDFile)
+	}
+	if _, err := systemd.Notify(systemd.Ready + "\n" + systemd.MainPID()); err != nil {
+		slog.Warn("无法通知 systemd 服务已就绪", "error", err)
+	}
+	if err := server.NotifyReady(); err != nil {
+		slog.Error("无法通知旧进程新进程已就绪", "error", err)
+	}
 
 	// ---- 优雅退出逻辑 ----
-	// 创建一个 channel 来接收操作系统的信号
-	quit := make(chan os.Signal, 1)
-	// 监听 SIGINT (Ctrl+C) 和 SIGTERM 信号
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	// 阻塞主 goroutine，直到接收到关闭信号或平滑升级成功
+	upgraded := false
+	for !upgraded {
+		sig := <-quit
+		if sig != syscall.SIGUSR2 {
+			slog.Warn("接收到关闭信号，开始优雅退出...", "signal", sig.String())
+			break
+		}
+		slog.Info("接收到升级信号，正在启动新进程...")
+		pid, err := server.Upgrade(servers, reloader.config().Server.Upgrade.ReadyTimeout)
+		if err != nil {
+			slog.Error("平滑升级失败，继续使用当前进程", "error", err)
+			continue
+		}
+		slog.Warn("新进程已接管监听器，当前进程开始优雅退出...", "pid", pid)
+		upgraded = true
+	}
+	stopBackground()
+	// 退出相关的超时使用最近一次重新加载的配置
+	current := reloader.config()
+	// 平滑升级后新进程已经是 systemd 跟踪的主进程，旧进程不能报告服务正在停止
+	if !upgraded {
+		if _, err := systemd.Notify(systemd.Stopping); err != nil {
+			slog.Warn("无法通知 systemd 服务正在停止", "error", err)
+		}
+	}
 
-	// 阻塞主 goroutine，直到接收到一个信号
-	sig := <-quit
-	slog.Warn("接收到关闭信号，开始优雅退出...", "signal", sig.String())
+	// /readyz 从此返回 503。监听器关闭前先等待 server.shutdown_delay，
+	// 让负载均衡器通过就绪探针发现网关正在退出并停止转发新请求。平滑升级后新进程已接管监听器，无需等待
+	health.SetDraining()
+	if delay := current.Server.ShutdownDelay; delay > 0 && !upgraded {
+		slog.Info("等待负载均衡器摘除网关...", "shutdown_delay", delay)
+		time.Sleep(delay)
+	}
 
-	// 创建一个带有超时的 context，用于通知服务器在指定时间内完成现有请求
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// 创建一个带有超时的 context，用于通知服务器在 server.shutdown_timeout 内完成现有请求
+	ctx, cancel := context.WithTimeout(context.Background(), current.Server.ShutdownTimeout)
 	defer cancel()
 
-	// 调用 Shutdown()，平滑地关闭服务器
-	if err := server.Shutdown(ctx); err != nil {
-		slog.Error("服务器优雅退出失败", "error", err)
-	} else {
-		slog.Info("HTTP 服务器已成功关闭。")
+	// 调用 Shutdown()，并行地平滑关闭所有监听器，共享同一个超时时间
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				slog.Error("服务器优雅退出失败", "listener", srv.Config.Name, "error", err)
+			} else {
+				slog.Info("HTTP 服务器已成功关闭。", "listener", srv.Config.Name)
+			}
+		}()
 	}
+	// Shutdown 不等待已劫持的 WebSocket 连接，由网关单独关闭。
+	// 平滑升级时先让会话自行结束，超时后与正常退出一样向双方发送 1001 关闭帧
+	if upgraded {
+		waitWebSockets(current.Server.Upgrade.DrainTimeout)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		drainCtx, cancel := context.WithTimeout(context.Background(), current.Websocket.DrainTimeout)
+		defer cancel()
+		if err := gateway.DrainWebSockets(drainCtx); err == nil {
+			slog.Info("WebSocket 会话已全部关闭。")
+		}
+	}()
+	wg.Wait()
 
 	// 清理其他资源，例如关闭密钥缓存的后台任务或连接
 	slog.Info("正在清理其余资源...")
+	reloader.stop() // 停止上游健康检查
 	keyCacher.Stop()
+	// 导出尚未发送的 span，等待 WebSocket 会话后 ctx 可能已经超时，使用新的超时时间
+	tracingCtx, cancelTracing := context.WithTimeout(context.Background(), current.Server.ShutdownTimeout)
+	defer cancelTracing()
+	if err := shutdownTracing(tracingCtx); err != nil {
+		slog.Error("关闭链路追踪失败", "error", err)
+	}
 
 	slog.Info("服务已成功优雅退出。")
 }
@@ -0,0 +1,205 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package main
+
+import (
+	"context"
+	"goga/configs"
+	"goga/internal/metrics"
+	"goga/internal/security"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// configDebounce 用于合并编辑器保存文件时产生的多个文件系统事件，只在最后一个事件之后重新加载一次
+const configDebounce = 500 * time.Millisecond
+
+// reloader 持有当前生效的处理器，并在收到 SIGHUP 或配置文件变更时重新加载配置。
+// 监听器始终通过 reloader 取得当前的处理器，替换是原子的：新请求使用新配置，
+// 已经开始处理的请求、上传和已劫持的 WebSocket 连接继续使用旧配置直到结束。
+type reloader struct {
+	mu      sync.Mutex // 保证同一时间只有一次重新加载
+	current atomic.Pointer[gatewayHandlers]
+
+	// 以下依赖在进程生命周期内保持不变，修改相应配置需要重启
+	keyCacher      security.KeyStore
+	runtimeSampler *metrics.RuntimeSampler
+	logLevel       *slog.LevelVar
+}
+
+// newReloader 创建以 handlers 为初始配置的 reloader
+func newReloader(handlers *gatewayHandlers, keyCacher security.KeyStore, runtimeSampler *metrics.RuntimeSampler, logLevel *slog.LevelVar) *reloader {
+	r := &reloader{keyCacher: keyCacher, runtimeSampler: runtimeSampler, logLevel: logLevel}
+	r.current.Store(handlers)
+	return r
+}
+
+// serveHandler 返回将请求交给当前 serve 处理器的处理器
+func (r *reloader) serveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.current.Load().serve.ServeHTTP(w, req)
+	})
+}
+
+// adminHandler 返回将请求交给当前 admin 处理器的处理器
+func (r *reloader) adminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.current.Load().admin.ServeHTTP(w, req)
+	})
+}
+
+// config 返回当前生效的配置
+func (r *reloader) config() configs.Config {
+	return r.current.Load().config
+}
+
+// reload 重新读取配置并原子替换处理器。新配置无法解析或构建失败时返回错误，继续使用当前配置
+func (r *reloader) reload(trigger string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	config, err := configs.LoadConfig()
+	if err != nil {
+		slog.Error("重新加载配置失败，继续使用当前配置", "trigger", trigger, "error", err)
+		return err
+	}
+	old := r.current.Load()
+	handlers, err := buildHandlers(config, r.keyCacher, r.runtimeSampler)
+	if err != nil {
+		slog.Error("新配置无效，继续使用当前配置", "trigger", trigger, "error", err)
+		return err
+	}
+
+	r.current.Store(handlers)
+	r.logLevel.Set(parseLogLevel(config.Log.LogLevel))
+	// 旧的路由表不再接收新请求，停止其健康检查。进行中的请求仍持有旧的上游连接，不受影响
+	old.routes.Stop()
+
+	if keys := restartRequired(old.config, config); len(keys) > 0 {
+		slog.Warn("部分配置修改需要重启才能生效", "keys", keys)
+	}
+	slog.Info("配置已重新加载", "trigger", trigger, "level", config.Log.LogLevel)
+	return nil
+}
+
+// stop 停止当前路由表的健康检查，在退出时调用
+func (r *reloader) stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.current.Load().routes.Stop()
+}
+
+// watch 在 hup 收到信号或配置文件变更时重新加载配置，直到 ctx 结束。configFile 为空时只响应 hup。
+// hup 由调用方在监听器开始服务之前注册，避免就绪后立即到达的 SIGHUP 按默认动作终止进程
+func (r *reloader) watch(ctx context.Context, hup <-chan os.Signal, configFile string) {
+	var fileChanged <-chan struct{}
+	if configFile != "" {
+		changed, err := watchConfigFile(ctx, configFile)
+		if err != nil {
+			slog.Warn("无法监视配置文件，只能通过 SIGHUP 重新加载配置", "file", configFile, "error", err)
+		} else {
+			fileChanged = changed
+			slog.Info("正在监视配置文件，修改后自动重新加载", "file", configFile)
+		}
+	}
+
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.reload("SIGHUP")
+		case <-fileChanged:
+			debounce = time.After(configDebounce)
+		case <-debounce:
+			debounce = nil
+			r.reload("config_file")
+		}
+	}
+}
+
+// watchConfigFile 监视配置文件，文件被写入、替换或其符号链接的指向变化 (例如 Kubernetes 更新 ConfigMap) 时发出通知。
+// 监视的是所在目录而不是文件本身，因为编辑器和配置管理工具通常以重命名的方式替换文件。
+func watchConfigFile(ctx context.Context, file string) (<-chan struct{}, error) {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	changed := make(chan struct{}, 1)
+	realFile, _ := filepath.EvalSymlinks(file)
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				current, _ := filepath.EvalSymlinks(file)
+				written := filepath.Clean(event.Name) == file && event.Op&(fsnotify.Write|fsnotify.Create) != 0
+				if written || (current != "" && current != realFile) {
+					realFile = current
+					select {
+					case changed <- struct{}{}:
+					default: // 已有未处理的通知
+					}
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Warn("监视配置文件出错", "file", file, "error", err)
+			}
+		}
+	}()
+	return changed, nil
+}
+
+// restartRequired 返回新配置中被修改、但只在启动时读取的配置项。
+// 监听器、TLS、密钥缓存后端、链路追踪和日志输出路径在进程生命周期内保持不变
+func restartRequired(old, next configs.Config) []string {
+	var keys []string
+	// 受信任的代理列表随处理器一起重新构建，退出和平滑升级的超时在使用时读取当前配置，都不需要重启
+	oldServer, nextServer := old.Server, next.Server
+	for _, s := range []*configs.ServerConfig{&oldServer, &nextServer} {
+		s.TrustedProxies = nil
+		s.ShutdownDelay, s.ShutdownTimeout = 0, 0
+		s.Upgrade = configs.UpgradeConfig{}
+	}
+	if !reflect.DeepEqual(oldServer, nextServer) {
+		keys = append(keys, "server")
+	}
+	if old.KeyCache.Type != next.KeyCache.Type || !reflect.DeepEqual(old.KeyCache.Redis, next.KeyCache.Redis) {
+		keys = append(keys, "key_cache")
+	}
+	if !reflect.DeepEqual(old.Tracing, next.Tracing) {
+		keys = append(keys, "tracing")
+	}
+	if !reflect.DeepEqual(old.Log.OutputPaths, next.Log.OutputPaths) {
+		keys = append(keys, "log.output_paths")
+	}
+	return keys
+}
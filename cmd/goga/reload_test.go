@@ -0,0 +1,178 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package main
+
+import (
+	"fmt"
+	"goga/configs"
+	"goga/internal/gateway"
+	"goga/internal/metrics"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newNamedBackend 创建一个在响应体中返回自身名称的后端
+func newNamedBackend(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name)
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+// writeConfig 在当前目录下写入 configs/config.yaml
+func writeConfig(t *testing.T, content string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(filepath.Join("configs", "config.yaml"), []byte(content), 0o644))
+}
+
+// newTestReloader 在临时目录中按 content 加载配置，返回 reloader 和对外提供服务的测试服务器
+func newTestReloader(t *testing.T, content string) (*reloader, *httptest.Server, *slog.LevelVar) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	require.NoError(t, os.MkdirAll("configs", 0o755))
+	require.NoError(t, os.MkdirAll("static", 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join("static", "goga.min.js"), []byte("// goga"), 0o644))
+	writeConfig(t, content)
+
+	config, err := configs.LoadConfig()
+	require.NoError(t, err)
+	keyCache := gateway.NewInMemoryKeyCache(0)
+	t.Cleanup(keyCache.Stop)
+	sampler := metrics.StartRuntimeSampler(time.Hour)
+	t.Cleanup(sampler.Stop)
+	handlers, err := buildHandlers(config, keyCache, sampler)
+	require.NoError(t, err)
+
+	level := new(slog.LevelVar)
+	r := newReloader(handlers, keyCache, sampler, level)
+	t.Cleanup(r.stop)
+	srv := httptest.NewServer(r.serveHandler())
+	t.Cleanup(srv.Close)
+	return r, srv, level
+}
+
+// get 请求 url 并返回响应体
+func get(t *testing.T, url string) string {
+	t.Helper()
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestReloader_SwapsHandlers(t *testing.T) {
+	a, b := newNamedBackend(t, "A"), newNamedBackend(t, "B")
+	r, srv, level := newTestReloader(t, fmt.Sprintf("backend_url: %q\nlog:\n  level: info\n", a.URL))
+	assert.Equal(t, "A", get(t, srv.URL))
+
+	writeConfig(t, fmt.Sprintf("backend_url: %q\nlog:\n  level: debug\n", b.URL))
+	require.NoError(t, r.reload("test"))
+	assert.Equal(t, "B", get(t, srv.URL))
+	assert.Equal(t, slog.LevelDebug, level.Level())
+
+	// 无效的配置被拒绝，继续使用当前配置
+	writeConfig(t, fmt.Sprintf("backend_url: %q\nencryption:\n  must_encrypt_routes: [\"^/api/(\"]\n", a.URL))
+	assert.Error(t, r.reload("test"))
+	writeConfig(t, "backend_url: [not, a, string\n")
+	assert.Error(t, r.reload("test"))
+	assert.Equal(t, "B", get(t, srv.URL))
+	assert.Equal(t, slog.LevelDebug, level.Level())
+}
+
+func TestReloader_WatchReloadsOnSignal(t *testing.T) {
+	a, b := newNamedBackend(t, "A"), newNamedBackend(t, "B")
+	r, srv, _ := newTestReloader(t, fmt.Sprintf("backend_url: %q\n", a.URL))
+	hup := make(chan os.Signal, 1)
+	go r.watch(t.Context(), hup, "")
+
+	writeConfig(t, fmt.Sprintf("backend_url: %q\n", b.URL))
+	hup <- syscall.SIGHUP
+	require.Eventually(t, func() bool { return get(t, srv.URL) == "B" }, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestReloader_KeepsInFlightRequests(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "slow")
+	}))
+	defer slow.Close()
+	b := newNamedBackend(t, "B")
+
+	r, srv, _ := newTestReloader(t, fmt.Sprintf("backend_url: %q\n", slow.URL))
+	inFlight := make(chan string)
+	go func() { inFlight <- get(t, srv.URL) }()
+	<-started
+
+	writeConfig(t, fmt.Sprintf("backend_url: %q\n", b.URL))
+	require.NoError(t, r.reload("test"))
+	assert.Equal(t, "B", get(t, srv.URL), "新请求使用新配置")
+
+	close(release)
+	assert.Equal(t, "slow", <-inFlight, "重新加载前开始的请求应正常完成")
+}
+
+func TestWatchConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(file, []byte("log:\n  level: info\n"), 0o644))
+
+	changed, err := watchConfigFile(t.Context(), file)
+	require.NoError(t, err)
+
+	// 其他文件的变化不触发重新加载
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other.yaml"), []byte("x"), 0o644))
+	select {
+	case <-changed:
+		t.Fatal("其他文件的变化不应触发通知")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// 以重命名的方式替换配置文件，与大多数编辑器的保存方式相同
+	tmp := filepath.Join(dir, ".config.yaml.tmp")
+	require.NoError(t, os.WriteFile(tmp, []byte("log:\n  level: debug\n"), 0o644))
+	require.NoError(t, os.Rename(tmp, file))
+	select {
+	case <-changed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("替换配置文件后应收到通知")
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	var old configs.Config
+	old.Server.Port = "8080"
+	old.KeyCache.Type = "in-memory"
+
+	next := old
+	next.Server.TrustedProxies = []string{"10.0.0.0/8"}
+	next.Websocket.AllowedOrigins = []string{"https://example.com"}
+	next.KeyCache.TTLSeconds = 60
+	next.Server.ShutdownTimeout = time.Minute
+	next.Server.ShutdownDelay = 10 * time.Second
+	next.Server.Upgrade.DrainTimeout = time.Hour
+	assert.Empty(t, restartRequired(old, next), "可以重新加载的配置不需要重启")
+
+	next.Server.Port = "9090"
+	next.KeyCache.Type = "redis"
+	next.Log.OutputPaths = []string{"stderr"}
+	assert.Equal(t, []string{"server", "key_cache", "log.output_paths"}, restartRequired(old, next))
+}
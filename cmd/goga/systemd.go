@@ -0,0 +1,40 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package main
+
+import (
+	"context"
+	"goga/internal/security"
+	"goga/internal/systemd"
+	"log/slog"
+	"time"
+)
+
+// runWatchdog 在启用 systemd 看门狗 (WatchdogSec=) 时，每隔 timeout 的一半检查一次密钥缓存，可用时发送心跳。
+// 密钥缓存 (例如 Redis) 不可用时停止发送心跳，由 systemd 在超时后按 Restart= 重启服务
+func runWatchdog(ctx context.Context, timeout time.Duration, keyStore security.KeyStore) {
+	interval := timeout / 2
+	slog.Info("已启用 systemd 看门狗", "timeout", timeout, "interval", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		pingCtx, cancel := context.WithTimeout(ctx, interval/2)
+		err := keyStore.Ping(pingCtx)
+		cancel()
+		if err != nil {
+			slog.Error("密钥缓存不可用，暂停发送 systemd 看门狗心跳", "error", err)
+			continue
+		}
+		if _, err := systemd.Notify(systemd.Watchdog); err != nil {
+			slog.Warn("无法发送 systemd 看门狗心跳", "error", err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"goga/internal/gateway"
+	"goga/internal/security"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// unavailableKeyStore 模拟无法连接的密钥缓存
+type unavailableKeyStore struct {
+	security.KeyStore
+}
+
+func (unavailableKeyStore) Ping(ctx context.Context) error {
+	return errors.New("connection refused")
+}
+
+// listenNotify 监听一个临时的 NOTIFY_SOCKET
+func listenNotify(t *testing.T) *net.UnixConn {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", socket)
+	return conn
+}
+
+func TestRunWatchdog(t *testing.T) {
+	conn := listenNotify(t)
+	buf := make([]byte, 64)
+
+	keyCache := gateway.NewInMemoryKeyCache(0)
+	defer keyCache.Stop()
+	ctx, cancel := context.WithCancel(t.Context())
+	go runWatchdog(ctx, 40*time.Millisecond, keyCache)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "WATCHDOG=1", string(buf[:n]))
+	cancel()
+
+	// 密钥缓存不可用时不发送心跳
+	time.Sleep(50 * time.Millisecond)
+	for conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond)); ; {
+		if _, err := conn.Read(buf); err != nil {
+			break // 丢弃取消前已经发出的心跳
+		}
+	}
+	go runWatchdog(t.Context(), 40*time.Millisecond, unavailableKeyStore{})
+	conn.SetReadDeadline(time.Now().Add(150 * time.Millisecond))
+	_, err = conn.Read(buf)
+	assert.Error(t, err, "密钥缓存不可用时不应发送心跳")
+}
@@ -0,0 +1,30 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package main
+
+import (
+	"context"
+	"goga/internal/gateway"
+	"log/slog"
+	"time"
+)
+
+// waitWebSockets 在平滑升级后等待已劫持的 WebSocket 会话自行结束，最多等待 timeout
+func waitWebSockets(timeout time.Duration) {
+	active := gateway.ActiveWebSockets()
+	if active == 0 {
+		return
+	}
+	slog.Info("等待 WebSocket 会话结束", "active", active, "timeout", timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := gateway.WaitWebSockets(ctx); err != nil {
+		slog.Warn("等待 WebSocket 会话结束超时，向剩余的会话发送关闭帧", "active", gateway.ActiveWebSockets())
+		return
+	}
+	slog.Info("WebSocket 会话已全部结束")
+}
@@ -8,6 +8,7 @@ package configs
 import (
 	"log"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -19,6 +20,12 @@ type Config struct {
 
 	BackendURL string `mapstructure:"backend_url"`
 
+	Upstream UpstreamConfig `mapstructure:"upstream"`
+
+	// Routes 按 Host 和路径前缀将请求分发到 Upstream.Backends 中的具名后端。
+	// 未配置时，所有请求都转发到默认后端。
+	Routes []RouteConfig `mapstructure:"routes"`
+
 	Websocket WebsocketConfig `mapstructure:"websocket"`
 
 	Encryption EncryptionConfig `mapstructure:"encryption"`
@@ -28,12 +35,218 @@ type Config struct {
 	ScriptInjection ScriptInjectionConfig `mapstructure:"script_injection"`
 
 	Log LogConfig `mapstructure:"log"`
+
+	Tracing TracingConfig `mapstructure:"tracing"`
+
+	Admin AdminConfig `mapstructure:"admin"`
+
+	Health HealthConfig `mapstructure:"health"`
+}
+
+// HealthConfig 存储 /livez 和 /readyz 探针接口的配置。admin 监听器上的请求不受来源限制
+type HealthConfig struct {
+	// AllowedCIDRs 是允许访问探针接口的来源 (CIDR 或单个 IP)，例如 Kubernetes 的 Pod 网段。本机始终可以访问
+	AllowedCIDRs []string `mapstructure:"allowed_cidrs"`
+
+	// Token 配置后，携带 "Authorization: Bearer <token>" 的请求不受来源限制
+	Token string `mapstructure:"token"`
+
+	// Timeout 是 /readyz 每项依赖检查的超时，默认 2s
+	Timeout time.Duration `mapstructure:"timeout"`
+
+	// RequireUpstreams 为 true 时任一上游池不可达都会使 /readyz 返回 503。
+	// 默认只有所有上游池都不可达时才返回 503，避免一个上游池故障使共享该上游的所有网关实例同时被摘除
+	RequireUpstreams bool `mapstructure:"require_upstreams"`
+}
+
+// AdminConfig 存储 admin API 的配置。admin API 只在 admin 角色的监听器上提供
+type AdminConfig struct {
+	// Token 是调用 admin API 时需要通过 "Authorization: Bearer <token>" 携带的令牌，为空时不启用 admin API。
+	// 建议通过环境变量 GOGA_ADMIN_TOKEN 设置，避免写入配置文件
+	Token string `mapstructure:"token"`
+}
+
+// TracingConfig 存储 OpenTelemetry 链路追踪的配置
+type TracingConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+
+	// Endpoint 是 OTLP/HTTP 接收端的地址，例如 "http://otel-collector:4318"，未指定路径时发送到 /v1/traces
+	Endpoint string `mapstructure:"endpoint"`
+
+	// Headers 是导出 span 时附带的请求头，例如接收端要求的认证信息
+	Headers map[string]string `mapstructure:"headers"`
+
+	ServiceName string `mapstructure:"service_name"` // 上报的服务名称，默认为 "goga"
+
+	// SampleRatio 是新建 trace 的采样比例 (0 到 1)。请求带有 traceparent 时沿用其中的采样决定
+	SampleRatio float64 `mapstructure:"sample_ratio"`
 }
 
 // WebsocketConfig 存储 Websocket 相关的配置
 type WebsocketConfig struct {
 	AllowedOrigins []string `mapstructure:"allowed_origins"`
 	InsecureSkipVerify bool `mapstructure:"insecure_skip_verify"` // 是否跳过后端TLS证书验证，生产环境禁用
+
+	// DrainTimeout 是网关关闭时向会话双方发送 1001 关闭帧后等待关闭握手完成的时间，超时后强制断开。默认 10s
+	DrainTimeout time.Duration `mapstructure:"drain_timeout"`
+}
+
+// UpstreamConfig 存储与后端通信相关的配置
+type UpstreamConfig struct {
+	// FlushInterval 为转发响应体时的刷新间隔，0 表示不定期刷新，负值表示每次写入后立即刷新。
+	// 长度未知的响应 (包括注入脚本后的 HTML) 和 text/event-stream 始终立即刷新。
+	FlushInterval time.Duration `mapstructure:"flush_interval"`
+
+	// Backends 是可供路由引用的具名后端。未配置时，使用 backend_url 作为名为 "default" 的后端。
+	Backends []BackendConfig `mapstructure:"backends"`
+
+	// Transport 是所有后端共享的连接配置，HTTP 代理和 WebSocket 代理都会使用
+	Transport TransportConfig `mapstructure:"transport"`
+
+	ClientCertHeaders ClientCertHeadersConfig `mapstructure:"client_cert_headers"`
+}
+
+// ClientCertHeadersConfig 存储转发给后端的客户端证书身份头的配置
+type ClientCertHeadersConfig struct {
+	// Secret 是对身份头进行 HMAC-SHA256 签名的密钥，后端用它确认身份头由网关生成。为空时不签名
+	Secret string `mapstructure:"secret"`
+}
+
+// TransportConfig 存储与后端建立连接相关的配置
+
+type TransportConfig struct {
+	DialTimeout time.Duration `mapstructure:"dial_timeout"` // 建立 TCP 连接的超时，默认 10s
+
+	KeepAlive time.Duration `mapstructure:"keep_alive"` // TCP keep-alive 间隔，默认 30s
+
+	TLSHandshakeTimeout time.Duration `mapstructure:"tls_handshake_timeout"` // 默认 10s
+
+	ResponseHeaderTimeout time.Duration `mapstructure:"response_header_timeout"` // 发送请求后等待响应头的超时，0 表示不限制
+
+	IdleConnTimeout time.Duration `mapstructure:"idle_conn_timeout"` // 空闲连接保留时间，默认 90s
+
+	MaxIdleConns int `mapstructure:"max_idle_conns"` // 所有后端的空闲连接总数上限，默认 100
+
+	MaxIdleConnsPerHost int `mapstructure:"max_idle_conns_per_host"` // 每个上游的空闲连接上限，默认 32
+
+	MaxConnsPerHost int `mapstructure:"max_conns_per_host"` // 每个上游的连接总数上限，0 表示不限制
+
+	CAFile string `mapstructure:"ca_file"` // 额外信任的 CA 证书 (PEM)，与系统根证书一起使用
+
+	CertFile string `mapstructure:"cert_file"` // 向后端出示的客户端证书 (mTLS)
+
+	KeyFile string `mapstructure:"key_file"`
+
+	ServerName string `mapstructure:"server_name"` // 覆盖 TLS 握手的 SNI 和证书校验使用的主机名
+
+	MinTLSVersion string `mapstructure:"min_tls_version"` // "1.0"、"1.1"、"1.2" (默认) 或 "1.3"
+
+	InsecureSkipVerify bool `mapstructure:"insecure_skip_verify"` // 跳过后端证书校验，生产环境禁用
+}
+
+// BackendConfig 存储一个具名后端 (上游池) 的配置
+type BackendConfig struct {
+	Name string `mapstructure:"name"`
+
+	URL string `mapstructure:"url"` // 单个上游地址，与 Targets 合并
+
+	Targets []string `mapstructure:"targets"` // 上游地址列表，所有地址的路径部分必须相同
+
+	Balance string `mapstructure:"balance"` // "round_robin" (默认), "least_conn", "consistent_hash"
+
+	HashKey string `mapstructure:"hash_key"` // consistent_hash 的键: "client_ip" (默认) 或 "cookie:<名称>"
+
+	MaxFails int `mapstructure:"max_fails"` // 连续多少次 BAD_GATEWAY/GATEWAY_TIMEOUT 后被动摘除上游，默认 3，负数表示禁用
+
+	FailTimeout time.Duration `mapstructure:"fail_timeout"` // 被动摘除的持续时间，默认 30s
+
+	HealthCheck HealthCheckConfig `mapstructure:"health_check"`
+
+	CircuitBreaker CircuitBreakerConfig `mapstructure:"circuit_breaker"`
+
+	Retry RetryConfig `mapstructure:"retry"`
+
+	// Protocol 是与该后端通信使用的协议: "auto" (默认，https 后端通过 ALPN 协商 HTTP/2)、
+	// "http1"、"h2" (仅 HTTP/2 over TLS) 或 "h2c" (HTTP/2 明文，prior knowledge)
+	Protocol string `mapstructure:"protocol"`
+}
+
+// CircuitBreakerConfig 存储每个上游的熔断器配置
+
+type CircuitBreakerConfig struct {
+	FailureThreshold int `mapstructure:"failure_threshold"` // 连续多少次转发失败后打开熔断器，默认 5，负数表示禁用
+
+	OpenTimeout time.Duration `mapstructure:"open_timeout"` // 熔断器打开多久后进入半开状态，默认 10s
+
+	HalfOpenRequests int `mapstructure:"half_open_requests"` // 半开状态下允许的试探请求数，全部成功后关闭熔断器，默认 1
+}
+
+// RetryConfig 存储转发失败后的重试配置，只有幂等请求 (或标记为幂等的路由) 且请求体可重放时才会重试
+
+type RetryConfig struct {
+	MaxRetries int `mapstructure:"max_retries"` // 每个请求最多重试次数，默认 1，负数表示禁用
+
+	BudgetRatio float64 `mapstructure:"budget_ratio"` // 重试预算：每个请求为后端积累的重试次数，默认 0.2 (即重试不超过请求数的 20%)
+
+	MinRetriesPerSecond int `mapstructure:"min_retries_per_second"` // 请求量很低时每秒至少允许的重试次数，默认 3
+
+	MaxBodyBytes int64 `mapstructure:"max_body_bytes"` // 为了重试而缓冲的请求体上限，超过时该请求不重试，默认 64KB
+}
+
+// HealthCheckConfig 存储主动健康检查的配置，Path 为空时不进行主动检查
+
+type HealthCheckConfig struct {
+	Path string `mapstructure:"path"`
+
+	Interval time.Duration `mapstructure:"interval"` // 默认 10s
+
+	Timeout time.Duration `mapstructure:"timeout"` // 默认 2s
+
+	HealthyThreshold int `mapstructure:"healthy_threshold"` // 连续成功多少次后恢复，默认 2
+
+	UnhealthyThreshold int `mapstructure:"unhealthy_threshold"` // 连续失败多少次后标记为不健康，默认 2
+}
+
+// RouteConfig 存储一条路由规则。Host 和 PathPrefix 为空时匹配任意值
+
+type RouteConfig struct {
+	Name string `mapstructure:"name"` // 路由名称，用作指标的 route 标签，默认为 <host><path_prefix>
+
+	Host string `mapstructure:"host"` // 精确 Host 或 "*.example.com" 通配，不区分大小写
+
+	PathPrefix string `mapstructure:"path_prefix"` // 按路径段匹配，"/api" 匹配 "/api" 和 "/api/x"，但不匹配 "/apix"
+
+	StripPrefix bool `mapstructure:"strip_prefix"` // 转发前去掉匹配的路径前缀
+
+	Rewrite string `mapstructure:"rewrite"` // 转发前将匹配的路径前缀替换为该值，与 strip_prefix 互斥
+
+	Upstream string `mapstructure:"upstream"` // 后端名称，为空时使用第一个后端
+
+	Idempotent bool `mapstructure:"idempotent"` // 标记路由的所有请求都是幂等的，非幂等方法 (如 POST) 在请求体缓冲完毕后也可重试
+
+	// Encryption 覆盖全局加密配置，未设置的字段沿用全局值
+	Encryption *RouteEncryptionConfig `mapstructure:"encryption"`
+
+	// ClientCert 要求该路由的请求出示经过验证的客户端证书 (需要监听器启用 client_auth)
+	ClientCert ClientCertConfig `mapstructure:"client_cert"`
+}
+
+// ClientCertConfig 存储对客户端证书的访问要求
+type ClientCertConfig struct {
+	Required bool `mapstructure:"required"`
+
+	// AllowedNames 是允许的证书 CommonName、SAN 取值或 "sha256:<指纹>"，配置后隐含 required
+	AllowedNames []string `mapstructure:"allowed_names"`
+}
+
+// RouteEncryptionConfig 存储路由级别的加密配置覆盖项
+
+type RouteEncryptionConfig struct {
+	Enabled *bool `mapstructure:"enabled"`
+
+	MustEncryptRoutes []string `mapstructure:"must_encrypt_routes"`
+
+	Methods []string `mapstructure:"methods"`
 }
 
 // LogConfig 存储日志相关的配置
@@ -49,9 +262,109 @@ type LogConfig struct {
 type ServerConfig struct {
 	Port string `mapstructure:"port"`
 
+	// Listen 覆盖 port 指定的监听地址，可以是 TCP 地址 (如 "127.0.0.1:8080") 或 Unix 套接字 (如 "unix:/run/goga.sock")
+	Listen string `mapstructure:"listen"`
+
+	// SocketMode 是 Unix 套接字文件的权限 (八进制字符串，如 "0660")
+	SocketMode string `mapstructure:"socket_mode"`
+
+	TLSCertPath string `mapstructure:"tls_cert_path"`
+
+	TLSKeyPath string `mapstructure:"tls_key_path"`
+
+	// Certificates 是按 SNI 选择的额外证书，tls_cert_path 配置的证书作为默认证书
+	Certificates []CertificateConfig `mapstructure:"certificates"`
+
+	ClientAuth ClientAuthConfig `mapstructure:"client_auth"`
+
+	// TrustedProxies 是受信任的前置代理 (CIDR 或单个 IP)，只有来自这些地址的 X-Forwarded-* 头才会被采信。
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+
+	ProxyProtocol ProxyProtocolConfig `mapstructure:"proxy_protocol"`
+
+	// H2C 允许客户端在未加密的监听端口上直接使用 HTTP/2 (prior knowledge)，
+	// 用于部署在终止 TLS 的负载均衡器之后。HTTPS 监听端口始终通过 ALPN 支持 HTTP/2
+	H2C bool `mapstructure:"h2c"`
+
+	// Listeners 配置多个监听器，每个监听器有各自的 TLS 设置和角色。
+	// 配置后忽略上面的 port、listen、socket_mode、tls_*、proxy_protocol 和 h2c
+	Listeners []ListenerConfig `mapstructure:"listeners"`
+
+	// ShutdownTimeout 是收到关闭信号后等待进行中的请求完成的时间。默认 5s
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+
+	// ShutdownDelay 是收到关闭信号后、停止接受新连接前继续服务的时间。期间 /readyz 返回 503，
+	// 负载均衡器 (例如 Kubernetes 的 Endpoints) 有时间摘除网关。默认 0
+	ShutdownDelay time.Duration `mapstructure:"shutdown_delay"`
+
+	// PIDFile 是写入主进程 PID 的文件，平滑升级后由新进程改写，供 systemd 的 PIDFile= 跟踪主进程。为空时不写入
+	PIDFile string `mapstructure:"pid_file"`
+
+	Upgrade UpgradeConfig `mapstructure:"upgrade"`
+}
+
+// UpgradeConfig 存储平滑升级 (收到 SIGUSR2 时把监听器交给新进程) 的配置
+type UpgradeConfig struct {
+	// ReadyTimeout 是等待新进程就绪的时间，超时后终止新进程，旧进程继续服务。默认 30s
+	ReadyTimeout time.Duration `mapstructure:"ready_timeout"`
+
+	// DrainTimeout 是旧进程关闭监听器后等待 WebSocket 连接自行结束的最长时间，超时后直接退出。默认 10m
+	DrainTimeout time.Duration `mapstructure:"drain_timeout"`
+}
+
+// CertificateConfig 存储一对证书和私钥文件，证书文件可以包含中间证书链
+type CertificateConfig struct {
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+}
+
+// ClientAuthConfig 存储监听器的客户端证书认证 (mTLS) 配置
+type ClientAuthConfig struct {
+	Mode string `mapstructure:"mode"` // "none" (默认)、"optional" (出示证书时校验) 或 "require" (必须出示有效证书)
+
+	CAFile string `mapstructure:"ca_file"` // 签发客户端证书的 CA (PEM)
+}
+
+// ListenerConfig 存储单个监听器的配置
+type ListenerConfig struct {
+	// Name 用于日志，默认为监听地址
+	Name string `mapstructure:"name"`
+
+	// Listen 是监听地址，可以是 TCP 地址 (如 ":443") 或 Unix 套接字 (如 "unix:/run/goga.sock")
+	Listen string `mapstructure:"listen"`
+
+	// Role 是监听器的角色: "serve" (默认，处理业务流量)、"redirect" (重定向到 HTTPS) 或 "admin" (仅提供运维接口)
+	Role string `mapstructure:"role"`
+
 	TLSCertPath string `mapstructure:"tls_cert_path"`
 
 	TLSKeyPath string `mapstructure:"tls_key_path"`
+
+	Certificates []CertificateConfig `mapstructure:"certificates"`
+
+	ClientAuth ClientAuthConfig `mapstructure:"client_auth"`
+
+	SocketMode string `mapstructure:"socket_mode"`
+
+	ProxyProtocol ProxyProtocolConfig `mapstructure:"proxy_protocol"`
+
+	H2C bool `mapstructure:"h2c"`
+
+	// HTTPSPort 是 redirect 监听器重定向的目标端口，默认取第一个启用 TLS 的 serve 监听器的端口，否则为 443
+	HTTPSPort string `mapstructure:"https_port"`
+
+	// PassthroughPrefixes 是 redirect 监听器不重定向、按 serve 监听器处理的路径前缀，
+	// 默认为 ACME HTTP-01 验证路径 "/.well-known/acme-challenge/"
+	PassthroughPrefixes []string `mapstructure:"passthrough_prefixes"`
+}
+
+// ProxyProtocolConfig 存储监听端口上 PROXY protocol (v1/v2) 的配置，用于部署在四层负载均衡器之后。
+type ProxyProtocolConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// AllowedSources 是允许发送 PROXY 头的来源 (CIDR 或单个 IP)，来自其他地址的连接不解析 PROXY 头
+	AllowedSources []string `mapstructure:"allowed_sources"`
+	// HeaderTimeout 是等待 PROXY 头的超时时间，默认 5s
+	HeaderTimeout time.Duration `mapstructure:"header_timeout"`
 }
 
 // EncryptionConfig 存储加密相关的配置
@@ -59,6 +372,19 @@ type ServerConfig struct {
 type EncryptionConfig struct {
 	Enabled           bool     `mapstructure:"enabled"`
 	MustEncryptRoutes []string `mapstructure:"must_encrypt_routes"`
+	Methods           []string `mapstructure:"methods"` // 需要进行加密/解密处理的 HTTP 方法，默认仅 POST
+
+	KeyIssuance KeyIssuanceConfig `mapstructure:"key_issuance"`
+}
+
+// KeyIssuanceConfig 存储密钥分发端点的访问策略
+type KeyIssuanceConfig struct {
+	// ClientCert 要求领取密钥的客户端出示经过验证的客户端证书，例如只向合作方客户端分发密钥
+	ClientCert ClientCertConfig `mapstructure:"client_cert"`
+
+	// SessionCookie 是后端应用的会话 Cookie 名称。配置后，分发密钥时记录该 Cookie 的摘要，
+	// 以便通过 admin API 吊销某个会话领取的所有令牌
+	SessionCookie string `mapstructure:"session_cookie"`
 }
 
 // RedisConfig 存储 Redis 连接相关的配置
@@ -85,6 +411,57 @@ type KeyCacheConfig struct {
 
 type ScriptInjectionConfig struct {
 	ScriptContent string `mapstructure:"script_content"`
+
+	// ClientConfig 是随脚本一起注入页面、供 goga.js 读取的客户端加密策略
+	ClientConfig ClientConfig `mapstructure:"client_config"`
+
+	// HostClientConfigs 按 Host 覆盖 ClientConfig，键为请求的 Host (不区分大小写)
+	HostClientConfigs map[string]ClientConfig `mapstructure:"host_client_configs"`
+
+	// StatusCodes 和 ContentTypes 是默认的注入条件，未命中任何规则时使用
+	StatusCodes []int `mapstructure:"status_codes"`
+
+	ContentTypes []string `mapstructure:"content_types"`
+
+	// Rules 按顺序匹配，第一条命中的规则决定是否注入
+	Rules []InjectionRuleConfig `mapstructure:"rules"`
+
+	// Compression 控制注入后 HTML 的压缩方式
+	Compression CompressionConfig `mapstructure:"compression"`
+}
+
+// CompressionConfig 存储注入后 HTML 重新压缩相关的配置
+
+type CompressionConfig struct {
+	Mode string `mapstructure:"mode"` // "preserve" (沿用后端编码), "identity" (不压缩), "negotiate" (按 Accept-Encoding 选择开销更小的编码)
+
+	GzipLevel int `mapstructure:"gzip_level"` // 1-9，0 表示默认级别
+
+	BrotliLevel int `mapstructure:"brotli_level"` // 1-11，0 表示默认级别 (4)
+
+	ZstdLevel int `mapstructure:"zstd_level"` // zstd 原生级别 1-22，0 表示默认级别
+}
+
+// InjectionRuleConfig 存储一条脚本注入规则，未配置的条件视为匹配任意值
+
+type InjectionRuleConfig struct {
+	Path string `mapstructure:"path"` // 请求路径正则
+
+	Host string `mapstructure:"host"` // 精确 Host 或 "*.example.com" 通配
+
+	StatusCodes []int `mapstructure:"status_codes"`
+
+	ContentTypes []string `mapstructure:"content_types"`
+
+	Action string `mapstructure:"action"` // "inject" 或 "skip"
+}
+
+// ClientConfig 存储下发给浏览器端加密脚本的 URL 匹配规则
+
+type ClientConfig struct {
+	ExcludeURLs []string `mapstructure:"exclude_urls"` // 不进行加密的 URL 正则列表
+
+	IncludeURLs []string `mapstructure:"include_urls"` // 非空时，仅对匹配的 URL 进行加密
 }
 
 // LoadConfig 从文件和环境变量中读取配置
@@ -95,16 +472,34 @@ func LoadConfig() (config Config, err error) {
 
 	viper.SetDefault("server.port", "8080")
 
+	viper.SetDefault("server.shutdown_timeout", "5s")
+
+	viper.SetDefault("server.pid_file", "") // 让 GOGA_SERVER_PID_FILE 环境变量生效
+
+	viper.SetDefault("server.upgrade.ready_timeout", "30s")
+
+	viper.SetDefault("server.upgrade.drain_timeout", "10m")
+
 	viper.SetDefault("backend_url", "http://localhost:3000")
 
+	viper.SetDefault("websocket.drain_timeout", "10s")
+
 	viper.SetDefault("log.level", "info")
 
 	viper.SetDefault("log.output_paths", []string{"stdout"}) // 默认输出到标准输出
 
 	viper.SetDefault("encryption.enabled", true)
 
+	viper.SetDefault("encryption.methods", []string{"POST"})
+
 	viper.SetDefault("script_injection.script_content", `<script src="/goga.min.js" defer></script>`)
 
+	viper.SetDefault("script_injection.status_codes", []int{200})
+
+	viper.SetDefault("script_injection.content_types", []string{"text/html"})
+
+	viper.SetDefault("script_injection.compression.mode", "preserve")
+
 	// KeyCache 默认配置
 
 	viper.SetDefault("key_cache.type", "in-memory")
@@ -117,6 +512,22 @@ func LoadConfig() (config Config, err error) {
 
 	viper.SetDefault("key_cache.redis.db", 0)
 
+	// 链路追踪默认关闭，启用后默认对所有新建 trace 采样
+
+	viper.SetDefault("tracing.service_name", "goga")
+
+	viper.SetDefault("tracing.sample_ratio", 1.0)
+
+	// admin API 默认不启用。设置默认值使 GOGA_ADMIN_TOKEN 环境变量可以被解组
+
+	viper.SetDefault("admin.token", "")
+
+	viper.SetDefault("health.token", "") // 让 GOGA_HEALTH_TOKEN 环境变量生效
+
+	viper.SetDefault("health.timeout", "2s")
+
+	viper.SetDefault("health.require_upstreams", false)
+
 	// 从配置文件加载
 
 	viper.SetConfigName("config") // 配置文件名 (不带扩展名)
@@ -170,3 +581,9 @@ func LoadConfig() (config Config, err error) {
 	return
 
 }
+
+// ConfigFile 返回 LoadConfig 读取的配置文件路径，未找到配置文件时为空。
+// 配置文件变更时可以重新调用 LoadConfig 读取新的配置
+func ConfigFile() string {
+	return viper.ConfigFileUsed()
+}
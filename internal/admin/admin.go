@@ -0,0 +1,222 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+// Package admin 提供 admin 监听器上的管理 API：查询和吊销一次性密钥的令牌，以及读取指标快照。
+// 怀疑某个浏览器会话被盗用时，可以按令牌、客户端 IP 或会话吊销它领取的所有令牌。
+package admin
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"goga/internal/gateway"
+	"goga/internal/middleware"
+	"goga/internal/security"
+	"goga/internal/server"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+)
+
+// admin API 的路径
+const (
+	KeysPath     = "/goga/admin/keys"             // GET 查询令牌数，DELETE 清空所有令牌
+	RevokePath   = "/goga/admin/keys/revoke"      // POST 按客户端 IP 或会话吊销令牌
+	SnapshotPath = "/goga/admin/metrics/snapshot" // GET 以 JSON 格式读取指标快照
+)
+
+// maxRevokeBodySize 是吊销请求体的最大长度
+const maxRevokeBodySize = 4 << 10
+
+// api 封装 admin API 的依赖项
+type api struct {
+	store security.KeyStore
+}
+
+// NewHandler 返回 admin API 的处理器。所有接口都只接受来自 admin 监听器、
+// 并通过 "Authorization: Bearer <token>" 携带正确令牌的请求。
+func NewHandler(token string, store security.KeyStore) http.Handler {
+	a := &api{store: store}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+KeysPath, a.countKeys)
+	mux.HandleFunc("DELETE "+KeysPath, a.flushKeys)
+	mux.HandleFunc("DELETE "+KeysPath+"/{token}", a.revokeKey)
+	mux.HandleFunc("POST "+RevokePath, a.revokeKeys)
+	mux.HandleFunc("GET "+SnapshotPath, a.snapshot)
+	return requireToken(token, mux)
+}
+
+// Register 将 admin API 注册到 admin 监听器的路由器上
+func Register(mux *http.ServeMux, handler http.Handler) {
+	mux.Handle(KeysPath, handler)
+	mux.Handle(KeysPath+"/", handler)
+	mux.Handle(SnapshotPath, handler)
+}
+
+// requireToken 校验请求来自 admin 监听器并携带正确的 Bearer 令牌。
+// 比较的是两者的 SHA-256 摘要，耗时与令牌内容和长度无关
+func requireToken(token string, next http.Handler) http.Handler {
+	want := sha256.Sum256([]byte(token))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !middleware.IsAdminListener(r) {
+			middleware.LogWarn(r, "拒绝了非 admin 监听器上的管理请求", "event_type", "security")
+			middleware.WriteJSONError(w, r, http.StatusForbidden, "FORBIDDEN", "禁止访问")
+			return
+		}
+
+		presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		got := sha256.Sum256([]byte(presented))
+		if !ok || subtle.ConstantTimeCompare(got[:], want[:]) != 1 {
+			middleware.LogWarn(r, "安全事件：admin API 认证失败",
+				"event_type", "security",
+				"reason", "invalid_admin_token",
+			)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="goga-admin"`)
+			middleware.WriteJSONError(w, r, http.StatusUnauthorized, "UNAUTHORIZED", "缺少或无效的管理令牌")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// countKeys 返回未过期的令牌数
+func (a *api) countKeys(w http.ResponseWriter, r *http.Request) {
+	count, err := a.store.Count(r.Context())
+	if err != nil {
+		a.storeError(w, r, "查询令牌数失败", err)
+		return
+	}
+	writeJSON(w, struct {
+		Count int64 `json:"count"`
+	}{count})
+}
+
+// revokeKey 吊销单个令牌
+func (a *api) revokeKey(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	found, err := a.store.Revoke(r.Context(), token)
+	if err != nil {
+		a.storeError(w, r, "吊销令牌失败", err)
+		return
+	}
+	if !found {
+		middleware.WriteJSONError(w, r, http.StatusNotFound, "TOKEN_NOT_FOUND", "令牌不存在或已过期")
+		return
+	}
+	middleware.LogWarn(r, "安全事件：已吊销令牌", "event_type", "security", "token", token)
+	writeRevoked(w, 1)
+}
+
+// revokeRequest 是按客户端 IP 或会话吊销令牌的请求体，两个字段必须且只能设置一个
+type revokeRequest struct {
+	ClientIP string `json:"client_ip"`
+	Session  string `json:"session"` // 会话 Cookie 的原始值，网关只保存其摘要
+}
+
+// revokeKeys 按客户端 IP 或会话吊销令牌
+func (a *api) revokeKeys(w http.ResponseWriter, r *http.Request) {
+	var req revokeRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRevokeBodySize)).Decode(&req); err != nil {
+		middleware.WriteJSONError(w, r, http.StatusBadRequest, "INVALID_REQUEST", "请求体不是有效的 JSON")
+		return
+	}
+	if (req.ClientIP == "") == (req.Session == "") {
+		middleware.WriteJSONError(w, r, http.StatusBadRequest, "INVALID_REQUEST", "必须且只能指定 client_ip 或 session 之一")
+		return
+	}
+
+	var (
+		revoked int64
+		err     error
+	)
+	if req.ClientIP != "" {
+		addr, parseErr := netip.ParseAddr(req.ClientIP)
+		if parseErr != nil {
+			middleware.WriteJSONError(w, r, http.StatusBadRequest, "INVALID_REQUEST", "无效的 client_ip")
+			return
+		}
+		clientIP := addr.Unmap().String()
+		revoked, err = a.store.RevokeClientIP(r.Context(), clientIP)
+		if err == nil {
+			middleware.LogWarn(r, "安全事件：已吊销客户端 IP 的所有令牌", "event_type", "security", "target_ip", clientIP, "revoked", revoked)
+		}
+	} else {
+		revoked, err = a.store.RevokeSession(r.Context(), security.SessionDigest(req.Session))
+		if err == nil {
+			middleware.LogWarn(r, "安全事件：已吊销会话的所有令牌", "event_type", "security", "revoked", revoked)
+		}
+	}
+	if err != nil {
+		a.storeError(w, r, "批量吊销令牌失败", err)
+		return
+	}
+	writeRevoked(w, revoked)
+}
+
+// flushKeys 吊销所有令牌
+func (a *api) flushKeys(w http.ResponseWriter, r *http.Request) {
+	revoked, err := a.store.Flush(r.Context())
+	if err != nil {
+		a.storeError(w, r, "清空令牌失败", err)
+		return
+	}
+	middleware.LogWarn(r, "安全事件：已清空所有令牌", "event_type", "security", "revoked", revoked)
+	writeRevoked(w, revoked)
+}
+
+// metricsSnapshot 汇总各模块的指标快照
+type metricsSnapshot struct {
+	Decrypt      middleware.DecryptMetricsSnapshot `json:"decrypt"`
+	Keys         gateway.KeyMetricsSnapshot        `json:"keys"`
+	Proxy        gateway.ProxyMetricsSnapshot      `json:"proxy"`
+	Injection    gateway.InjectionMetricsSnapshot  `json:"injection"`
+	WebSocket    webSocketSnapshot                 `json:"websocket"`
+	Certificates server.CertificateMetricsSnapshot `json:"certificates"`
+}
+
+// webSocketSnapshot 是 WebSocket 连接数的快照
+type webSocketSnapshot struct {
+	Active int64 `json:"active"`
+	Total  int64 `json:"total"`
+}
+
+// snapshot 以 JSON 格式返回指标快照
+func (a *api) snapshot(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, metricsSnapshot{
+		Decrypt:   middleware.GlobalDecryptMetrics.GetSnapshot(),
+		Keys:      gateway.GlobalKeyMetrics.GetSnapshot(),
+		Proxy:     gateway.GlobalProxyMetrics.GetSnapshot(),
+		Injection: gateway.GlobalInjectionMetrics.GetSnapshot(),
+		WebSocket: webSocketSnapshot{
+			Active: atomic.LoadInt64(&gateway.GlobalWebSocketMetrics.Active),
+			Total:  atomic.LoadInt64(&gateway.GlobalWebSocketMetrics.Total),
+		},
+		Certificates: server.GlobalCertificateMetrics.GetSnapshot(),
+	})
+}
+
+// storeError 记录密钥缓存不可用 (例如 Redis 连接失败) 的错误并返回 503
+func (a *api) storeError(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	middleware.LogError(r, msg, "error", err)
+	middleware.WriteJSONError(w, r, http.StatusServiceUnavailable, "KEY_STORE_UNAVAILABLE", "密钥缓存不可用")
+}
+
+// writeRevoked 返回吊销的令牌数
+func writeRevoked(w http.ResponseWriter, revoked int64) {
+	writeJSON(w, struct {
+		Revoked int64 `json:"revoked"`
+	}{revoked})
+}
+
+// writeJSON 以不可缓存的 JSON 响应返回 v
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("写入 admin API 响应失败", "error", err)
+	}
+}
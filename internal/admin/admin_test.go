@@ -0,0 +1,124 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package admin
+
+import (
+	"encoding/json"
+	"goga/internal/gateway"
+	"goga/internal/middleware"
+	"goga/internal/security"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testToken = "s3cret-admin-token"
+
+// newTestAPI 返回挂载在 admin 监听器上的 admin API 和它使用的内存密钥缓存
+func newTestAPI(t *testing.T) (http.Handler, security.KeyStore) {
+	t.Helper()
+	store := gateway.NewInMemoryKeyCache(0)
+	t.Cleanup(store.Stop)
+	mux := http.NewServeMux()
+	Register(mux, NewHandler(testToken, store))
+	return middleware.AdminListener(mux), store
+}
+
+// do 以管理令牌发起请求，返回状态码和解码后的 JSON 响应体
+func do(t *testing.T, handler http.Handler, method, path, body string) (int, map[string]any) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	var resp map[string]any
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp), rr.Body.String())
+	return rr.Code, resp
+}
+
+func TestAdminAPI_Authentication(t *testing.T) {
+	handler, store := newTestAPI(t)
+
+	for name, header := range map[string]string{
+		"缺少令牌": "",
+		"错误令牌": "Bearer wrong",
+		"错误方案": "Basic " + testToken,
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, KeysPath, nil)
+			if header != "" {
+				req.Header.Set("Authorization", header)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			assert.Equal(t, http.StatusUnauthorized, rr.Code)
+			assert.NotEmpty(t, rr.Header().Get("WWW-Authenticate"))
+		})
+	}
+
+	t.Run("非 admin 监听器", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodDelete, KeysPath, nil)
+		req.Header.Set("Authorization", "Bearer "+testToken)
+		rr := httptest.NewRecorder()
+		NewHandler(testToken, store).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+	})
+}
+
+func TestAdminAPI_Revocation(t *testing.T) {
+	handler, store := newTestAPI(t)
+	ctx := t.Context()
+	store.Set(ctx, "tok-a1", []byte("k"), time.Minute, security.KeyMetadata{ClientIP: "203.0.113.7", Session: security.SessionDigest("sess-a")})
+	store.Set(ctx, "tok-a2", []byte("k"), time.Minute, security.KeyMetadata{ClientIP: "203.0.113.7"})
+	store.Set(ctx, "tok-b1", []byte("k"), time.Minute, security.KeyMetadata{ClientIP: "198.51.100.2", Session: security.SessionDigest("sess-b")})
+	store.Set(ctx, "tok-c1", []byte("k"), time.Minute, security.KeyMetadata{ClientIP: "192.0.2.1"})
+
+	code, resp := do(t, handler, http.MethodGet, KeysPath, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(4), resp["count"])
+
+	code, resp = do(t, handler, http.MethodDelete, KeysPath+"/tok-c1", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(1), resp["revoked"])
+	code, resp = do(t, handler, http.MethodDelete, KeysPath+"/tok-c1", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "TOKEN_NOT_FOUND", resp["error"].(map[string]any)["code"])
+
+	code, resp = do(t, handler, http.MethodPost, RevokePath, `{"session":"sess-b"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(1), resp["revoked"])
+
+	code, resp = do(t, handler, http.MethodPost, RevokePath, `{"client_ip":"::ffff:203.0.113.7"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(2), resp["revoked"], "IPv4 映射地址应按 IPv4 地址匹配")
+
+	for _, body := range []string{`{}`, `{"client_ip":"1.2.3.4","session":"x"}`, `{"client_ip":"not-an-ip"}`, `not json`} {
+		code, _ = do(t, handler, http.MethodPost, RevokePath, body)
+		assert.Equal(t, http.StatusBadRequest, code, body)
+	}
+
+	store.Set(ctx, "tok-d1", []byte("k"), time.Minute, security.KeyMetadata{})
+	code, resp = do(t, handler, http.MethodDelete, KeysPath, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(1), resp["revoked"])
+	_, found := store.Get(ctx, "tok-d1")
+	assert.False(t, found)
+}
+
+func TestAdminAPI_Snapshot(t *testing.T) {
+	handler, _ := newTestAPI(t)
+	code, resp := do(t, handler, http.MethodGet, SnapshotPath, "")
+	assert.Equal(t, http.StatusOK, code)
+	for _, section := range []string{"decrypt", "keys", "proxy", "injection", "websocket", "certificates"} {
+		assert.Contains(t, resp, section)
+	}
+	assert.Contains(t, resp["decrypt"], "total_requests")
+}
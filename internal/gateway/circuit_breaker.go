@@ -0,0 +1,262 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package gateway
+
+import (
+	"errors"
+	"goga/configs"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultFailureThreshold    = 5
+	defaultOpenTimeout         = 10 * time.Second
+	defaultHalfOpenRequests    = 1
+	defaultMaxRetries          = 1
+	defaultBudgetRatio         = 0.2
+	defaultMinRetriesPerSecond = 3
+	defaultMaxRetryBodyBytes   = 64 * 1024
+
+	// retryBudgetWindow 决定重试预算最多可以积累多少秒的最低重试次数
+	retryBudgetWindow = 10
+)
+
+// errCircuitOpen 表示上游池中所有可选上游的熔断器都处于打开状态，请求未被发送。
+var errCircuitOpen = errors.New("上游熔断器已打开")
+
+// 熔断器状态
+const (
+	circuitClosed int32 = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuitStateNames 是熔断器状态在状态接口和日志中的名称
+var circuitStateNames = [...]string{
+	circuitClosed:   "closed",
+	circuitOpen:     "open",
+	circuitHalfOpen: "half_open",
+}
+
+// circuitBreaker 是单个上游的熔断器。
+// 关闭状态下连续失败达到阈值后打开；打开状态下拒绝所有请求，超时后进入半开状态；
+// 半开状态下只放行有限的试探请求，全部成功后关闭，任意一次失败则重新打开。
+type circuitBreaker struct {
+	backend string // 仅用于日志
+	target  string
+
+	threshold   int32 // 小于等于 0 表示禁用
+	openTimeout time.Duration
+	halfOpenMax int32
+
+	// state 和 failures 在关闭状态的快速路径上无锁读写，状态切换需要持有 mu
+	state     atomic.Int32
+	failures  atomic.Int32
+	openUntil atomic.Int64 // 打开状态的截止时间 (UnixNano)
+
+	mu        sync.Mutex
+	inflight  int32 // 半开状态下正在进行的试探请求数
+	successes int32 // 半开状态下已成功的试探请求数
+}
+
+// init 按配置初始化熔断器，未配置的字段使用默认值。
+func (cb *circuitBreaker) init(backend, target string, cfg configs.CircuitBreakerConfig) {
+	cb.backend = backend
+	cb.target = target
+	cb.threshold = int32(cfg.FailureThreshold)
+	if cb.threshold == 0 {
+		cb.threshold = defaultFailureThreshold
+	}
+	cb.openTimeout = cfg.OpenTimeout
+	if cb.openTimeout <= 0 {
+		cb.openTimeout = defaultOpenTimeout
+	}
+	cb.halfOpenMax = int32(cfg.HalfOpenRequests)
+	if cb.halfOpenMax <= 0 {
+		cb.halfOpenMax = defaultHalfOpenRequests
+	}
+}
+
+// disabled 判断熔断器是否被禁用。
+func (cb *circuitBreaker) disabled() bool {
+	return cb.threshold <= 0
+}
+
+// ready 判断熔断器是否可能放行请求，供选择上游时使用，不会改变状态。
+func (cb *circuitBreaker) ready(now int64) bool {
+	switch cb.state.Load() {
+	case circuitClosed:
+		return true
+	case circuitOpen:
+		return now >= cb.openUntil.Load()
+	default:
+		cb.mu.Lock()
+		defer cb.mu.Unlock()
+		return cb.inflight < cb.halfOpenMax
+	}
+}
+
+// allow 判断是否放行一次请求。半开状态下放行的请求占用一个试探名额，
+// 结果必须通过 onSuccess、onFailure 或 onCancel 报告。
+func (cb *circuitBreaker) allow(now int64) bool {
+	if cb.disabled() || cb.state.Load() == circuitClosed {
+		return true
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.state.Load() {
+	case circuitOpen:
+		if now < cb.openUntil.Load() {
+			return false
+		}
+		cb.state.Store(circuitHalfOpen)
+		cb.inflight, cb.successes = 0, 0
+		slog.Info("上游熔断器进入半开状态", "backend", cb.backend, "target", cb.target)
+		fallthrough
+	case circuitHalfOpen:
+		if cb.inflight >= cb.halfOpenMax {
+			return false
+		}
+		cb.inflight++
+	}
+	return true
+}
+
+// onSuccess 报告一次成功的请求。
+func (cb *circuitBreaker) onSuccess() {
+	if cb.state.Load() == circuitClosed {
+		if cb.failures.Load() != 0 {
+			cb.failures.Store(0)
+		}
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state.Load() != circuitHalfOpen {
+		return
+	}
+	cb.releaseProbe()
+	cb.successes++
+	if cb.successes >= cb.halfOpenMax {
+		cb.failures.Store(0)
+		cb.state.Store(circuitClosed)
+		slog.Info("上游熔断器已关闭", "backend", cb.backend, "target", cb.target)
+	}
+}
+
+// onFailure 报告一次失败的请求。
+func (cb *circuitBreaker) onFailure(now int64) {
+	if cb.disabled() {
+		return
+	}
+	if cb.state.Load() == circuitClosed && cb.failures.Add(1) < cb.threshold {
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.state.Load() {
+	case circuitClosed:
+		if cb.failures.Load() < cb.threshold {
+			return // 其他请求的成功已清零失败次数
+		}
+	case circuitHalfOpen:
+		cb.releaseProbe()
+	default:
+		return // 已经打开，打开前发出的请求失败不延长打开时间
+	}
+	cb.failures.Store(0)
+	cb.openUntil.Store(now + int64(cb.openTimeout))
+	cb.state.Store(circuitOpen)
+	slog.Warn("上游熔断器已打开", "backend", cb.backend, "target", cb.target, "open_timeout", cb.openTimeout)
+}
+
+// onCancel 报告一次没有结果的请求 (例如客户端断开)，只释放半开状态的试探名额。
+func (cb *circuitBreaker) onCancel() {
+	if cb.state.Load() != circuitHalfOpen {
+		return
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state.Load() == circuitHalfOpen {
+		cb.releaseProbe()
+	}
+}
+
+// releaseProbe 释放一个试探名额，调用方需持有 mu。
+// 熔断器打开前发出的请求可能在半开状态下才返回，因此名额不会减到负数。
+func (cb *circuitBreaker) releaseProbe() {
+	if cb.inflight > 0 {
+		cb.inflight--
+	}
+}
+
+// stateName 返回熔断器当前状态的名称，打开状态已超时但尚未放行试探请求时视为半开。
+func (cb *circuitBreaker) stateName(now int64) string {
+	state := cb.state.Load()
+	if state == circuitOpen && now >= cb.openUntil.Load() {
+		state = circuitHalfOpen
+	}
+	return circuitStateNames[state]
+}
+
+// retryBudget 限制上游池的重试总量，避免上游故障时重试把流量放大数倍。
+// 每个请求积累 ratio 次重试额度，另外每秒补充 minPerSecond 次，每次重试消耗一次额度。
+type retryBudget struct {
+	ratio        float64
+	minPerSecond float64
+	capacity     float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newRetryBudget 创建重试预算，初始额度为一秒的最低重试次数。
+func newRetryBudget(cfg configs.RetryConfig) *retryBudget {
+	ratio := cfg.BudgetRatio
+	if ratio <= 0 {
+		ratio = defaultBudgetRatio
+	}
+	minPerSecond := float64(cfg.MinRetriesPerSecond)
+	if minPerSecond <= 0 {
+		minPerSecond = defaultMinRetriesPerSecond
+	}
+	return &retryBudget{
+		ratio:        ratio,
+		minPerSecond: minPerSecond,
+		capacity:     minPerSecond * retryBudgetWindow,
+		tokens:       minPerSecond,
+		last:         time.Now(),
+	}
+}
+
+// deposit 为一个新请求积累重试额度。
+func (rb *retryBudget) deposit() {
+	rb.mu.Lock()
+	rb.tokens = min(rb.capacity, rb.tokens+rb.ratio)
+	rb.mu.Unlock()
+}
+
+// withdraw 尝试消耗一次重试额度，额度不足时返回 false。
+func (rb *retryBudget) withdraw(now time.Time) bool {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if elapsed := now.Sub(rb.last); elapsed > 0 {
+		rb.tokens = min(rb.capacity, rb.tokens+elapsed.Seconds()*rb.minPerSecond)
+		rb.last = now
+	}
+	if rb.tokens < 1 {
+		return false
+	}
+	rb.tokens--
+	return true
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package gateway
+
+import (
+	"goga/configs"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestBreaker(cfg configs.CircuitBreakerConfig) *circuitBreaker {
+	cb := &circuitBreaker{}
+	cb.init("pool", "10.0.0.1:80", cfg)
+	return cb
+}
+
+func TestCircuitBreaker_StateTransitions(t *testing.T) {
+	cb := newTestBreaker(configs.CircuitBreakerConfig{FailureThreshold: 3, OpenTimeout: time.Second, HalfOpenRequests: 2})
+	now := time.Now().UnixNano()
+
+	// 关闭状态：成功会清零连续失败次数
+	cb.onFailure(now)
+	cb.onFailure(now)
+	cb.onSuccess()
+	cb.onFailure(now)
+	cb.onFailure(now)
+	assert.Equal(t, "closed", cb.stateName(now))
+	assert.True(t, cb.allow(now))
+
+	// 连续失败达到阈值后打开，拒绝所有请求
+	cb.onFailure(now)
+	assert.Equal(t, "open", cb.stateName(now))
+	assert.False(t, cb.allow(now))
+	assert.False(t, cb.ready(now))
+
+	// 超时后进入半开状态，只放行配置数量的试探请求
+	later := now + int64(time.Second)
+	assert.True(t, cb.ready(later))
+	assert.True(t, cb.allow(later))
+	assert.True(t, cb.allow(later))
+	assert.False(t, cb.allow(later), "试探名额已用完")
+	assert.Equal(t, "half_open", cb.stateName(later))
+
+	// 取消的试探请求归还名额
+	cb.onCancel()
+	assert.True(t, cb.allow(later))
+
+	// 所有试探请求成功后关闭
+	cb.onSuccess()
+	assert.Equal(t, "half_open", cb.stateName(later))
+	cb.onSuccess()
+	assert.Equal(t, "closed", cb.stateName(later))
+}
+
+func TestCircuitBreaker_HalfOpenFailureReopens(t *testing.T) {
+	cb := newTestBreaker(configs.CircuitBreakerConfig{FailureThreshold: 1, OpenTimeout: time.Second})
+	now := time.Now().UnixNano()
+
+	cb.onFailure(now)
+	later := now + int64(time.Second)
+	assert.True(t, cb.allow(later))
+
+	cb.onFailure(later)
+	assert.Equal(t, "open", cb.stateName(later))
+	assert.False(t, cb.allow(later+int64(time.Second)/2), "重新打开后应等待新的超时")
+	assert.True(t, cb.allow(later+int64(time.Second)))
+}
+
+func TestCircuitBreaker_Disabled(t *testing.T) {
+	cb := newTestBreaker(configs.CircuitBreakerConfig{FailureThreshold: -1})
+	now := time.Now().UnixNano()
+	for i := 0; i < 100; i++ {
+		cb.onFailure(now)
+	}
+	assert.True(t, cb.allow(now))
+	assert.Equal(t, "closed", cb.stateName(now))
+}
+
+func TestRetryBudget(t *testing.T) {
+	now := time.Now()
+	rb := newRetryBudget(configs.RetryConfig{BudgetRatio: 0.5, MinRetriesPerSecond: 1})
+	rb.last = now
+
+	// 初始额度为一秒的最低重试次数
+	assert.True(t, rb.withdraw(now))
+	assert.False(t, rb.withdraw(now))
+
+	// 每个请求积累 ratio 次额度
+	rb.deposit()
+	assert.False(t, rb.withdraw(now))
+	rb.deposit()
+	assert.True(t, rb.withdraw(now))
+
+	// 随时间补充最低重试次数，但不超过上限
+	assert.True(t, rb.withdraw(now.Add(time.Second)))
+	assert.False(t, rb.withdraw(now.Add(time.Second)))
+	later := now.Add(time.Hour)
+	for i := 0; i < retryBudgetWindow; i++ {
+		assert.True(t, rb.withdraw(later))
+	}
+	assert.False(t, rb.withdraw(later))
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package gateway
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"goga/internal/middleware"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 转发给后端的客户端证书身份头
+const (
+	clientCertHeaderPrefix    = "X-Client-Cert-"
+	clientCertSubjectHeader   = "X-Client-Cert-Subject"
+	clientCertSANHeader       = "X-Client-Cert-San"
+	clientCertFPHeader        = "X-Client-Cert-Fingerprint"
+	clientCertTimeHeader      = "X-Client-Cert-Timestamp"
+	clientCertSignatureHeader = "X-Client-Cert-Signature"
+)
+
+// setClientCertHeaders 为转发给后端的请求设置客户端证书身份头。in 是网关收到的请求，out 是转发请求的头部。
+// 客户端自带的 X-Client-Cert-* 头一律删除，后端看到的身份头只可能由网关生成。
+// 配置了 secret 时附带时间戳和 HMAC-SHA256 签名，签名内容为
+// Subject、SAN、指纹和时间戳以换行符连接的字符串，签名以小写十六进制表示。
+func setClientCertHeaders(out http.Header, in *http.Request, secret []byte) {
+	for name := range out {
+		if strings.HasPrefix(name, clientCertHeaderPrefix) {
+			out.Del(name)
+		}
+	}
+
+	id, ok := middleware.ClientCert(in)
+	if !ok {
+		return
+	}
+	sans := strings.Join(id.SANs, ", ")
+	out.Set(clientCertSubjectHeader, id.Subject)
+	out.Set(clientCertSANHeader, sans)
+	out.Set(clientCertFPHeader, id.Fingerprint)
+	if len(secret) == 0 {
+		return
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(id.Subject + "\n" + sans + "\n" + id.Fingerprint + "\n" + timestamp))
+	out.Set(clientCertTimeHeader, timestamp)
+	out.Set(clientCertSignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+}
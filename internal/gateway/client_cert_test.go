@@ -0,0 +1,124 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package gateway
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"goga/configs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newVerifiedClientState 返回一个已校验客户端证书 (CN=goga) 的 TLS 连接状态。
+func newVerifiedClientState(t *testing.T) *tls.ConnectionState {
+	t.Helper()
+	_, certFile, keyFile := newClientCertificate(t)
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	require.NoError(t, err)
+	return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{pair.Leaf}}}
+}
+
+// clientCertHeaderNames 是网关为后端生成的客户端证书身份头
+var clientCertHeaderNames = []string{clientCertSubjectHeader, clientCertSANHeader, clientCertFPHeader, clientCertTimeHeader, clientCertSignatureHeader}
+
+func TestProxy_ClientCertHeaders(t *testing.T) {
+	backend := newHeaderEchoBackend(clientCertHeaderNames...)
+	defer backend.Close()
+	cfg := newTestConfig(backend.URL)
+	cfg.Upstream.ClientCertHeaders.Secret = "s3cret"
+	proxy := newTestProxy(t, cfg)
+
+	do := func(state *tls.ConnectionState) map[string]string {
+		req := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+		req.TLS = state
+		req.Header.Set(clientCertSubjectHeader, "CN=spoofed")
+		req.Header.Set(clientCertSignatureHeader, "forged")
+		rr := httptest.NewRecorder()
+		proxy.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+		var echoed map[string][]string
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &echoed))
+		seen := make(map[string]string)
+		for name, values := range echoed {
+			if len(values) > 0 {
+				seen[name] = values[0]
+			}
+		}
+		return seen
+	}
+
+	assert.Empty(t, do(&tls.ConnectionState{}), "客户端伪造的身份头应被删除")
+
+	seen := do(newVerifiedClientState(t))
+	assert.Equal(t, "CN=goga", seen[clientCertSubjectHeader])
+	assert.Len(t, seen[clientCertFPHeader], 64)
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(seen[clientCertSubjectHeader] + "\n" + seen[clientCertSANHeader] + "\n" + seen[clientCertFPHeader] + "\n" + seen[clientCertTimeHeader]))
+	assert.Equal(t, hex.EncodeToString(mac.Sum(nil)), seen[clientCertSignatureHeader], "后端应能用共享密钥校验签名")
+}
+
+func TestProxy_RouteClientCert(t *testing.T) {
+	backend := newHeaderEchoBackend(clientCertHeaderNames...)
+	defer backend.Close()
+	cfg := newTestConfig("")
+	cfg.Upstream.Backends = []configs.BackendConfig{{Name: "app", URL: backend.URL}}
+	cfg.Routes = []configs.RouteConfig{
+		{PathPrefix: "/partner", ClientCert: configs.ClientCertConfig{AllowedNames: []string{"goga"}}},
+		{PathPrefix: "/"},
+	}
+	proxy := newTestProxy(t, cfg)
+	verified := newVerifiedClientState(t)
+
+	testCases := []struct {
+		name   string
+		path   string
+		state  *tls.ConnectionState
+		status int
+	}{
+		{"合作方路由未出示证书", "/partner/orders", nil, http.StatusForbidden},
+		{"合作方路由出示允许的证书", "/partner/orders", verified, http.StatusOK},
+		{"其他路由不要求证书", "/home", nil, http.StatusOK},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "https://example.com"+tc.path, nil)
+			req.TLS = tc.state
+			rr := httptest.NewRecorder()
+			proxy.ServeHTTP(rr, req)
+			assert.Equal(t, tc.status, rr.Code)
+		})
+	}
+}
+
+func TestKeyDistribution_ClientCertPolicy(t *testing.T) {
+	cfg := newTestConfig("")
+	cfg.Encryption.KeyIssuance.ClientCert = configs.ClientCertConfig{AllowedNames: []string{"goga"}}
+	keyCache := NewInMemoryKeyCache(time.Minute)
+	defer keyCache.Stop()
+	router, err := NewRouter(cfg, keyCache)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "https://example.com"+KeyEndpointPath, nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code, "未出示客户端证书时不应分发密钥")
+
+	req.TLS = newVerifiedClientState(t)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"token"`)
+}
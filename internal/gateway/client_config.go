@@ -0,0 +1,119 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"goga/configs"
+	"net"
+	"strings"
+)
+
+// clientConfigElementID 是注入页面的 JSON 配置块的元素 ID，goga.js 通过它读取服务端下发的策略。
+const clientConfigElementID = "goga-config"
+
+// clientConfig 是下发给浏览器端 goga.js 的加密策略。
+// JSON 字段名需要与 goga.js 中读取的字段保持一致。
+type clientConfig struct {
+	KeyEndpoint       string   `json:"keyEndpoint"`
+	Methods           []string `json:"methods"`
+	ExcludeURLs       []string `json:"excludeUrls"`
+	IncludeURLs       []string `json:"includeUrls"`
+	MustEncryptRoutes []string `json:"mustEncryptRoutes"`
+}
+
+// injectionContent 保存预先生成的注入内容 (JSON 配置块 + 脚本标签)。
+// 内容在启动时按 Host 一次性生成，响应处理路径上只做一次 map 查找。
+type injectionContent struct {
+	defaultContent []byte
+	byHost         map[string][]byte
+}
+
+// newInjectionContent 根据配置生成默认及各 Host 专属的注入内容。
+func newInjectionContent(cfg *configs.Config) (*injectionContent, error) {
+	defaultContent, err := buildInjectionContent(cfg, cfg.ScriptInjection.ClientConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	ic := &injectionContent{
+		defaultContent: defaultContent,
+		byHost:         make(map[string][]byte, len(cfg.ScriptInjection.HostClientConfigs)),
+	}
+	for host, override := range cfg.ScriptInjection.HostClientConfigs {
+		merged := cfg.ScriptInjection.ClientConfig
+		if override.ExcludeURLs != nil {
+			merged.ExcludeURLs = override.ExcludeURLs
+		}
+		if override.IncludeURLs != nil {
+			merged.IncludeURLs = override.IncludeURLs
+		}
+		content, err := buildInjectionContent(cfg, merged)
+		if err != nil {
+			return nil, fmt.Errorf("为 Host %s 生成客户端配置失败: %w", host, err)
+		}
+		ic.byHost[strings.ToLower(host)] = content
+	}
+	return ic, nil
+}
+
+// forHost 返回指定 Host 的注入内容，未单独配置的 Host 使用默认内容。
+// 先按完整 Host (可能带端口) 查找，再按去掉端口后的主机名查找。
+func (ic *injectionContent) forHost(host string) []byte {
+	if len(ic.byHost) > 0 {
+		host = strings.ToLower(host)
+		if content, ok := ic.byHost[host]; ok {
+			return content
+		}
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			if content, ok := ic.byHost[hostname]; ok {
+				return content
+			}
+		}
+	}
+	return ic.defaultContent
+}
+
+// buildInjectionContent 生成 "<script type=application/json> 配置块 + script_content" 形式的注入内容。
+// 使用非执行型的 JSON 数据块，既不受 CSP 的内联脚本限制，也不会污染页面的全局变量。
+func buildInjectionContent(cfg *configs.Config, cc configs.ClientConfig) ([]byte, error) {
+	methods := cfg.Encryption.Methods
+	if len(methods) == 0 {
+		methods = []string{"POST"}
+	}
+
+	payload := clientConfig{
+		KeyEndpoint:       KeyEndpointPath,
+		Methods:           nonNil(methods),
+		ExcludeURLs:       nonNil(cc.ExcludeURLs),
+		IncludeURLs:       nonNil(cc.IncludeURLs),
+		MustEncryptRoutes: nonNil(cfg.Encryption.MustEncryptRoutes),
+	}
+
+	// json.Marshal 默认会转义 <、> 和 &，因此内容不会提前闭合 script 标签
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("序列化客户端配置失败: %w", err)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(`<script type="application/json" id="`)
+	sb.WriteString(clientConfigElementID)
+	sb.WriteString(`">`)
+	sb.Write(data)
+	sb.WriteString(`</script>`)
+	sb.WriteString(cfg.ScriptInjection.ScriptContent)
+	return []byte(sb.String()), nil
+}
+
+// nonNil 确保切片序列化为 [] 而不是 null，便于客户端直接遍历。
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package gateway
+
+import (
+	"encoding/json"
+	"goga/configs"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// parseInjectedConfig 从注入内容中提取并解析 JSON 配置块。
+func parseInjectedConfig(t *testing.T, content []byte) clientConfig {
+	t.Helper()
+	s := string(content)
+	start := strings.Index(s, `">`)
+	end := strings.Index(s, `</script>`)
+	require.True(t, start != -1 && end > start, "注入内容中应包含 JSON 配置块: %s", s)
+
+	var cc clientConfig
+	require.NoError(t, json.Unmarshal([]byte(s[start+2:end]), &cc))
+	return cc
+}
+
+func TestInjectionContent(t *testing.T) {
+	cfg := &configs.Config{
+		Encryption: configs.EncryptionConfig{
+			Enabled:           true,
+			MustEncryptRoutes: []string{"^/api/v1/user/profile$"},
+			Methods:           []string{"POST", "PUT"},
+		},
+		ScriptInjection: configs.ScriptInjectionConfig{
+			ScriptContent: `<script src="/goga.min.js" defer></script>`,
+			ClientConfig: configs.ClientConfig{
+				ExcludeURLs: []string{"^/public/"},
+			},
+			HostClientConfigs: map[string]configs.ClientConfig{
+				"admin.example.com": {IncludeURLs: []string{"^/admin/api/"}},
+			},
+		},
+	}
+
+	content, err := newInjectionContent(cfg)
+	require.NoError(t, err)
+
+	t.Run("默认内容包含配置块和脚本标签", func(t *testing.T) {
+		got := content.forHost("www.example.com")
+		assert.True(t, strings.HasPrefix(string(got), `<script type="application/json" id="goga-config">`))
+		assert.True(t, strings.HasSuffix(string(got), cfg.ScriptInjection.ScriptContent))
+
+		cc := parseInjectedConfig(t, got)
+		assert.Equal(t, KeyEndpointPath, cc.KeyEndpoint)
+		assert.Equal(t, []string{"POST", "PUT"}, cc.Methods)
+		assert.Equal(t, []string{"^/public/"}, cc.ExcludeURLs)
+		assert.Equal(t, []string{}, cc.IncludeURLs)
+		assert.Equal(t, []string{"^/api/v1/user/profile$"}, cc.MustEncryptRoutes)
+	})
+
+	t.Run("按 Host 覆盖，且忽略端口和大小写", func(t *testing.T) {
+		cc := parseInjectedConfig(t, content.forHost("Admin.Example.com:8443"))
+		assert.Equal(t, []string{"^/admin/api/"}, cc.IncludeURLs)
+		// 未覆盖的字段继承默认配置
+		assert.Equal(t, []string{"^/public/"}, cc.ExcludeURLs)
+	})
+}
+
+func TestInjectionContent_EscapesHTML(t *testing.T) {
+	cfg := &configs.Config{
+		ScriptInjection: configs.ScriptInjectionConfig{
+			ClientConfig: configs.ClientConfig{
+				ExcludeURLs: []string{"</script><script>alert(1)</script>"},
+			},
+		},
+	}
+
+	content, err := newInjectionContent(cfg)
+	require.NoError(t, err)
+
+	got := string(content.forHost("example.com"))
+	assert.Equal(t, 1, strings.Count(got, "</script>"), "配置中的 HTML 字符必须被转义，不能提前闭合 script 标签")
+	assert.Equal(t, []string{"POST"}, parseInjectedConfig(t, content.forHost("example.com")).Methods, "未配置方法时默认为 POST")
+}
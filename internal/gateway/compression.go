@@ -0,0 +1,365 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package gateway
+
+import (
+	"bufio"
+	"fmt"
+	"goga/configs"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// 注入后 HTML 的压缩模式
+const (
+	compressionModePreserve  = "preserve"  // 使用后端原来的编码重新压缩 (默认)
+	compressionModeIdentity  = "identity"  // 不再压缩，直接发送注入后的明文 HTML
+	compressionModeNegotiate = "negotiate" // 按客户端 Accept-Encoding 选择开销更小的编码
+)
+
+// negotiatePreference 是 negotiate 模式下的编码优先级，按压缩开销从低到高排列。
+var negotiatePreference = []string{"zstd", "gzip", "br"}
+
+// maxContentEncodings 限制 Content-Encoding 链的最大层数，超过时视为不支持。
+const maxContentEncodings = 3
+
+// encoder 是所有可池化压缩器的公共接口。
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// decoder 是所有可池化解压器的公共接口。
+type decoder interface {
+	io.Reader
+	Reset(r io.Reader) error
+}
+
+// lz4ReaderAdapter 为 lz4.Reader 补齐带 error 返回值的 Reset 方法。
+type lz4ReaderAdapter struct{ *lz4.Reader }
+
+func (a lz4ReaderAdapter) Reset(r io.Reader) error {
+	a.Reader.Reset(r)
+	return nil
+}
+
+// gzipReaderAdapter 使 gzip.Reader 可以用 Reset(nil) 解除对上游的引用。
+// gzip.Reader.Reset 会立即读取头部，直接传入 nil 会导致 panic。
+type gzipReaderAdapter struct{ *gzip.Reader }
+
+func (a gzipReaderAdapter) Reset(r io.Reader) error {
+	if r == nil {
+		return nil
+	}
+	return a.Reader.Reset(r)
+}
+
+// deflateDecoder 解码 HTTP deflate 编码。
+// RFC 9110 规定 deflate 为 zlib 格式，但部分服务器发送的是裸 deflate 流，
+// 因此根据前两个字节是否为合法的 zlib 头部选择解码方式。
+type deflateDecoder struct {
+	br  *bufio.Reader
+	zr  io.ReadCloser // zlib 解码器，首次使用时创建
+	fr  io.ReadCloser // 裸 deflate 解码器，首次使用时创建
+	cur io.Reader
+}
+
+func (d *deflateDecoder) Read(p []byte) (int, error) {
+	if d.cur == nil {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return d.cur.Read(p)
+}
+
+func (d *deflateDecoder) Reset(r io.Reader) error {
+	d.br.Reset(r)
+	d.cur = nil
+	if r == nil {
+		return nil
+	}
+
+	if isZlibHeader(d.br) {
+		if d.zr == nil {
+			zr, err := zlib.NewReader(d.br)
+			if err != nil {
+				return err
+			}
+			d.zr = zr
+		} else if err := d.zr.(zlib.Resetter).Reset(d.br, nil); err != nil {
+			return err
+		}
+		d.cur = d.zr
+		return nil
+	}
+
+	if d.fr == nil {
+		d.fr = flate.NewReader(d.br)
+	} else if err := d.fr.(flate.Resetter).Reset(d.br, nil); err != nil {
+		return err
+	}
+	d.cur = d.fr
+	return nil
+}
+
+// isZlibHeader 判断流的前两个字节是否为 zlib 头部 (RFC 1950)：
+// CM 为 8 (deflate)，且 CMF*256+FLG 是 31 的倍数。
+func isZlibHeader(br *bufio.Reader) bool {
+	hdr, err := br.Peek(2)
+	if err != nil {
+		return false
+	}
+	return hdr[0]&0x0f == 8 && (uint16(hdr[0])<<8|uint16(hdr[1]))%31 == 0
+}
+
+// decoderPools 按编码缓存解压器。解压器与配置无关，因此全局共享。
+var decoderPools = map[string]*sync.Pool{
+	"gzip": {New: func() any { return gzipReaderAdapter{new(gzip.Reader)} }},
+	"br":   {New: func() any { return brotli.NewReader(nil) }},
+	"zstd": {New: func() any {
+		// 并发度为 1 时 zstd 以同步方式解码，不会为每个流启动后台 goroutine
+		d, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		return d
+	}},
+	"lz4":     {New: func() any { return lz4ReaderAdapter{lz4.NewReader(nil)} }},
+	"deflate": {New: func() any { return &deflateDecoder{br: bufio.NewReader(nil)} }},
+}
+
+// pooledDecoder 在 Close 时将解压器归还到池中。
+type pooledDecoder struct {
+	decoder
+	pool *sync.Pool
+}
+
+func (pd *pooledDecoder) Close() error {
+	if pd.decoder == nil {
+		return nil
+	}
+	// 解除对上游 reader 的引用，避免池中对象持有已结束的响应体
+	pd.decoder.Reset(nil)
+	pd.pool.Put(pd.decoder)
+	pd.decoder = nil
+	return nil
+}
+
+// getDecompressionReader 从池中取出一个解压器并绑定到 upstream。
+// 不支持的编码返回 (nil, nil)。
+func getDecompressionReader(encoding string, upstream io.Reader) (io.ReadCloser, error) {
+	pool, ok := decoderPools[encoding]
+	if !ok {
+		return nil, nil
+	}
+	d := pool.Get().(decoder)
+	if err := d.Reset(upstream); err != nil {
+		pool.Put(d)
+		return nil, err
+	}
+	return &pooledDecoder{decoder: d, pool: pool}, nil
+}
+
+// parseContentEncoding 将 Content-Encoding 头部解析为按施加顺序排列的编码列表，
+// 例如 "gzip, br" 表示先 gzip 再 br。identity 会被忽略，x-gzip 视为 gzip。
+func parseContentEncoding(values []string) []string {
+	var encodings []string
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			enc := strings.ToLower(strings.TrimSpace(part))
+			switch enc {
+			case "", "identity":
+				continue
+			case "x-gzip":
+				enc = "gzip"
+			}
+			encodings = append(encodings, enc)
+		}
+	}
+	return encodings
+}
+
+// unsupportedEncoding 返回编码链中第一个无法处理的编码。
+// 全部支持时返回空字符串；层数超过 maxContentEncodings 时返回整条编码链。
+func unsupportedEncoding(encodings []string) string {
+	if len(encodings) > maxContentEncodings {
+		return strings.Join(encodings, ", ")
+	}
+	for _, enc := range encodings {
+		if _, ok := decoderPools[enc]; !ok {
+			return enc
+		}
+	}
+	return ""
+}
+
+// newDecodingChain 按与施加顺序相反的顺序逐层解压，返回最内层的明文 reader
+// 以及需要在结束时关闭的解压器。调用方需先用 unsupportedEncoding 检查编码链。
+func newDecodingChain(encodings []string, upstream io.Reader) (io.Reader, []io.Closer, error) {
+	reader := upstream
+	closers := make([]io.Closer, 0, len(encodings))
+	for i := len(encodings) - 1; i >= 0; i-- {
+		d, err := getDecompressionReader(encodings[i], reader)
+		if err == nil && d == nil {
+			err = fmt.Errorf("不支持的编码: %s", encodings[i])
+		}
+		if err != nil {
+			for _, c := range closers {
+				c.Close()
+			}
+			return nil, nil, err
+		}
+		// 内层解压器先关闭，再关闭外层
+		closers = append([]io.Closer{d}, closers...)
+		reader = d
+	}
+	return reader, closers, nil
+}
+
+// compressionPools 按编码缓存压缩器。压缩级别来自配置，因此每个代理实例持有自己的池。
+type compressionPools struct {
+	mode  string
+	pools map[string]*sync.Pool
+}
+
+// newCompressionPools 根据配置创建压缩器池，未配置或超出范围的级别使用各编码的推荐值。
+func newCompressionPools(cfg configs.CompressionConfig) *compressionPools {
+	// deflate 与 gzip 使用相同的压缩算法，因此共用 gzip_level
+	gzipLevel := cfg.GzipLevel
+	if gzipLevel < gzip.HuffmanOnly || gzipLevel > gzip.BestCompression || gzipLevel == 0 {
+		gzipLevel = gzip.DefaultCompression
+	}
+	brotliLevel := cfg.BrotliLevel
+	if brotliLevel <= brotli.BestSpeed || brotliLevel > brotli.BestCompression {
+		// brotli 默认级别 (6) 对实时响应来说 CPU 开销过高，这里默认使用 4
+		brotliLevel = 4
+	}
+	zstdLevel := zstd.SpeedDefault
+	if cfg.ZstdLevel > 0 {
+		zstdLevel = zstd.EncoderLevelFromZstd(cfg.ZstdLevel)
+	}
+
+	mode := strings.ToLower(cfg.Mode)
+	if mode == "" {
+		mode = compressionModePreserve
+	}
+
+	return &compressionPools{
+		mode: mode,
+		pools: map[string]*sync.Pool{
+			"gzip": {New: func() any {
+				w, _ := gzip.NewWriterLevel(nil, gzipLevel)
+				return w
+			}},
+			"br": {New: func() any { return brotli.NewWriterLevel(nil, brotliLevel) }},
+			"zstd": {New: func() any {
+				w, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstdLevel), zstd.WithEncoderConcurrency(1))
+				return w
+			}},
+			"lz4": {New: func() any { return lz4.NewWriter(nil) }},
+			"deflate": {New: func() any {
+				w, _ := zlib.NewWriterLevel(nil, gzipLevel)
+				return w
+			}},
+		},
+	}
+}
+
+// pooledEncoder 在 Close 时写出压缩流的结尾并将压缩器归还到池中。
+type pooledEncoder struct {
+	encoder
+	pool *sync.Pool
+}
+
+func (pe *pooledEncoder) Close() error {
+	if pe.encoder == nil {
+		return nil
+	}
+	err := pe.encoder.Close()
+	pe.encoder.Reset(nil)
+	pe.pool.Put(pe.encoder)
+	pe.encoder = nil
+	return err
+}
+
+// getCompressionWriter 从池中取出一个压缩器并绑定到 downstream。
+// 不支持的编码返回一个不做压缩的 writer。
+func (cp *compressionPools) getCompressionWriter(encoding string, downstream io.Writer) encoder {
+	pool, ok := cp.pools[encoding]
+	if !ok {
+		return &nopEncoder{downstream}
+	}
+	e := pool.Get().(encoder)
+	e.Reset(downstream)
+	return &pooledEncoder{encoder: e, pool: pool}
+}
+
+// outputEncoding 根据压缩模式和客户端的 Accept-Encoding 决定注入后响应使用的编码。
+// originalEncoding 为后端编码链中最外层的编码，preserve 模式下只用它重新压缩一次，
+// 多层编码叠加几乎不会进一步减小体积。返回空字符串表示不压缩。
+func (cp *compressionPools) outputEncoding(originalEncoding, acceptEncoding string) string {
+	switch cp.mode {
+	case compressionModeIdentity:
+		return ""
+	case compressionModeNegotiate:
+		accepted := parseAcceptEncoding(acceptEncoding)
+		for _, enc := range negotiatePreference {
+			if accepted.allows(enc) {
+				return enc
+			}
+		}
+		return ""
+	default:
+		return originalEncoding
+	}
+}
+
+// acceptEncoding 是解析后的 Accept-Encoding 头部，值为各编码的 q 值。
+type acceptEncoding map[string]float64
+
+// parseAcceptEncoding 解析 Accept-Encoding 头部，例如 "gzip, br;q=0.8, *;q=0"。
+func parseAcceptEncoding(header string) acceptEncoding {
+	accepted := make(acceptEncoding)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		accepted[name] = q
+	}
+	return accepted
+}
+
+// allows 判断客户端是否接受指定编码。
+func (a acceptEncoding) allows(encoding string) bool {
+	if q, ok := a[encoding]; ok {
+		return q > 0
+	}
+	if q, ok := a["*"]; ok {
+		return q > 0
+	}
+	return false
+}
+
+// nopEncoder 是一个不执行任何压缩的 encoder，用于在没有压缩时包装 io.Writer。
+type nopEncoder struct{ io.Writer }
+
+func (ne *nopEncoder) Close() error      { return nil }
+func (ne *nopEncoder) Flush() error      { return nil }
+func (ne *nopEncoder) Reset(w io.Writer) { ne.Writer = w }
@@ -0,0 +1,345 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package gateway
+
+import (
+	"bytes"
+	"fmt"
+	"goga/configs"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// compressForTest 使用全新的压缩器压缩数据，作为独立于池实现的参照。
+func compressForTest(t testing.TB, encoding string, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	case "zstd":
+		zw, err := zstd.NewWriter(&buf)
+		require.NoError(t, err)
+		w = zw
+	case "lz4":
+		w = lz4.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw-deflate":
+		// 部分服务器在 deflate 编码下发送不带 zlib 头部的裸 deflate 流
+		fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		require.NoError(t, err)
+		w = fw
+	default:
+		t.Fatalf("不支持的测试编码: %s", encoding)
+	}
+	_, err := w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+// compressChainForTest 按 Content-Encoding 链的顺序逐层压缩数据。
+func compressChainForTest(t testing.TB, encodings []string, data []byte) []byte {
+	t.Helper()
+	for _, enc := range encodings {
+		data = compressForTest(t, enc, data)
+	}
+	return data
+}
+
+// decompressForTest 解压数据，编码为空时原样返回。
+func decompressForTest(t testing.TB, encoding string, data []byte) string {
+	t.Helper()
+	if encoding == "" {
+		return string(data)
+	}
+	r, closers, err := newDecodingChain(parseContentEncoding([]string{encoding}), bytes.NewReader(data))
+	require.NoError(t, err)
+	defer func() {
+		for _, c := range closers {
+			c.Close()
+		}
+	}()
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(out)
+}
+
+// newCompressedResponse 构造一个带压缩响应体的后端响应。
+func newCompressedResponse(t testing.TB, encoding, acceptEncoding string, page []byte) *http.Response {
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Request:    req,
+	}
+	resp.Header.Set("Content-Type", "text/html")
+	body := page
+	if encoding != "" {
+		body = compressForTest(t, encoding, page)
+		resp.Header.Set("Content-Encoding", encoding)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	return resp
+}
+
+func TestRewriteHTMLResponse_Modes(t *testing.T) {
+	script := []byte(`<script src="/goga.min.js"></script>`)
+	page := []byte(strings.Repeat("<p>content</p>", 2000) + "</body></html>")
+	expected := strings.Repeat("<p>content</p>", 2000) + string(script) + "</body></html>"
+
+	testCases := []struct {
+		name           string
+		mode           string
+		inputEncoding  string
+		acceptEncoding string
+		wantEncoding   string
+	}{
+		{"preserve gzip", compressionModePreserve, "gzip", "gzip", "gzip"},
+		{"preserve br", compressionModePreserve, "br", "br", "br"},
+		{"preserve zstd", compressionModePreserve, "zstd", "zstd", "zstd"},
+		{"preserve lz4", compressionModePreserve, "lz4", "lz4", "lz4"},
+		{"preserve deflate", compressionModePreserve, "deflate", "deflate", "deflate"},
+		{"preserve 未压缩", compressionModePreserve, "", "gzip", ""},
+		{"identity br", compressionModeIdentity, "br", "br, gzip", ""},
+		{"negotiate br -> zstd", compressionModeNegotiate, "br", "gzip, br, zstd", "zstd"},
+		{"negotiate br -> gzip", compressionModeNegotiate, "br", "gzip;q=0.5, br", "gzip"},
+		{"negotiate 客户端拒绝所有编码", compressionModeNegotiate, "gzip", "identity, *;q=0", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pools := newCompressionPools(configs.CompressionConfig{Mode: tc.mode})
+			resp := newCompressedResponse(t, tc.inputEncoding, tc.acceptEncoding, page)
+
+			rewritten, err := rewriteHTMLResponse(resp, script, pools)
+			require.NoError(t, err)
+			require.True(t, rewritten)
+
+			assert.Equal(t, tc.wantEncoding, resp.Header.Get("Content-Encoding"))
+			assert.Equal(t, int64(-1), resp.ContentLength)
+
+			out, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			assert.Equal(t, expected, decompressForTest(t, tc.wantEncoding, out))
+		})
+	}
+}
+
+func TestRewriteHTMLResponse_EncodingChains(t *testing.T) {
+	script := []byte(`<script src="/goga.min.js"></script>`)
+	page := []byte(strings.Repeat("<p>content</p>", 200) + "</body></html>")
+	expected := strings.Repeat("<p>content</p>", 200) + string(script) + "</body></html>"
+
+	testCases := []struct {
+		name         string
+		header       []string
+		layers       []string // 实际施加的压缩，与 header 不同时用于模拟裸 deflate
+		wantEncoding string
+	}{
+		{"裸 deflate 流", []string{"deflate"}, []string{"raw-deflate"}, "deflate"},
+		{"x-gzip 视为 gzip", []string{"x-gzip"}, []string{"gzip"}, "gzip"},
+		{"逗号分隔的编码链", []string{"gzip, br"}, []string{"gzip", "br"}, "br"},
+		{"多个头部组成的编码链", []string{"deflate", "zstd"}, []string{"deflate", "zstd"}, "zstd"},
+		{"忽略 identity", []string{"identity, gzip"}, []string{"gzip"}, "gzip"},
+	}
+
+	pools := newCompressionPools(configs.CompressionConfig{})
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := newCompressedResponse(t, "", "", page)
+			body := compressChainForTest(t, tc.layers, page)
+			resp.Header["Content-Encoding"] = tc.header
+			resp.Body = io.NopCloser(bytes.NewReader(body))
+
+			rewritten, err := rewriteHTMLResponse(resp, script, pools)
+			require.NoError(t, err)
+			require.True(t, rewritten)
+
+			assert.Equal(t, []string{tc.wantEncoding}, resp.Header.Values("Content-Encoding"), "编码链应合并为单层编码")
+			out, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			assert.Equal(t, expected, decompressForTest(t, tc.wantEncoding, out))
+		})
+	}
+}
+
+func TestRewriteHTMLResponse_NonStreamingCompressionRatio(t *testing.T) {
+	script := []byte(`<script src="/goga.min.js"></script>`)
+	var page strings.Builder
+	page.WriteString("<html><body><table>")
+	for i := range 10000 {
+		fmt.Fprintf(&page, "<tr><td>%d</td><td>item-%x</td></tr>", i, uint32(i)*2654435761)
+	}
+	page.WriteString("</table></body></html>")
+	expected := strings.Replace(page.String(), "</body>", string(script)+"</body>", 1)
+
+	// 后端一次性返回长度已知的压缩页面，经过真实的 TCP 连接读取
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := compressForTest(t, "gzip", []byte(page.String()))
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		w.Write(body)
+	}))
+	defer backend.Close()
+	req, err := http.NewRequest(http.MethodGet, backend.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	pools := newCompressionPools(configs.CompressionConfig{})
+	rewritten, err := rewriteHTMLResponse(resp, script, pools)
+	require.NoError(t, err)
+	require.True(t, rewritten)
+	out, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, expected, decompressForTest(t, "gzip", out))
+
+	// 与使用同一压缩器一次性压缩的结果相比，大小相差不超过 1%
+	var single bytes.Buffer
+	enc := pools.getCompressionWriter("gzip", &single)
+	_, err = enc.Write([]byte(expected))
+	require.NoError(t, err)
+	require.NoError(t, enc.Close())
+	assert.InEpsilon(t, single.Len(), len(out), 0.01, "single=%d rewritten=%d", single.Len(), len(out))
+}
+
+func TestRewriteHTMLResponse_UnsupportedEncoding(t *testing.T) {
+	pools := newCompressionPools(configs.CompressionConfig{})
+	for _, header := range []string{"compress", "gzip, compress", "gzip, gzip, gzip, gzip"} {
+		t.Run(header, func(t *testing.T) {
+			body := []byte("opaque body")
+			resp := newCompressedResponse(t, "", "", body)
+			resp.Header.Set("Content-Encoding", header)
+
+			rewritten, err := rewriteHTMLResponse(resp, []byte("<script></script>"), pools)
+			require.NoError(t, err)
+			assert.False(t, rewritten)
+
+			// 响应应原样透传
+			assert.Equal(t, header, resp.Header.Get("Content-Encoding"))
+			assert.Equal(t, int64(len(body)), resp.ContentLength)
+			out, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, body, out)
+		})
+	}
+}
+
+func TestParseContentEncoding(t *testing.T) {
+	assert.Equal(t, []string{"gzip", "br"}, parseContentEncoding([]string{" GZIP ,br"}))
+	assert.Equal(t, []string{"deflate", "zstd"}, parseContentEncoding([]string{"deflate", "identity", "zstd"}))
+	assert.Empty(t, parseContentEncoding(nil))
+	assert.Equal(t, "compress", unsupportedEncoding([]string{"gzip", "compress"}))
+	assert.Equal(t, "", unsupportedEncoding([]string{"deflate", "br"}))
+}
+
+func TestParseAcceptEncoding(t *testing.T) {
+	accepted := parseAcceptEncoding("gzip, BR;q=0.8, zstd;q=0, *;q=0.1")
+	assert.True(t, accepted.allows("gzip"))
+	assert.True(t, accepted.allows("br"))
+	assert.False(t, accepted.allows("zstd"), "q=0 表示明确拒绝")
+	assert.True(t, accepted.allows("lz4"), "未列出的编码由 * 决定")
+	assert.False(t, parseAcceptEncoding("").allows("gzip"))
+}
+
+// legacyInjectCompressed 复现池化之前的实现：每个响应新建解压器、压缩器、goroutine 和 io.Pipe。
+func legacyInjectCompressed(encoding string, body io.Reader, script []byte) io.ReadCloser {
+	var reader io.Reader
+	switch encoding {
+	case "gzip":
+		reader, _ = gzip.NewReader(body)
+	case "br":
+		reader = brotli.NewReader(body)
+	}
+	injector := NewScriptInjector(reader, script)
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer pw.Close()
+		defer injector.Close()
+		var w io.WriteCloser
+		switch encoding {
+		case "gzip":
+			w = gzip.NewWriter(pw)
+		case "br":
+			w = brotli.NewWriter(pw)
+		}
+		defer w.Close()
+		buf := make([]byte, 32*1024)
+		io.CopyBuffer(w, injector, buf)
+	}()
+	return pr
+}
+
+func benchmarkInjection(b *testing.B, encoding string, pooled bool) {
+	script := []byte(`<script src="/goga.min.js" defer></script>`)
+	page := []byte(strings.Repeat("<div class=\"row\"><span>benchmark content</span></div>\n", 1000) + "</body></html>")
+	compressed := compressForTest(b, encoding, page)
+	pools := newCompressionPools(configs.CompressionConfig{})
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(page)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var body io.ReadCloser
+		if pooled {
+			resp := newCompressedResponseFromBytes(encoding, compressed)
+			if _, err := rewriteHTMLResponse(resp, script, pools); err != nil {
+				b.Fatal(err)
+			}
+			body = resp.Body
+		} else {
+			body = legacyInjectCompressed(encoding, bytes.NewReader(compressed), script)
+		}
+		if _, err := io.Copy(io.Discard, body); err != nil {
+			b.Fatal(err)
+		}
+		body.Close()
+	}
+}
+
+// newCompressedResponseFromBytes 是基准测试使用的轻量构造函数，避免在计时循环中重复压缩。
+func newCompressedResponseFromBytes(encoding string, compressed []byte) *http.Response {
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Encoding": {encoding}},
+		Request:    &http.Request{Header: http.Header{}},
+		Body:       io.NopCloser(bytes.NewReader(compressed)),
+	}
+	return resp
+}
+
+// go test -bench=Injection -benchmem ./internal/gateway/
+func BenchmarkInjectionGzipLegacy(b *testing.B)   { benchmarkInjection(b, "gzip", false) }
+func BenchmarkInjectionGzipPooled(b *testing.B)   { benchmarkInjection(b, "gzip", true) }
+func BenchmarkInjectionBrotliLegacy(b *testing.B) { benchmarkInjection(b, "br", false) }
+func BenchmarkInjectionBrotliPooled(b *testing.B) { benchmarkInjection(b, "br", true) }
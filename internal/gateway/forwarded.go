@@ -0,0 +1,122 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package gateway
+
+import (
+	"goga/internal/middleware"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// setForwardedHeaders 为转发给后端的请求设置 Forwarded (RFC 7239) 以及 X-Forwarded-For/Proto/Host/Port 头。
+// in 是网关收到的请求，out 是转发请求的头部 (WebSocket 握手时二者是同一个请求)。
+// 直接对端是受信任的代理时保留其转发信息并追加本跳；否则这些头部可能由客户端伪造，一律按本跳重新生成。
+func setForwardedHeaders(out http.Header, in *http.Request) {
+	peer := middleware.RemoteIP(in)
+	proto := "http"
+	if in.TLS != nil {
+		proto = "https"
+	}
+	host := in.Host
+	port := forwardedPort(in, proto)
+
+	// Forwarded 的每个元素描述一跳，先按本跳生成，再按需拼接前面代理的元素
+	forwarded := forwardedElement(peer, host, proto)
+	var xff []string
+	if middleware.FromTrustedProxy(in) {
+		if prior := strings.Join(in.Header.Values("Forwarded"), ", "); prior != "" {
+			forwarded = prior + ", " + forwarded
+		}
+		if prior := strings.Join(in.Header.Values("X-Forwarded-For"), ", "); prior != "" {
+			xff = append(xff, prior)
+		}
+		// 协议、Host 和端口描述客户端最初访问的地址，以最外层代理的记录为准
+		if v := in.Header.Get("X-Forwarded-Proto"); v != "" {
+			proto = v
+		}
+		if v := in.Header.Get("X-Forwarded-Host"); v != "" {
+			host = v
+		}
+		if v := in.Header.Get("X-Forwarded-Port"); v != "" {
+			port = v
+		}
+	}
+
+	// Unix 套接字的对端没有 IP 地址，X-Forwarded-For 中不记录本跳 (Forwarded 中记为 for=unknown)
+	if _, err := netip.ParseAddr(peer); err == nil {
+		xff = append(xff, peer)
+	}
+
+	out.Set("Forwarded", forwarded)
+	if len(xff) > 0 {
+		out.Set("X-Forwarded-For", strings.Join(xff, ", "))
+	} else {
+		out.Del("X-Forwarded-For")
+	}
+	out.Set("X-Forwarded-Proto", proto)
+	out.Set("X-Forwarded-Host", host)
+	out.Set("X-Forwarded-Port", port)
+}
+
+// forwardedPort 返回客户端连接的网关端口：优先使用监听地址，其次是 Host 中的端口，最后按协议取默认端口。
+func forwardedPort(r *http.Request, proto string) string {
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if _, port, err := net.SplitHostPort(addr.String()); err == nil {
+			return port
+		}
+	}
+	if _, port, err := net.SplitHostPort(r.Host); err == nil && port != "" {
+		return port
+	}
+	if proto == "https" {
+		return "443"
+	}
+	return "80"
+}
+
+// forwardedElement 生成 Forwarded 头中描述一跳的元素，例如 for=192.0.2.1;host=example.com;proto=https。
+func forwardedElement(peer, host, proto string) string {
+	node := "unknown"
+	if addr, err := netip.ParseAddr(peer); err == nil {
+		node = addr.String()
+		if addr.Is6() {
+			node = "[" + node + "]" // IPv6 地址必须加方括号，并因此需要加引号
+		}
+	}
+	element := "for=" + forwardedValue(node)
+	if host != "" {
+		element += ";host=" + forwardedValue(host)
+	}
+	return element + ";proto=" + forwardedValue(proto)
+}
+
+// forwardedValue 按 RFC 7239 的语法返回参数值：合法的 token 原样返回，否则使用带转义的 quoted-string。
+func forwardedValue(v string) string {
+	if v != "" && strings.IndexFunc(v, func(r rune) bool { return !isTokenChar(r) }) < 0 {
+		return v
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range v {
+		if r == '"' || r == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// isTokenChar 判断字符是否属于 RFC 9110 定义的 token 字符。
+func isTokenChar(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-.^_`|~", r)
+}
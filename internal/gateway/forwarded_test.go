@@ -0,0 +1,169 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package gateway
+
+import (
+	"bufio"
+	"encoding/json"
+	"goga/internal/middleware"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// forwardedHeaderNames 是网关为后端生成的转发头
+var forwardedHeaderNames = []string{"Forwarded", "X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host", "X-Forwarded-Port"}
+
+// newHeaderEchoBackend 创建一个以 JSON 返回所收到的指定请求头的模拟后端，同一头部的多个值都会保留。
+func newHeaderEchoBackend(names ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen := make(map[string][]string)
+		for _, name := range names {
+			seen[name] = r.Header.Values(name)
+		}
+		json.NewEncoder(w).Encode(seen)
+	}))
+}
+
+func TestProxy_ForwardedHeaders(t *testing.T) {
+	backend := newHeaderEchoBackend(forwardedHeaderNames...)
+	defer backend.Close()
+	proxy := newTestProxy(t, newTestConfig(backend.URL))
+
+	trusted, err := middleware.NewTrustedProxies([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		expected   map[string][]string
+	}{
+		{
+			name:       "不受信任的对端伪造的转发头被丢弃",
+			remoteAddr: "203.0.113.7:4000",
+			headers: map[string]string{
+				"X-Forwarded-For":   "1.2.3.4",
+				"Forwarded":         "for=1.2.3.4",
+				"X-Forwarded-Proto": "https",
+				"X-Forwarded-Host":  "evil.example",
+			},
+			expected: map[string][]string{
+				"Forwarded":         {"for=203.0.113.7;host=example.com;proto=http"},
+				"X-Forwarded-For":   {"203.0.113.7"},
+				"X-Forwarded-Proto": {"http"},
+				"X-Forwarded-Host":  {"example.com"},
+				"X-Forwarded-Port":  {"80"},
+			},
+		},
+		{
+			name:       "受信任的代理的转发头被保留并追加本跳",
+			remoteAddr: "10.0.0.2:4000",
+			headers: map[string]string{
+				"X-Forwarded-For":   "198.51.100.1",
+				"Forwarded":         "for=198.51.100.1;proto=https",
+				"X-Forwarded-Proto": "https",
+				"X-Forwarded-Host":  "shop.example.com",
+				"X-Forwarded-Port":  "443",
+			},
+			expected: map[string][]string{
+				"Forwarded":         {"for=198.51.100.1;proto=https, for=10.0.0.2;host=example.com;proto=http"},
+				"X-Forwarded-For":   {"198.51.100.1, 10.0.0.2"}, // 本跳只追加一次
+				"X-Forwarded-Proto": {"https"},
+				"X-Forwarded-Host":  {"shop.example.com"},
+				"X-Forwarded-Port":  {"443"},
+			},
+		},
+		{
+			name:       "Unix 套接字对端没有 IP 地址时不记录在 X-Forwarded-For 中",
+			remoteAddr: "@",
+			headers:    map[string]string{"X-Forwarded-For": "1.2.3.4"},
+			expected: map[string][]string{
+				"Forwarded":         {"for=unknown;host=example.com;proto=http"},
+				"X-Forwarded-For":   nil,
+				"X-Forwarded-Proto": {"http"},
+				"X-Forwarded-Host":  {"example.com"},
+				"X-Forwarded-Port":  {"80"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			req.RemoteAddr = tc.remoteAddr
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			rr := httptest.NewRecorder()
+			middleware.ClientIP(trusted)(proxy).ServeHTTP(rr, req)
+			require.Equal(t, http.StatusOK, rr.Code)
+
+			var seen map[string][]string
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &seen))
+			assert.Equal(t, tc.expected, seen)
+		})
+	}
+}
+
+func TestForwardedElement(t *testing.T) {
+	assert.Equal(t, "for=192.0.2.1;host=example.com;proto=https", forwardedElement("192.0.2.1", "example.com", "https"))
+	assert.Equal(t, `for="[2001:db8::1]";host="example.com:8443";proto=http`, forwardedElement("2001:db8::1", "example.com:8443", "http"))
+	assert.Equal(t, "for=unknown;proto=http", forwardedElement("@", "", "http"), "无法解析的对端地址记为 unknown")
+	assert.Equal(t, `"a\"b"`, forwardedValue(`a"b`))
+}
+
+func TestWebsocketProxy_ForwardedHeaders(t *testing.T) {
+	seen := make(chan http.Header, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- r.Header.Clone()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n"))
+	}))
+	defer backend.Close()
+
+	cfg := newTestConfig(backend.URL)
+	routes, err := NewRouteTable(cfg)
+	require.NoError(t, err)
+	defer routes.Stop()
+	proxyServer := httptest.NewServer(NewWebsocketProxy(http.NotFoundHandler(), cfg, routes))
+	defer proxyServer.Close()
+	proxyURL, err := url.Parse(proxyServer.URL)
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", proxyURL.Host)
+	require.NoError(t, err)
+	defer conn.Close()
+	req, err := http.NewRequest("GET", proxyServer.URL+"/ws", nil)
+	require.NoError(t, err)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Origin", "http://localhost")
+	req.Header.Set("X-Forwarded-For", "1.2.3.4")
+	require.NoError(t, req.Write(conn))
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	headers := <-seen
+	assert.Equal(t, "127.0.0.1", headers.Get("X-Forwarded-For"), "未经过受信任代理的握手请求应丢弃伪造的 X-Forwarded-For")
+	assert.Equal(t, `for=127.0.0.1;host="`+proxyURL.Host+`";proto=http`, headers.Get("Forwarded"))
+	assert.Equal(t, "http", headers.Get("X-Forwarded-Proto"))
+	assert.Equal(t, proxyURL.Host, headers.Get("X-Forwarded-Host"))
+	assert.Equal(t, proxyURL.Port(), headers.Get("X-Forwarded-Port"))
+}
@@ -17,6 +17,9 @@ import (
 	"time"
 )
 
+// KeyEndpointPath 是一次性密钥分发端点的路径，同时会通过客户端配置下发给 goga.js。
+const KeyEndpointPath = "/goga/api/v1/key"
+
 // Router 封装了网关的路由逻辑和依赖项。
 type Router struct {
 	mux         *http.ServeMux
@@ -35,8 +38,8 @@ func NewRouter(cfg *configs.Config, kc security.KeyCacher) (http.Handler, error)
 	}
 
 	// 注册 API 处理器
-	slog.Debug("注册 API 处理器", "path", "/goga/api/v1/key")
-	mux.HandleFunc("/goga/api/v1/key", r.keyDistributionHandler(cfg))
+	slog.Debug("注册 API 处理器", "path", KeyEndpointPath)
+	mux.HandleFunc(KeyEndpointPath, r.keyDistributionHandler(cfg))
 
 	// 注册静态脚本处理器
 	// 注意：这里的路径是 "/goga.min.js"，在 main.go 中需要确保它被正确代理
@@ -60,6 +63,11 @@ func (r *Router) keyDistributionHandler(cfg *configs.Config) http.HandlerFunc {
 			return
 		}
 
+		// 按密钥分发策略检查客户端证书，例如只向经过验证的合作方客户端分发密钥
+		if !middleware.EnforceClientCert(w, req, cfg.Encryption.KeyIssuance.ClientCert) {
+			return
+		}
+
 		// 1. 生成一个 32 字节的随机密钥 (用于 AES-256)
 		onetimeKey := make([]byte, 32)
 		if _, err := rand.Read(onetimeKey); err != nil {
@@ -78,8 +86,15 @@ func (r *Router) keyDistributionHandler(cfg *configs.Config) http.HandlerFunc {
 		// 将令牌编码为字符串格式，适合用作 map 键和在 JSON 中使用
 		token := base64.URLEncoding.EncodeToString(tokenBytes)
 
-		// 3. 将密钥以令牌为键存入缓存
-		r.keyCache.Set(token, onetimeKey, r.keyCacheTTL)
+		// 3. 将密钥以令牌为键存入缓存，同时记录签发对象，以便通过 admin API 按客户端 IP 或会话吊销
+		meta := security.KeyMetadata{ClientIP: middleware.GetClientIP(req)}
+		if name := cfg.Encryption.KeyIssuance.SessionCookie; name != "" {
+			if cookie, err := req.Cookie(name); err == nil && cookie.Value != "" {
+				meta.Session = security.SessionDigest(cookie.Value)
+			}
+		}
+		r.keyCache.Set(req.Context(), token, onetimeKey, r.keyCacheTTL, meta)
+		GlobalKeyMetrics.RecordIssued()
 		slog.Debug("生成并缓存了一次性密钥", "token", token)
 
 		// 4. 构建并发送 JSON 响应
@@ -0,0 +1,183 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package gateway
+
+import (
+	"fmt"
+	"goga/configs"
+	"mime"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// InjectHeader 是后端用于逐个响应控制脚本注入的响应头，取值为 "on" 或 "off"。
+// 该头部总会在响应返回给客户端之前被移除。
+const InjectHeader = "X-Goga-Inject"
+
+// injectionRule 是编译后的注入规则，未配置的条件视为匹配任意值。
+type injectionRule struct {
+	path         *regexp.Regexp
+	host         string
+	statusCodes  map[int]struct{}
+	contentTypes map[string]struct{}
+	inject       bool
+}
+
+// injectionPolicy 决定一个后端响应是否需要注入脚本。
+type injectionPolicy struct {
+	statusCodes  map[int]struct{}
+	contentTypes map[string]struct{}
+	rules        []injectionRule
+}
+
+// newInjectionPolicy 根据配置编译注入策略。无效的正则或动作会导致返回错误，以免静默地注入到不该注入的页面。
+func newInjectionPolicy(cfg configs.ScriptInjectionConfig) (*injectionPolicy, error) {
+	statusCodes := cfg.StatusCodes
+	if len(statusCodes) == 0 {
+		statusCodes = []int{http.StatusOK}
+	}
+	contentTypes := cfg.ContentTypes
+	if len(contentTypes) == 0 {
+		contentTypes = []string{"text/html"}
+	}
+
+	p := &injectionPolicy{
+		statusCodes:  toIntSet(statusCodes),
+		contentTypes: toMediaTypeSet(contentTypes),
+	}
+
+	for i, rc := range cfg.Rules {
+		rule := injectionRule{
+			host:         strings.ToLower(rc.Host),
+			statusCodes:  toIntSet(rc.StatusCodes),
+			contentTypes: toMediaTypeSet(rc.ContentTypes),
+		}
+		switch strings.ToLower(rc.Action) {
+		case "inject":
+			rule.inject = true
+		case "skip":
+			rule.inject = false
+		default:
+			return nil, fmt.Errorf("第 %d 条注入规则的 action 无效: %q (可选值: inject, skip)", i+1, rc.Action)
+		}
+		if rc.Path != "" {
+			re, err := regexp.Compile(rc.Path)
+			if err != nil {
+				return nil, fmt.Errorf("第 %d 条注入规则的 path 正则无效: %w", i+1, err)
+			}
+			rule.path = re
+		}
+		p.rules = append(p.rules, rule)
+	}
+	return p, nil
+}
+
+// decide 判断是否对响应进行注入，并返回用于日志的原因。
+// 优先级: 后端响应头 X-Goga-Inject > 按顺序匹配的第一条规则 > 默认的状态码和内容类型。
+func (p *injectionPolicy) decide(resp *http.Response) (bool, string) {
+	switch strings.ToLower(strings.TrimSpace(resp.Header.Get(InjectHeader))) {
+	case "on":
+		return true, "header_on"
+	case "off":
+		return false, "header_off"
+	}
+
+	mediaType := baseMediaType(resp.Header.Get("Content-Type"))
+	host, path := clientHostPath(resp.Request)
+	host = strings.ToLower(host)
+
+	for _, rule := range p.rules {
+		if rule.matches(host, path, resp.StatusCode, mediaType) {
+			if rule.inject {
+				return true, "rule_inject"
+			}
+			return false, "rule_skip"
+		}
+	}
+
+	if _, ok := p.statusCodes[resp.StatusCode]; !ok {
+		return false, "status_code"
+	}
+	if _, ok := p.contentTypes[mediaType]; !ok {
+		return false, "content_type"
+	}
+	return true, "default"
+}
+
+// clientHostPath 返回客户端请求的 Host 和路径。resp.Request 是转发给后端的请求，其路径已按路由改写
+// (strip_prefix、rewrite) 并拼接了后端的基础路径，因此优先使用 Rewrite 中记录的客户端原始值。
+func clientHostPath(out *http.Request) (string, string) {
+	if out == nil {
+		return "", ""
+	}
+	if sel := upstreamSelectionFrom(out.Context()); sel != nil && sel.clientPath != "" {
+		return sel.clientHost, sel.clientPath
+	}
+	return out.Host, out.URL.Path
+}
+
+// matches 检查规则的所有条件是否都满足。
+func (r *injectionRule) matches(host, path string, statusCode int, mediaType string) bool {
+	if r.host != "" && !matchHost(r.host, host) {
+		return false
+	}
+	if r.path != nil && !r.path.MatchString(path) {
+		return false
+	}
+	if len(r.statusCodes) > 0 {
+		if _, ok := r.statusCodes[statusCode]; !ok {
+			return false
+		}
+	}
+	if len(r.contentTypes) > 0 {
+		if _, ok := r.contentTypes[mediaType]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// matchHost 支持精确匹配和 "*.example.com" 形式的通配符匹配，请求 Host 中的端口会被忽略。
+func matchHost(pattern, host string) bool {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return pattern == host
+}
+
+// baseMediaType 返回去掉参数 (如 charset) 的小写媒体类型。
+func baseMediaType(contentType string) string {
+	if contentType == "" {
+		return ""
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// 无法解析时退化为分号前的部分
+		mediaType, _, _ = strings.Cut(contentType, ";")
+	}
+	return strings.ToLower(strings.TrimSpace(mediaType))
+}
+
+func toIntSet(values []int) map[int]struct{} {
+	set := make(map[int]struct{}, len(values))
+	for _, v := range values {
+		set[v] = struct{}{}
+	}
+	return set
+}
+
+func toMediaTypeSet(values []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		set[baseMediaType(v)] = struct{}{}
+	}
+	return set
+}
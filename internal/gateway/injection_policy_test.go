@@ -0,0 +1,115 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package gateway
+
+import (
+	"goga/configs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestResponse 构造一个用于策略判断的后端响应。
+func newTestResponse(host, path string, statusCode int, contentType string) *http.Response {
+	req := httptest.NewRequest(http.MethodGet, "http://"+host+path, nil)
+	resp := &http.Response{
+		StatusCode: statusCode,
+		Header:     make(http.Header),
+		Request:    req,
+	}
+	if contentType != "" {
+		resp.Header.Set("Content-Type", contentType)
+	}
+	return resp
+}
+
+func TestInjectionPolicy(t *testing.T) {
+	policy, err := newInjectionPolicy(configs.ScriptInjectionConfig{
+		StatusCodes:  []int{200, 404},
+		ContentTypes: []string{"text/html", "application/xhtml+xml"},
+		Rules: []configs.InjectionRuleConfig{
+			{Path: "^/admin/", Action: "skip"},
+			{Host: "*.preview.example.com", Action: "skip"},
+			{Path: "^/errors/", StatusCodes: []int{500, 502}, Action: "inject"},
+		},
+	})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name       string
+		resp       *http.Response
+		header     string
+		wantInject bool
+		wantReason string
+	}{
+		{"默认：200 HTML", newTestResponse("example.com", "/", 200, "text/html; charset=utf-8"), "", true, "default"},
+		{"默认：XHTML", newTestResponse("example.com", "/", 200, "application/xhtml+xml"), "", true, "default"},
+		{"默认：配置的错误页状态码", newTestResponse("example.com", "/missing", 404, "text/html"), "", true, "default"},
+		{"默认：未配置的状态码", newTestResponse("example.com", "/", 500, "text/html"), "", false, "status_code"},
+		{"默认：非 HTML", newTestResponse("example.com", "/", 200, "application/json"), "", false, "content_type"},
+		{"规则：管理后台跳过", newTestResponse("example.com", "/admin/users", 200, "text/html"), "", false, "rule_skip"},
+		{"规则：通配 Host 跳过", newTestResponse("mail.preview.example.com:8443", "/", 200, "text/html"), "", false, "rule_skip"},
+		{"规则：错误页注入", newTestResponse("example.com", "/errors/oops", 502, "text/html"), "", true, "rule_inject"},
+		{"规则：状态码不匹配时继续默认判断", newTestResponse("example.com", "/errors/oops", 503, "text/html"), "", false, "status_code"},
+		{"响应头 off 优先于默认", newTestResponse("example.com", "/", 200, "text/html"), "off", false, "header_off"},
+		{"响应头 on 优先于规则", newTestResponse("example.com", "/admin/", 200, "text/html"), "On", true, "header_on"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.header != "" {
+				tc.resp.Header.Set(InjectHeader, tc.header)
+			}
+			inject, reason := policy.decide(tc.resp)
+			assert.Equal(t, tc.wantInject, inject)
+			assert.Equal(t, tc.wantReason, reason)
+		})
+	}
+}
+
+func TestInjectionPolicy_InvalidConfig(t *testing.T) {
+	_, err := newInjectionPolicy(configs.ScriptInjectionConfig{
+		Rules: []configs.InjectionRuleConfig{{Path: "(", Action: "skip"}},
+	})
+	assert.Error(t, err, "无效的正则应返回错误")
+
+	_, err = newInjectionPolicy(configs.ScriptInjectionConfig{
+		Rules: []configs.InjectionRuleConfig{{Path: "^/", Action: "maybe"}},
+	})
+	assert.Error(t, err, "无效的 action 应返回错误")
+}
+
+func TestInjectionPolicy_MatchesClientPath(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><head></head><body>" + r.URL.Path + "</body></html>"))
+	}))
+	defer backend.Close()
+
+	cfg := newTestConfig("")
+	cfg.Encryption.Enabled = true
+	cfg.ScriptInjection.ScriptContent = `<script src="/goga.min.js"></script>`
+	cfg.ScriptInjection.Rules = []configs.InjectionRuleConfig{
+		{Host: "www.example.com", Path: "^/app/admin/", Action: "skip"},
+	}
+	cfg.Upstream.Backends = []configs.BackendConfig{{Name: "app", URL: backend.URL + "/base"}}
+	cfg.Routes = []configs.RouteConfig{{PathPrefix: "/app", StripPrefix: true, Upstream: "app"}}
+	proxy := newTestProxy(t, cfg)
+
+	// 后端收到的路径是 /base/admin/users，规则仍按客户端请求的 /app/admin/users 匹配
+	rr := httptest.NewRecorder()
+	proxy.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://www.example.com/app/admin/users", nil))
+	assert.Contains(t, rr.Body.String(), "/base/admin/users")
+	assert.NotContains(t, rr.Body.String(), "goga.min.js")
+
+	rr = httptest.NewRecorder()
+	proxy.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://www.example.com/app/home", nil))
+	assert.Contains(t, rr.Body.String(), "/base/home")
+	assert.Contains(t, rr.Body.String(), "goga.min.js")
+}
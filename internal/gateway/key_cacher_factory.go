@@ -14,8 +14,8 @@ import (
 	"goga/configs"
 )
 
-// NewKeyCacherFactory 根据配置创建并返回一个 KeyCacher 实例。
-func NewKeyCacherFactory(cfg configs.KeyCacheConfig) (security.KeyCacher, error) {
+// NewKeyCacherFactory 根据配置创建并返回一个 KeyStore 实例。
+func NewKeyCacherFactory(cfg configs.KeyCacheConfig) (security.KeyStore, error) {
 	switch cfg.Type {
 	case "in-memory":
 		slog.Info("正在初始化 In-Memory KeyCache")
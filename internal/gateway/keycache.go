@@ -6,6 +6,8 @@
 package gateway
 
 import (
+	"context"
+	"goga/internal/security"
 	"log/slog"
 	"sync"
 	"time"
@@ -15,13 +17,15 @@ import (
 type cacheEntry struct {
 	key       []byte
 	expiresAt time.Time
+	meta      security.KeyMetadata // 签发对象，用于按客户端 IP 或会话吊销
 }
 
 // InMemoryKeyCache 是一个支持 TTL 的线程安全内存密钥缓存
 type InMemoryKeyCache struct {
 	mu    sync.RWMutex
 	items map[string]cacheEntry
-	stop  chan struct{} // 用于停止后台清理 goroutine
+	stop  chan struct{}     // 用于停止后台清理 goroutine
+	stats *keyCacheCounters // 命中和未命中计数
 }
 
 // NewInMemoryKeyCache 创建一个新的密钥缓存，并启动一个后台清理 goroutine
@@ -29,6 +33,7 @@ func NewInMemoryKeyCache(cleanupInterval time.Duration) *InMemoryKeyCache {
 	kc := &InMemoryKeyCache{
 		items: make(map[string]cacheEntry),
 		stop:  make(chan struct{}),
+		stats: GlobalKeyMetrics.cache(keyCacheInMemory),
 	}
 
 	// 只有在 cleanupInterval 大于 0 时才启动清理 goroutine
@@ -40,7 +45,10 @@ func NewInMemoryKeyCache(cleanupInterval time.Duration) *InMemoryKeyCache {
 }
 
 // Set 向缓存中添加一个带特定 TTL 的密钥
-func (kc *InMemoryKeyCache) Set(token string, key []byte, ttl time.Duration) {
+func (kc *InMemoryKeyCache) Set(ctx context.Context, token string, key []byte, ttl time.Duration, meta security.KeyMetadata) {
+	_, span := startKeyCacheSpan(ctx, "set", keyCacheInMemory)
+	defer span.End()
+
 	kc.mu.Lock()
 	defer kc.mu.Unlock()
 
@@ -48,6 +56,7 @@ func (kc *InMemoryKeyCache) Set(token string, key []byte, ttl time.Duration) {
 	kc.items[token] = cacheEntry{
 		key:       key,
 		expiresAt: expiresAt,
+		meta:      meta,
 	}
 	slog.Debug("密钥已缓存", "token", token, "ttl", ttl.String())
 }
@@ -55,13 +64,17 @@ func (kc *InMemoryKeyCache) Set(token string, key []byte, ttl time.Duration) {
 // Get 从缓存中检索一个密钥。如果找到且未过期，则返回密钥和 true。
 // 如果密钥未找到或已过期，则返回 nil 和 false。
 // 过期的密钥在被访问时会被删除。
-func (kc *InMemoryKeyCache) Get(token string) ([]byte, bool) {
+func (kc *InMemoryKeyCache) Get(ctx context.Context, token string) (key []byte, found bool) {
+	_, span := startKeyCacheSpan(ctx, "get", keyCacheInMemory)
+	defer func() { endKeyCacheSpan(span, found, nil) }()
+
 	kc.mu.RLock()
 	entry, found := kc.items[token]
 	kc.mu.RUnlock()
 
 	if !found {
 		slog.Debug("缓存未命中", "token", token)
+		kc.stats.misses.Add(1)
 		return nil, false
 	}
 
@@ -77,13 +90,89 @@ func (kc *InMemoryKeyCache) Get(token string) ([]byte, bool) {
 		}
 		kc.mu.Unlock()
 		if !found {
+			kc.stats.misses.Add(1)
 			return nil, false
 		}
 	}
 	slog.Debug("缓存命中", "token", token)
+	kc.stats.hits.Add(1)
 	return entry.key, true
 }
 
+// Size 返回缓存中的条目数，包括已过期但尚未被清理的条目
+func (kc *InMemoryKeyCache) Size() (int64, error) {
+	kc.mu.RLock()
+	defer kc.mu.RUnlock()
+	return int64(len(kc.items)), nil
+}
+
+// Count 返回未过期的令牌数
+func (kc *InMemoryKeyCache) Count(ctx context.Context) (int64, error) {
+	kc.mu.RLock()
+	defer kc.mu.RUnlock()
+
+	now := time.Now()
+	var count int64
+	for _, entry := range kc.items {
+		if !now.After(entry.expiresAt) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Ping 检查密钥缓存是否可用，内存缓存始终可用
+func (kc *InMemoryKeyCache) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Revoke 吊销单个令牌，令牌不存在或已过期时返回 false
+func (kc *InMemoryKeyCache) Revoke(ctx context.Context, token string) (bool, error) {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	entry, found := kc.items[token]
+	if !found {
+		return false, nil
+	}
+	delete(kc.items, token)
+	return !time.Now().After(entry.expiresAt), nil
+}
+
+// RevokeClientIP 吊销签发给指定客户端 IP 的所有令牌
+func (kc *InMemoryKeyCache) RevokeClientIP(ctx context.Context, clientIP string) (int64, error) {
+	return kc.revokeWhere(func(meta security.KeyMetadata) bool { return meta.ClientIP == clientIP }), nil
+}
+
+// RevokeSession 吊销签发给指定会话的所有令牌
+func (kc *InMemoryKeyCache) RevokeSession(ctx context.Context, session string) (int64, error) {
+	return kc.revokeWhere(func(meta security.KeyMetadata) bool { return meta.Session == session }), nil
+}
+
+// Flush 吊销所有令牌
+func (kc *InMemoryKeyCache) Flush(ctx context.Context) (int64, error) {
+	return kc.revokeWhere(func(security.KeyMetadata) bool { return true }), nil
+}
+
+// revokeWhere 删除所有签发对象满足条件的条目，返回其中未过期的数量。已过期的条目一并删除，但不计入吊销数
+func (kc *InMemoryKeyCache) revokeWhere(match func(security.KeyMetadata) bool) int64 {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	now := time.Now()
+	var revoked int64
+	for token, entry := range kc.items {
+		if !match(entry.meta) {
+			continue
+		}
+		delete(kc.items, token)
+		if !now.After(entry.expiresAt) {
+			revoked++
+		}
+	}
+	return revoked
+}
+
 // Stop 停止后台清理 goroutine，用于优雅关闭
 func (kc *InMemoryKeyCache) Stop() {
 	// 检查 stop channel 是否已关闭或为 nil，避免重复关闭导致 panic
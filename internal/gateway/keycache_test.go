@@ -3,6 +3,7 @@ package gateway
 import (
 	"bytes"
 	"fmt"
+	"goga/internal/security"
 	"sync"
 	"testing"
 	"time"
@@ -17,9 +18,9 @@ func TestInMemoryKeyCache_SetAndGet(t *testing.T) {
 	key := []byte("secret_key_1")
 	ttl := 5 * time.Minute
 
-	cache.Set(token, key, ttl)
+	cache.Set(t.Context(), token, key, ttl, security.KeyMetadata{})
 
-	retrievedKey, found := cache.Get(token)
+	retrievedKey, found := cache.Get(t.Context(), token)
 	if !found {
 		t.Fatal("未能找到刚刚设置的密钥")
 	}
@@ -38,12 +39,12 @@ func TestInMemoryKeyCache_GetExpired(t *testing.T) {
 	key := []byte("secret_key_expired")
 	ttl := 1 * time.Millisecond
 
-	cache.Set(token, key, ttl)
+	cache.Set(t.Context(), token, key, ttl, security.KeyMetadata{})
 
 	// 等待足够长的时间以确保密钥已过期
 	time.Sleep(5 * time.Millisecond)
 
-	_, found := cache.Get(token)
+	_, found := cache.Get(t.Context(), token)
 	if found {
 		t.Fatal("不应找到已过期的密钥")
 	}
@@ -54,7 +55,7 @@ func TestInMemoryKeyCache_GetNonExistent(t *testing.T) {
 	cache := NewInMemoryKeyCache(1 * time.Minute)
 	defer cache.Stop()
 
-	_, found := cache.Get("non_existent_token")
+	_, found := cache.Get(t.Context(), "non_existent_token")
 	if found {
 		t.Fatal("不应找到不存在的密钥")
 	}
@@ -70,10 +71,10 @@ func TestInMemoryKeyCache_Cleanup(t *testing.T) {
 	token := "test_token_cleanup"
 	key := []byte("secret_key_cleanup")
 	ttl := 1 * time.Millisecond
-	cache.Set(token, key, ttl)
+	cache.Set(t.Context(), token, key, ttl, security.KeyMetadata{})
 
 	// 设置一个不会过期的
-	cache.Set("non_expiring_token", []byte("key"), 1*time.Minute)
+	cache.Set(t.Context(), "non_expiring_token", []byte("key"), 1*time.Minute, security.KeyMetadata{})
 
 	// 等待足够长的时间以确保清理 goroutine 已运行
 	time.Sleep(cleanupInterval * 3)
@@ -86,7 +87,7 @@ func TestInMemoryKeyCache_Cleanup(t *testing.T) {
 		t.Errorf("后台清理后，过期的密钥仍然存在")
 	}
 
-	_, found = cache.Get("non_expiring_token")
+	_, found = cache.Get(t.Context(), "non_expiring_token")
 	if !found {
 		t.Errorf("未过期的密钥不应该被清理")
 	}
@@ -107,7 +108,7 @@ func TestInMemoryKeyCache_Concurrency(t *testing.T) {
 			defer wg.Done()
 			token := fmt.Sprintf("token_%d", i)
 			key := []byte(fmt.Sprintf("key_%d", i))
-			cache.Set(token, key, 100*time.Millisecond)
+			cache.Set(t.Context(), token, key, 100*time.Millisecond, security.KeyMetadata{})
 		}(i)
 	}
 
@@ -120,7 +121,7 @@ func TestInMemoryKeyCache_Concurrency(t *testing.T) {
 			key := []byte(fmt.Sprintf("key_%d", i))
 
 			// 可能会读到，也可能由于过期或还未写入而读不到，这里主要测试会不会 panic
-			retrievedKey, found := cache.Get(token)
+			retrievedKey, found := cache.Get(t.Context(), token)
 			if found && !bytes.Equal(key, retrievedKey) {
 				t.Errorf("并发读取时数据不一致 for token %s", token)
 			}
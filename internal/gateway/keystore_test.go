@@ -0,0 +1,128 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package gateway
+
+import (
+	"goga/internal/security"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testKeyStore 是一个待测的 KeyStore 实现
+type testKeyStore struct {
+	security.KeyStore
+	elapse func(d time.Duration) // 让时间流逝 d，miniredis 的键不会随真实时间过期，需要手动快进
+}
+
+// newTestKeyStores 返回内存和 Redis 两种 KeyStore 实现，Redis 由 miniredis 模拟
+func newTestKeyStores(t *testing.T) map[string]testKeyStore {
+	t.Helper()
+	memory := NewInMemoryKeyCache(0)
+	t.Cleanup(memory.Stop)
+
+	mr := miniredis.RunT(t)
+	redisStore, err := NewRedisKeyCache(RedisKeyCacheConfig{Addr: mr.Addr()})
+	require.NoError(t, err)
+	t.Cleanup(redisStore.Stop)
+
+	return map[string]testKeyStore{
+		keyCacheInMemory: {memory, time.Sleep},
+		keyCacheRedis: {redisStore, func(d time.Duration) {
+			time.Sleep(d)
+			mr.FastForward(d)
+		}},
+	}
+}
+
+func TestKeyStore_Revocation(t *testing.T) {
+	alice := security.KeyMetadata{ClientIP: "203.0.113.7", Session: security.SessionDigest("alice-session")}
+	bob := security.KeyMetadata{ClientIP: "198.51.100.2", Session: security.SessionDigest("bob-session")}
+
+	for backend, store := range newTestKeyStores(t) {
+		t.Run(backend, func(t *testing.T) {
+			ctx := t.Context()
+			store.Set(ctx, "a1", []byte("k"), time.Minute, alice)
+			store.Set(ctx, "a2", []byte("k"), time.Minute, alice)
+			store.Set(ctx, "a3", []byte("k"), time.Minute, security.KeyMetadata{ClientIP: alice.ClientIP})
+			store.Set(ctx, "b1", []byte("k"), time.Minute, bob)
+			store.Set(ctx, "b2", []byte("k"), time.Minute, bob)
+
+			count, err := store.Count(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, int64(5), count)
+
+			// 单个令牌
+			found, err := store.Revoke(ctx, "b1")
+			require.NoError(t, err)
+			assert.True(t, found)
+			_, ok := store.Get(ctx, "b1")
+			assert.False(t, ok, "吊销后的令牌应立即失效")
+			found, err = store.Revoke(ctx, "b1")
+			require.NoError(t, err)
+			assert.False(t, found, "重复吊销应报告令牌不存在")
+
+			// 按会话吊销只影响该会话领取的令牌
+			revoked, err := store.RevokeSession(ctx, alice.Session)
+			require.NoError(t, err)
+			assert.Equal(t, int64(2), revoked)
+			_, ok = store.Get(ctx, "a3")
+			assert.True(t, ok, "未携带会话的令牌不应被按会话吊销")
+
+			// 按客户端 IP 吊销，已吊销的令牌不重复计数
+			revoked, err = store.RevokeClientIP(ctx, alice.ClientIP)
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), revoked)
+			key, ok := store.Get(ctx, "b2")
+			assert.True(t, ok, "其他客户端的令牌不受影响")
+			assert.Equal(t, []byte("k"), key)
+
+			// 清空
+			revoked, err = store.Flush(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), revoked)
+			count, err = store.Count(ctx)
+			require.NoError(t, err)
+			assert.Zero(t, count)
+		})
+	}
+}
+
+func TestKeyStore_CountExcludesExpired(t *testing.T) {
+	for backend, store := range newTestKeyStores(t) {
+		t.Run(backend, func(t *testing.T) {
+			store.Set(t.Context(), "short", []byte("k"), 20*time.Millisecond, security.KeyMetadata{ClientIP: "203.0.113.7"})
+			store.Set(t.Context(), "long", []byte("k"), time.Minute, security.KeyMetadata{ClientIP: "203.0.113.7"})
+			store.elapse(40 * time.Millisecond)
+
+			count, err := store.Count(t.Context())
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), count)
+
+			revoked, err := store.RevokeClientIP(t.Context(), "203.0.113.7")
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), revoked, "已过期的令牌不计入吊销数")
+		})
+	}
+}
+
+func TestKeyStore_Ping(t *testing.T) {
+	for backend, store := range newTestKeyStores(t) {
+		t.Run(backend, func(t *testing.T) {
+			assert.NoError(t, store.Ping(t.Context()))
+		})
+	}
+
+	mr := miniredis.RunT(t)
+	store, err := NewRedisKeyCache(RedisKeyCacheConfig{Addr: mr.Addr()})
+	require.NoError(t, err)
+	defer store.Stop()
+	mr.Close()
+	assert.Error(t, store.Ping(t.Context()), "Redis 不可用时应返回错误")
+}
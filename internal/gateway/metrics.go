@@ -0,0 +1,404 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package gateway
+
+import (
+	"cmp"
+	"goga/internal/metrics"
+	"goga/internal/security"
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxTrackedEncodings 限制按编码统计的不同取值数量，防止后端返回任意编码导致内存无限增长。
+const maxTrackedEncodings = 32
+
+// otherEncoding 是超出统计上限后的编码归类。
+const otherEncoding = "other"
+
+// InjectionMetrics 脚本注入指标
+type InjectionMetrics struct {
+	Injected            int64 // 成功装配注入管道的响应数
+	Skipped             int64 // 按注入策略跳过的响应数
+	UnsupportedEncoding int64 // 因编码无法处理而跳过的响应数
+	Failed              int64 // 装配注入管道失败的响应数
+
+	mutex                sync.Mutex
+	unsupportedEncodings map[string]*int64 // 按编码统计的不支持次数
+}
+
+var (
+	// 全局注入指标实例
+	GlobalInjectionMetrics = NewInjectionMetrics()
+)
+
+// NewInjectionMetrics 创建新的注入指标实例
+func NewInjectionMetrics() *InjectionMetrics {
+	return &InjectionMetrics{unsupportedEncodings: make(map[string]*int64)}
+}
+
+// RecordInjected 记录一次成功注入
+func (im *InjectionMetrics) RecordInjected() {
+	atomic.AddInt64(&im.Injected, 1)
+}
+
+// RecordSkipped 记录一次按策略跳过
+func (im *InjectionMetrics) RecordSkipped() {
+	atomic.AddInt64(&im.Skipped, 1)
+}
+
+// RecordFailed 记录一次注入失败
+func (im *InjectionMetrics) RecordFailed() {
+	atomic.AddInt64(&im.Failed, 1)
+}
+
+// RecordUnsupportedEncoding 记录一次因编码不受支持而跳过注入
+func (im *InjectionMetrics) RecordUnsupportedEncoding(encoding string) {
+	atomic.AddInt64(&im.UnsupportedEncoding, 1)
+
+	im.mutex.Lock()
+	counter, ok := im.unsupportedEncodings[encoding]
+	if !ok {
+		if len(im.unsupportedEncodings) >= maxTrackedEncodings {
+			encoding = otherEncoding
+			counter = im.unsupportedEncodings[encoding]
+		}
+		if counter == nil {
+			counter = new(int64)
+			im.unsupportedEncodings[encoding] = counter
+		}
+	}
+	im.mutex.Unlock()
+
+	atomic.AddInt64(counter, 1)
+}
+
+// GetSnapshot 获取指标快照
+func (im *InjectionMetrics) GetSnapshot() InjectionMetricsSnapshot {
+	im.mutex.Lock()
+	byEncoding := make(map[string]int64, len(im.unsupportedEncodings))
+	for enc, counter := range im.unsupportedEncodings {
+		byEncoding[enc] = atomic.LoadInt64(counter)
+	}
+	im.mutex.Unlock()
+
+	return InjectionMetricsSnapshot{
+		Injected:             atomic.LoadInt64(&im.Injected),
+		Skipped:              atomic.LoadInt64(&im.Skipped),
+		UnsupportedEncoding:  atomic.LoadInt64(&im.UnsupportedEncoding),
+		Failed:               atomic.LoadInt64(&im.Failed),
+		UnsupportedEncodings: byEncoding,
+	}
+}
+
+// InjectionMetricsSnapshot 注入指标快照
+type InjectionMetricsSnapshot struct {
+	Injected             int64            `json:"injected"`
+	Skipped              int64            `json:"skipped"`
+	UnsupportedEncoding  int64            `json:"unsupported_encoding"`
+	Failed               int64            `json:"failed"`
+	UnsupportedEncodings map[string]int64 `json:"unsupported_encodings"`
+}
+
+// LogMetrics 记录指标到日志
+func (s InjectionMetricsSnapshot) LogMetrics() {
+	slog.Info("脚本注入指标",
+		"注入数", s.Injected,
+		"策略跳过数", s.Skipped,
+		"编码不支持跳过数", s.UnsupportedEncoding,
+		"失败数", s.Failed,
+		"不支持的编码", s.UnsupportedEncodings,
+	)
+}
+
+// maxStatusCode 是按状态码统计时可以记录的最大状态码 (不含)
+const maxStatusCode = 600
+
+// ProxyMetrics 按路由统计的代理指标
+type ProxyMetrics struct {
+	mutex  sync.Mutex
+	routes map[string]*RouteMetrics
+}
+
+// RouteMetrics 单条路由的代理指标，按路由预先创建，记录时无需加锁
+type RouteMetrics struct {
+	statusCodes [maxStatusCode]atomic.Int64 // 按状态码统计的响应数
+	duration    *metrics.Histogram          // 从进入路由到处理完成的耗时
+}
+
+var (
+	// 全局代理指标实例
+	GlobalProxyMetrics = NewProxyMetrics()
+)
+
+// NewProxyMetrics 创建新的代理指标实例
+func NewProxyMetrics() *ProxyMetrics {
+	return &ProxyMetrics{routes: make(map[string]*RouteMetrics)}
+}
+
+// Route 返回指定路由的指标，同名路由共享同一组计数
+func (pm *ProxyMetrics) Route(name string) *RouteMetrics {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	rm, ok := pm.routes[name]
+	if !ok {
+		rm = &RouteMetrics{duration: metrics.NewHistogram(metrics.LatencyBuckets)}
+		pm.routes[name] = rm
+	}
+	return rm
+}
+
+// Record 记录一次请求的状态码和耗时，无效的状态码只计入耗时
+func (rm *RouteMetrics) Record(statusCode int, duration time.Duration) {
+	if statusCode > 0 && statusCode < maxStatusCode {
+		rm.statusCodes[statusCode].Add(1)
+	}
+	rm.duration.ObserveDuration(duration)
+}
+
+// GetSnapshot 获取指标快照
+func (pm *ProxyMetrics) GetSnapshot() ProxyMetricsSnapshot {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	routes := make(map[string]RouteMetricsSnapshot, len(pm.routes))
+	for name, rm := range pm.routes {
+		codes := make(map[int]int64)
+		for code := range rm.statusCodes {
+			if n := rm.statusCodes[code].Load(); n > 0 {
+				codes[code] = n
+			}
+		}
+		routes[name] = RouteMetricsSnapshot{StatusCodes: codes, Duration: rm.duration.Snapshot()}
+	}
+	return ProxyMetricsSnapshot{Routes: routes}
+}
+
+// ProxyMetricsSnapshot 代理指标快照
+type ProxyMetricsSnapshot struct {
+	Routes map[string]RouteMetricsSnapshot `json:"routes"`
+}
+
+// RouteMetricsSnapshot 单条路由的指标快照
+type RouteMetricsSnapshot struct {
+	StatusCodes map[int]int64             `json:"status_codes"`
+	Duration    metrics.HistogramSnapshot `json:"duration"`
+}
+
+// LogMetrics 记录指标到日志
+func (s ProxyMetricsSnapshot) LogMetrics() {
+	for name, route := range s.Routes {
+		slog.Info("路由代理指标", "路由", name, "请求数", route.Duration.Count, "状态码", route.StatusCodes)
+	}
+}
+
+// 密钥缓存的后端名称，与 key_cache.type 的取值一致
+const (
+	keyCacheInMemory = "in-memory"
+	keyCacheRedis    = "redis"
+)
+
+// KeyMetrics 密钥分发和密钥缓存指标
+type KeyMetrics struct {
+	Issued int64 // 分发的一次性密钥数
+
+	mutex  sync.Mutex
+	caches map[string]*keyCacheCounters // 按缓存后端统计的访问次数
+}
+
+// keyCacheCounters 单个缓存后端的访问计数，由缓存实例在创建时取得
+type keyCacheCounters struct {
+	hits   atomic.Int64
+	misses atomic.Int64 // 令牌不存在或已过期
+	errors atomic.Int64 // 访问缓存出错 (例如 Redis 不可用)
+}
+
+var (
+	// 全局密钥指标实例
+	GlobalKeyMetrics = NewKeyMetrics()
+)
+
+// NewKeyMetrics 创建新的密钥指标实例
+func NewKeyMetrics() *KeyMetrics {
+	return &KeyMetrics{caches: make(map[string]*keyCacheCounters)}
+}
+
+// RecordIssued 记录一次密钥分发
+func (km *KeyMetrics) RecordIssued() {
+	atomic.AddInt64(&km.Issued, 1)
+}
+
+// cache 返回指定缓存后端的计数器
+func (km *KeyMetrics) cache(backend string) *keyCacheCounters {
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+	c, ok := km.caches[backend]
+	if !ok {
+		c = &keyCacheCounters{}
+		km.caches[backend] = c
+	}
+	return c
+}
+
+// GetSnapshot 获取指标快照
+func (km *KeyMetrics) GetSnapshot() KeyMetricsSnapshot {
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+	caches := make(map[string]KeyCacheSnapshot, len(km.caches))
+	for backend, c := range km.caches {
+		caches[backend] = KeyCacheSnapshot{Hits: c.hits.Load(), Misses: c.misses.Load(), Errors: c.errors.Load()}
+	}
+	return KeyMetricsSnapshot{Issued: atomic.LoadInt64(&km.Issued), Caches: caches}
+}
+
+// KeyMetricsSnapshot 密钥指标快照
+type KeyMetricsSnapshot struct {
+	Issued int64                       `json:"issued"`
+	Caches map[string]KeyCacheSnapshot `json:"caches"`
+}
+
+// KeyCacheSnapshot 单个缓存后端的访问计数
+type KeyCacheSnapshot struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	Errors int64 `json:"errors"`
+}
+
+// LogMetrics 记录指标到日志
+func (s KeyMetricsSnapshot) LogMetrics() {
+	slog.Info("密钥指标", "分发数", s.Issued, "缓存访问", s.Caches)
+}
+
+// keyCacheSizer 由能够报告当前条目数的密钥缓存实现
+type keyCacheSizer interface {
+	Size() (int64, error)
+}
+
+// WebSocketMetrics WebSocket 代理指标
+type WebSocketMetrics struct {
+	Active int64 // 正在转发数据的连接数
+	Total  int64 // 握手成功的连接总数
+}
+
+var (
+	// 全局 WebSocket 指标实例
+	GlobalWebSocketMetrics = &WebSocketMetrics{}
+)
+
+// RecordOpened 记录一个握手成功、开始转发数据的连接
+func (wm *WebSocketMetrics) RecordOpened() {
+	atomic.AddInt64(&wm.Active, 1)
+	atomic.AddInt64(&wm.Total, 1)
+}
+
+// RecordClosed 记录一个连接关闭
+func (wm *WebSocketMetrics) RecordClosed() {
+	atomic.AddInt64(&wm.Active, -1)
+}
+
+// NewMetricsCollector 返回以 Prometheus 文本格式写出代理、密钥、脚本注入、WebSocket 和上游池指标的 Collector。
+// keyCacher 实现了 Size 时同时输出密钥缓存的条目数。
+func NewMetricsCollector(routes *RouteTable, keyCacher security.KeyCacher) metrics.Collector {
+	return func(w *metrics.Writer) {
+		collectProxyMetrics(w)
+		collectKeyMetrics(w, keyCacher)
+
+		inj := GlobalInjectionMetrics.GetSnapshot()
+		w.Counter("goga_injection_responses_total", "脚本注入的处理结果",
+			metrics.Sample{Labels: metrics.Labels("outcome", "injected"), Value: float64(inj.Injected)},
+			metrics.Sample{Labels: metrics.Labels("outcome", "skipped"), Value: float64(inj.Skipped)},
+			metrics.Sample{Labels: metrics.Labels("outcome", "unsupported_encoding"), Value: float64(inj.UnsupportedEncoding)},
+			metrics.Sample{Labels: metrics.Labels("outcome", "failed"), Value: float64(inj.Failed)},
+		)
+		encodings := make([]metrics.Sample, 0, len(inj.UnsupportedEncodings))
+		for _, enc := range sortedKeys(inj.UnsupportedEncodings) {
+			encodings = append(encodings, metrics.Sample{Labels: metrics.Labels("encoding", enc), Value: float64(inj.UnsupportedEncodings[enc])})
+		}
+		w.Counter("goga_injection_unsupported_encodings_total", "因编码不受支持而跳过注入的响应数，按编码区分", encodings...)
+
+		w.Gauge("goga_websocket_connections_active", "正在转发数据的 WebSocket 连接数",
+			metrics.Sample{Value: float64(atomic.LoadInt64(&GlobalWebSocketMetrics.Active))})
+		w.Counter("goga_websocket_connections_total", "握手成功的 WebSocket 连接总数",
+			metrics.Sample{Value: float64(atomic.LoadInt64(&GlobalWebSocketMetrics.Total))})
+
+		collectUpstreamMetrics(w, routes)
+	}
+}
+
+// collectProxyMetrics 写出按路由统计的状态码和耗时
+func collectProxyMetrics(w *metrics.Writer) {
+	s := GlobalProxyMetrics.GetSnapshot()
+	var codes []metrics.Sample
+	var durations []metrics.HistogramSample
+	for _, name := range sortedKeys(s.Routes) {
+		route := s.Routes[name]
+		for _, code := range sortedKeys(route.StatusCodes) {
+			codes = append(codes, metrics.Sample{Labels: metrics.Labels("route", name, "code", strconv.Itoa(code)), Value: float64(route.StatusCodes[code])})
+		}
+		durations = append(durations, metrics.HistogramSample{Labels: metrics.Labels("route", name), Snapshot: route.Duration})
+	}
+	w.Counter("goga_proxy_responses_total", "经过 HTTP 代理的响应数，按路由和状态码区分", codes...)
+	w.Histogram("goga_proxy_request_duration_seconds", "HTTP 代理请求的处理耗时 (包括解密和转发)", durations...)
+}
+
+// collectKeyMetrics 写出密钥分发和密钥缓存指标
+func collectKeyMetrics(w *metrics.Writer, keyCacher security.KeyCacher) {
+	s := GlobalKeyMetrics.GetSnapshot()
+	w.Counter("goga_keys_issued_total", "分发的一次性密钥数", metrics.Sample{Value: float64(s.Issued)})
+
+	var lookups []metrics.Sample
+	for _, backend := range sortedKeys(s.Caches) {
+		c := s.Caches[backend]
+		lookups = append(lookups,
+			metrics.Sample{Labels: metrics.Labels("backend", backend, "result", "hit"), Value: float64(c.Hits)},
+			metrics.Sample{Labels: metrics.Labels("backend", backend, "result", "miss"), Value: float64(c.Misses)},
+			metrics.Sample{Labels: metrics.Labels("backend", backend, "result", "error"), Value: float64(c.Errors)},
+		)
+	}
+	w.Counter("goga_key_cache_lookups_total", "按令牌查找密钥的次数，按缓存后端和结果区分", lookups...)
+
+	if sizer, ok := keyCacher.(keyCacheSizer); ok {
+		if size, err := sizer.Size(); err == nil {
+			w.Gauge("goga_key_cache_entries", "密钥缓存中的条目数", metrics.Sample{Value: float64(size)})
+		} else {
+			slog.Warn("无法获取密钥缓存的条目数", "error", err)
+		}
+	}
+}
+
+// collectUpstreamMetrics 写出上游池中每个上游的可用状态、活跃请求数和重试次数
+func collectUpstreamMetrics(w *metrics.Writer, routes *RouteTable) {
+	var available, active, retries []metrics.Sample
+	for _, backend := range routes.Backends() {
+		status := backend.Status()
+		retries = append(retries, metrics.Sample{Labels: metrics.Labels("backend", status.Name), Value: float64(status.Retries)})
+		for _, t := range status.Targets {
+			labels := metrics.Labels("backend", status.Name, "target", t.URL)
+			up := 0.0
+			if t.Available {
+				up = 1
+			}
+			available = append(available, metrics.Sample{Labels: labels, Value: up})
+			active = append(active, metrics.Sample{Labels: labels, Value: float64(t.ActiveRequests)})
+		}
+	}
+	w.Gauge("goga_upstream_available", "上游是否可被选中 (健康、未被摘除且熔断器未打开)", available...)
+	w.Gauge("goga_upstream_active_requests", "正在转发到上游的请求数", active...)
+	w.Counter("goga_upstream_retries_total", "转发失败后重试到其他上游的次数", retries...)
+}
+
+// sortedKeys 返回按升序排列的 map 键，使指标输出的顺序稳定
+func sortedKeys[K cmp.Ordered, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
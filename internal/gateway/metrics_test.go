@@ -0,0 +1,80 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package gateway
+
+import (
+	"fmt"
+	"goga/configs"
+	"goga/internal/metrics"
+	"goga/internal/security"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInjectionMetrics_UnsupportedEncodingCardinality(t *testing.T) {
+	m := NewInjectionMetrics()
+	for i := 0; i < maxTrackedEncodings+10; i++ {
+		m.RecordUnsupportedEncoding(fmt.Sprintf("x-enc-%d", i))
+	}
+	m.RecordUnsupportedEncoding("x-enc-0")
+
+	s := m.GetSnapshot()
+	assert.Equal(t, int64(maxTrackedEncodings+11), s.UnsupportedEncoding)
+	assert.LessOrEqual(t, len(s.UnsupportedEncodings), maxTrackedEncodings+1)
+	assert.Equal(t, int64(2), s.UnsupportedEncodings["x-enc-0"])
+	assert.Equal(t, int64(10), s.UnsupportedEncodings[otherEncoding])
+}
+
+func TestMetricsCollector(t *testing.T) {
+	backend := newHTMLBackend()
+	defer backend.Close()
+
+	cfg := newTestConfig(backend.URL)
+	cfg.Routes = []configs.RouteConfig{
+		{Name: "pages", PathPrefix: "/pages"},
+		{PathPrefix: "/api"},
+	}
+	proxy, routes := newTestProxyWithRoutes(t, cfg)
+	keyCache := NewInMemoryKeyCache(0)
+	keyCache.Set(t.Context(), "token", []byte("key"), time.Minute, security.KeyMetadata{})
+
+	doProxyRequest(t, proxy, "/pages/a")
+	doProxyRequest(t, proxy, "/pages/b?status=503")
+	doProxyRequest(t, proxy, "/api/c?status=404")
+
+	rr := httptest.NewRecorder()
+	metrics.Handler(NewMetricsCollector(routes, keyCache)).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, metrics.Path, nil))
+	body := rr.Body.String()
+
+	// 全局指标在测试之间共享，只检查本测试独有的路由名称
+	assert.Contains(t, body, `goga_proxy_responses_total{route="pages",code="200"} 1`)
+	assert.Contains(t, body, `goga_proxy_responses_total{route="pages",code="503"} 1`)
+	assert.Contains(t, body, `goga_proxy_responses_total{route="*/api",code="404"} 1`, "未配置 name 的路由以 Host 和路径前缀命名")
+	assert.Contains(t, body, `goga_proxy_request_duration_seconds_count{route="pages"} 2`)
+	assert.Contains(t, body, "goga_key_cache_entries 1")
+	assert.Contains(t, body, `goga_key_cache_lookups_total{backend="in-memory",result="hit"}`)
+	assert.Contains(t, body, "# TYPE goga_injection_responses_total counter")
+	assert.Contains(t, body, "# TYPE goga_websocket_connections_active gauge")
+	assert.Contains(t, body, `goga_upstream_available{backend="default",target="`+backend.URL+`"} 1`)
+}
+
+func TestStatusRecorder(t *testing.T) {
+	rm := NewProxyMetrics().Route("test")
+	route := &Route{name: "test", Backend: &Backend{Name: "default"}}
+	handler := instrumentRoute(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusEarlyHints)
+		w.WriteHeader(http.StatusCreated)
+		w.(http.Flusher).Flush()
+	}), route, rm)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, int64(1), rm.statusCodes[http.StatusCreated].Load(), "信息性响应不应作为最终状态码")
+	assert.Zero(t, rm.statusCodes[http.StatusEarlyHints].Load())
+}
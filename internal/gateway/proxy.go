@@ -6,19 +6,27 @@
 package gateway
 
 import (
+	"errors"
+	"fmt"
 	"goga/configs"
 	"goga/internal/middleware"
-	"io"
+	"goga/internal/security"
+	"goga/internal/tracing"
 	"log/slog"
 	"net" // 导入 net 包
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"os"
 	"strings"
 	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// copyBufPool 是代理路径共享的 32KB 复制缓冲区池
 var copyBufPool = sync.Pool{
 	New: func() interface{} {
 		// 32KB 是 io.Copy 的默认缓冲区大小，一个不错的默认值。
@@ -27,25 +35,89 @@ var copyBufPool = sync.Pool{
 	},
 }
 
-// NewProxy 创建并返回一个配置好的反向代理处理器
-func NewProxy(config *configs.Config) (http.Handler, error) {
-	// 解析后端目标 URL
-	target, err := url.Parse(config.BackendURL)
+// NewProxy 创建并返回一个按路由表分发请求的反向代理处理器。
+// 每条路由拥有独立的反向代理和解密中间件，加密配置按路由生效。
+func NewProxy(config *configs.Config, routes *RouteTable, keyCacher security.KeyCacher) (http.Handler, error) {
+	// 编译注入策略 (规则、状态码、内容类型)
+	policy, err := newInjectionPolicy(config.ScriptInjection)
+	if err != nil {
+		return nil, err
+	}
+
+	// 压缩器池，注入后的 HTML 按配置的模式和级别重新压缩
+	pools := newCompressionPools(config.ScriptInjection.Compression)
+
+	handlers := make([]http.Handler, len(routes.Routes()))
+	for _, route := range routes.Routes() {
+		handler, err := newRouteProxy(config, route, routes.client, policy, pools)
+		if err != nil {
+			return nil, err
+		}
+		if route.Encryption.Enabled {
+			// DecryptionMiddleware 会忽略无效的规则，在这里提前拒绝，避免强制加密的路由在重新加载后静默失效
+			if err := middleware.ValidateMustEncryptRoutes(route.Encryption.MustEncryptRoutes); err != nil {
+				return nil, fmt.Errorf("路由 %s: %w", route.name, err)
+			}
+			handler = middleware.DecryptionMiddleware(keyCacher, route.Encryption)(handler)
+		}
+		// 客户端证书在解密之前检查，未通过的请求不会消耗一次性密钥
+		if route.ClientCert.Required || len(route.ClientCert.AllowedNames) > 0 {
+			handler = middleware.RequireClientCert(route.ClientCert)(handler)
+		}
+		slog.Info("路由已加载",
+			"name", route.name,
+			"host", route.host,
+			"path_prefix", route.pathPrefix,
+			"backend", route.Backend.Name,
+			"target", route.Backend.targets[0].displayURL(),
+			"encryption_enabled", route.Encryption.Enabled,
+		)
+		handlers[route.index] = instrumentRoute(handler, route, GlobalProxyMetrics.Route(route.name))
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routes.Match(r)
+		if route == nil {
+			middleware.LogWarn(r, "没有匹配的路由", "host", r.Host, "path", r.URL.Path)
+			middleware.WriteJSONError(w, r, http.StatusNotFound, "NO_ROUTE", "没有与请求匹配的路由")
+			return
+		}
+		handlers[route.index].ServeHTTP(w, r)
+	}), nil
+}
+
+// newRouteProxy 为单条路由创建反向代理，路由的加密配置决定是否注入脚本以及下发给客户端的策略。
+func newRouteProxy(config *configs.Config, route *Route, client *upstreamClient, policy *injectionPolicy, pools *compressionPools) (http.Handler, error) {
+	backend := route.Backend
+	clientCertSecret := []byte(config.Upstream.ClientCertHeaders.Secret)
+
+	// 预先生成注入内容 (客户端配置块 + 脚本标签)，客户端策略使用路由的加密配置
+	routeConfig := *config
+	routeConfig.Encryption = route.Encryption
+	content, err := newInjectionContent(&routeConfig)
 	if err != nil {
-		slog.Error("无法解析目标 URL", "url", config.BackendURL, "error", err)
-		os.Exit(1)
+		return nil, err
 	}
-	slog.Debug("反向代理目标已设置", "target", config.BackendURL)
 
-	// 创建一个反向代理
-	proxy := httputil.NewSingleHostReverseProxy(target)
+	// 创建一个反向代理，目标上游在每个请求进入时由上游池选出
+	proxy := &httputil.ReverseProxy{
+		// ReverseProxy 对 ContentLength 为 -1 的响应和 text/event-stream 始终立即刷新，
+		// FlushInterval 只影响长度已知的响应
+		FlushInterval: config.Upstream.FlushInterval,
+		// 熔断器检查、转发结果统计和幂等请求的重试都在 Transport 中完成
+		// 使用后端配置的协议 (HTTP/1.1、h2 或 h2c)，ReverseProxy 会透传请求和响应的 Trailer
+		Transport: &upstreamTransport{base: client.transportFor(backend.protocol), route: route},
+	}
 
 	// 设置自定义错误处理器
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
 		middleware.LogError(r, "反向代理错误", "error", err)
 
 		// 检查错误的具体类型以返回更精确的状态码
-		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		if errors.Is(err, errCircuitOpen) {
+			// 熔断器打开时请求未被发送，立即失败而不是等待连接超时
+			middleware.WriteJSONError(w, r, http.StatusServiceUnavailable, "CIRCUIT_OPEN", "后端服务暂时不可用")
+		} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 			// 后端服务超时
 			middleware.WriteJSONError(w, r, http.StatusGatewayTimeout, "GATEWAY_TIMEOUT", "后端服务响应超时")
 		} else {
@@ -54,117 +126,187 @@ func NewProxy(config *configs.Config) (http.Handler, error) {
 		}
 	}
 
-	// 修改 Director 来自定义请求如何被转发
-	originalDirector := proxy.Director
-	proxy.Director = func(req *http.Request) {
-		// 手动保留 Host 头的逻辑，以兼容无法识别 PreserveHost 的环境
-		savedHost := req.Host
-		originalDirector(req) // 调用默认 Director，它会设置 req.URL.Scheme, req.URL.Host 等
-		req.Host = savedHost
-
-		// --- 完善 X-Forwarded-For 逻辑 ---
-		clientIP, _, err := net.SplitHostPort(req.RemoteAddr)
-		if err != nil {
-			middleware.LogWarn(req, "无法解析客户端 IP", "remote_addr", req.RemoteAddr, "error", err)
-			clientIP = req.RemoteAddr // fallback to full remote address if parsing fails
+	// Rewrite 自定义请求如何被转发。与 Director 不同，ReverseProxy 会在调用 Rewrite 之前移除客户端发来的
+	// Forwarded 和 X-Forwarded-* 头，且不会再自动追加 X-Forwarded-For，转发头完全由 setForwardedHeaders 生成
+	proxy.Rewrite = func(pr *httputil.ProxyRequest) {
+		req := pr.Out
+		target := backend.URL
+		if sel := upstreamSelectionFrom(req.Context()); sel != nil {
+			target = sel.target.URL
+			sel.clientHost, sel.clientPath = pr.In.Host, pr.In.URL.Path
 		}
 
-		// 获取现有的 X-Forwarded-For 头部
-		existingXFF := req.Header.Get("X-Forwarded-For")
+		// 保留客户端请求的 Host 头
+		savedHost := req.Host
+		route.rewriteURL(req.URL) // 先按路由改写路径，再拼接上游路径
+		directRequest(req, target)
+		req.Host = savedHost
 
-		if existingXFF == "" {
-			// 如果没有 X-Forwarded-For，则直接设置
-			req.Header.Set("X-Forwarded-For", clientIP)
-		} else {
-			// 如果已存在，则追加客户端 IP
-			req.Header.Set("X-Forwarded-For", existingXFF+", "+clientIP)
-		}
-		// --- X-Forwarded-For 逻辑结束 ---
+		setForwardedHeaders(req.Header, pr.In)
+		setClientCertHeaders(req.Header, pr.In, clientCertSecret)
+		setRequestIDHeader(req.Header, pr.In)
 	}
 
 	// 添加 ModifyResponse 函数来注入脚本
 	proxy.ModifyResponse = func(resp *http.Response) error {
-		const maxBodySize = 1 * 1024 * 1024
-		// 1 MB: 限制 HTML 响应体最大处理尺寸。
-
-		// 仅在加密启用、响应成功且类型为 HTML 时才注入脚本
-		if config.Encryption.Enabled && resp.StatusCode == http.StatusOK && strings.Contains(resp.Header.Get("Content-Type"), "text/html") {
-			slog.Debug("响应符合脚本注入条件，将使用流式处理。", "content-type", resp.Header.Get("Content-Type"))
-
-			encoding := resp.Header.Get("Content-Encoding")
-
-			// 1. 移除 Content-Length 并为流式处理设置 ContentLength = -1
+		// HTTP/2 后端的响应可能同时带有 Content-Length 和 Trailer，转发给 HTTP/1.1 客户端时
+		// 必须改用分块传输，否则 Trailer 无处发送而被丢弃
+		if len(resp.Trailer) > 0 {
 			resp.Header.Del("Content-Length")
 			resp.ContentLength = -1
+		}
 
-			// 2. 使用 io.LimitedReader 包装原始 body 以限制大小
-			limitedReader := &io.LimitedReader{R: resp.Body, N: maxBodySize}
-
-			var reader io.Reader = limitedReader
-			var needsRecompression bool
-
-			if encoding != "" {
-				// 3. 如果有压缩，则构建流式解压 Reader
-				decompressionReader, err := getDecompressionReader(encoding, limitedReader)
-				if err != nil {
-					slog.Error("创建流式解压 reader 失败", "encoding", encoding, "error", err)
-					return nil
-				}
-				if decompressionReader != nil {
-					reader = decompressionReader
-					needsRecompression = true
-					slog.Debug("已应用流式解压", "encoding", encoding)
-				}
-			}
+		// 后端的注入控制头只对网关有意义，无论是否注入都不应泄露给客户端
+		inject, reason := policy.decide(resp)
+		resp.Header.Del(InjectHeader)
 
-			// 4. 将 reader 传递给 scriptInjector
-			injector := NewScriptInjector(reader, []byte(config.ScriptInjection.ScriptContent))
-
-			if !needsRecompression {
-				// 场景一：未压缩，直接将注入器作为响应体
-				resp.Body = injector
-			} else {
-				// 场景二：已压缩，需构建“解压->注入->重压缩”的完整管道
-				pr, pw := io.Pipe()
-				resp.Body = pr
-
-				go func() {
-					defer pw.Close()
-					defer injector.Close() // 确保注入器也被关闭以释放其内部缓冲区
-
-					// 创建流式压缩 writer
-					compressionWriter, err := getCompressionWriter(encoding, pw)
-					if err != nil {
-						slog.Error("创建流式压缩 writer 失败", "encoding", encoding, "error", err)
-						pw.CloseWithError(err)
-						return
-					}
-					defer compressionWriter.Close()
-
-					// 从池中获取缓冲区并使用 io.CopyBuffer
-					bufPtr := copyBufPool.Get().(*[]byte)
-					defer copyBufPool.Put(bufPtr)
-
-					// io.CopyBuffer 将驱动整个数据流转：
-					// injector (source) -> compressionWriter -> pipeWriter (sink)
-					if _, err := io.CopyBuffer(compressionWriter, injector, *bufPtr); err != nil {
-						// 将复制过程中发生的错误传递给管道的读取端
-						pw.CloseWithError(err)
-						slog.Error("在流式管道中复制数据时出错", "error", err)
-					}
-					slog.Debug("流式管道处理完成", "encoding", encoding)
-				}()
-			}
-		} else {
+		// 仅在加密启用且注入策略允许时才注入脚本
+		if !route.Encryption.Enabled || !inject {
 			slog.Debug("响应不符合脚本注入条件，已跳过",
+				"reason", reason,
 				"status_code", resp.StatusCode,
 				"content_type", resp.Header.Get("Content-Type"),
-				"encryption_enabled", config.Encryption.Enabled,
+				"encryption_enabled", route.Encryption.Enabled,
 			)
+			GlobalInjectionMetrics.RecordSkipped()
+			return nil
+		}
+
+		_, span := tracing.Start(resp.Request.Context(), "goga.inject", trace.WithAttributes(
+			semconv.HTTPResponseStatusCode(resp.StatusCode),
+			attribute.String("goga.inject.reason", reason),
+		))
+
+		// 无法解码的编码链 (未知编码或层数过多) 明确跳过注入，响应体原样透传
+		if enc := unsupportedEncoding(parseContentEncoding(resp.Header.Values("Content-Encoding"))); enc != "" {
+			middleware.LogWarn(resp.Request, "响应编码不受支持，已跳过脚本注入", "content_encoding", enc)
+			GlobalInjectionMetrics.RecordUnsupportedEncoding(enc)
+			span.SetAttributes(injectOutcomeAttr.String("unsupported_encoding"), semconv.HTTPResponseHeader("content-encoding", enc))
+			span.End()
+			return nil
 		}
 
+		slog.Debug("响应符合脚本注入条件，将使用流式处理。", "content-type", resp.Header.Get("Content-Type"), "reason", reason)
+
+		// resp.Request 是转发给后端的请求，其 Host 已在 Rewrite 中保留为客户端请求的 Host
+		rewritten, err := rewriteHTMLResponse(resp, content.forHost(resp.Request.Host), pools)
+		if err != nil {
+			middleware.LogError(resp.Request, "装配脚本注入管道失败", "error", err)
+			GlobalInjectionMetrics.RecordFailed()
+			span.SetAttributes(injectOutcomeAttr.String("failed"))
+			tracing.EndWithError(span, err)
+			return err
+		}
+		if !rewritten {
+			span.SetAttributes(injectOutcomeAttr.String("skipped"))
+			span.End()
+			return nil
+		}
+		GlobalInjectionMetrics.RecordInjected()
+		// 注入在后端响应体被读取时流式进行，span 在改写后的响应体关闭时结束
+		span.SetAttributes(injectOutcomeAttr.String("injected"))
+		resp.Body = &spanEndingBody{ReadCloser: resp.Body, span: span}
 		return nil
 	}
 
-	return proxy, nil
-}
\ No newline at end of file
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sel := &upstreamSelection{backend: backend, target: backend.pick(r)}
+		sel.target.acquire()
+		// 重试可能切换上游，因此释放时以最终选中的上游为准
+		defer func() { sel.target.release() }()
+		proxy.ServeHTTP(w, withUpstreamSelection(r, sel))
+	}), nil
+}
+
+// instrumentRoute 记录路由上每个请求最终返回给客户端的状态码和处理耗时，并在请求的 span 上标记路由和后端
+func instrumentRoute(next http.Handler, route *Route, rm *RouteMetrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		trace.SpanFromContext(r.Context()).SetAttributes(routeAttr.String(route.name), backendAttr.String(route.Backend.Name))
+		sr := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(sr, r)
+		rm.Record(sr.statusCode(), time.Since(start))
+	})
+}
+
+// statusRecorder 记录写给客户端的最终状态码，忽略 103 Early Hints 等信息性响应
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader 记录第一个最终状态码 (2xx 及以上，或 101 协议切换)
+func (sr *statusRecorder) WriteHeader(code int) {
+	if sr.status == 0 && (code >= 200 || code == http.StatusSwitchingProtocols) {
+		sr.status = code
+	}
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+// Write 在未显式写入状态码时按 200 记录
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(b)
+}
+
+// Flush 刷新底层的 ResponseWriter，保持流式响应的刷新边界
+func (sr *statusRecorder) Flush() {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	if f, ok := sr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap 返回底层的 ResponseWriter，供 http.ResponseController 使用
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// statusCode 返回记录的状态码，处理器没有写入任何内容时响应为 200
+func (sr *statusRecorder) statusCode() int {
+	if sr.status == 0 {
+		return http.StatusOK
+	}
+	return sr.status
+}
+
+// directRequest 将请求的目标改为指定上游，逻辑与 httputil.NewSingleHostReverseProxy 的默认 Director 一致。
+func directRequest(req *http.Request, target *url.URL) {
+	targetQuery := target.RawQuery
+	req.URL.Scheme = target.Scheme
+	req.URL.Host = target.Host
+	req.URL.Path, req.URL.RawPath = joinURLPath(target, req.URL)
+	if targetQuery == "" || req.URL.RawQuery == "" {
+		req.URL.RawQuery = targetQuery + req.URL.RawQuery
+	} else {
+		req.URL.RawQuery = targetQuery + "&" + req.URL.RawQuery
+	}
+	if _, ok := req.Header["User-Agent"]; !ok {
+		// 明确禁用 User-Agent，避免被设置为 Go 的默认值
+		req.Header.Set("User-Agent", "")
+	}
+}
+
+// joinURLPath 拼接上游路径与请求路径，同时处理转义形式的路径。
+func joinURLPath(a, b *url.URL) (path, rawpath string) {
+	if a.RawPath == "" && b.RawPath == "" {
+		return singleJoiningSlash(a.Path, b.Path), ""
+	}
+	apath := a.EscapedPath()
+	bpath := b.EscapedPath()
+
+	aslash := strings.HasSuffix(apath, "/")
+	bslash := strings.HasPrefix(bpath, "/")
+
+	switch {
+	case aslash && bslash:
+		return a.Path + b.Path[1:], apath + bpath[1:]
+	case !aslash && !bslash:
+		return a.Path + "/" + b.Path, apath + "/" + bpath
+	}
+	return a.Path + b.Path, apath + bpath
+}
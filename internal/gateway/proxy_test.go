@@ -0,0 +1,294 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package gateway
+
+import (
+	"bufio"
+	"fmt"
+	"goga/configs"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPage = `<html><body><h1>Hello</h1></body></html>`
+
+// newHTMLBackend 创建一个返回 HTML 的模拟后端，状态码和注入控制头通过查询参数指定。
+func newHTMLBackend() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v := r.URL.Query().Get("inject"); v != "" {
+			w.Header().Set(InjectHeader, v)
+		}
+		if v := r.URL.Query().Get("encoding"); v != "" {
+			w.Header().Set("Content-Encoding", v)
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		status := http.StatusOK
+		if s := r.URL.Query().Get("status"); s != "" {
+			fmt.Sscanf(s, "%d", &status)
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, testPage)
+	}))
+}
+
+// newTestProxy 按配置构建路由表和反向代理。
+func newTestProxy(t *testing.T, cfg *configs.Config) http.Handler {
+	t.Helper()
+	proxy, _ := newTestProxyWithRoutes(t, cfg)
+	return proxy
+}
+
+// newTestProxyWithRoutes 与 newTestProxy 相同，同时返回路由表以便检查上游池状态。
+func newTestProxyWithRoutes(t *testing.T, cfg *configs.Config) (http.Handler, *RouteTable) {
+	t.Helper()
+	routes, err := NewRouteTable(cfg)
+	require.NoError(t, err)
+	t.Cleanup(routes.Stop)
+	keyCache := NewInMemoryKeyCache(time.Minute)
+	t.Cleanup(keyCache.Stop)
+	proxy, err := NewProxy(cfg, routes, keyCache)
+	require.NoError(t, err)
+	return proxy, routes
+}
+
+// doProxyRequest 通过代理发起请求，返回响应和完整的响应体。
+func doProxyRequest(t *testing.T, proxy http.Handler, path string) (*http.Response, string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "http://example.com"+path, nil)
+	rr := httptest.NewRecorder()
+	proxy.ServeHTTP(rr, req)
+	resp := rr.Result()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+func TestProxy_InjectionControl(t *testing.T) {
+	backend := newHTMLBackend()
+	defer backend.Close()
+
+	cfg := newTestConfig(backend.URL)
+	cfg.Encryption.Enabled = true
+	cfg.ScriptInjection.ScriptContent = `<script src="/goga.min.js"></script>`
+	cfg.ScriptInjection.StatusCodes = []int{200, 404}
+
+	proxy := newTestProxy(t, cfg)
+
+	t.Run("默认注入 200 页面", func(t *testing.T) {
+		_, body := doProxyRequest(t, proxy, "/")
+		assert.Contains(t, body, `<script src="/goga.min.js"></script></body>`)
+	})
+
+	t.Run("注入配置的错误页", func(t *testing.T) {
+		resp, body := doProxyRequest(t, proxy, "/?status=404")
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		assert.Contains(t, body, `<script src="/goga.min.js"></script></body>`)
+	})
+
+	t.Run("后端要求跳过注入，且控制头被移除", func(t *testing.T) {
+		resp, body := doProxyRequest(t, proxy, "/?inject=off")
+		assert.Equal(t, testPage, body)
+		assert.Empty(t, resp.Header.Get(InjectHeader))
+	})
+
+	t.Run("后端要求注入未配置的状态码", func(t *testing.T) {
+		resp, body := doProxyRequest(t, proxy, "/?status=500&inject=on")
+		assert.True(t, strings.Contains(body, `goga.min.js`))
+		assert.Empty(t, resp.Header.Get(InjectHeader))
+	})
+}
+
+func TestProxy_UnsupportedEncoding(t *testing.T) {
+	backend := newHTMLBackend()
+	defer backend.Close()
+
+	cfg := newTestConfig(backend.URL)
+	cfg.Encryption.Enabled = true
+	cfg.ScriptInjection.ScriptContent = `<script src="/goga.min.js"></script>`
+
+	proxy := newTestProxy(t, cfg)
+
+	before := GlobalInjectionMetrics.GetSnapshot()
+	resp, body := doProxyRequest(t, proxy, "/?encoding=compress")
+	after := GlobalInjectionMetrics.GetSnapshot()
+
+	assert.Equal(t, testPage, body, "无法解码的响应应原样透传")
+	assert.Equal(t, "compress", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, before.UnsupportedEncoding+1, after.UnsupportedEncoding)
+	assert.Equal(t, before.UnsupportedEncodings["compress"]+1, after.UnsupportedEncodings["compress"])
+	assert.Equal(t, before.Injected, after.Injected)
+}
+
+// newStreamingBackend 创建一个分两段输出 HTML 的模拟后端：先输出并刷新页面头部，
+// 等待 release 关闭后再输出 </body>。encoding 为空时不压缩。
+func newStreamingBackend(t *testing.T, encoding string, release <-chan struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		var out io.Writer = w
+		var flush func()
+		switch encoding {
+		case "gzip":
+			w.Header().Set("Content-Encoding", "gzip")
+			gw := gzip.NewWriter(w)
+			defer gw.Close()
+			out = gw
+			flush = func() { gw.Flush(); w.(http.Flusher).Flush() }
+		default:
+			flush = func() { w.(http.Flusher).Flush() }
+		}
+		io.WriteString(out, "<html><body><h1>shell</h1>")
+		flush()
+		<-release
+		io.WriteString(out, "<p>late</p></body></html>")
+	}))
+}
+
+func TestProxy_PreservesFlushBoundaries(t *testing.T) {
+	for _, encoding := range []string{"", "gzip"} {
+		t.Run("encoding="+encoding, func(t *testing.T) {
+			release := make(chan struct{})
+			backend := newStreamingBackend(t, encoding, release)
+			defer backend.Close()
+			defer close(release)
+
+			cfg := newTestConfig(backend.URL)
+			cfg.Encryption.Enabled = true
+			cfg.ScriptInjection.ScriptContent = `<script src="/goga.min.js"></script>`
+			proxy := newTestProxy(t, cfg)
+			front := httptest.NewServer(proxy)
+			defer front.Close()
+
+			req, err := http.NewRequest(http.MethodGet, front.URL, nil)
+			require.NoError(t, err)
+			if encoding != "" {
+				req.Header.Set("Accept-Encoding", encoding)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			var body io.Reader = resp.Body
+			if encoding == "gzip" {
+				gr, err := gzip.NewReader(resp.Body)
+				require.NoError(t, err)
+				body = gr
+			}
+
+			// 后端尚未输出 </body>，页面头部必须已经到达客户端
+			want := "<html><body><h1>shell</h1>"
+			got := make([]byte, len(want))
+			done := make(chan error, 1)
+			go func() {
+				_, err := io.ReadFull(body, got)
+				done <- err
+			}()
+			select {
+			case err := <-done:
+				require.NoError(t, err)
+				assert.Equal(t, want, string(got))
+			case <-time.After(2 * time.Second):
+				t.Fatal("页面头部被缓冲，未能在后端刷新后及时到达客户端")
+			}
+		})
+	}
+}
+
+func TestProxy_FlushesEventStreamImmediately(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer backend.Close()
+	defer close(release)
+
+	cfg := newTestConfig(backend.URL)
+	cfg.Upstream.FlushInterval = time.Hour // 即使配置了很长的刷新间隔，SSE 也必须立即刷新
+	proxy := newTestProxy(t, cfg)
+	front := httptest.NewServer(proxy)
+	defer front.Close()
+
+	resp, err := http.Get(front.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	line := make(chan string, 1)
+	go func() {
+		l, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		line <- l
+	}()
+	select {
+	case l := <-line:
+		assert.Equal(t, "data: first\n", l)
+	case <-time.After(2 * time.Second):
+		t.Fatal("SSE 事件未被立即刷新")
+	}
+}
+
+func TestProxy_Routing(t *testing.T) {
+	// echoBackend 返回带有后端名称和收到的路径的 HTML 页面
+	echoBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintf(w, "<html><body>%s %s</body></html>", name, r.URL.Path)
+		}))
+	}
+	web, api := echoBackend("web"), echoBackend("api")
+	defer web.Close()
+	defer api.Close()
+
+	disabled := false
+	cfg := newTestConfig("")
+	cfg.Encryption.Enabled = true
+	cfg.ScriptInjection.ScriptContent = `<script src="/goga.min.js"></script>`
+	cfg.ScriptInjection.StatusCodes = []int{200}
+	cfg.ScriptInjection.ContentTypes = []string{"text/html"}
+	cfg.Upstream.Backends = []configs.BackendConfig{
+		{Name: "web", URL: web.URL},
+		{Name: "api", URL: api.URL + "/v1"},
+	}
+	cfg.Routes = []configs.RouteConfig{
+		{Host: "www.example.com", Upstream: "web",
+			Encryption: &configs.RouteEncryptionConfig{Methods: []string{"POST", "PUT"}}},
+		{Host: "www.example.com", PathPrefix: "/api", StripPrefix: true, Upstream: "api",
+			Encryption: &configs.RouteEncryptionConfig{Enabled: &disabled}},
+	}
+	proxy := newTestProxy(t, cfg)
+
+	request := func(rawURL string) (*http.Response, string) {
+		rr := httptest.NewRecorder()
+		proxy.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, rawURL, nil))
+		return rr.Result(), rr.Body.String()
+	}
+
+	t.Run("按 Host 转发并注入路由的客户端策略", func(t *testing.T) {
+		_, body := request("http://www.example.com/page")
+		assert.Contains(t, body, "web /page")
+		assert.Contains(t, body, `"methods":["POST","PUT"]`)
+	})
+
+	t.Run("按路径前缀转发、去掉前缀并拼接后端路径", func(t *testing.T) {
+		_, body := request("http://www.example.com/api/users")
+		assert.Contains(t, body, "api /v1/users")
+		assert.NotContains(t, body, "goga.min.js", "路由关闭了加密，不应注入脚本")
+	})
+
+	t.Run("没有匹配的路由", func(t *testing.T) {
+		resp, body := request("http://other.example.com/")
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		assert.Contains(t, body, "NO_ROUTE")
+	})
+}
@@ -9,12 +9,29 @@ import (
 	"context"
 	"fmt"
 	"goga/internal/security"
+	"goga/internal/tracing"
 	"log/slog"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+// redisSizeTimeout 是抓取指标时查询令牌数的超时时间，避免 Redis 不可用时阻塞指标接口
+const redisSizeTimeout = time.Second
+
+// Redis 中的键布局。所有键都带有 "goga:" 前缀，与同一数据库中的其他数据区分
+const (
+	redisEntryPrefix    = "goga:key:"       // 哈希: key (密钥)、client_ip、session，随令牌一起过期
+	redisTokensKey      = "goga:tokens"     // 有序集合: 令牌 -> 过期时间 (Unix 毫秒)，用于计数和清空
+	redisClientIPPrefix = "goga:client_ip:" // 集合: 签发给该客户端 IP 的令牌
+	redisSessionPrefix  = "goga:session:"   // 集合: 签发给该会话的令牌
+)
+
+// redisRevokeBatch 是批量吊销时每个 pipeline 删除的令牌数
+const redisRevokeBatch = 500
+
 // RedisKeyCacheConfig 定义了 RedisKeyCache 的配置。
 type RedisKeyCacheConfig struct {
 	Addr       string
@@ -23,14 +40,15 @@ type RedisKeyCacheConfig struct {
 	TTLSeconds int // 密钥的 TTL (秒)
 }
 
-// RedisKeyCache 是一个基于 Redis 的 KeyCacher 实现。
+// RedisKeyCache 是一个基于 Redis 的 KeyStore 实现。
 type RedisKeyCache struct {
 	client *redis.Client
-	ctx    context.Context // 用于 Redis 操作的上下文
+	ctx    context.Context   // 不属于任何请求的 Redis 操作使用的上下文
+	stats  *keyCacheCounters // 命中、未命中和出错计数
 }
 
 // NewRedisKeyCache 创建并返回一个新的 RedisKeyCache 实例。
-func NewRedisKeyCache(cfg RedisKeyCacheConfig) (security.KeyCacher, error) {
+func NewRedisKeyCache(cfg RedisKeyCacheConfig) (security.KeyStore, error) {
 	client := redis.NewClient(&redis.Options{
 		Addr:     cfg.Addr,
 		Password: cfg.Password,
@@ -49,13 +67,31 @@ func NewRedisKeyCache(cfg RedisKeyCacheConfig) (security.KeyCacher, error) {
 
 	return &RedisKeyCache{
 		client: client,
-		ctx:    context.Background(), // 使用一个长期上下文
+		ctx:    context.Background(), // 用于不属于任何请求的操作，例如抓取指标时查询令牌数
+		stats:  GlobalKeyMetrics.cache(keyCacheRedis),
 	}, nil
 }
 
-// Set 向 Redis 缓存中添加一个带特定 TTL 的密钥。
-func (rc *RedisKeyCache) Set(token string, key []byte, ttl time.Duration) {
-	err := rc.client.Set(rc.ctx, token, key, ttl).Err()
+// Set 向 Redis 缓存中添加一个带特定 TTL 的密钥，并将令牌加入计数和吊销使用的索引。
+func (rc *RedisKeyCache) Set(ctx context.Context, token string, key []byte, ttl time.Duration, meta security.KeyMetadata) {
+	ctx, span := startKeyCacheSpan(ctx, "set", keyCacheRedis)
+	now := time.Now()
+	_, err := rc.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		entryKey := redisEntryPrefix + token
+		pipe.HSet(ctx, entryKey, "key", key, "client_ip", meta.ClientIP, "session", meta.Session)
+		pipe.PExpire(ctx, entryKey, ttl)
+		pipe.ZAdd(ctx, redisTokensKey, redis.Z{Score: float64(now.Add(ttl).UnixMilli()), Member: token})
+		// 顺带清理已过期的令牌，使有序集合的大小与存活的令牌数相当
+		pipe.ZRemRangeByScore(ctx, redisTokensKey, "-inf", strconv.FormatInt(now.UnixMilli(), 10))
+		if meta.ClientIP != "" {
+			addToIndex(ctx, pipe, redisClientIPPrefix+meta.ClientIP, token, ttl)
+		}
+		if meta.Session != "" {
+			addToIndex(ctx, pipe, redisSessionPrefix+meta.Session, token, ttl)
+		}
+		return nil
+	})
+	tracing.EndWithError(span, err)
 	if err != nil {
 		slog.Error("RedisKeyCache: 设置密钥失败", "token", token, "error", err)
 	} else {
@@ -63,23 +99,117 @@ func (rc *RedisKeyCache) Set(token string, key []byte, ttl time.Duration) {
 	}
 }
 
+// addToIndex 将令牌加入按客户端 IP 或会话建立的索引集合。
+// 所有令牌的 TTL 相同，集合的过期时间随最新加入的令牌顺延，不会早于其中任何令牌过期
+func addToIndex(ctx context.Context, pipe redis.Pipeliner, indexKey, token string, ttl time.Duration) {
+	pipe.SAdd(ctx, indexKey, token)
+	pipe.PExpire(ctx, indexKey, ttl)
+}
+
 // Get 从 Redis 缓存中检索一个密钥。
 // 如果找到且未过期，则返回密钥和 true。
 // 如果密钥未找到或已过期，则返回 nil 和 false。
-func (rc *RedisKeyCache) Get(token string) ([]byte, bool) {
-	val, err := rc.client.Get(rc.ctx, token).Bytes()
+func (rc *RedisKeyCache) Get(ctx context.Context, token string) ([]byte, bool) {
+	ctx, span := startKeyCacheSpan(ctx, "get", keyCacheRedis)
+	val, err := rc.client.HGet(ctx, redisEntryPrefix+token, "key").Bytes()
 	if err == redis.Nil {
+		endKeyCacheSpan(span, false, nil)
 		slog.Debug("RedisKeyCache: 缓存未命中或已过期", "token", token)
+		rc.stats.misses.Add(1)
 		return nil, false
 	}
 	if err != nil {
 		slog.Error("RedisKeyCache: 获取密钥失败", "token", token, "error", err)
+		endKeyCacheSpan(span, false, err)
+		rc.stats.errors.Add(1)
 		return nil, false
 	}
 	slog.Debug("RedisKeyCache: 缓存命中", "token", token)
+	endKeyCacheSpan(span, true, nil)
+	rc.stats.hits.Add(1)
 	return val, true
 }
 
+// Count 返回未过期的令牌数
+func (rc *RedisKeyCache) Count(ctx context.Context) (int64, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	return rc.client.ZCount(ctx, redisTokensKey, "("+now, "+inf").Result()
+}
+
+// Ping 向 Redis 发送 PING 命令，检查密钥缓存是否可用
+func (rc *RedisKeyCache) Ping(ctx context.Context) error {
+	return rc.client.Ping(ctx).Err()
+}
+
+// Size 返回未过期的令牌数，供指标接口使用
+func (rc *RedisKeyCache) Size() (int64, error) {
+	ctx, cancel := context.WithTimeout(rc.ctx, redisSizeTimeout)
+	defer cancel()
+	return rc.Count(ctx)
+}
+
+// Revoke 吊销单个令牌，令牌不存在或已过期时返回 false
+func (rc *RedisKeyCache) Revoke(ctx context.Context, token string) (bool, error) {
+	revoked, err := rc.revokeTokens(ctx, []string{token})
+	return revoked > 0, err
+}
+
+// RevokeClientIP 吊销签发给指定客户端 IP 的所有令牌
+func (rc *RedisKeyCache) RevokeClientIP(ctx context.Context, clientIP string) (int64, error) {
+	return rc.revokeIndex(ctx, redisClientIPPrefix+clientIP)
+}
+
+// RevokeSession 吊销签发给指定会话的所有令牌
+func (rc *RedisKeyCache) RevokeSession(ctx context.Context, session string) (int64, error) {
+	return rc.revokeIndex(ctx, redisSessionPrefix+session)
+}
+
+// Flush 吊销所有令牌。按客户端 IP 和会话建立的索引集合不再指向有效令牌，随 TTL 自然过期
+func (rc *RedisKeyCache) Flush(ctx context.Context) (int64, error) {
+	tokens, err := rc.client.ZRange(ctx, redisTokensKey, 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+	return rc.revokeTokens(ctx, tokens)
+}
+
+// revokeIndex 吊销索引集合中的所有令牌，然后删除该集合
+func (rc *RedisKeyCache) revokeIndex(ctx context.Context, indexKey string) (int64, error) {
+	tokens, err := rc.client.SMembers(ctx, indexKey).Result()
+	if err != nil {
+		return 0, err
+	}
+	revoked, err := rc.revokeTokens(ctx, tokens)
+	if err != nil {
+		return revoked, err
+	}
+	return revoked, rc.client.Del(ctx, indexKey).Err()
+}
+
+// revokeTokens 分批删除令牌的条目并将其移出有序集合，返回实际删除的条目数 (不含已过期的令牌)
+func (rc *RedisKeyCache) revokeTokens(ctx context.Context, tokens []string) (int64, error) {
+	var revoked int64
+	for batch := range slices.Chunk(tokens, redisRevokeBatch) {
+		members := make([]any, len(batch))
+		dels := make([]*redis.IntCmd, len(batch))
+		_, err := rc.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, token := range batch {
+				dels[i] = pipe.Del(ctx, redisEntryPrefix+token)
+				members[i] = token
+			}
+			pipe.ZRem(ctx, redisTokensKey, members...)
+			return nil
+		})
+		if err != nil {
+			return revoked, err
+		}
+		for _, del := range dels {
+			revoked += del.Val()
+		}
+	}
+	return revoked, nil
+}
+
 // Stop 关闭 Redis 客户端连接。
 func (rc *RedisKeyCache) Stop() {
 	err := rc.client.Close()
@@ -0,0 +1,203 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package gateway
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// maxInjectBodySize 限制 HTML 响应体的最大处理尺寸 (1 MB)。
+const maxInjectBodySize = 1 * 1024 * 1024
+
+// rewriteHTMLResponse 为响应装配 "解压 -> 注入 -> 可选重压缩" 的流式管道。
+// 返回 false 表示响应的编码链中有无法处理的编码，响应体未被修改。
+func rewriteHTMLResponse(resp *http.Response, script []byte, pools *compressionPools) (bool, error) {
+	encodings := parseContentEncoding(resp.Header.Values("Content-Encoding"))
+	if unsupportedEncoding(encodings) != "" {
+		return false, nil
+	}
+
+	original := resp.Body
+	boundary := &readBoundary{r: original}
+
+	// 使用 io.LimitedReader 包装原始 body 以限制大小
+	limitedReader := &io.LimitedReader{R: boundary, N: maxInjectBodySize}
+
+	var reader io.Reader = limitedReader
+	var closers []io.Closer
+
+	if len(encodings) > 0 {
+		// 如果有压缩，则逐层构建流式解压 Reader
+		decoded, decoders, err := newDecodingChain(encodings, limitedReader)
+		if err != nil {
+			// 解压器在初始化时已经读取了部分响应体，无法再原样透传
+			return false, fmt.Errorf("创建流式解压 reader 失败 (encoding=%s): %w", strings.Join(encodings, ", "), err)
+		}
+		reader = decoded
+		closers = decoders
+		slog.Debug("已应用流式解压", "encodings", encodings)
+	}
+	closers = append(closers, original)
+
+	injector := NewScriptInjector(reader, script)
+
+	// 长度在注入后会变化，移除 Content-Length 并改为流式传输
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+
+	outputEncoding, encoding := "", ""
+	if len(encodings) > 0 {
+		var acceptEncoding string
+		if resp.Request != nil {
+			acceptEncoding = resp.Request.Header.Get("Accept-Encoding")
+		}
+		encoding = encodings[len(encodings)-1]
+		outputEncoding = pools.outputEncoding(encoding, acceptEncoding)
+	}
+
+	if outputEncoding == "" {
+		// 场景一：以明文发送注入后的 HTML
+		resp.Header.Del("Content-Encoding")
+		resp.Body = &readCloser{Reader: injector, closers: append([]io.Closer{injector}, closers...)}
+		return true, nil
+	}
+
+	// 场景二：重新压缩。多层编码链会被合并为单层编码
+	resp.Header.Set("Content-Encoding", outputEncoding)
+	if outputEncoding != encoding {
+		resp.Header.Add("Vary", "Accept-Encoding")
+	}
+	resp.Body = newCompressingReader(injector, boundary, outputEncoding, pools, closers...)
+	slog.Debug("已装配注入后的重压缩管道", "input_encodings", encodings, "output_encoding", outputEncoding)
+	return true, nil
+}
+
+// readCloser 将 Reader 与需要一并关闭的资源组合在一起。
+type readCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (rc *readCloser) Close() error {
+	var firstErr error
+	for _, c := range rc.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	rc.closers = nil
+	return firstErr
+}
+
+// readBoundary 记录后端响应体的读取是否停在了已到达数据的末尾。
+// 对后端响应体的一次读取没有填满缓冲区，说明当时连接上没有更多已到达的数据：分块传输时通常是后端刷新的边界，
+// 其他情况下是数据尚未到达。注入器和解压器自身产生的短读 (例如注入器查找 </body> 时按块返回) 不会被计入。
+type readBoundary struct {
+	r       io.Reader
+	stalled bool
+}
+
+func (b *readBoundary) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err == nil && n < len(p) {
+		b.stalled = true
+	}
+	return n, err
+}
+
+// take 返回自上次调用以来后端是否出现过停顿，并清除该标记
+func (b *readBoundary) take() bool {
+	stalled := b.stalled
+	b.stalled = false
+	return stalled
+}
+
+// compressingReader 以拉取方式完成 "读取注入后的 HTML -> 压缩" 的过程。
+// 与 goroutine + io.Pipe 的方案相比，它不需要为每个响应额外启动 goroutine，
+// 压缩器和复制缓冲区也都来自池。
+//
+// 只有后端响应体的读取停顿 (见 readBoundary) 时才刷新压缩器，使后端已经刷新的内容立即发送给客户端；
+// 一次性返回的页面按单次压缩处理，不会因为注入器按块返回数据而反复刷新、降低压缩率。
+type compressingReader struct {
+	src     io.ReadCloser
+	stalls  *readBoundary // 后端响应体的读取停顿
+	enc     encoder
+	out     bytes.Buffer // 压缩器的输出，等待被调用者读取
+	bufPtr  *[]byte
+	closers []io.Closer
+	srcDone bool
+	pending bool // 压缩器中是否有尚未刷新的数据
+}
+
+// newCompressingReader 创建一个 compressingReader，关闭时会一并关闭 closers。
+func newCompressingReader(src io.ReadCloser, stalls *readBoundary, encoding string, pools *compressionPools, closers ...io.Closer) *compressingReader {
+	cr := &compressingReader{
+		src:     src,
+		stalls:  stalls,
+		bufPtr:  getCopyBuffer(),
+		closers: closers,
+	}
+	cr.enc = pools.getCompressionWriter(encoding, &cr.out)
+	return cr
+}
+
+// Read 实现 io.Reader 接口。
+func (cr *compressingReader) Read(p []byte) (int, error) {
+	for cr.out.Len() == 0 && !cr.srcDone {
+		buf := (*cr.bufPtr)[:cap(*cr.bufPtr)]
+		n, err := cr.src.Read(buf)
+		if n > 0 {
+			if _, werr := cr.enc.Write(buf[:n]); werr != nil {
+				return 0, werr
+			}
+			cr.pending = true
+		}
+		if err == nil && cr.pending && cr.stalls.take() {
+			// 后端暂时没有更多数据：保留后端的刷新边界
+			if ferr := cr.enc.Flush(); ferr != nil {
+				return 0, ferr
+			}
+			cr.pending = false
+		}
+		if err == io.EOF {
+			// 写出压缩流的结尾，并将压缩器归还到池中
+			cr.srcDone = true
+			if cerr := cr.enc.Close(); cerr != nil {
+				return 0, cerr
+			}
+		} else if err != nil {
+			return 0, err
+		}
+	}
+
+	if cr.out.Len() > 0 {
+		return cr.out.Read(p)
+	}
+	return 0, io.EOF
+}
+
+// Close 释放压缩器、缓冲区并关闭上游。
+func (cr *compressingReader) Close() error {
+	if cr.bufPtr == nil {
+		return nil
+	}
+	cr.enc.Close()
+	putCopyBuffer(cr.bufPtr)
+	cr.bufPtr = nil
+
+	err := cr.src.Close()
+	for _, c := range cr.closers {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,297 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"goga/configs"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultBackendName 是未配置 upstream.backends 时由 backend_url 生成的后端名称。
+const defaultBackendName = "default"
+
+// Route 是一条编译后的路由规则。
+type Route struct {
+	index       int    // 在路由表中的序号，用于查找按路由预先构建的处理器
+	name        string // 指标中的 route 标签
+	host        string // 精确 Host，或以 "*." 开头的通配 Host，为空表示任意 Host
+	pathPrefix  string
+	stripPrefix bool
+	rewrite     string
+	hasRewrite  bool
+	idempotent  bool // 路由上的所有请求都可以安全重试
+
+	Backend    *Backend
+	Encryption configs.EncryptionConfig // 合并全局配置后的加密配置
+	ClientCert configs.ClientCertConfig // 对客户端证书的要求
+}
+
+// RouteTable 保存所有路由及其引用的后端，HTTP 代理和 WebSocket 代理共享同一张路由表。
+type RouteTable struct {
+	routes   []*Route // 按匹配优先级排序
+	backends []*Backend
+	client   *upstreamClient // 与所有后端通信共享的连接配置
+}
+
+// NewRouteTable 根据配置构建路由表，并为配置了健康检查的上游池启动主动健康检查，使用完毕后需调用 Stop。
+// 未配置 upstream.backends 时使用 backend_url 作为默认后端；未配置 routes 时生成一条匹配所有请求的路由。
+func NewRouteTable(cfg *configs.Config) (*RouteTable, error) {
+	backendConfigs := cfg.Upstream.Backends
+	implicitBackend := len(backendConfigs) == 0
+	if implicitBackend {
+		backendConfigs = []configs.BackendConfig{{Name: defaultBackendName, URL: cfg.BackendURL}}
+	}
+
+	client, err := newUpstreamClient(cfg.Upstream.Transport)
+	if err != nil {
+		return nil, err
+	}
+
+	rt := &RouteTable{client: client}
+	backendsByName := make(map[string]*Backend, len(backendConfigs))
+	for _, bc := range backendConfigs {
+		if bc.Name == "" {
+			return nil, fmt.Errorf("后端缺少 name (url=%s)", bc.URL)
+		}
+		if _, ok := backendsByName[bc.Name]; ok {
+			return nil, fmt.Errorf("后端名称重复: %s", bc.Name)
+		}
+		// 兼容旧配置：由 backend_url 生成的默认后端不做严格校验，错误在转发时以 502 体现
+		backend, err := newBackend(bc, !implicitBackend)
+		if err != nil {
+			return nil, err
+		}
+		backendsByName[bc.Name] = backend
+		rt.backends = append(rt.backends, backend)
+	}
+
+	routeConfigs := cfg.Routes
+	if len(routeConfigs) == 0 {
+		routeConfigs = []configs.RouteConfig{{}}
+	}
+
+	for i, rc := range routeConfigs {
+		backend := rt.backends[0]
+		if rc.Upstream != "" {
+			var ok bool
+			if backend, ok = backendsByName[rc.Upstream]; !ok {
+				return nil, fmt.Errorf("路由 #%d 引用了不存在的后端: %s", i, rc.Upstream)
+			}
+		}
+		if rc.StripPrefix && rc.Rewrite != "" {
+			return nil, fmt.Errorf("路由 #%d 不能同时配置 strip_prefix 和 rewrite", i)
+		}
+		if rc.PathPrefix != "" && !strings.HasPrefix(rc.PathPrefix, "/") {
+			return nil, fmt.Errorf("路由 #%d 的 path_prefix 必须以 / 开头: %q", i, rc.PathPrefix)
+		}
+
+		rt.routes = append(rt.routes, &Route{
+			name:        routeName(rc),
+			host:        strings.ToLower(rc.Host),
+			pathPrefix:  rc.PathPrefix,
+			stripPrefix: rc.StripPrefix,
+			rewrite:     rc.Rewrite,
+			hasRewrite:  rc.Rewrite != "",
+			idempotent:  rc.Idempotent,
+			Backend:     backend,
+			Encryption:  mergeEncryptionConfig(cfg.Encryption, rc.Encryption),
+			ClientCert:  rc.ClientCert,
+		})
+	}
+
+	// 精确 Host 优先于通配 Host，通配 Host 优先于任意 Host；Host 相同时路径前缀越长越优先。
+	// 优先级相同的路由保持配置中的顺序。
+	sort.SliceStable(rt.routes, func(i, j int) bool {
+		hi, hj := hostPriority(rt.routes[i].host), hostPriority(rt.routes[j].host)
+		if hi != hj {
+			return hi > hj
+		}
+		return len(rt.routes[i].pathPrefix) > len(rt.routes[j].pathPrefix)
+	})
+	for i, route := range rt.routes {
+		route.index = i
+	}
+
+	for _, backend := range rt.backends {
+		backend.startHealthCheck(client.transportFor(backend.protocol))
+	}
+
+	return rt, nil
+}
+
+// Stop 停止所有上游池的主动健康检查，并关闭到后端的空闲连接。
+func (rt *RouteTable) Stop() {
+	for _, backend := range rt.backends {
+		backend.stopHealthCheck()
+	}
+	rt.client.closeIdleConnections()
+}
+
+// routeName 返回路由的名称，未配置 name 时由 Host 和路径前缀生成，例如 "shop.example.com/api" 或 "*/"。
+func routeName(rc configs.RouteConfig) string {
+	if rc.Name != "" {
+		return rc.Name
+	}
+	host, prefix := strings.ToLower(rc.Host), rc.PathPrefix
+	if host == "" {
+		host = "*"
+	}
+	if prefix == "" {
+		prefix = "/"
+	}
+	return host + prefix
+}
+
+// mergeEncryptionConfig 用路由级别的覆盖项合并全局加密配置。
+func mergeEncryptionConfig(global configs.EncryptionConfig, override *configs.RouteEncryptionConfig) configs.EncryptionConfig {
+	merged := global
+	if override == nil {
+		return merged
+	}
+	if override.Enabled != nil {
+		merged.Enabled = *override.Enabled
+	}
+	if override.MustEncryptRoutes != nil {
+		merged.MustEncryptRoutes = override.MustEncryptRoutes
+	}
+	if override.Methods != nil {
+		merged.Methods = override.Methods
+	}
+	return merged
+}
+
+// hostPriority 返回 Host 模式的匹配优先级：精确 Host > 通配 Host (后缀越长越优先) > 任意 Host。
+func hostPriority(host string) int {
+	switch {
+	case host == "":
+		return 0
+	case strings.HasPrefix(host, "*."):
+		return len(host)
+	default:
+		// 精确 Host 总是排在所有通配 Host 之前
+		return 1 << 16
+	}
+}
+
+// Routes 返回按匹配优先级排序的路由。
+func (rt *RouteTable) Routes() []*Route {
+	return rt.routes
+}
+
+// Backends 返回路由表中的所有后端。
+func (rt *RouteTable) Backends() []*Backend {
+	return rt.backends
+}
+
+// CheckBackend 检查上游池是否可达：至少有一个上游没有被主动健康检查、被动摘除或熔断器排除。
+// 配置了主动健康检查时以其结果为准，否则还需要能与该上游建立连接。全部不可达时返回最后一个错误
+func (rt *RouteTable) CheckBackend(ctx context.Context, b *Backend) error {
+	now := time.Now().UnixNano()
+	err := errors.New("没有配置上游")
+	for _, t := range b.targets {
+		if !t.available(now) {
+			err = fmt.Errorf("上游 %s 不可用 (健康检查失败、被摘除或熔断)", t.addr())
+			continue
+		}
+		if b.healthCheck.Path != "" {
+			return nil
+		}
+		conn, dialErr := rt.client.dialContext(ctx, "tcp", dialAddr(t.URL))
+		if dialErr != nil {
+			err = fmt.Errorf("无法连接上游 %s: %w", t.addr(), dialErr)
+			continue
+		}
+		conn.Close()
+		return nil
+	}
+	return err
+}
+
+// Match 返回与请求匹配的第一条路由，没有匹配时返回 nil。
+func (rt *RouteTable) Match(r *http.Request) *Route {
+	host := strings.ToLower(r.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, route := range rt.routes {
+		if route.matchHost(host) && route.matchPath(r.URL.Path) {
+			return route
+		}
+	}
+	return nil
+}
+
+// matchHost 判断 Host (已去掉端口并转为小写) 是否与路由匹配。
+func (route *Route) matchHost(host string) bool {
+	if route.host == "" {
+		return true
+	}
+	return matchHost(route.host, host)
+}
+
+// matchPath 按路径段判断路径是否以路由的前缀开头。
+func (route *Route) matchPath(path string) bool {
+	prefix := route.pathPrefix
+	if prefix == "" || prefix == "/" {
+		return true
+	}
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return strings.HasSuffix(prefix, "/") || len(path) == len(prefix) || path[len(prefix)] == '/'
+}
+
+// rewriteURL 按路由的 strip_prefix 或 rewrite 配置改写转发给后端的路径。
+func (route *Route) rewriteURL(u *url.URL) {
+	if route.pathPrefix == "" || (!route.stripPrefix && !route.hasRewrite) {
+		return
+	}
+	u.Path = route.replacePrefix(u.Path)
+	if u.RawPath != "" {
+		// 转义形式的前缀与 Path 不一致时，交由 url 包根据新的 Path 重新生成
+		if strings.HasPrefix(u.RawPath, route.pathPrefix) {
+			u.RawPath = route.replacePrefix(u.RawPath)
+		} else {
+			u.RawPath = ""
+		}
+	}
+}
+
+// replacePrefix 将路径中匹配的前缀替换为 rewrite (strip_prefix 时替换为空)，并保证结果以 / 开头。
+func (route *Route) replacePrefix(path string) string {
+	rest := strings.TrimPrefix(path, route.pathPrefix)
+	if route.hasRewrite {
+		rest = singleJoiningSlash(route.rewrite, rest)
+	}
+	if !strings.HasPrefix(rest, "/") {
+		rest = "/" + rest
+	}
+	return rest
+}
+
+// singleJoiningSlash 拼接两段路径，保证连接处只有一个斜杠。b 为空时原样返回 a。
+func singleJoiningSlash(a, b string) string {
+	if b == "" {
+		return a
+	}
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package gateway
+
+import (
+	"goga/configs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRoutingConfig() *configs.Config {
+	disabled := false
+	cfg := newTestConfig("")
+	cfg.Encryption = configs.EncryptionConfig{Enabled: true, Methods: []string{"POST"}}
+	cfg.Upstream.Backends = []configs.BackendConfig{
+		{Name: "web", URL: "http://web.internal:3000"},
+		{Name: "api", URL: "http://api.internal:8000/base"},
+		{Name: "admin", URL: "http://admin.internal"},
+		{Name: "static", URL: "http://static.internal"},
+	}
+	cfg.Routes = []configs.RouteConfig{
+		{Upstream: "web"},
+		{PathPrefix: "/api", StripPrefix: true, Upstream: "api",
+			Encryption: &configs.RouteEncryptionConfig{Methods: []string{"POST", "PUT"}}},
+		{Host: "admin.example.com", Upstream: "admin",
+			Encryption: &configs.RouteEncryptionConfig{MustEncryptRoutes: []string{"^/"}}},
+		{Host: "*.cdn.example.com", PathPrefix: "/assets/", Rewrite: "/public/", Upstream: "static",
+			Encryption: &configs.RouteEncryptionConfig{Enabled: &disabled}},
+	}
+	return cfg
+}
+
+func TestRouteTable_Match(t *testing.T) {
+	rt, err := NewRouteTable(newRoutingConfig())
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name        string
+		url         string
+		wantBackend string
+	}{
+		{"默认路由", "http://example.com/", "web"},
+		{"路径前缀", "http://example.com/api/users", "api"},
+		{"路径前缀本身", "http://example.com/api", "api"},
+		{"前缀按路径段匹配", "http://example.com/apix", "web"},
+		{"精确 Host 优先于路径前缀", "http://admin.example.com:8443/api/users", "admin"},
+		{"Host 不区分大小写", "http://ADMIN.example.com/", "admin"},
+		{"通配 Host", "http://img.cdn.example.com/assets/a.png", "static"},
+		{"通配 Host 但路径不匹配", "http://img.cdn.example.com/other", "web"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			route := rt.Match(httptest.NewRequest("GET", tc.url, nil))
+			require.NotNil(t, route)
+			assert.Equal(t, tc.wantBackend, route.Backend.Name)
+		})
+	}
+}
+
+func TestRouteTable_NoMatch(t *testing.T) {
+	cfg := newRoutingConfig()
+	cfg.Routes = cfg.Routes[1:] // 去掉兜底路由
+	rt, err := NewRouteTable(cfg)
+	require.NoError(t, err)
+	assert.Nil(t, rt.Match(httptest.NewRequest("GET", "http://example.com/", nil)))
+}
+
+func TestRouteTable_Encryption(t *testing.T) {
+	rt, err := NewRouteTable(newRoutingConfig())
+	require.NoError(t, err)
+
+	match := func(rawURL string) *Route {
+		return rt.Match(httptest.NewRequest("GET", rawURL, nil))
+	}
+
+	web := match("http://example.com/")
+	assert.True(t, web.Encryption.Enabled, "未覆盖时沿用全局配置")
+	assert.Equal(t, []string{"POST"}, web.Encryption.Methods)
+
+	api := match("http://example.com/api")
+	assert.True(t, api.Encryption.Enabled)
+	assert.Equal(t, []string{"POST", "PUT"}, api.Encryption.Methods)
+
+	admin := match("http://admin.example.com/")
+	assert.Equal(t, []string{"^/"}, admin.Encryption.MustEncryptRoutes)
+	assert.Equal(t, []string{"POST"}, admin.Encryption.Methods)
+
+	static := match("http://img.cdn.example.com/assets/")
+	assert.False(t, static.Encryption.Enabled)
+}
+
+func TestRoute_RewriteURL(t *testing.T) {
+	rt, err := NewRouteTable(newRoutingConfig())
+	require.NoError(t, err)
+
+	testCases := []struct {
+		url      string
+		wantPath string
+	}{
+		{"http://example.com/api/users?id=1", "/users"},
+		{"http://example.com/api", "/"},
+		{"http://img.cdn.example.com/assets/css/a.css", "/public/css/a.css"},
+		{"http://example.com/keep/path", "/keep/path"},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest("GET", tc.url, nil)
+		u := *req.URL
+		rt.Match(req).rewriteURL(&u)
+		assert.Equal(t, tc.wantPath, u.Path, tc.url)
+	}
+
+	// 转义路径同样需要改写
+	u, err := url.Parse("http://example.com/api/a%2Fb")
+	require.NoError(t, err)
+	rt.Match(httptest.NewRequest("GET", u.String(), nil)).rewriteURL(u)
+	assert.Equal(t, "/a%2Fb", u.EscapedPath())
+}
+
+func TestNewRouteTable_InvalidConfig(t *testing.T) {
+	testCases := map[string]func(cfg *configs.Config){
+		"未知后端":                        func(cfg *configs.Config) { cfg.Routes[0].Upstream = "missing" },
+		"后端名称重复":                      func(cfg *configs.Config) { cfg.Upstream.Backends[1].Name = "web" },
+		"后端缺少协议":                      func(cfg *configs.Config) { cfg.Upstream.Backends[0].URL = "web.internal:3000" },
+		"strip_prefix 与 rewrite 同时配置": func(cfg *configs.Config) { cfg.Routes[1].Rewrite = "/v1" },
+		"path_prefix 不以 / 开头":         func(cfg *configs.Config) { cfg.Routes[1].PathPrefix = "api" },
+	}
+	for name, mutate := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfg := newRoutingConfig()
+			mutate(cfg)
+			_, err := NewRouteTable(cfg)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestNewRouteTable_DefaultsToBackendURL(t *testing.T) {
+	rt, err := NewRouteTable(newTestConfig("http://localhost:3000"))
+	require.NoError(t, err)
+	require.Len(t, rt.Backends(), 1)
+	assert.Equal(t, defaultBackendName, rt.Backends()[0].Name)
+
+	route := rt.Match(httptest.NewRequest("GET", "http://any.host/any/path", nil))
+	require.NotNil(t, route)
+	assert.Equal(t, "localhost:3000", route.Backend.URL.Host)
+}
+
+func TestRouteTable_CheckBackend(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	rt, err := NewRouteTable(newTestConfig(upstream.URL))
+	require.NoError(t, err)
+	defer rt.Stop()
+	b := rt.Backends()[0]
+	assert.NoError(t, rt.CheckBackend(t.Context(), b))
+
+	// 上游无法连接
+	upstream.Close()
+	assert.ErrorContains(t, rt.CheckBackend(t.Context(), b), "无法连接上游")
+
+	// 被摘除的上游不再尝试连接
+	b.targets[0].healthy.Store(false)
+	assert.ErrorContains(t, rt.CheckBackend(t.Context(), b), "不可用")
+}
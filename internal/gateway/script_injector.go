@@ -9,11 +9,6 @@ import (
 	"bytes"
 	"io"
 	"sync"
-
-	"github.com/andybalholm/brotli"
-	"github.com/klauspost/compress/gzip"
-	"github.com/klauspost/compress/zstd"
-	"github.com/pierrec/lz4/v4"
 )
 
 // injectorState 定义了 scriptInjector 的内部状态
@@ -163,37 +158,35 @@ func (si *scriptInjector) Read(p []byte) (n int, err error) {
 				continue
 			}
 
-			// 5. 写出安全数据
-			safeWriteEnd := si.bufferEnd
-			if safeWriteEnd > len(si.searchTag) {
-				safeWriteEnd -= (len(si.searchTag) - 1)
-			} else {
-				// 缓冲区中的数据不足以做出安全判断，并且上游还未结束
-				// 如果上游已经EOF但缓冲区数据不足，直接切换到透传模式
-				if si.upstreamEOF {
-					si.state = statePassthrough
-					continue
-				}
+			// 5. 写出安全数据：只保留可能是标签前缀的末尾字节，其余数据立即交给下游，
+			// 以保持后端的刷新边界 (流式 SSR 等场景依赖尽早输出)
+			safeWriteEnd := si.bufferEnd - partialTagSuffix(si.buffer[si.searchPos:si.bufferEnd], si.searchTag)
+			if safeWriteEnd <= si.searchPos {
+				// 缓冲区中只剩可能的标签前缀，并且上游还未结束
 				// 返回 (0, nil) 等待更多数据，这依赖于调用者（如 io.Copy）的重试
 				return 0, nil
 			}
 
-			if dataToWrite := si.buffer[si.searchPos:safeWriteEnd]; len(dataToWrite) > 0 {
-				n = copy(p, dataToWrite)
-				si.searchPos += n
-				return n, nil
-			}
+			n = copy(p, si.buffer[si.searchPos:safeWriteEnd])
+			si.searchPos += n
+			return n, nil
+		}
+	}
+}
 
-			// 没有可写的安全数据，可能因为searchPos已经赶上了safeWriteEnd
-			// 如果上游已经EOF，直接切换到透传模式
-			if si.upstreamEOF {
-				si.state = statePassthrough
-				continue
-			}
-			// 返回 (0, nil) 等待更多数据
-			return 0, nil
+// partialTagSuffix 返回 data 末尾与 tag 前缀相同的最长字节数 (不含完整的 tag)。
+// 这些字节可能与下一次读取的数据拼成完整的标签，因此需要暂时保留。
+func partialTagSuffix(data, tag []byte) int {
+	maxLen := len(tag) - 1
+	if len(data) < maxLen {
+		maxLen = len(data)
+	}
+	for l := maxLen; l > 0; l-- {
+		if bytes.Equal(data[len(data)-l:], tag[:l]) {
+			return l
 		}
 	}
+	return 0
 }
 
 // Close 实现 io.Closer 接口。
@@ -209,43 +202,3 @@ func (si *scriptInjector) Close() error {
 	}
 	return nil
 }
-
-// getDecompressionReader 和其他辅助函数...
-func getDecompressionReader(encoding string, upstream io.Reader) (io.ReadCloser, error) {
-	switch encoding {
-	case "gzip":
-		return gzip.NewReader(upstream)
-	case "br":
-		return io.NopCloser(brotli.NewReader(upstream)), nil
-	case "zstd":
-		r, err := zstd.NewReader(upstream)
-		if err != nil {
-			return nil, err
-		}
-		return r.IOReadCloser(), nil
-	case "lz4":
-		return io.NopCloser(lz4.NewReader(upstream)), nil
-	default:
-		return nil, nil
-	}
-}
-
-// nopWriteCloser 是一个不执行任何操作的 io.WriteCloser，用于在没有压缩时包装 io.Writer。
-type nopWriteCloser struct{ io.Writer }
-
-func (nwc *nopWriteCloser) Close() error           { return nil } // Close 方法不执行任何操作。
-func newNopWriteCloser(w io.Writer) io.WriteCloser { return &nopWriteCloser{w} } // newNopWriteCloser 创建一个新的 nopWriteCloser 实例。
-func getCompressionWriter(encoding string, downstream io.Writer) (io.WriteCloser, error) {
-	switch encoding {
-	case "gzip":
-		return gzip.NewWriter(downstream), nil
-	case "br":
-		return brotli.NewWriter(downstream), nil
-	case "zstd":
-		return zstd.NewWriter(downstream)
-	case "lz4":
-		return lz4.NewWriter(downstream), nil
-	default:
-		return newNopWriteCloser(downstream), nil
-	}
-}
@@ -84,4 +84,69 @@ func TestScriptInjector(t *testing.T) {
 		`<script/>`,
 		prefix+`<body>`+suffix+`<script/></body>`,
 	)
-}
\ No newline at end of file
+}
+// chunkedReader 每次 Read 只返回一个预先切分好的数据块，用于模拟后端的分段刷新。
+type chunkedReader struct {
+	chunks []string
+}
+
+func (cr *chunkedReader) Read(p []byte) (int, error) {
+	if len(cr.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, cr.chunks[0])
+	cr.chunks[0] = cr.chunks[0][n:]
+	if cr.chunks[0] == "" {
+		cr.chunks = cr.chunks[1:]
+	}
+	return n, nil
+}
+
+func TestScriptInjector_FlushBoundaries(t *testing.T) {
+	// 每个数据块读取后，注入器应立即输出除可能的标签前缀以外的全部数据
+	upstream := &chunkedReader{chunks: []string{"<div>a</div>", "<p>b</p></bo", "dy></html>"}}
+	injector := NewScriptInjector(upstream, []byte("<script/>"))
+	defer injector.Close()
+
+	readChunk := func() string {
+		buf := make([]byte, 64)
+		for {
+			n, err := injector.Read(buf)
+			if n > 0 || err != nil {
+				return string(buf[:n])
+			}
+		}
+	}
+
+	if got := readChunk(); got != "<div>a</div>" {
+		t.Errorf("第一个数据块应被完整输出，得到: %q", got)
+	}
+	if got := readChunk(); got != "<p>b</p>" {
+		t.Errorf("只应保留可能的标签前缀 \"</bo\"，得到: %q", got)
+	}
+	rest, err := io.ReadAll(injector)
+	if err != nil {
+		t.Fatalf("读取时发生意外错误: %v", err)
+	}
+	if string(rest) != "<script/></body></html>" {
+		t.Errorf("注入结果不匹配，得到: %q", rest)
+	}
+}
+
+func TestPartialTagSuffix(t *testing.T) {
+	tag := []byte("</body>")
+	cases := map[string]int{
+		"<div>":     0,
+		"abc<":      1,
+		"abc</bo":   4,
+		"abc</body": 6,
+		"</b":       3,
+		"<<":        1,
+		"":          0,
+	}
+	for data, want := range cases {
+		if got := partialTagSuffix([]byte(data), tag); got != want {
+			t.Errorf("partialTagSuffix(%q) = %d, 预期 %d", data, got, want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package gateway
+
+import (
+	"context"
+	"goga/internal/middleware"
+	"goga/internal/tracing"
+	"io"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 网关自定义的 span 属性
+const (
+	routeAttr           = attribute.Key("goga.route")
+	backendAttr         = attribute.Key("goga.backend")
+	attemptAttr         = attribute.Key("goga.upstream.attempt")
+	keyCacheBackendAttr = attribute.Key("goga.key_cache.backend")
+	keyCacheHitAttr     = attribute.Key("goga.key_cache.hit")
+	injectOutcomeAttr   = attribute.Key("goga.inject.outcome")
+)
+
+// startKeyCacheSpan 为一次密钥缓存操作创建 span
+func startKeyCacheSpan(ctx context.Context, op, backend string) (context.Context, trace.Span) {
+	kind := trace.SpanKindInternal
+	attrs := []attribute.KeyValue{keyCacheBackendAttr.String(backend)}
+	if backend == keyCacheRedis {
+		kind = trace.SpanKindClient
+		attrs = append(attrs, semconv.DBSystemNameRedis)
+	}
+	return tracing.Start(ctx, "goga.key_cache."+op, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+}
+
+// endKeyCacheSpan 记录查找结果并结束密钥缓存 span
+func endKeyCacheSpan(span trace.Span, hit bool, err error) {
+	span.SetAttributes(keyCacheHitAttr.Bool(hit))
+	tracing.EndWithError(span, err)
+}
+
+// startUpstreamSpan 为一次转发尝试创建客户端 span，并把该 span 的 traceparent 写入转发请求头，
+// 后端的 span 因此成为网关 span 的子 span
+func startUpstreamSpan(req *http.Request, route *Route, target *Target, attempt int) trace.Span {
+	ctx, span := tracing.Start(req.Context(), req.Method, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.HTTPRequestMethodKey.String(req.Method),
+		semconv.URLPath(req.URL.Path),
+		semconv.ServerAddress(target.addr()),
+		routeAttr.String(route.name),
+		backendAttr.String(route.Backend.Name),
+		attemptAttr.Int(attempt),
+	))
+	tracing.Inject(ctx, req.Header)
+	return span
+}
+
+// endUpstreamSpan 记录上游的响应状态码或错误并结束客户端 span
+func endUpstreamSpan(span trace.Span, resp *http.Response, err error) {
+	if resp != nil {
+		span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	}
+	tracing.EndWithError(span, err)
+}
+
+// setRequestIDHeader 将网关的请求 ID 转发给后端，后端日志可以据此与网关日志和 span 关联
+func setRequestIDHeader(out http.Header, in *http.Request) {
+	if requestID, _ := in.Context().Value(middleware.RequestIDKey).(string); requestID != "" {
+		out.Set(middleware.RequestIDHeader, requestID)
+	}
+}
+
+// spanEndingBody 在响应体关闭时结束 span，使注入 span 覆盖整个流式改写过程
+type spanEndingBody struct {
+	io.ReadCloser
+	span trace.Span
+}
+
+// Close 关闭响应体并结束 span
+func (b *spanEndingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.span.End()
+	return err
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package gateway
+
+import (
+	"goga/internal/middleware"
+	"goga/internal/tracing"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// useSpanRecorder 将全局 TracerProvider 替换为记录所有 span 的实现，测试结束后恢复
+func useSpanRecorder(t *testing.T, opts ...sdktrace.TracerProviderOption) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(append(opts, sdktrace.WithSpanProcessor(recorder))...)
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		provider.Shutdown(t.Context())
+	})
+	return recorder
+}
+
+// spanByName 按名称查找已结束的 span
+func spanByName(spans []sdktrace.ReadOnlySpan, name string, kind trace.SpanKind) sdktrace.ReadOnlySpan {
+	for _, s := range spans {
+		if s.Name() == name && s.SpanKind() == kind {
+			return s
+		}
+	}
+	return nil
+}
+
+func TestTracing_PropagatesTraceContext(t *testing.T) {
+	recorder := useSpanRecorder(t)
+
+	var gotTraceparent, gotRequestID string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTraceparent = r.Header.Get("traceparent")
+		gotRequestID = r.Header.Get(middleware.RequestIDHeader)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, testPage)
+	}))
+	defer backend.Close()
+
+	cfg := newTestConfig(backend.URL)
+	cfg.Encryption.Enabled = true
+	cfg.ScriptInjection.ScriptContent = `<script src="/goga.min.js"></script>`
+	// httptest.NewRequest 的对端地址 192.0.2.1 是受信任的代理
+	trusted, err := middleware.NewTrustedProxies([]string{"192.0.2.0/24"})
+	require.NoError(t, err)
+	handler := middleware.ClientIP(trusted)(middleware.RequestID(middleware.Tracing(newTestProxy(t, cfg))))
+
+	const incoming = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.Header.Set("traceparent", incoming)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "goga.min.js")
+
+	spans := recorder.Ended()
+	server := spanByName(spans, http.MethodGet, trace.SpanKindServer)
+	upstream := spanByName(spans, http.MethodGet, trace.SpanKindClient)
+	inject := spanByName(spans, "goga.inject", trace.SpanKindInternal)
+	require.NotNil(t, server, "应创建服务端 span")
+	require.NotNil(t, upstream, "应为转发创建客户端 span")
+	require.NotNil(t, inject, "应为脚本注入创建 span")
+
+	// 网关的 span 延续调用方的 trace，后端收到的 traceparent 指向转发 span
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	assert.Equal(t, traceID, server.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+	assert.Equal(t, server.SpanContext().SpanID(), upstream.Parent().SpanID())
+	assert.Equal(t, "00-"+traceID+"-"+upstream.SpanContext().SpanID().String()+"-01", gotTraceparent)
+
+	// 请求 ID 同时出现在响应头、后端请求头和服务端 span 上
+	requestID := rr.Header().Get(middleware.RequestIDHeader)
+	require.NotEmpty(t, requestID)
+	assert.Equal(t, requestID, gotRequestID)
+	assert.Contains(t, server.Attributes(), tracing.RequestIDKey.String(requestID))
+	assert.Contains(t, server.Attributes(), routeAttr.String("*/"))
+	assert.Contains(t, inject.Attributes(), injectOutcomeAttr.String("injected"))
+}
+
+func TestTracing_UntrustedTraceparentStartsNewRoot(t *testing.T) {
+	var gotTraceparent string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTraceparent = r.Header.Get("traceparent")
+	}))
+	defer backend.Close()
+	proxy := newTestProxy(t, newTestConfig(backend.URL))
+	trusted, err := middleware.NewTrustedProxies([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	handler := middleware.ClientIP(trusted)(middleware.Tracing(proxy))
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	request := func(remoteAddr string) {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	t.Run("客户端的 trace 作为链接记录", func(t *testing.T) {
+		recorder := useSpanRecorder(t)
+		request("203.0.113.7:4000")
+		server := spanByName(recorder.Ended(), http.MethodGet, trace.SpanKindServer)
+		require.NotNil(t, server)
+		assert.NotEqual(t, traceID, server.SpanContext().TraceID().String())
+		assert.False(t, server.Parent().IsValid(), "应为新的根 span")
+		require.Len(t, server.Links(), 1)
+		assert.Equal(t, traceID, server.Links()[0].SpanContext.TraceID().String())
+		assert.NotContains(t, gotTraceparent, traceID, "后端收到的是网关的 trace")
+	})
+
+	t.Run("客户端不能强制采样", func(t *testing.T) {
+		recorder := useSpanRecorder(t, sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(0))))
+		request("203.0.113.7:4000")
+		assert.Empty(t, recorder.Ended(), "sample_ratio 为 0 时不受信任的客户端的请求不应被采样")
+
+		// 受信任的代理的采样决定仍然被沿用
+		request("10.0.0.2:4000")
+		server := spanByName(recorder.Ended(), http.MethodGet, trace.SpanKindServer)
+		require.NotNil(t, server)
+		assert.Equal(t, traceID, server.SpanContext().TraceID().String())
+	})
+}
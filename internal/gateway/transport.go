@@ -0,0 +1,273 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"goga/configs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDialTimeout         = 10 * time.Second
+	defaultKeepAlive           = 30 * time.Second
+	defaultTLSHandshakeTimeout = 10 * time.Second
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 32
+)
+
+// 与后端通信的协议，即 upstream.backends[].protocol 可选的值
+const (
+	protocolAuto  = "auto"  // HTTP/1.1，https 后端通过 ALPN 协商 HTTP/2
+	protocolHTTP1 = "http1" // 只使用 HTTP/1.1
+	protocolH2    = "h2"    // 只使用 HTTP/2 over TLS
+	protocolH2C   = "h2c"   // HTTP/2 明文 (prior knowledge)
+)
+
+// unixHostSuffix 是 Unix 套接字后端的虚拟主机名后缀。Transport 按主机名维护连接池，
+// 因此把套接字路径编码进主机名，拨号时再还原为路径
+const unixHostSuffix = ".unix.goga"
+
+// tlsVersions 是 min_tls_version 可选的值
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// upstreamClient 保存与后端通信使用的连接配置，HTTP 代理和 WebSocket 代理共享同一份配置。
+type upstreamClient struct {
+	cfg       configs.TransportConfig
+	dialer    *net.Dialer
+	tlsConfig *tls.Config // 不含 ALPN 协议的基础 TLS 配置
+
+	mu         sync.Mutex
+	transports map[string]*http.Transport // 按协议缓存的 Transport，每种协议各自维护连接池
+}
+
+// newUpstreamClient 根据 upstream.transport 配置创建后端连接配置，未配置的字段使用默认值。
+func newUpstreamClient(cfg configs.TransportConfig) (*upstreamClient, error) {
+	tlsConfig, err := newUpstreamTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   durationOrDefault(cfg.DialTimeout, defaultDialTimeout),
+		KeepAlive: durationOrDefault(cfg.KeepAlive, defaultKeepAlive),
+	}
+	if cfg.MaxIdleConns <= 0 {
+		cfg.MaxIdleConns = defaultMaxIdleConns
+	}
+	if cfg.MaxIdleConnsPerHost <= 0 {
+		cfg.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+
+	return &upstreamClient{
+		cfg:        cfg,
+		dialer:     dialer,
+		tlsConfig:  tlsConfig,
+		transports: make(map[string]*http.Transport),
+	}, nil
+}
+
+// transportFor 返回指定协议使用的 Transport，各协议的超时、连接池和 TLS 配置相同。
+// 每种协议单独创建 Transport 而不是 Clone：Clone 会把源 Transport 的 HTTP/2 ALPN 设置带入副本。
+func (c *upstreamClient) transportFor(protocol string) *http.Transport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t, ok := c.transports[protocol]; ok {
+		return t
+	}
+
+	// 其余字段与 http.DefaultTransport 保持一致
+	t := &http.Transport{
+		Proxy:                 upstreamProxy,
+		DialContext:           c.dialContext,
+		ForceAttemptHTTP2:     true,
+		TLSClientConfig:       c.tlsConfig.Clone(),
+		TLSHandshakeTimeout:   durationOrDefault(c.cfg.TLSHandshakeTimeout, defaultTLSHandshakeTimeout),
+		ResponseHeaderTimeout: c.cfg.ResponseHeaderTimeout,
+		IdleConnTimeout:       durationOrDefault(c.cfg.IdleConnTimeout, defaultIdleConnTimeout),
+		MaxIdleConns:          c.cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   c.cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       c.cfg.MaxConnsPerHost,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	if protocol != protocolAuto {
+		protocols := new(http.Protocols)
+		switch protocol {
+		case protocolHTTP1:
+			protocols.SetHTTP1(true)
+		case protocolH2:
+			protocols.SetHTTP2(true)
+		case protocolH2C:
+			protocols.SetUnencryptedHTTP2(true)
+		}
+		t.Protocols = protocols
+	}
+	c.transports[protocol] = t
+	return t
+}
+
+// dialContext 建立到后端的连接，Unix 套接字后端的虚拟主机名会被还原为套接字路径。
+func (c *upstreamClient) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		if socket, ok := unixSocketFromHost(host); ok {
+			return c.dialer.DialContext(ctx, "unix", socket)
+		}
+	}
+	return c.dialer.DialContext(ctx, network, addr)
+}
+
+// upstreamProxy 与 http.ProxyFromEnvironment 相同，但 Unix 套接字后端始终直连。
+func upstreamProxy(req *http.Request) (*url.URL, error) {
+	if _, ok := unixSocketFromHost(req.URL.Hostname()); ok {
+		return nil, nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+// parseUnixTarget 解析 unix:/run/app.sock 形式的后端地址，返回套接字路径和指向它的 http 虚拟地址。
+func parseUnixTarget(u *url.URL) (*url.URL, string, error) {
+	socket := u.Path
+	if u.Opaque != "" {
+		socket = u.Opaque // unix:app.sock 这类相对路径
+	}
+	if u.Host != "" || socket == "" || u.RawQuery != "" || u.Fragment != "" {
+		return nil, "", errors.New("Unix 套接字地址的格式应为 unix:/path/to/app.sock")
+	}
+	host := hex.EncodeToString([]byte(socket)) + unixHostSuffix
+	return &url.URL{Scheme: "http", Host: host}, socket, nil
+}
+
+// unixSocketFromHost 从虚拟主机名中还原 Unix 套接字路径。
+func unixSocketFromHost(host string) (string, bool) {
+	encoded, ok := strings.CutSuffix(host, unixHostSuffix)
+	if !ok {
+		return "", false
+	}
+	socket, err := hex.DecodeString(encoded)
+	if err != nil {
+		return "", false
+	}
+	return string(socket), true
+}
+
+// closeIdleConnections 关闭所有协议的 Transport 中的空闲连接。
+func (c *upstreamClient) closeIdleConnections() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range c.transports {
+		t.CloseIdleConnections()
+	}
+}
+
+// newUpstreamTLSConfig 创建连接后端使用的 TLS 配置：额外信任的 CA、客户端证书、SNI 和最低 TLS 版本。
+func newUpstreamTLSConfig(cfg configs.TransportConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.MinTLSVersion != "" {
+		version, ok := tlsVersions[cfg.MinTLSVersion]
+		if !ok {
+			return nil, fmt.Errorf("无效的 min_tls_version: %q，可选值为 1.0、1.1、1.2、1.3", cfg.MinTLSVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("无法读取后端 CA 证书 %s: %w", cfg.CAFile, err)
+		}
+		// 在系统根证书的基础上追加内部 CA，公网后端仍可正常校验
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("后端 CA 证书 %s 中没有有效的 PEM 证书", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		if cfg.CertFile == "" || cfg.KeyFile == "" {
+			return nil, fmt.Errorf("客户端证书需要同时配置 cert_file 和 key_file")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("无法加载后端客户端证书: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// dialWebSocket 按 upstream.transport 配置连接 WebSocket 后端，https/wss 后端使用 TLS 并只协商 HTTP/1.1。
+// insecureSkipVerify 兼容旧的 websocket.insecure_skip_verify 配置。
+func (c *upstreamClient) dialWebSocket(ctx context.Context, u *url.URL, insecureSkipVerify bool) (net.Conn, error) {
+	secure := isSecureScheme(u.Scheme)
+	addr := dialAddr(u)
+	if !secure {
+		return c.dialContext(ctx, "tcp", addr)
+	}
+
+	tlsConfig := c.tlsConfig.Clone()
+	tlsConfig.NextProtos = []string{"http/1.1"} // WebSocket 升级只能在 HTTP/1.1 上进行
+	if insecureSkipVerify {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = u.Hostname()
+	}
+	tlsDialer := &tls.Dialer{NetDialer: c.dialer, Config: tlsConfig}
+	return tlsDialer.DialContext(ctx, "tcp", addr)
+}
+
+// isSecureScheme 判断上游地址是否使用 TLS
+func isSecureScheme(scheme string) bool {
+	return scheme == "https" || scheme == "wss"
+}
+
+// dialAddr 返回连接上游时使用的 host:port，未指定端口时按协议使用 80 或 443。
+// Unix 套接字后端的虚拟主机名由 dialContext 转换为套接字路径
+func dialAddr(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	port := "80"
+	if isSecureScheme(u.Scheme) {
+		port = "443"
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// durationOrDefault 在 d 未配置 (小于等于 0) 时返回默认值。
+func durationOrDefault(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
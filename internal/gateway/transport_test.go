@@ -0,0 +1,365 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package gateway
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"goga/configs"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writePEM 将 PEM 块写入临时目录下的文件并返回路径。
+func writePEM(t *testing.T, name, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+	return path
+}
+
+// writeServerCA 将 httptest TLS 服务器的证书写入文件，作为后端 CA 使用。
+func writeServerCA(t *testing.T, server *httptest.Server) string {
+	return writePEM(t, "ca.pem", "CERTIFICATE", server.Certificate().Raw)
+}
+
+// newClientCertificate 生成一个自签名 CA 以及由它签发的客户端证书，返回 CA 证书池和客户端证书、私钥文件路径。
+func newClientCertificate(t *testing.T) (*x509.CertPool, string, string) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "goga test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	clientTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "goga"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientDER, err := x509.CreateCertificate(rand.Reader, clientTemplate, caCert, &clientKey.PublicKey, caKey)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(clientKey)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	return pool, writePEM(t, "client.pem", "CERTIFICATE", clientDER), writePEM(t, "client-key.pem", "PRIVATE KEY", keyDER)
+}
+
+func TestProxy_UpstreamTLS(t *testing.T) {
+	html := newHTMLBackend()
+	html.Close() // 只复用其处理器
+	backend := httptest.NewTLSServer(html.Config.Handler)
+	defer backend.Close()
+	caFile := writeServerCA(t, backend)
+
+	testCases := []struct {
+		name      string
+		transport configs.TransportConfig
+		status    int
+	}{
+		{"未信任后端 CA", configs.TransportConfig{}, http.StatusBadGateway},
+		{"信任配置的 CA", configs.TransportConfig{CAFile: caFile}, http.StatusOK},
+		{"覆盖 SNI 为证书中的主机名", configs.TransportConfig{CAFile: caFile, ServerName: "example.com"}, http.StatusOK},
+		{"覆盖 SNI 为证书外的主机名", configs.TransportConfig{CAFile: caFile, ServerName: "other.invalid"}, http.StatusBadGateway},
+		{"跳过证书校验", configs.TransportConfig{InsecureSkipVerify: true}, http.StatusOK},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := newTestConfig(backend.URL)
+			cfg.Upstream.Transport = tc.transport
+			resp, _ := doProxyRequest(t, newTestProxy(t, cfg), "/")
+			assert.Equal(t, tc.status, resp.StatusCode)
+		})
+	}
+}
+
+func TestProxy_UpstreamMTLS(t *testing.T) {
+	clientCAs, certFile, keyFile := newClientCertificate(t)
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	backend.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	backend.StartTLS()
+	defer backend.Close()
+	caFile := writeServerCA(t, backend)
+
+	t.Run("出示客户端证书", func(t *testing.T) {
+		cfg := newTestConfig(backend.URL)
+		cfg.Upstream.Transport = configs.TransportConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}
+		resp, body := doProxyRequest(t, newTestProxy(t, cfg), "/")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "goga", body)
+	})
+
+	t.Run("未配置客户端证书", func(t *testing.T) {
+		cfg := newTestConfig(backend.URL)
+		cfg.Upstream.Transport = configs.TransportConfig{CAFile: caFile}
+		resp, _ := doProxyRequest(t, newTestProxy(t, cfg), "/")
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	})
+}
+
+func TestProxy_ResponseHeaderTimeout(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer backend.Close()
+
+	cfg := newTestConfig(backend.URL)
+	cfg.Upstream.Transport.ResponseHeaderTimeout = 20 * time.Millisecond
+	cfg.Upstream.Backends = []configs.BackendConfig{{Name: "slow", URL: backend.URL, Retry: configs.RetryConfig{MaxRetries: -1}}}
+	resp, _ := doProxyRequest(t, newTestProxy(t, cfg), "/")
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+}
+
+func TestNewUpstreamClient_InvalidConfig(t *testing.T) {
+	notPEM := filepath.Join(t.TempDir(), "not-pem.pem")
+	require.NoError(t, os.WriteFile(notPEM, []byte("not a certificate"), 0o600))
+
+	testCases := map[string]configs.TransportConfig{
+		"无效的 TLS 版本":  {MinTLSVersion: "1.4"},
+		"CA 文件不存在":    {CAFile: filepath.Join(t.TempDir(), "missing.pem")},
+		"CA 文件不是 PEM": {CAFile: notPEM},
+		"只配置了客户端证书":   {CertFile: "client.pem"},
+		"客户端证书文件不存在":  {CertFile: "missing.pem", KeyFile: "missing-key.pem"},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := newUpstreamClient(tc)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestWebsocketProxy_UpstreamTLS(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n"))
+	}))
+	defer backend.Close()
+
+	cfg := newTestConfig(backend.URL)
+	cfg.Upstream.Transport.CAFile = writeServerCA(t, backend)
+	routes, err := NewRouteTable(cfg)
+	require.NoError(t, err)
+	defer routes.Stop()
+	proxyServer := httptest.NewServer(NewWebsocketProxy(http.NotFoundHandler(), cfg, routes))
+	defer proxyServer.Close()
+
+	proxyURL, err := url.Parse(proxyServer.URL)
+	require.NoError(t, err)
+	conn, err := net.Dial("tcp", proxyURL.Host)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	req, err := http.NewRequest("GET", proxyServer.URL+"/ws", nil)
+	require.NoError(t, err)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Origin", "http://localhost")
+	require.NoError(t, req.Write(conn))
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode, "WebSocket 代理应使用配置的 CA 校验后端证书")
+}
+
+// newProtocolBackend 创建一个在响应头中返回请求协议、并在响应体之后发送 Trailer 的模拟后端。
+func newProtocolBackend() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		w.Header().Set("X-Proto", r.Proto)
+		w.Write([]byte("body"))
+		w.Header().Set("X-Checksum", "abc")
+		w.Header().Set(http.TrailerPrefix+"X-Undeclared", "late") // 未预先声明的 Trailer
+	})
+}
+
+func TestProxy_UpstreamProtocols(t *testing.T) {
+	h2c := httptest.NewUnstartedServer(newProtocolBackend())
+	h2c.Config.Protocols = new(http.Protocols)
+	h2c.Config.Protocols.SetHTTP1(true)
+	h2c.Config.Protocols.SetUnencryptedHTTP2(true)
+	h2c.Start()
+	defer h2c.Close()
+
+	h2 := httptest.NewUnstartedServer(newProtocolBackend())
+	h2.EnableHTTP2 = true
+	h2.StartTLS()
+	defer h2.Close()
+	caFile := writeServerCA(t, h2)
+
+	testCases := []struct {
+		name     string
+		url      string
+		protocol string
+		proto    string
+	}{
+		{"默认使用 HTTP/1.1 连接明文后端", h2c.URL, "", "HTTP/1.1"},
+		{"h2c 连接明文后端", h2c.URL, "h2c", "HTTP/2.0"},
+		{"默认通过 ALPN 协商 HTTP/2", h2.URL, "", "HTTP/2.0"},
+		{"h2 连接 TLS 后端", h2.URL, "h2", "HTTP/2.0"},
+		{"http1 禁用 HTTP/2", h2.URL, "http1", "HTTP/1.1"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := newTestConfig("")
+			cfg.Upstream.Transport.CAFile = caFile
+			cfg.Upstream.Backends = []configs.BackendConfig{{Name: "app", URL: tc.url, Protocol: tc.protocol}}
+			proxyServer := httptest.NewServer(newTestProxy(t, cfg))
+			defer proxyServer.Close()
+
+			resp, err := http.Get(proxyServer.URL)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, "body", string(body))
+			assert.Equal(t, tc.proto, resp.Header.Get("X-Proto"))
+			assert.Equal(t, "abc", resp.Trailer.Get("X-Checksum"), "声明的 Trailer 应透传给客户端")
+			assert.Equal(t, "late", resp.Trailer.Get("X-Undeclared"), "未声明的 Trailer 应透传给客户端")
+		})
+	}
+}
+
+func TestNewRouteTable_InvalidProtocol(t *testing.T) {
+	testCases := map[string]configs.BackendConfig{
+		"未知协议":          {Name: "app", URL: "http://127.0.0.1:8080", Protocol: "http3"},
+		"h2 用于明文后端":     {Name: "app", URL: "http://127.0.0.1:8080", Protocol: "h2"},
+		"h2c 用于 TLS 后端": {Name: "app", URL: "https://127.0.0.1:8443", Protocol: "h2c"},
+	}
+	for name, bc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfg := newTestConfig("")
+			cfg.Upstream.Backends = []configs.BackendConfig{bc}
+			_, err := NewRouteTable(cfg)
+			assert.Error(t, err)
+		})
+	}
+}
+
+// newUnixBackend 在临时目录的 Unix 套接字上启动模拟后端，返回套接字路径。
+func newUnixBackend(t *testing.T, handler http.Handler) string {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "app.sock")
+	ln, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	backend := httptest.NewUnstartedServer(handler)
+	backend.Listener = ln
+	backend.Start()
+	t.Cleanup(backend.Close)
+	return socket
+}
+
+func TestProxy_UnixSocketBackend(t *testing.T) {
+	socket := newUnixBackend(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isWebSocketUpgrade(r) {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n"))
+			return
+		}
+		w.Write([]byte(r.Host + " " + r.URL.Path))
+	}))
+
+	cfg := newTestConfig("")
+	cfg.Upstream.Backends = []configs.BackendConfig{{
+		Name:        "app",
+		URL:         "unix:" + socket,
+		HealthCheck: configs.HealthCheckConfig{Path: "/healthz", Interval: 10 * time.Millisecond},
+	}}
+	proxy, routes := newTestProxyWithRoutes(t, cfg)
+	proxyServer := httptest.NewServer(NewWebsocketProxy(proxy, cfg, routes))
+	defer proxyServer.Close()
+
+	t.Run("HTTP 请求转发到 Unix 套接字", func(t *testing.T) {
+		resp, err := http.Get(proxyServer.URL + "/api/items")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, strings.TrimPrefix(proxyServer.URL, "http://")+" /api/items", string(body), "应保留客户端的 Host")
+	})
+
+	t.Run("状态中显示套接字路径", func(t *testing.T) {
+		assert.Eventually(t, func() bool {
+			status := routes.Backends()[0].Status()
+			return status.Targets[0].URL == "unix:"+socket && status.Targets[0].LastCheckTime != nil && status.Targets[0].Healthy
+		}, 2*time.Second, 10*time.Millisecond, "主动健康检查应通过 Unix 套接字完成")
+	})
+
+	t.Run("WebSocket 连接到 Unix 套接字", func(t *testing.T) {
+		conn, err := net.Dial("tcp", strings.TrimPrefix(proxyServer.URL, "http://"))
+		require.NoError(t, err)
+		defer conn.Close()
+
+		req, err := http.NewRequest("GET", proxyServer.URL+"/ws", nil)
+		require.NoError(t, err)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Origin", "http://localhost")
+		require.NoError(t, req.Write(conn))
+
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	})
+}
+
+func TestNewRouteTable_InvalidUnixTarget(t *testing.T) {
+	for _, raw := range []string{"unix:", "unix://app.sock", "unix:/run/app.sock?x=1"} {
+		cfg := newTestConfig("")
+		cfg.Upstream.Backends = []configs.BackendConfig{{Name: "app", URL: raw}}
+		_, err := NewRouteTable(cfg)
+		assert.Error(t, err, raw)
+	}
+}
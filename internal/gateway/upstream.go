@@ -0,0 +1,606 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"goga/configs"
+	"goga/internal/middleware"
+	"hash/crc32"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 负载均衡策略
+const (
+	balanceRoundRobin     = "round_robin"
+	balanceLeastConn      = "least_conn"
+	balanceConsistentHash = "consistent_hash"
+)
+
+// 一致性哈希的键
+const (
+	hashKeyClientIP     = "client_ip"
+	hashKeyCookiePrefix = "cookie:"
+)
+
+const (
+	defaultMaxFails           = 3
+	defaultFailTimeout        = 30 * time.Second
+	defaultHealthInterval     = 10 * time.Second
+	defaultHealthTimeout      = 2 * time.Second
+	defaultHealthyThreshold   = 2
+	defaultUnhealthyThreshold = 2
+
+	// hashReplicas 是一致性哈希环上每个上游的虚拟节点数
+	hashReplicas = 160
+)
+
+// Target 是上游池中的一个上游地址及其运行时状态。
+type Target struct {
+	URL    *url.URL
+	socket string // Unix 套接字后端的套接字路径，此时 URL 的主机名是由路径编码而来的虚拟主机名
+
+	healthy      atomic.Bool  // 主动健康检查的结果
+	ejectedUntil atomic.Int64 // 被动摘除的截止时间 (UnixNano)，0 表示未被摘除
+	fails        atomic.Int32 // 连续的被动失败次数
+	active       atomic.Int64 // 正在处理的请求数 (包括 WebSocket 连接)
+	breaker      circuitBreaker
+
+	// 以下字段只由健康检查 goroutine 修改，读取时需要持有 mu
+	mu            sync.Mutex
+	checkPasses   int
+	checkFails    int
+	lastCheckErr  string
+	lastCheckTime time.Time
+}
+
+// addr 返回用于日志和状态展示的上游地址。
+func (t *Target) addr() string {
+	if t.socket != "" {
+		return "unix:" + t.socket
+	}
+	return t.URL.Host
+}
+
+// displayURL 返回用于状态展示的上游 URL，Unix 套接字后端显示为配置中的 unix: 地址。
+func (t *Target) displayURL() string {
+	if t.socket != "" {
+		return t.addr()
+	}
+	return t.URL.String()
+}
+
+// hostHeader 返回需要改写 Host 时发送给上游的 Host，Unix 套接字后端没有真实的主机名，使用 localhost。
+func (t *Target) hostHeader() string {
+	if t.socket != "" {
+		return "localhost"
+	}
+	return t.URL.Host
+}
+
+// available 判断上游当前是否可以接收请求。
+func (t *Target) available(now int64) bool {
+	return t.healthy.Load() && now >= t.ejectedUntil.Load() && t.breaker.ready(now)
+}
+
+// acquire 和 release 统计正在处理的请求数，供 least_conn 使用。
+func (t *Target) acquire() { t.active.Add(1) }
+func (t *Target) release() { t.active.Add(-1) }
+
+// Backend 是一个具名的上游池。
+type Backend struct {
+	Name string
+	// URL 是第一个上游的地址，所有上游共享其路径部分
+	URL *url.URL
+
+	targets     []*Target
+	balance     string
+	hashCookie  string // 为空时按客户端 IP 哈希
+	ring        []ringNode
+	rrCounter   atomic.Uint64
+	maxFails    int32
+	failTimeout time.Duration
+
+	maxRetries      int
+	maxRetryBody    int64
+	retryBudget     *retryBudget
+	retries         atomic.Int64 // 已执行的重试次数
+	budgetExhausted atomic.Int64 // 因重试预算耗尽而放弃的重试次数
+
+	protocol string // 与上游通信的协议，见 protocolAuto 等常量
+
+	healthCheck configs.HealthCheckConfig
+	stop        chan struct{}
+	wg          sync.WaitGroup
+}
+
+// ringNode 是一致性哈希环上的一个虚拟节点。
+type ringNode struct {
+	hash   uint32
+	target *Target
+}
+
+// newBackend 根据配置创建上游池。strict 为 false 时不校验地址的协议和主机 (兼容由 backend_url 生成的默认后端)。
+func newBackend(bc configs.BackendConfig, strict bool) (*Backend, error) {
+	rawTargets := bc.Targets
+	if bc.URL != "" {
+		rawTargets = append([]string{bc.URL}, rawTargets...)
+	}
+	if len(rawTargets) == 0 {
+		if strict {
+			return nil, fmt.Errorf("后端 %s 未配置 url 或 targets", bc.Name)
+		}
+		rawTargets = []string{""}
+	}
+
+	b := &Backend{
+		Name:        bc.Name,
+		balance:     strings.ToLower(bc.Balance),
+		maxFails:    int32(bc.MaxFails),
+		failTimeout: bc.FailTimeout,
+		healthCheck: bc.HealthCheck,
+		stop:        make(chan struct{}),
+
+		maxRetries:   bc.Retry.MaxRetries,
+		maxRetryBody: bc.Retry.MaxBodyBytes,
+		retryBudget:  newRetryBudget(bc.Retry),
+
+		protocol: strings.ToLower(bc.Protocol),
+	}
+	if b.balance == "" {
+		b.balance = balanceRoundRobin
+	}
+	if b.maxFails == 0 {
+		b.maxFails = defaultMaxFails
+	}
+	if b.failTimeout <= 0 {
+		b.failTimeout = defaultFailTimeout
+	}
+	if b.maxRetries == 0 {
+		b.maxRetries = defaultMaxRetries
+	}
+	if b.maxRetryBody <= 0 {
+		b.maxRetryBody = defaultMaxRetryBodyBytes
+	}
+	switch b.protocol {
+	case "":
+		b.protocol = protocolAuto
+	case protocolAuto, protocolHTTP1, protocolH2, protocolH2C:
+	default:
+		return nil, fmt.Errorf("后端 %s 的 protocol 无效: %q，可选值为 auto、http1、h2、h2c", bc.Name, bc.Protocol)
+	}
+
+	for _, raw := range rawTargets {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("无法解析后端 %s 的 URL %q: %w", bc.Name, raw, err)
+		}
+		var socket string
+		if u.Scheme == "unix" {
+			if u, socket, err = parseUnixTarget(u); err != nil {
+				return nil, fmt.Errorf("后端 %s 的 URL %q 无效: %w", bc.Name, raw, err)
+			}
+		}
+		if strict && (u.Scheme == "" || u.Host == "") {
+			return nil, fmt.Errorf("后端 %s 的 URL 必须包含协议和主机: %q", bc.Name, raw)
+		}
+		// h2 只能在 TLS 上协商，h2c 只能用于明文连接
+		if (b.protocol == protocolH2 && u.Scheme != "https") || (b.protocol == protocolH2C && u.Scheme != "http") {
+			return nil, fmt.Errorf("后端 %s 的 protocol %s 不能用于 %q", bc.Name, b.protocol, raw)
+		}
+		if b.URL != nil && u.Path != b.URL.Path {
+			return nil, fmt.Errorf("后端 %s 的所有上游必须使用相同的路径: %q 与 %q", bc.Name, b.URL.Path, u.Path)
+		}
+		if b.URL == nil {
+			b.URL = u
+		}
+		t := &Target{URL: u, socket: socket}
+		t.healthy.Store(true) // 在第一次健康检查完成前假定上游可用
+		t.breaker.init(bc.Name, t.addr(), bc.CircuitBreaker)
+		b.targets = append(b.targets, t)
+	}
+
+	switch b.balance {
+	case balanceRoundRobin, balanceLeastConn:
+	case balanceConsistentHash:
+		hashKey := strings.ToLower(bc.HashKey)
+		switch {
+		case hashKey == "" || hashKey == hashKeyClientIP:
+		case strings.HasPrefix(hashKey, hashKeyCookiePrefix) && len(bc.HashKey) > len(hashKeyCookiePrefix):
+			// Cookie 名称区分大小写，因此从原始配置中截取
+			b.hashCookie = bc.HashKey[len(hashKeyCookiePrefix):]
+		default:
+			return nil, fmt.Errorf("后端 %s 的 hash_key 无效: %q", bc.Name, bc.HashKey)
+		}
+		b.buildRing()
+	default:
+		return nil, fmt.Errorf("后端 %s 的负载均衡策略无效: %q", bc.Name, bc.Balance)
+	}
+
+	return b, nil
+}
+
+// buildRing 构建一致性哈希环。
+func (b *Backend) buildRing() {
+	b.ring = make([]ringNode, 0, len(b.targets)*hashReplicas)
+	for _, t := range b.targets {
+		for i := 0; i < hashReplicas; i++ {
+			h := crc32.ChecksumIEEE([]byte(t.URL.Host + "#" + strconv.Itoa(i)))
+			b.ring = append(b.ring, ringNode{hash: h, target: t})
+		}
+	}
+	sort.Slice(b.ring, func(i, j int) bool { return b.ring[i].hash < b.ring[j].hash })
+}
+
+// pick 按负载均衡策略为请求选择一个上游。
+// 所有上游都不可用时仍然返回一个上游 (故障开放)，避免健康检查误判导致整个站点不可用。
+func (b *Backend) pick(r *http.Request) *Target {
+	if len(b.targets) == 1 {
+		return b.targets[0]
+	}
+	if t := b.pickExcluding(r, time.Now().UnixNano(), nil); t != nil {
+		return t
+	}
+
+	middleware.LogWarn(r, "上游池中没有可用的上游，将忽略健康状态进行转发", "backend", b.Name)
+	return b.targets[int(b.rrCounter.Add(1)%uint64(len(b.targets)))]
+}
+
+// pickExcluding 按负载均衡策略选择一个不在 exclude 中的可用上游，没有时返回 nil。
+func (b *Backend) pickExcluding(r *http.Request, now int64, exclude []*Target) *Target {
+	switch b.balance {
+	case balanceLeastConn:
+		return b.pickLeastConn(now, exclude)
+	case balanceConsistentHash:
+		return b.pickHash(b.hashKey(r), now, exclude)
+	default:
+		return b.pickRoundRobin(now, exclude)
+	}
+}
+
+// usable 判断上游是否可用且未被排除。
+func usable(t *Target, now int64, exclude []*Target) bool {
+	return t.available(now) && !slices.Contains(exclude, t)
+}
+
+// pickRoundRobin 依次选择下一个可用的上游。
+func (b *Backend) pickRoundRobin(now int64, exclude []*Target) *Target {
+	n := uint64(len(b.targets))
+	start := b.rrCounter.Add(1)
+	for i := uint64(0); i < n; i++ {
+		if t := b.targets[(start+i)%n]; usable(t, now, exclude) {
+			return t
+		}
+	}
+	return nil
+}
+
+// pickLeastConn 选择正在处理请求数最少的可用上游，从轮询位置开始扫描以分散并列的情况。
+func (b *Backend) pickLeastConn(now int64, exclude []*Target) *Target {
+	n := uint64(len(b.targets))
+	start := b.rrCounter.Add(1)
+	var best *Target
+	var bestActive int64
+	for i := uint64(0); i < n; i++ {
+		t := b.targets[(start+i)%n]
+		if !usable(t, now, exclude) {
+			continue
+		}
+		if active := t.active.Load(); best == nil || active < bestActive {
+			best, bestActive = t, active
+		}
+	}
+	return best
+}
+
+// pickHash 在一致性哈希环上顺时针查找第一个可用的上游。
+func (b *Backend) pickHash(key string, now int64, exclude []*Target) *Target {
+	h := crc32.ChecksumIEEE([]byte(key))
+	idx := sort.Search(len(b.ring), func(i int) bool { return b.ring[i].hash >= h })
+	for i := 0; i < len(b.ring); i++ {
+		if t := b.ring[(idx+i)%len(b.ring)].target; usable(t, now, exclude) {
+			return t
+		}
+	}
+	return nil
+}
+
+// admit 为一次转发尝试确定熔断器放行的上游。sel 中的上游不被放行或已在 exclude 中时，
+// 改选池中的其他上游并更新 sel (同时转移活跃请求计数)；exclude 中的上游只在没有其他选择时才会再次使用。
+// 所有候选上游的熔断器都拒绝时返回 errCircuitOpen。
+func (b *Backend) admit(r *http.Request, sel *upstreamSelection, exclude []*Target) (*Target, error) {
+	now := time.Now().UnixNano()
+	candidate := sel.target
+	if slices.Contains(exclude, candidate) {
+		if next := b.pickExcluding(r, now, exclude); next != nil {
+			candidate = next
+		}
+	}
+
+	rejected := slices.Clone(exclude)
+	for !candidate.breaker.allow(now) {
+		rejected = append(rejected, candidate)
+		if candidate = b.pickExcluding(r, now, rejected); candidate == nil {
+			return nil, errCircuitOpen
+		}
+	}
+
+	if candidate != sel.target {
+		sel.target.release()
+		candidate.acquire()
+		sel.target = candidate
+	}
+	return candidate, nil
+}
+
+// hashKey 返回一致性哈希使用的键，配置的 Cookie 不存在时回退到客户端 IP。
+func (b *Backend) hashKey(r *http.Request) string {
+	if b.hashCookie != "" {
+		if c, err := r.Cookie(b.hashCookie); err == nil && c.Value != "" {
+			return c.Value
+		}
+	}
+	return middleware.GetClientIP(r)
+}
+
+// reportFailure 记录一次转发失败 (BAD_GATEWAY/GATEWAY_TIMEOUT)，计入熔断器；连续失败达到阈值后被动摘除上游。
+func (b *Backend) reportFailure(t *Target) {
+	t.breaker.onFailure(time.Now().UnixNano())
+	if b.maxFails < 0 {
+		return
+	}
+	if t.fails.Add(1) < b.maxFails {
+		return
+	}
+	t.fails.Store(0)
+	until := time.Now().Add(b.failTimeout)
+	t.ejectedUntil.Store(until.UnixNano())
+	slog.Warn("上游连续失败，已被动摘除", "backend", b.Name, "target", t.addr(), "until", until.Format(time.RFC3339))
+}
+
+// reportSuccess 清零上游的连续失败次数，并报告熔断器。
+func (b *Backend) reportSuccess(t *Target) {
+	t.breaker.onSuccess()
+	if t.fails.Load() != 0 {
+		t.fails.Store(0)
+	}
+}
+
+// reportCanceled 报告一次被放行但没有结果的转发 (例如客户端断开)。
+func (b *Backend) reportCanceled(t *Target) {
+	t.breaker.onCancel()
+}
+
+// startHealthCheck 为上游池启动主动健康检查，未配置检查路径时不做任何事。
+// transport 与转发请求使用的相同，健康检查因此沿用 upstream.transport 的 CA 和客户端证书配置。
+func (b *Backend) startHealthCheck(transport http.RoundTripper) {
+	hc := b.healthCheck
+	if hc.Path == "" {
+		return
+	}
+	if hc.Interval <= 0 {
+		hc.Interval = defaultHealthInterval
+	}
+	if hc.Timeout <= 0 {
+		hc.Timeout = defaultHealthTimeout
+	}
+	if hc.HealthyThreshold <= 0 {
+		hc.HealthyThreshold = defaultHealthyThreshold
+	}
+	if hc.UnhealthyThreshold <= 0 {
+		hc.UnhealthyThreshold = defaultUnhealthyThreshold
+	}
+	b.healthCheck = hc
+
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   hc.Timeout,
+		// 健康检查只关心上游自身的响应，不跟随重定向
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		ticker := time.NewTicker(hc.Interval)
+		defer ticker.Stop()
+		for {
+			b.checkAll(client)
+			select {
+			case <-ticker.C:
+			case <-b.stop:
+				return
+			}
+		}
+	}()
+	slog.Info("已启动上游主动健康检查", "backend", b.Name, "path", hc.Path, "interval", hc.Interval)
+}
+
+// checkAll 并发检查池中的所有上游。
+func (b *Backend) checkAll(client *http.Client) {
+	var wg sync.WaitGroup
+	for _, t := range b.targets {
+		wg.Add(1)
+		go func(t *Target) {
+			defer wg.Done()
+			b.checkTarget(client, t)
+		}(t)
+	}
+	wg.Wait()
+}
+
+// checkTarget 对单个上游执行一次健康检查，状态码 2xx/3xx 视为成功。
+func (b *Backend) checkTarget(client *http.Client, t *Target) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-b.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	checkURL := *t.URL
+	checkURL.Path = singleJoiningSlash(t.URL.Path, b.healthCheck.Path)
+	checkURL.RawPath = ""
+	checkURL.RawQuery = ""
+
+	var checkErr string
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, checkURL.String(), nil)
+	if err == nil {
+		req.Host = t.hostHeader()
+		var resp *http.Response
+		if resp, err = client.Do(req); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+				checkErr = "unexpected status " + strconv.Itoa(resp.StatusCode)
+			}
+		}
+	}
+	if err != nil {
+		checkErr = err.Error()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastCheckTime = time.Now()
+	t.lastCheckErr = checkErr
+	if checkErr == "" {
+		t.checkFails = 0
+		t.checkPasses++
+		if !t.healthy.Load() && t.checkPasses >= b.healthCheck.HealthyThreshold {
+			t.healthy.Store(true)
+			slog.Info("上游健康检查恢复", "backend", b.Name, "target", t.addr())
+		}
+		return
+	}
+	t.checkPasses = 0
+	t.checkFails++
+	if t.healthy.Load() && t.checkFails >= b.healthCheck.UnhealthyThreshold {
+		t.healthy.Store(false)
+		slog.Warn("上游健康检查失败，已标记为不健康", "backend", b.Name, "target", t.addr(), "error", checkErr)
+	}
+}
+
+// stopHealthCheck 停止主动健康检查并等待 goroutine 退出。
+func (b *Backend) stopHealthCheck() {
+	select {
+	case <-b.stop:
+	default:
+		close(b.stop)
+	}
+	b.wg.Wait()
+}
+
+// upstreamSelection 记录为请求选中的上游，通过 context 在 Rewrite、Transport 和 ErrorHandler 之间传递。
+// 重试切换上游时，Transport 会更新 target。
+type upstreamSelection struct {
+	backend *Backend
+	target  *Target
+	// 客户端请求的 Host 和路径，在路由改写之前记录，注入规则按它们匹配
+	clientHost string
+	clientPath string
+}
+
+type upstreamSelectionKey struct{}
+
+// withUpstreamSelection 将选中的上游保存到请求的 context 中。
+func withUpstreamSelection(r *http.Request, sel *upstreamSelection) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), upstreamSelectionKey{}, sel))
+}
+
+// upstreamSelectionFrom 从 context 中取出选中的上游。
+func upstreamSelectionFrom(ctx context.Context) *upstreamSelection {
+	sel, _ := ctx.Value(upstreamSelectionKey{}).(*upstreamSelection)
+	return sel
+}
+
+// UpstreamStatusPath 是上游池状态接口的路径，仅允许本地回环地址访问。
+const UpstreamStatusPath = "/goga/admin/upstreams"
+
+// BackendStatus 是上游池状态接口返回的单个上游池状态。
+type BackendStatus struct {
+	Name                 string         `json:"name"`
+	Balance              string         `json:"balance"`
+	Retries              int64          `json:"retries"`
+	RetryBudgetExhausted int64          `json:"retry_budget_exhausted"`
+	Targets              []TargetStatus `json:"targets"`
+}
+
+// TargetStatus 是单个上游的运行时状态。
+type TargetStatus struct {
+	URL                 string     `json:"url"`
+	Available           bool       `json:"available"`
+	Healthy             bool       `json:"healthy"`
+	Circuit             string     `json:"circuit"` // "closed"、"open" 或 "half_open"
+	EjectedUntil        *time.Time `json:"ejected_until,omitempty"`
+	ActiveRequests      int64      `json:"active_requests"`
+	ConsecutiveFailures int32      `json:"consecutive_failures"`
+	LastCheckTime       *time.Time `json:"last_check_time,omitempty"`
+	LastCheckError      string     `json:"last_check_error,omitempty"`
+}
+
+// Status 返回上游池的当前状态快照。
+func (b *Backend) Status() BackendStatus {
+	now := time.Now()
+	status := BackendStatus{
+		Name:                 b.Name,
+		Balance:              b.balance,
+		Retries:              b.retries.Load(),
+		RetryBudgetExhausted: b.budgetExhausted.Load(),
+		Targets:              make([]TargetStatus, 0, len(b.targets)),
+	}
+	for _, t := range b.targets {
+		ts := TargetStatus{
+			URL:                 t.displayURL(),
+			Available:           t.available(now.UnixNano()),
+			Healthy:             t.healthy.Load(),
+			Circuit:             t.breaker.stateName(now.UnixNano()),
+			ActiveRequests:      t.active.Load(),
+			ConsecutiveFailures: t.fails.Load(),
+		}
+		if until := t.ejectedUntil.Load(); until > now.UnixNano() {
+			ejectedUntil := time.Unix(0, until)
+			ts.EjectedUntil = &ejectedUntil
+		}
+		t.mu.Lock()
+		if !t.lastCheckTime.IsZero() {
+			lastCheck := t.lastCheckTime
+			ts.LastCheckTime = &lastCheck
+		}
+		ts.LastCheckError = t.lastCheckErr
+		t.mu.Unlock()
+		status.Targets = append(status.Targets, ts)
+	}
+	return status
+}
+
+// NewUpstreamStatusHandler 创建以 JSON 形式输出所有上游池状态的处理器，仅允许本地回环地址访问。
+func NewUpstreamStatusHandler(routes *RouteTable) http.Handler {
+	return middleware.LocalOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		statuses := make([]BackendStatus, 0, len(routes.Backends()))
+		for _, backend := range routes.Backends() {
+			statuses = append(statuses, backend.Status())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(struct {
+			Backends []BackendStatus `json:"backends"`
+		}{statuses})
+	}))
+}
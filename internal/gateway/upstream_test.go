@@ -0,0 +1,237 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"goga/configs"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestBackend 创建一个包含三个上游的上游池。
+func newTestBackend(t *testing.T, bc configs.BackendConfig) *Backend {
+	t.Helper()
+	bc.Name = "pool"
+	if bc.Targets == nil {
+		bc.Targets = []string{"http://10.0.0.1:80", "http://10.0.0.2:80", "http://10.0.0.3:80"}
+	}
+	b, err := newBackend(bc, true)
+	require.NoError(t, err)
+	return b
+}
+
+func newClientRequest(remoteAddr string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.RemoteAddr = remoteAddr
+	return req
+}
+
+func TestBackend_RoundRobin(t *testing.T) {
+	b := newTestBackend(t, configs.BackendConfig{})
+	req := newClientRequest("192.0.2.1:1234")
+
+	counts := make(map[*Target]int)
+	for i := 0; i < 30; i++ {
+		counts[b.pick(req)]++
+	}
+	for _, target := range b.targets {
+		assert.Equal(t, 10, counts[target], "轮询应均匀分配请求")
+	}
+
+	// 被摘除的上游不再接收请求
+	b.targets[1].ejectedUntil.Store(time.Now().Add(time.Minute).UnixNano())
+	for i := 0; i < 10; i++ {
+		assert.NotSame(t, b.targets[1], b.pick(req))
+	}
+}
+
+func TestBackend_LeastConn(t *testing.T) {
+	b := newTestBackend(t, configs.BackendConfig{Balance: "least_conn"})
+	req := newClientRequest("192.0.2.1:1234")
+
+	b.targets[0].acquire()
+	b.targets[0].acquire()
+	b.targets[2].acquire()
+	for i := 0; i < 5; i++ {
+		assert.Same(t, b.targets[1], b.pick(req), "应选择活跃请求最少的上游")
+	}
+}
+
+func TestBackend_ConsistentHash(t *testing.T) {
+	t.Run("按 Cookie", func(t *testing.T) {
+		b := newTestBackend(t, configs.BackendConfig{Balance: "consistent_hash", HashKey: "cookie:SESSION"})
+		req := newClientRequest("192.0.2.1:1234")
+		req.AddCookie(&http.Cookie{Name: "SESSION", Value: "user-42"})
+
+		first := b.pick(req)
+		for i := 0; i < 10; i++ {
+			other := newClientRequest("198.51.100.7:4321") // 客户端 IP 变化不影响结果
+			other.AddCookie(&http.Cookie{Name: "SESSION", Value: "user-42"})
+			assert.Same(t, first, b.pick(other))
+		}
+	})
+
+	t.Run("按客户端 IP，上游被摘除后只迁移受影响的键", func(t *testing.T) {
+		b := newTestBackend(t, configs.BackendConfig{Balance: "consistent_hash"})
+
+		before := make(map[string]*Target)
+		for i := 0; i < 200; i++ {
+			addr := fmt.Sprintf("10.1.%d.%d", i/250, i%250)
+			before[addr] = b.pick(newClientRequest(addr + ":1000"))
+		}
+
+		ejected := b.targets[0]
+		ejected.ejectedUntil.Store(time.Now().Add(time.Minute).UnixNano())
+		for addr, target := range before {
+			after := b.pick(newClientRequest(addr + ":1000"))
+			if target != ejected {
+				assert.Same(t, target, after, "未受影响的键不应迁移: %s", addr)
+			} else {
+				assert.NotSame(t, ejected, after)
+			}
+		}
+	})
+}
+
+func TestBackend_AllUnavailableFailsOpen(t *testing.T) {
+	b := newTestBackend(t, configs.BackendConfig{})
+	for _, target := range b.targets {
+		target.healthy.Store(false)
+	}
+	assert.NotNil(t, b.pick(newClientRequest("192.0.2.1:1234")))
+}
+
+func TestBackend_PassiveEjection(t *testing.T) {
+	b := newTestBackend(t, configs.BackendConfig{MaxFails: 2, FailTimeout: time.Minute})
+	target := b.targets[0]
+	now := time.Now().UnixNano()
+
+	b.reportFailure(target)
+	b.reportSuccess(target) // 成功会清零连续失败次数
+	b.reportFailure(target)
+	assert.True(t, target.available(now))
+
+	b.reportFailure(target)
+	assert.False(t, target.available(now), "连续失败达到阈值后应被摘除")
+	assert.True(t, target.available(time.Now().Add(2*time.Minute).UnixNano()), "摘除到期后应自动恢复")
+}
+
+func TestBackend_ActiveHealthCheck(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/base/healthz" || !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	b, err := newBackend(configs.BackendConfig{
+		Name: "pool",
+		URL:  server.URL + "/base",
+		HealthCheck: configs.HealthCheckConfig{
+			Path:               "/healthz",
+			Interval:           10 * time.Millisecond,
+			HealthyThreshold:   1,
+			UnhealthyThreshold: 2,
+		},
+	}, true)
+	require.NoError(t, err)
+	b.startHealthCheck(nil)
+	defer b.stopHealthCheck()
+
+	target := b.targets[0]
+	healthy.Store(false)
+	require.Eventually(t, func() bool { return !target.healthy.Load() }, 2*time.Second, 5*time.Millisecond)
+	assert.Contains(t, b.Status().Targets[0].LastCheckError, "503")
+
+	healthy.Store(true)
+	require.Eventually(t, func() bool { return target.healthy.Load() }, 2*time.Second, 5*time.Millisecond)
+}
+
+func TestNewBackend_InvalidConfig(t *testing.T) {
+	testCases := map[string]configs.BackendConfig{
+		"无效的负载均衡策略": {Name: "x", URL: "http://a", Balance: "random"},
+		"无效的哈希键":    {Name: "x", URL: "http://a", Balance: "consistent_hash", HashKey: "header:X-User"},
+		"上游路径不一致":   {Name: "x", Targets: []string{"http://a/v1", "http://b/v2"}},
+		"未配置上游":     {Name: "x"},
+	}
+	for name, bc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := newBackend(bc, true)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestProxy_EjectsFailingUpstream(t *testing.T) {
+	live := newHTMLBackend()
+	defer live.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close() // 连接会被拒绝
+
+	cfg := newTestConfig("")
+	cfg.Upstream.Backends = []configs.BackendConfig{{
+		Name:        "pool",
+		Targets:     []string{live.URL, dead.URL},
+		MaxFails:    2,
+		FailTimeout: time.Minute,
+		Retry:       configs.RetryConfig{MaxRetries: -1}, // 关闭重试，让失败直接暴露给客户端
+	}}
+	proxy := newTestProxy(t, cfg)
+
+	var badGateways int
+	for i := 0; i < 10; i++ {
+		resp, _ := doProxyRequest(t, proxy, "/")
+		if resp.StatusCode == http.StatusBadGateway {
+			badGateways++
+		}
+	}
+	assert.Equal(t, 2, badGateways, "达到 max_fails 后故障上游应被摘除")
+}
+
+func TestUpstreamStatusHandler(t *testing.T) {
+	cfg := newTestConfig("")
+	cfg.Upstream.Backends = []configs.BackendConfig{{Name: "pool", Targets: []string{"http://10.0.0.1", "http://10.0.0.2"}}}
+	routes, err := NewRouteTable(cfg)
+	require.NoError(t, err)
+	defer routes.Stop()
+	routes.Backends()[0].targets[1].ejectedUntil.Store(time.Now().Add(time.Minute).UnixNano())
+
+	handler := NewUpstreamStatusHandler(routes)
+
+	req := httptest.NewRequest(http.MethodGet, UpstreamStatusPath, nil)
+	req.RemoteAddr = "127.0.0.1:5555"
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var body struct {
+		Backends []BackendStatus `json:"backends"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	require.Len(t, body.Backends, 1)
+	assert.Equal(t, "round_robin", body.Backends[0].Balance)
+	assert.True(t, body.Backends[0].Targets[0].Available)
+	assert.False(t, body.Backends[0].Targets[1].Available)
+	assert.NotNil(t, body.Backends[0].Targets[1].EjectedUntil)
+
+	// 非本机访问被拒绝
+	req.RemoteAddr = "203.0.113.9:5555"
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+}
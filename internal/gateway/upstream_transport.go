@@ -0,0 +1,133 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"goga/internal/middleware"
+	"io"
+	"net/http"
+	"time"
+)
+
+// upstreamTransport 包装反向代理的 Transport，在每次转发尝试前检查上游的熔断器，
+// 记录转发结果，并在上游不可达时把幂等请求重试到池中的其他上游。
+type upstreamTransport struct {
+	base  http.RoundTripper
+	route *Route
+}
+
+// RoundTrip 实现 http.RoundTripper 接口。
+// 只有在收到响应头之前发生的错误才会重试，此时客户端尚未收到任何数据。
+func (t *upstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	sel := upstreamSelectionFrom(req.Context())
+	if sel == nil {
+		return t.base.RoundTrip(req)
+	}
+	backend := sel.backend
+
+	getBody, retryable := t.prepareRetry(req)
+	if retryable {
+		backend.retryBudget.deposit()
+	}
+
+	directed := sel.target // Rewrite 已将请求指向该上游
+	var tried []*Target
+	for attempt := 0; ; attempt++ {
+		target, err := backend.admit(req, sel, tried)
+		if err != nil {
+			return nil, err
+		}
+		tried = append(tried, target)
+
+		out := req
+		if attempt > 0 || target != directed {
+			out = req.Clone(req.Context())
+			out.URL.Scheme = target.URL.Scheme
+			out.URL.Host = target.URL.Host
+			if attempt > 0 && getBody != nil {
+				if out.Body, err = getBody(); err != nil {
+					backend.reportCanceled(target)
+					return nil, err
+				}
+			}
+		}
+
+		span := startUpstreamSpan(out, t.route, target, attempt)
+		resp, err := t.base.RoundTrip(out)
+		endUpstreamSpan(span, resp, err)
+		if err == nil {
+			backend.reportSuccess(target)
+			return resp, nil
+		}
+		// 客户端主动断开不代表上游故障
+		if errors.Is(err, context.Canceled) || req.Context().Err() != nil {
+			backend.reportCanceled(target)
+			return nil, err
+		}
+		backend.reportFailure(target)
+
+		if !retryable || attempt >= backend.maxRetries {
+			return nil, err
+		}
+		if !backend.retryBudget.withdraw(time.Now()) {
+			backend.budgetExhausted.Add(1)
+			middleware.LogWarn(req, "重试预算已耗尽，放弃重试", "backend", backend.Name, "target", target.addr(), "error", err)
+			return nil, err
+		}
+		backend.retries.Add(1)
+		middleware.LogWarn(req, "转发到上游失败，正在重试", "backend", backend.Name, "target", target.addr(), "attempt", attempt+1, "error", err)
+	}
+}
+
+// prepareRetry 判断请求失败后能否重试，并返回重试时重新生成请求体的函数。
+// 只有幂等方法或标记为幂等的路由上的请求才会重试；请求体必须可以重放：
+// 已有 GetBody (例如解密中间件完整缓冲的明文请求体) 时直接使用，
+// 否则在 max_body_bytes 以内完整缓冲请求体 (包括流式解密后的请求体)，超过上限的请求不重试。
+func (t *upstreamTransport) prepareRetry(req *http.Request) (func() (io.ReadCloser, error), bool) {
+	backend := t.route.Backend
+	if backend.maxRetries < 0 || (!isIdempotentMethod(req.Method) && !t.route.idempotent) {
+		return nil, false
+	}
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true
+	}
+	if req.GetBody != nil {
+		return req.GetBody, true
+	}
+
+	// 请求由反向代理为本次转发创建，可以直接替换其请求体
+	body := req.Body
+	data, err := io.ReadAll(io.LimitReader(body, backend.maxRetryBody+1))
+	if err != nil || int64(len(data)) > backend.maxRetryBody {
+		// 无法完整缓冲，将已读取的部分放回请求体，按不可重试的请求转发
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(data), body), body}
+		return nil, false
+	}
+	body.Close()
+
+	getBody := func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	req.Body, _ = getBody()
+	req.GetBody = getBody
+	req.ContentLength = int64(len(data))
+	return getBody, true
+}
+
+// isIdempotentMethod 判断请求方法是否是 RFC 9110 定义的幂等方法。
+func isIdempotentMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
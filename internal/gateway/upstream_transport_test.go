@@ -0,0 +1,170 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package gateway
+
+import (
+	"encoding/json"
+	"goga/configs"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newEchoBackend 创建一个原样返回请求体的模拟后端。
+func newEchoBackend() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.Copy(w, r.Body)
+	}))
+}
+
+// newDeadURL 返回一个连接会被拒绝的地址。
+func newDeadURL() string {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	return dead.URL
+}
+
+// newFlakyPoolConfig 创建一个由正常上游和不可达上游组成的上游池配置，默认关闭被动摘除和熔断器，
+// 使每个请求都有机会落到不可达的上游上，并放宽重试预算以免影响短时间内的连续请求。
+func newFlakyPoolConfig(liveURL string, routeIdempotent bool) *configs.Config {
+	cfg := newTestConfig("")
+	cfg.Upstream.Backends = []configs.BackendConfig{{
+		Name:           "pool",
+		Targets:        []string{liveURL, newDeadURL()},
+		MaxFails:       -1,
+		CircuitBreaker: configs.CircuitBreakerConfig{FailureThreshold: -1},
+		Retry:          configs.RetryConfig{MinRetriesPerSecond: 100},
+	}}
+	cfg.Routes = []configs.RouteConfig{{Upstream: "pool", Idempotent: routeIdempotent}}
+	return cfg
+}
+
+// doProxyPost 通过代理发起 POST 请求，请求体是不可重放的流。
+func doProxyPost(t *testing.T, proxy http.Handler, body string) (*http.Response, string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/", strings.NewReader(body))
+	require.Nil(t, req.GetBody)
+	rr := httptest.NewRecorder()
+	proxy.ServeHTTP(rr, req)
+	resp := rr.Result()
+	respBody, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(respBody)
+}
+
+func TestUpstreamTransport_RetriesIdempotentRequests(t *testing.T) {
+	live := newEchoBackend()
+	defer live.Close()
+
+	cfg := newFlakyPoolConfig(live.URL, false)
+	proxy, routes := newTestProxyWithRoutes(t, cfg)
+
+	for i := 0; i < 10; i++ {
+		resp, _ := doProxyRequest(t, proxy, "/")
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "GET 请求应重试到正常的上游")
+	}
+	status := routes.Backends()[0].Status()
+	assert.Positive(t, status.Retries)
+	for _, target := range routes.Backends()[0].targets {
+		assert.Zero(t, target.active.Load(), "重试切换上游后活跃请求计数应正确释放")
+	}
+}
+
+func TestUpstreamTransport_PostBodies(t *testing.T) {
+	live := newEchoBackend()
+	defer live.Close()
+
+	t.Run("非幂等路由上的 POST 不重试", func(t *testing.T) {
+		proxy := newTestProxy(t, newFlakyPoolConfig(live.URL, false))
+		var badGateways int
+		for i := 0; i < 10; i++ {
+			if resp, _ := doProxyPost(t, proxy, `{"n":1}`); resp.StatusCode == http.StatusBadGateway {
+				badGateways++
+			}
+		}
+		assert.Equal(t, 5, badGateways)
+	})
+
+	t.Run("幂等路由上的 POST 缓冲请求体后重试", func(t *testing.T) {
+		proxy := newTestProxy(t, newFlakyPoolConfig(live.URL, true))
+		for i := 0; i < 10; i++ {
+			resp, body := doProxyPost(t, proxy, `{"n":1}`)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, `{"n":1}`, body, "重试时应重放完整的请求体")
+		}
+	})
+
+	t.Run("请求体超过缓冲上限时不重试", func(t *testing.T) {
+		cfg := newFlakyPoolConfig(live.URL, true)
+		cfg.Upstream.Backends[0].Retry.MaxBodyBytes = 4
+		proxy := newTestProxy(t, cfg)
+		var badGateways int
+		for i := 0; i < 10; i++ {
+			resp, body := doProxyPost(t, proxy, `{"n":1}`)
+			if resp.StatusCode == http.StatusBadGateway {
+				badGateways++
+			} else {
+				assert.Equal(t, `{"n":1}`, body, "部分缓冲的请求体应完整转发")
+			}
+		}
+		assert.Equal(t, 5, badGateways)
+	})
+}
+
+func TestUpstreamTransport_RetryBudget(t *testing.T) {
+	live := newEchoBackend()
+	defer live.Close()
+
+	cfg := newFlakyPoolConfig(live.URL, false)
+	cfg.Upstream.Backends[0].Retry = configs.RetryConfig{BudgetRatio: 0.01, MinRetriesPerSecond: 1}
+	proxy, routes := newTestProxyWithRoutes(t, cfg)
+
+	var badGateways int
+	for i := 0; i < 10; i++ {
+		if resp, _ := doProxyRequest(t, proxy, "/"); resp.StatusCode == http.StatusBadGateway {
+			badGateways++
+		}
+	}
+	// 重试选择上游也会推进轮询位置，因此只断言预算耗尽的请求都以 502 结束
+	status := routes.Backends()[0].Status()
+	assert.Equal(t, int64(1), status.Retries, "初始预算只允许一次重试")
+	assert.Positive(t, status.RetryBudgetExhausted)
+	assert.Equal(t, int(status.RetryBudgetExhausted), badGateways)
+}
+
+func TestUpstreamTransport_CircuitBreakerFailsFast(t *testing.T) {
+	cfg := newTestConfig("")
+	cfg.Upstream.Backends = []configs.BackendConfig{{
+		Name:           "pool",
+		URL:            newDeadURL(),
+		MaxFails:       -1,
+		CircuitBreaker: configs.CircuitBreakerConfig{FailureThreshold: 2},
+		Retry:          configs.RetryConfig{MaxRetries: -1},
+	}}
+	proxy, routes := newTestProxyWithRoutes(t, cfg)
+
+	for i := 0; i < 2; i++ {
+		resp, _ := doProxyRequest(t, proxy, "/")
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	}
+
+	resp, body := doProxyRequest(t, proxy, "/")
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	var errResp struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(body), &errResp))
+	assert.Equal(t, "CIRCUIT_OPEN", errResp.Error.Code)
+	assert.Equal(t, "open", routes.Backends()[0].Status().Targets[0].Circuit)
+}
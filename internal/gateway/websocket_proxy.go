@@ -8,32 +8,25 @@ package gateway
 import (
 	"bufio"
 	"context"
-	"crypto/tls"
 	"errors"
 	"goga/configs"
 	"goga/internal/middleware"
+	"goga/internal/tracing"
 	"io"
 	"log/slog"
 	"net"
 	"net/http"
-	"net/url"
 	"strings"
 	"sync"
-	"time"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // NewWebsocketProxy 创建一个 WebSocket 代理中间件，它会包裹现有的 http.Handler。
 // 它会检查传入的请求是否为 WebSocket 升级请求。
-// 如果是，它将处理代理逻辑；否则，它会将请求传递给下一个处理器。
-func NewWebsocketProxy(next http.Handler, config *configs.Config) http.Handler {
-	backendURL, err := url.Parse(config.BackendURL)
-	if err != nil {
-		slog.Error("无法解析后端 URL 用于 WebSocket 代理", "url", config.BackendURL, "error", err)
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			middleware.WriteJSONError(w, r, http.StatusInternalServerError, "CONFIG_ERROR", "后端 URL 配置错误")
-		})
-	}
-
+// 如果是，它将按路由表选择后端并处理代理逻辑；否则，它会将请求传递给下一个处理器。
+func NewWebsocketProxy(next http.Handler, config *configs.Config, routes *RouteTable) http.Handler {
 	// 预处理允许的 Origin，以便快速查找
 	allowedOrigins := make(map[string]struct{})
 	for _, origin := range config.Websocket.AllowedOrigins {
@@ -47,7 +40,16 @@ func NewWebsocketProxy(next http.Handler, config *configs.Config) http.Handler {
 		}
 
 		slog.Debug("检测到 WebSocket 升级请求，正在处理...", "url", r.URL.String())
-		handleWebSocketProxy(w, r, backendURL, allowedOrigins, config)
+		route := routes.Match(r)
+		if route == nil {
+			middleware.LogWarn(r, "WebSocket 请求没有匹配的路由", "host", r.Host, "path", r.URL.Path)
+			middleware.WriteJSONError(w, r, http.StatusNotFound, "NO_ROUTE", "没有与请求匹配的路由")
+			return
+		}
+		if !middleware.EnforceClientCert(w, r, route.ClientCert) {
+			return
+		}
+		handleWebSocketProxy(w, r, route, routes.client, allowedOrigins, config)
 	})
 }
 
@@ -77,55 +79,78 @@ func isOriginAllowed(r *http.Request, allowedOrigins map[string]struct{}) bool {
 }
 
 // handleWebSocketProxy 处理实际的 WebSocket 代理逻辑。
-func handleWebSocketProxy(w http.ResponseWriter, r *http.Request, backendURL *url.URL, allowedOrigins map[string]struct{}, config *configs.Config) {
+func handleWebSocketProxy(w http.ResponseWriter, r *http.Request, route *Route, client *upstreamClient, allowedOrigins map[string]struct{}, config *configs.Config) {
 	// 0. 安全检查：验证 Origin
 	if !isOriginAllowed(r, allowedOrigins) {
 		middleware.WriteJSONError(w, r, http.StatusForbidden, "FORBIDDEN_ORIGIN", "请求来源不被允许")
 		return
 	}
 
+	// WebSocket span 覆盖握手和整个会话，会话结束时结束
+	ctx, span := tracing.Start(r.Context(), "goga.websocket", trace.WithAttributes(
+		routeAttr.String(route.name),
+		backendAttr.String(route.Backend.Name),
+		semconv.URLPath(r.URL.Path),
+	))
+	var sessionErr error
+	defer func() { tracing.EndWithError(span, sessionErr) }()
+
+	// 从上游池中选择熔断器放行的上游，连接存续期间计入该上游的活跃连接数
+	backend := route.Backend
+	sel := &upstreamSelection{backend: backend, target: backend.pick(r)}
+	sel.target.acquire()
+	defer func() { sel.target.release() }()
+	target, err := backend.admit(r, sel, nil)
+	if err != nil {
+		sessionErr = err
+		middleware.LogWarn(r, "WebSocket 后端的熔断器已打开", "backend", backend.Name)
+		middleware.WriteJSONError(w, r, http.StatusServiceUnavailable, "CIRCUIT_OPEN", "后端服务暂时不可用")
+		return
+	}
+	backendURL := target.URL
+	span.SetAttributes(semconv.ServerAddress(target.addr()))
+
 	// 1. 劫持客户端连接
 	hijacker, ok := w.(http.Hijacker)
 	if !ok {
+		backend.reportCanceled(target)
 		middleware.WriteJSONError(w, r, http.StatusInternalServerError, "HIJACK_NOT_SUPPORTED", "HTTP 服务器不支持连接劫持")
 		return
 	}
 	clientConn, _, err := hijacker.Hijack()
 	if err != nil {
+		sessionErr = err
+		backend.reportCanceled(target)
 		middleware.LogError(r, "无法劫持连接", "error", err)
 		middleware.WriteJSONError(w, r, http.StatusInternalServerError, "HIJACK_FAILED", "无法劫持客户端连接")
 		return
 	}
 
-	// 2. 使用上下文连接到后端 (支持 wss 和 context cancellation)
-	var backendConn net.Conn
-	var dialErr error
-
-	// 创建一个支持上下文的拨号器
-	dialer := &net.Dialer{
-		Timeout:   10 * time.Second, // 总连接超时
-		KeepAlive: 30 * time.Second,
-	}
-
-	if backendURL.Scheme == "https" {
-		slog.Debug("正在连接到安全的 WebSocket 后端 (TLS)", "host", backendURL.Host)
-		tlsConfig := &tls.Config{InsecureSkipVerify: config.Websocket.InsecureSkipVerify}
-		backendConn, dialErr = tls.DialWithDialer(dialer, "tcp", backendURL.Host, tlsConfig)
-	} else {
-		slog.Debug("正在连接到非安全的 WebSocket 后端 (TCP)", "host", backendURL.Host)
-		backendConn, dialErr = dialer.DialContext(r.Context(), "tcp", backendURL.Host)
-	}
+	// 2. 使用上下文连接到后端 (支持 wss 和 context cancellation)，超时、CA、客户端证书等沿用 upstream.transport 配置
+	slog.Debug("正在连接到 WebSocket 后端", "host", target.addr(), "scheme", backendURL.Scheme)
+	backendConn, dialErr := client.dialWebSocket(r.Context(), backendURL, config.Websocket.InsecureSkipVerify)
 
 	if dialErr != nil {
-		middleware.LogError(r, "无法连接到 WebSocket 后端", "host", backendURL.Host, "scheme", backendURL.Scheme, "error", dialErr)
+		sessionErr = dialErr
+		middleware.LogError(r, "无法连接到 WebSocket 后端", "host", target.addr(), "scheme", backendURL.Scheme, "error", dialErr)
+		backend.reportFailure(target)
 		clientConn.Close() // Explicitly close clientConn if backend connection fails
 		return
 	}
 
-	// 3. 转发客户端的握手请求到后端
-	r.Host = backendURL.Host
+	// 3. 转发客户端的握手请求到后端，路径按路由改写并拼接后端 URL 中的路径。
+	// 转发头需在改写 Host 之前生成，X-Forwarded-Host 记录的是客户端访问的 Host
+	setForwardedHeaders(r.Header, r)
+	setClientCertHeaders(r.Header, r, []byte(config.Upstream.ClientCertHeaders.Secret))
+	setRequestIDHeader(r.Header, r)
+	tracing.Inject(ctx, r.Header)
+	r.Host = target.hostHeader()
+	route.rewriteURL(r.URL)
+	r.URL.Path, r.URL.RawPath = joinURLPath(backendURL, r.URL)
 	if err := r.Write(backendConn); err != nil {
+		sessionErr = err
 		middleware.LogError(r, "向后端写入 WebSocket 握手请求失败", "error", err)
+		backend.reportFailure(target)
 		backendConn.Close()
 		clientConn.Close()
 		return
@@ -135,12 +160,16 @@ func handleWebSocketProxy(w http.ResponseWriter, r *http.Request, backendURL *ur
 	br := bufio.NewReader(backendConn)
 	resp, err := http.ReadResponse(br, r)
 	if err != nil {
+		sessionErr = err
 		middleware.LogError(r, "从后端读取 WebSocket 握手响应失败", "error", err)
+		backend.reportFailure(target)
 		backendConn.Close()
 		clientConn.Close()
 		return
 	}
 	defer resp.Body.Close()
+	backend.reportSuccess(target) // 后端已响应握手，无论是否切换协议都说明上游可达
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
 
 	if resp.StatusCode != http.StatusSwitchingProtocols {
 		middleware.LogWarn(r, "WebSocket 握手失败：后端未切换协议", "status_code", resp.StatusCode)
@@ -153,6 +182,7 @@ func handleWebSocketProxy(w http.ResponseWriter, r *http.Request, backendURL *ur
 	}
 
 	if err := resp.Write(clientConn); err != nil {
+		sessionErr = err
 		middleware.LogError(r, "向客户端转发 WebSocket 握手响应失败", "error", err)
 		backendConn.Close()
 		clientConn.Close()
@@ -167,6 +197,8 @@ func handleWebSocketProxy(w http.ResponseWriter, r *http.Request, backendURL *ur
 		backendReader = io.MultiReader(br, backendConn)
 	}
 
+	GlobalWebSocketMetrics.RecordOpened()
+	defer GlobalWebSocketMetrics.RecordClosed()
 	transferStreams(r.Context(), r.URL.String(), clientConn, backendConn, backendReader)
 }
 
@@ -194,40 +226,25 @@ func transferStreams(ctx context.Context, requestURL string, clientConn, backend
 		}
 	}()
 
+	// 登记会话，网关关闭时向双方发送关闭帧。按帧转发，关闭帧只会插入在帧边界上
+	session := newWSSession(clientConn, backendConn)
+	webSocketSessions.add(session)
+	defer webSocketSessions.remove(session)
+
 	wg.Add(2)
 
 	// 后端 -> 客户端
 	go func() {
 		defer wg.Done()
-		buf := getCopyBuffer()
-		defer putCopyBuffer(buf)
-		_, err := io.CopyBuffer(clientConn, backendReader, *buf)
-		if err != nil && !isClosingError(err) {
+		if err := session.toClient.relay(backendReader); err != nil && !isClosingError(err) {
 			errChan <- err
 		}
 	}()
 
-	// 客户端 -> 后端
+	// 客户端 -> 后端，帧负载在 TCP 连接之间仍使用零拷贝
 	go func() {
 		defer wg.Done()
-		// 尝试进行零拷贝优化
-		if tcpDst, ok := backendConn.(*net.TCPConn); ok {
-			if tcpSrc, ok := clientConn.(*net.TCPConn); ok {
-				slog.Debug("使用零拷贝路径进行 WebSocket 数据流复制 (客户端->后端)", "url", requestURL)
-				_, err := io.Copy(tcpDst, tcpSrc) // io.Copy 在这种情况下会触发零拷贝
-				if err != nil && !isClosingError(err) {
-					errChan <- err
-				}
-				return // 零拷贝路径完成
-			}
-		}
-
-		// 回退到缓冲池路径
-		slog.Debug("回退到缓冲池路径进行 WebSocket 数据流复制 (客户端->后端)", "url", requestURL)
-		buf := getCopyBuffer()
-		defer putCopyBuffer(buf)
-		_, err := io.CopyBuffer(backendConn, clientConn, *buf)
-		if err != nil && !isClosingError(err) {
+		if err := session.toBackend.relay(clientConn); err != nil && !isClosingError(err) {
 			errChan <- err
 		}
 	}()
@@ -262,8 +279,8 @@ func getCopyBuffer() *[]byte {
 
 // putCopyBuffer 将缓冲区安全地放回池中。
 func putCopyBuffer(buf *[]byte) {
-	// 重置缓冲区长度以重用内存
-	*buf = (*buf)[:0]
+	// 恢复缓冲区的完整长度，io.CopyBuffer 不接受长度为 0 的缓冲区
+	*buf = (*buf)[:cap(*buf)]
 	copyBufPool.Put(buf)
 }
 
@@ -91,7 +91,9 @@ func TestWebSocketProxy(t *testing.T) {
 		w.Write([]byte("next handler called"))
 	})
 
-	wsProxy := NewWebsocketProxy(nextHandler, cfg)
+	routes, err := NewRouteTable(cfg)
+	require.NoError(t, err)
+	wsProxy := NewWebsocketProxy(nextHandler, cfg, routes)
 
 	// 3. 测试非 WebSocket 请求
 	t.Run("Non-WebSocket request should be passed to next handler", func(t *testing.T) {
@@ -179,3 +181,57 @@ func (m *mockHijacker) WriteHeader(statusCode int) {
 func (m *mockHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	return m.conn, bufio.NewReadWriter(bufio.NewReader(m.conn), bufio.NewWriter(m.conn)), nil
 }
+
+func TestWebsocketProxy_Routing(t *testing.T) {
+	// 后端记录收到的握手路径后直接完成升级
+	paths := make(chan string, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.Path
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n"))
+	}))
+	defer backend.Close()
+
+	cfg := newTestConfig("")
+	cfg.Upstream.Backends = []configs.BackendConfig{{Name: "ws", URL: backend.URL + "/socket"}}
+	cfg.Routes = []configs.RouteConfig{{PathPrefix: "/live", StripPrefix: true, Upstream: "ws"}}
+	routes, err := NewRouteTable(cfg)
+	require.NoError(t, err)
+	proxyServer := httptest.NewServer(NewWebsocketProxy(http.NotFoundHandler(), cfg, routes))
+	defer proxyServer.Close()
+
+	upgrade := func(path string) *http.Response {
+		proxyURL, err := url.Parse(proxyServer.URL)
+		require.NoError(t, err)
+		conn, err := net.Dial("tcp", proxyURL.Host)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+
+		req, err := http.NewRequest("GET", proxyServer.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Origin", "http://localhost")
+		require.NoError(t, req.Write(conn))
+
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	t.Run("按路由转发并改写路径", func(t *testing.T) {
+		resp := upgrade("/live/chat")
+		assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+		assert.Equal(t, "/socket/chat", <-paths)
+	})
+
+	t.Run("没有匹配的路由", func(t *testing.T) {
+		resp := upgrade("/other")
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
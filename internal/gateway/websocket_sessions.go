@@ -0,0 +1,296 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package gateway
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// WebSocket 帧 (RFC 6455 第 5.2 节) 的相关常量
+const (
+	wsOpClose           = 0x8
+	wsCloseGoingAway    = 1001 // 服务端正在关闭
+	wsMaxHeaderLen      = 14   // 2 字节基本头 + 8 字节扩展长度 + 4 字节掩码
+	wsCloseWriteTimeout = time.Second
+)
+
+// wsCloseReason 是网关关闭时发送的关闭帧中的原因
+const wsCloseReason = "Going Away"
+
+// webSocketPollInterval 是等待 WebSocket 会话结束时检查的间隔
+const webSocketPollInterval = 50 * time.Millisecond
+
+// errNotWebSocketFrame 表示读到的数据不是合法的 WebSocket 帧头，此后该方向按字节透传
+var errNotWebSocketFrame = errors.New("不是合法的 WebSocket 帧")
+
+// wsFrameHeader 是一个帧的帧头
+type wsFrameHeader struct {
+	raw    []byte // 原始帧头，包括掩码
+	opcode byte
+	length int64 // 负载长度
+}
+
+// readFrameHeader 读取一个帧头。保留位 RSV2/RSV3 被置位、操作码未定义、控制帧被分片或过长时
+// 返回 errNotWebSocketFrame 以及已经读取的字节。RSV1 由 permessage-deflate 使用，是合法的
+func readFrameHeader(r io.Reader, buf *[wsMaxHeaderLen]byte) (wsFrameHeader, error) {
+	h := wsFrameHeader{raw: buf[:2]}
+	if _, err := io.ReadFull(r, h.raw); err != nil {
+		return h, err
+	}
+	fin, rsv, masked := buf[0]&0x80 != 0, buf[0]&0x30, buf[1]&0x80 != 0
+	h.opcode = buf[0] & 0x0f
+	length := int64(buf[1] & 0x7f)
+	switch {
+	case rsv != 0, h.opcode > 0xa, h.opcode > 0x2 && h.opcode < 0x8:
+		return h, errNotWebSocketFrame
+	case h.opcode >= wsOpClose && (!fin || length > 125):
+		return h, errNotWebSocketFrame
+	}
+
+	extra := 0
+	switch length {
+	case 126:
+		extra = 2
+	case 127:
+		extra = 8
+	}
+	if masked {
+		extra += 4
+	}
+	h.raw = buf[:2+extra]
+	if _, err := io.ReadFull(r, h.raw[2:]); err != nil {
+		return h, err
+	}
+	switch length {
+	case 126:
+		length = int64(binary.BigEndian.Uint16(buf[2:4]))
+	case 127:
+		n := binary.BigEndian.Uint64(buf[2:10])
+		if n>>63 != 0 {
+			return h, errNotWebSocketFrame
+		}
+		length = int64(n)
+	}
+	h.length = length
+	return h, nil
+}
+
+// closeFrame 返回状态码为 1001 的关闭帧。客户端发往服务端的帧必须使用掩码 (RFC 6455 第 5.3 节)
+func closeFrame(masked bool) []byte {
+	payload := binary.BigEndian.AppendUint16(nil, wsCloseGoingAway)
+	payload = append(payload, wsCloseReason...)
+	frame := []byte{0x80 | wsOpClose, byte(len(payload))}
+	if !masked {
+		return append(frame, payload...)
+	}
+	var key [4]byte
+	rand.Read(key[:])
+	frame[1] |= 0x80
+	frame = append(frame, key[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^key[i%4])
+	}
+	return frame
+}
+
+// wsDirection 是 WebSocket 会话的一个转发方向。转发一个帧期间持有 mu，关闭帧因此只会写在帧边界上
+type wsDirection struct {
+	dst       net.Conn
+	masked    bool // 写往后端的帧需要掩码
+	mu        sync.Mutex
+	opaque    bool // 数据不是合法的 WebSocket 帧，按字节透传，无法插入关闭帧
+	closeSent bool // 已向 dst 转发或发送关闭帧，此后不再转发数据帧
+}
+
+// relay 逐帧把 src 的数据转发到 dst，直到连接关闭。网关发送关闭帧后丢弃 src 的后续数据帧，
+// 收到 src 的关闭帧 (即对方确认关闭) 时返回 nil
+func (d *wsDirection) relay(src io.Reader) error {
+	var buf [wsMaxHeaderLen]byte
+	for {
+		h, err := readFrameHeader(src, &buf)
+		if errors.Is(err, errNotWebSocketFrame) {
+			d.mu.Lock()
+			d.opaque = true
+			d.mu.Unlock()
+			if _, err := d.dst.Write(h.raw); err != nil {
+				return err
+			}
+			_, err = io.Copy(d.dst, src)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		d.mu.Lock()
+		acknowledged := d.closeSent
+		if acknowledged {
+			_, err = io.CopyN(io.Discard, src, h.length)
+		} else if _, err = d.dst.Write(h.raw); err == nil {
+			// 负载直接复制，TCP 连接之间仍可使用零拷贝
+			_, err = io.CopyN(d.dst, src, h.length)
+			d.closeSent = h.opcode == wsOpClose
+		}
+		d.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if acknowledged && h.opcode == wsOpClose {
+			return nil
+		}
+	}
+}
+
+// goingAway 在帧边界向 dst 发送 1001 关闭帧
+func (d *wsDirection) goingAway() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.opaque || d.closeSent {
+		return
+	}
+	d.closeSent = true
+	d.dst.SetWriteDeadline(time.Now().Add(wsCloseWriteTimeout))
+	defer d.dst.SetWriteDeadline(time.Time{})
+	if _, err := d.dst.Write(closeFrame(d.masked)); err != nil {
+		slog.Debug("发送 WebSocket 关闭帧失败", "remote_addr", d.dst.RemoteAddr().String(), "error", err)
+	}
+}
+
+// wsSession 是一个正在转发数据的 WebSocket 会话
+type wsSession struct {
+	clientConn  net.Conn
+	backendConn net.Conn
+	toClient    *wsDirection
+	toBackend   *wsDirection
+}
+
+// newWSSession 创建客户端与后端之间的会话
+func newWSSession(clientConn, backendConn net.Conn) *wsSession {
+	return &wsSession{
+		clientConn:  clientConn,
+		backendConn: backendConn,
+		toClient:    &wsDirection{dst: clientConn},
+		toBackend:   &wsDirection{dst: backendConn, masked: true},
+	}
+}
+
+// goingAway 同时向客户端和后端发送 1001 关闭帧，等待双方回复关闭帧后会话结束
+func (s *wsSession) goingAway() {
+	go s.toClient.goingAway()
+	go s.toBackend.goingAway()
+}
+
+// close 强制关闭会话的两个连接
+func (s *wsSession) close() {
+	s.clientConn.Close()
+	s.backendConn.Close()
+}
+
+// wsRegistry 跟踪正在转发数据的 WebSocket 会话。http.Server.Shutdown 不跟踪已劫持的连接，
+// 网关关闭时由它通知会话双方并等待会话结束
+type wsRegistry struct {
+	mu       sync.Mutex
+	sessions map[*wsSession]struct{}
+	draining bool
+}
+
+// webSocketSessions 是所有 WebSocket 代理共用的会话表，重新加载配置后旧代理的会话仍在其中
+var webSocketSessions = newWSRegistry()
+
+func newWSRegistry() *wsRegistry {
+	return &wsRegistry{sessions: make(map[*wsSession]struct{})}
+}
+
+// add 登记会话。网关正在关闭时，握手刚完成的会话也会立即收到关闭帧
+func (reg *wsRegistry) add(s *wsSession) {
+	reg.mu.Lock()
+	reg.sessions[s] = struct{}{}
+	draining := reg.draining
+	reg.mu.Unlock()
+	if draining {
+		s.goingAway()
+	}
+}
+
+// remove 在会话结束时移除登记
+func (reg *wsRegistry) remove(s *wsSession) {
+	reg.mu.Lock()
+	delete(reg.sessions, s)
+	reg.mu.Unlock()
+}
+
+// count 返回正在转发数据的会话数
+func (reg *wsRegistry) count() int {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	return len(reg.sessions)
+}
+
+// wait 等待所有会话结束，ctx 结束时返回 ctx.Err()
+func (reg *wsRegistry) wait(ctx context.Context) error {
+	ticker := time.NewTicker(webSocketPollInterval)
+	defer ticker.Stop()
+	for reg.count() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// drain 向所有会话的双方发送 1001 关闭帧并等待会话结束，ctx 结束时强制关闭剩余的会话
+func (reg *wsRegistry) drain(ctx context.Context) error {
+	reg.mu.Lock()
+	reg.draining = true
+	sessions := make([]*wsSession, 0, len(reg.sessions))
+	for s := range reg.sessions {
+		sessions = append(sessions, s)
+	}
+	reg.mu.Unlock()
+
+	if len(sessions) > 0 {
+		slog.Info("正在关闭 WebSocket 会话", "active", len(sessions))
+	}
+	for _, s := range sessions {
+		s.goingAway()
+	}
+	err := reg.wait(ctx)
+	if err != nil {
+		reg.mu.Lock()
+		slog.Warn("WebSocket 会话未在限定时间内结束，强制关闭", "active", len(reg.sessions))
+		for s := range reg.sessions {
+			s.close()
+		}
+		reg.mu.Unlock()
+	}
+	return err
+}
+
+// ActiveWebSockets 返回正在转发数据的 WebSocket 会话数
+func ActiveWebSockets() int {
+	return webSocketSessions.count()
+}
+
+// WaitWebSockets 等待所有 WebSocket 会话自行结束，ctx 结束时返回 ctx.Err()。平滑升级后旧进程用它让会话继续完成
+func WaitWebSockets(ctx context.Context) error {
+	return webSocketSessions.wait(ctx)
+}
+
+// DrainWebSockets 向所有 WebSocket 会话的客户端和后端发送 1001 (Going Away) 关闭帧，等待双方完成关闭握手，
+// ctx 结束时强制关闭剩余的连接并返回 ctx.Err()。调用后新建立的会话也会立即收到关闭帧
+func DrainWebSockets(ctx context.Context) error {
+	return webSocketSessions.drain(ctx)
+}
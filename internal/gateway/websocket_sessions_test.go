@@ -0,0 +1,207 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFrame 写入一个未分片的帧，masked 为 true 时按客户端的要求使用掩码
+func writeFrame(w io.Writer, opcode byte, payload []byte, masked bool) error {
+	frame := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = binary.BigEndian.AppendUint16(append(frame, 126), uint16(n))
+	default:
+		frame = binary.BigEndian.AppendUint64(append(frame, 127), uint64(n))
+	}
+	if masked {
+		key := []byte{1, 2, 3, 4}
+		frame[1] |= 0x80
+		frame = append(frame, key...)
+		for i, b := range payload {
+			frame = append(frame, b^key[i%4])
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	_, err := w.Write(frame)
+	return err
+}
+
+// readFrame 读取一个帧，返回操作码和去掉掩码后的负载
+func readFrame(r io.Reader) (byte, []byte, error) {
+	var buf [wsMaxHeaderLen]byte
+	h, err := readFrameHeader(r, &buf)
+	if err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, h.length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	if h.raw[1]&0x80 != 0 {
+		key := h.raw[len(h.raw)-4:]
+		for i := range payload {
+			payload[i] ^= key[i%4]
+		}
+	}
+	return h.opcode, payload, nil
+}
+
+// frameWebsocketBackend 创建一个按帧回显的 WebSocket 后端，收到关闭帧时回复关闭帧，并把关闭帧的负载发送到 closed
+func frameWebsocketBackend(closed chan<- []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		for {
+			opcode, payload, err := readFrame(brw)
+			if err != nil {
+				return
+			}
+			if opcode == wsOpClose {
+				writeFrame(conn, wsOpClose, payload, false)
+				closed <- payload
+				return
+			}
+			writeFrame(conn, opcode, payload, false)
+		}
+	}))
+}
+
+// dialWebSocket 通过代理完成握手，返回客户端连接和读取器
+func dialWebSocket(t *testing.T, proxyURL string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(proxyURL, "http://"))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	req, err := http.NewRequest(http.MethodGet, proxyURL, nil)
+	require.NoError(t, err)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Origin", "http://localhost")
+	require.NoError(t, req.Write(conn))
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn, br
+}
+
+// newDrainTestProxy 使用独立的会话表启动 WebSocket 代理
+func newDrainTestProxy(t *testing.T, backendURL string) string {
+	t.Helper()
+	old := webSocketSessions
+	webSocketSessions = newWSRegistry()
+	t.Cleanup(func() { webSocketSessions = old })
+
+	cfg := newTestConfig(backendURL)
+	routes, err := NewRouteTable(cfg)
+	require.NoError(t, err)
+	t.Cleanup(routes.Stop)
+	proxy := httptest.NewServer(NewWebsocketProxy(http.NotFoundHandler(), cfg, routes))
+	t.Cleanup(proxy.Close)
+	return proxy.URL
+}
+
+func TestReadFrameHeader(t *testing.T) {
+	for _, n := range []int{0, 125, 126, 70000} {
+		var buf bytes.Buffer
+		require.NoError(t, writeFrame(&buf, 0x2, make([]byte, n), true))
+		opcode, payload, err := readFrame(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, byte(0x2), opcode)
+		assert.Len(t, payload, n)
+	}
+
+	for _, raw := range [][]byte{
+		[]byte("hello websocket"), // 保留位 RSV2 被置位
+		{0x83, 0x00},              // 未定义的操作码
+		{0x08, 0x00},              // 被分片的关闭帧
+		{0x88, 126, 0x00, 0x80},   // 过长的控制帧
+	} {
+		var buf [wsMaxHeaderLen]byte
+		h, err := readFrameHeader(bytes.NewReader(raw), &buf)
+		assert.ErrorIs(t, err, errNotWebSocketFrame, "%q", raw)
+		assert.Equal(t, raw[:2], h.raw, "透传时需要先写出已经读取的字节")
+	}
+}
+
+func TestDrainWebSockets(t *testing.T) {
+	closed := make(chan []byte, 1)
+	backend := frameWebsocketBackend(closed)
+	defer backend.Close()
+	conn, br := dialWebSocket(t, newDrainTestProxy(t, backend.URL))
+
+	require.NoError(t, writeFrame(conn, 0x1, []byte("hello"), true))
+	opcode, payload, err := readFrame(br)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello"), payload)
+	require.Eventually(t, func() bool { return ActiveWebSockets() == 1 }, time.Second, 10*time.Millisecond)
+
+	drained := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		drained <- DrainWebSockets(ctx)
+	}()
+
+	// 客户端收到未加掩码的 1001 关闭帧，后端收到加掩码的 1001 关闭帧
+	opcode, payload, err = readFrame(br)
+	require.NoError(t, err)
+	assert.Equal(t, byte(wsOpClose), opcode)
+	assert.Equal(t, uint16(wsCloseGoingAway), binary.BigEndian.Uint16(payload))
+	assert.Equal(t, wsCloseReason, string(payload[2:]))
+	backendPayload := <-closed
+	assert.Equal(t, uint16(wsCloseGoingAway), binary.BigEndian.Uint16(backendPayload))
+
+	// 客户端回复关闭帧后会话结束，后端的回复不会再转发给客户端
+	require.NoError(t, writeFrame(conn, wsOpClose, payload[:2], true))
+	require.NoError(t, <-drained)
+	assert.Zero(t, ActiveWebSockets())
+	_, err = br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestDrainWebSockets_ForceClose(t *testing.T) {
+	backend := frameWebsocketBackend(make(chan []byte, 1))
+	defer backend.Close()
+	conn, br := dialWebSocket(t, newDrainTestProxy(t, backend.URL))
+	require.NoError(t, writeFrame(conn, 0x1, []byte("hello"), true))
+	_, _, err := readFrame(br)
+	require.NoError(t, err)
+
+	// 客户端不回复关闭帧，超时后连接被强制关闭
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, DrainWebSockets(ctx), context.DeadlineExceeded)
+	opcode, _, err := readFrame(br)
+	require.NoError(t, err)
+	assert.Equal(t, byte(wsOpClose), opcode)
+	_, err = br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+	require.Eventually(t, func() bool { return ActiveWebSockets() == 0 }, time.Second, 10*time.Millisecond)
+}
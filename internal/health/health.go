@@ -0,0 +1,257 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+// Package health 提供 /livez (存活) 和 /readyz (就绪) 探针接口。
+// 存活探针只说明进程仍在处理请求，不检查依赖，避免 Redis 等依赖故障时编排系统反复重启网关；
+// 就绪探针检查密钥缓存、上游和是否正在退出，检查未通过时返回 503，负载均衡器据此暂停向网关转发流量。
+package health
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goga/configs"
+	"goga/internal/middleware"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 探针接口的路径
+const (
+	LivezPath  = "/livez"
+	ReadyzPath = "/readyz"
+)
+
+// defaultTimeout 是未配置 health.timeout 时每项就绪检查的超时
+const defaultTimeout = 2 * time.Second
+
+// 检查结果和整体状态
+const (
+	statusOK       = "ok"
+	statusFail     = "fail"
+	statusDegraded = "degraded" // 部分检查失败，但仍然就绪
+)
+
+// draining 标记网关正在退出，由 SetDraining 设置
+var draining atomic.Bool
+
+// SetDraining 标记网关开始退出，此后 /readyz 返回 503
+func SetDraining() {
+	draining.Store(true)
+}
+
+// Check 是一项就绪检查，Run 返回 nil 表示依赖可用。
+// Group 相同的检查互为备选，只有同组的检查全部失败时才不就绪；Group 为空的检查失败即不就绪
+type Check struct {
+	Name  string
+	Group string
+	Run   func(ctx context.Context) error
+}
+
+// Result 是一项检查的结果
+type Result struct {
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// Report 是探针接口的响应体
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks,omitempty"`
+}
+
+// Handler 处理 /livez 和 /readyz 请求
+type Handler struct {
+	allowed []netip.Prefix
+	token   [sha256.Size]byte
+	hasKey  bool
+	timeout time.Duration
+	checks  []Check
+}
+
+// NewHandler 按 health 配置创建探针处理器，checks 是 /readyz 除退出状态外需要执行的检查
+func NewHandler(cfg configs.HealthConfig, checks ...Check) (*Handler, error) {
+	h := &Handler{timeout: cfg.Timeout, checks: checks}
+	if h.timeout <= 0 {
+		h.timeout = defaultTimeout
+	}
+	for _, s := range cfg.AllowedCIDRs {
+		prefix, err := parsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("无效的 health.allowed_cidrs: %w", err)
+		}
+		h.allowed = append(h.allowed, prefix)
+	}
+	if cfg.Token != "" {
+		h.token, h.hasKey = sha256.Sum256([]byte(cfg.Token)), true
+	}
+	return h, nil
+}
+
+// parsePrefix 解析 CIDR 或单个 IP
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+}
+
+// Register 在 mux 上注册探针接口
+func Register(mux *http.ServeMux, h *Handler) {
+	mux.Handle(LivezPath, h.authorize(http.HandlerFunc(h.livez)))
+	mux.Handle(ReadyzPath, h.authorize(http.HandlerFunc(h.readyz)))
+}
+
+// authorize 只允许本机、admin 监听器、allowed_cidrs 中的来源或携带正确令牌的请求访问
+func (h *Handler) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.allowedSource(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if h.hasKey {
+			presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			got := sha256.Sum256([]byte(presented))
+			if ok && subtle.ConstantTimeCompare(got[:], h.token[:]) == 1 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			middleware.LogWarn(r, "拒绝了令牌无效的探针请求", "path", r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="goga-health"`)
+			middleware.WriteJSONError(w, r, http.StatusUnauthorized, "UNAUTHORIZED", "缺少或无效的令牌")
+			return
+		}
+		middleware.LogWarn(r, "拒绝了来自不允许来源的探针请求", "remote_host", middleware.GetClientIP(r), "path", r.URL.Path)
+		middleware.WriteJSONError(w, r, http.StatusForbidden, "FORBIDDEN", "禁止访问")
+	})
+}
+
+// allowedSource 判断请求是否来自允许的来源。经过受信任代理转发的请求以解析出的客户端地址为准
+func (h *Handler) allowedSource(r *http.Request) bool {
+	if middleware.IsAdminListener(r) {
+		return true
+	}
+	host := middleware.GetClientIP(r)
+	if middleware.IsUnixSocket(r) && host == middleware.RemoteIP(r) {
+		return true
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	if addr.IsLoopback() {
+		return true
+	}
+	for _, prefix := range h.allowed {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// livez 在进程能够处理请求时返回 200
+func (h *Handler) livez(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, http.StatusOK, Report{Status: statusOK})
+}
+
+// readyz 并发执行所有检查，就绪时返回 200，否则返回 503，响应体包含每项检查的结果。
+// 同组的检查只有部分失败时仍然就绪，整体状态为 degraded
+func (h *Handler) readyz(w http.ResponseWriter, r *http.Request) {
+	results := h.run(r.Context())
+	report := Report{Status: h.status(results), Checks: results}
+	code := http.StatusOK
+	switch report.Status {
+	case statusFail:
+		code = http.StatusServiceUnavailable
+		middleware.LogWarn(r, "就绪检查未通过", "checks", report.Checks)
+	case statusDegraded:
+		middleware.LogWarn(r, "部分就绪检查未通过", "checks", report.Checks)
+	}
+	writeReport(w, code, report)
+}
+
+// status 按检查结果返回整体状态: 未分组的检查失败或某一组的检查全部失败时为 fail，其他检查失败时为 degraded
+func (h *Handler) status(results map[string]Result) string {
+	if results["draining"].Status != statusOK {
+		return statusFail
+	}
+	status := statusOK
+	groupOK := make(map[string]bool)
+	for _, check := range h.checks {
+		ok := results[check.Name].Status == statusOK
+		if !ok {
+			status = statusDegraded
+		}
+		if check.Group == "" {
+			if !ok {
+				return statusFail
+			}
+			continue
+		}
+		groupOK[check.Group] = groupOK[check.Group] || ok
+	}
+	for _, ok := range groupOK {
+		if !ok {
+			return statusFail
+		}
+	}
+	return status
+}
+
+// run 执行退出状态检查和所有依赖检查，每项检查有各自的超时
+func (h *Handler) run(ctx context.Context) map[string]Result {
+	results := map[string]Result{"draining": {Status: statusOK}}
+	if draining.Load() {
+		results["draining"] = Result{Status: statusFail, Error: "网关正在退出"}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range h.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, h.timeout)
+			defer cancel()
+			start := time.Now()
+			err := check.Run(ctx)
+			if errors.Is(err, context.DeadlineExceeded) {
+				err = fmt.Errorf("检查超时 (%s)", h.timeout)
+			}
+			result := Result{Status: statusOK, DurationMs: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				result.Status, result.Error = statusFail, err.Error()
+			}
+			mu.Lock()
+			results[check.Name] = result
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// writeReport 以 JSON 写出探针结果，探针结果不能被缓存
+func writeReport(w http.ResponseWriter, code int, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"goga/configs"
+	"goga/internal/middleware"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testToken = "s3cret-probe-token"
+
+// newTestMux 返回注册了探针接口的 mux
+func newTestMux(t *testing.T, cfg configs.HealthConfig, checks ...Check) http.Handler {
+	t.Helper()
+	h, err := NewHandler(cfg, checks...)
+	require.NoError(t, err)
+	mux := http.NewServeMux()
+	Register(mux, h)
+	return mux
+}
+
+// probe 从 remoteAddr 请求 path，返回状态码和解码后的响应体
+func probe(t *testing.T, handler http.Handler, path, remoteAddr, token string) (int, Report) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.RemoteAddr = remoteAddr
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	var report Report
+	if rr.Code == http.StatusOK || rr.Code == http.StatusServiceUnavailable {
+		assert.Equal(t, "no-store", rr.Header().Get("Cache-Control"))
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	}
+	return rr.Code, report
+}
+
+func TestNewHandler_InvalidCIDR(t *testing.T) {
+	_, err := NewHandler(configs.HealthConfig{AllowedCIDRs: []string{"10.0.0.0/33"}})
+	assert.Error(t, err)
+	_, err = NewHandler(configs.HealthConfig{AllowedCIDRs: []string{"not-an-ip"}})
+	assert.Error(t, err)
+}
+
+func TestAccessControl(t *testing.T) {
+	handler := newTestMux(t, configs.HealthConfig{AllowedCIDRs: []string{"10.0.0.0/8", "192.168.1.10"}, Token: testToken})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		token      string
+		want       int
+	}{
+		{"IPv4 回环地址", "127.0.0.1:1234", "", http.StatusOK},
+		{"IPv6 回环地址", "[::1]:1234", "", http.StatusOK},
+		{"允许的网段", "10.1.2.3:1234", "", http.StatusOK},
+		{"允许的单个地址", "192.168.1.10:1234", "", http.StatusOK},
+		{"IPv4 映射的 IPv6 地址", "[::ffff:10.1.2.3]:1234", "", http.StatusOK},
+		{"不允许的来源携带正确令牌", "203.0.113.1:1234", testToken, http.StatusOK},
+		{"不允许的来源未携带令牌", "203.0.113.1:1234", "", http.StatusUnauthorized},
+		{"不允许的来源携带错误令牌", "192.168.1.11:1234", "wrong", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, path := range []string{LivezPath, ReadyzPath} {
+				code, _ := probe(t, handler, path, tt.remoteAddr, tt.token)
+				assert.Equal(t, tt.want, code, path)
+			}
+		})
+	}
+
+	// 没有配置令牌时拒绝不允许的来源，令牌不起作用
+	handler = newTestMux(t, configs.HealthConfig{})
+	code, _ := probe(t, handler, LivezPath, "203.0.113.1:1234", testToken)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	// admin 监听器上的请求不受来源限制
+	code, _ = probe(t, middleware.AdminListener(handler), LivezPath, "203.0.113.1:1234", "")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestLivez_IgnoresChecks(t *testing.T) {
+	failing := Check{Name: "key_cache", Run: func(context.Context) error { return errors.New("redis down") }}
+	code, report := probe(t, newTestMux(t, configs.HealthConfig{}, failing), LivezPath, "127.0.0.1:1234", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", report.Status)
+	assert.Empty(t, report.Checks)
+}
+
+func TestReadyz(t *testing.T) {
+	healthy := Check{Name: "upstream:web", Run: func(context.Context) error { return nil }}
+	failing := Check{Name: "key_cache", Run: func(context.Context) error { return errors.New("redis down") }}
+	slow := Check{Name: "upstream:slow", Run: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+
+	code, report := probe(t, newTestMux(t, configs.HealthConfig{}, healthy), ReadyzPath, "127.0.0.1:1234", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", report.Status)
+	assert.Equal(t, "ok", report.Checks["draining"].Status)
+	assert.Equal(t, "ok", report.Checks["upstream:web"].Status)
+
+	// 未分组的检查失败或超时时返回 503，响应体包含每项检查的结果
+	handler := newTestMux(t, configs.HealthConfig{Timeout: 50 * time.Millisecond}, healthy, failing, slow)
+	code, report = probe(t, handler, ReadyzPath, "127.0.0.1:1234", "")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "fail", report.Status)
+	assert.Equal(t, "ok", report.Checks["upstream:web"].Status)
+	assert.Equal(t, "fail", report.Checks["key_cache"].Status)
+	assert.Equal(t, "redis down", report.Checks["key_cache"].Error)
+	assert.Equal(t, "fail", report.Checks["upstream:slow"].Status)
+	assert.Contains(t, report.Checks["upstream:slow"].Error, "超时")
+	assert.GreaterOrEqual(t, report.Checks["upstream:slow"].DurationMs, 50.0)
+}
+
+func TestReadyz_Draining(t *testing.T) {
+	t.Cleanup(func() { draining.Store(false) })
+	handler := newTestMux(t, configs.HealthConfig{})
+
+	SetDraining()
+	code, report := probe(t, handler, ReadyzPath, "127.0.0.1:1234", "")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "fail", report.Checks["draining"].Status)
+
+	// 退出期间进程仍然存活
+	code, _ = probe(t, handler, LivezPath, "127.0.0.1:1234", "")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestReadyz_Groups(t *testing.T) {
+	up := func(name string) Check {
+		return Check{Name: name, Group: "upstreams", Run: func(context.Context) error { return nil }}
+	}
+	down := func(name string) Check {
+		return Check{Name: name, Group: "upstreams", Run: func(context.Context) error { return errors.New("connection refused") }}
+	}
+
+	// 同组的检查部分失败时仍然就绪
+	code, report := probe(t, newTestMux(t, configs.HealthConfig{}, up("upstream:shop"), down("upstream:api")), ReadyzPath, "127.0.0.1:1234", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "degraded", report.Status)
+	assert.Equal(t, "fail", report.Checks["upstream:api"].Status)
+
+	// 同组的检查全部失败时不就绪
+	code, report = probe(t, newTestMux(t, configs.HealthConfig{}, down("upstream:shop"), down("upstream:api")), ReadyzPath, "127.0.0.1:1234", "")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "fail", report.Status)
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package metrics
+
+import (
+	"math/rand/v2"
+	"sync/atomic"
+)
+
+// shardCount 是分片计数器和直方图的分片数，必须是 2 的幂
+const shardCount = 16
+
+// cacheLineSize 是用于避免伪共享的缓存行大小
+const cacheLineSize = 64
+
+// paddedInt64 独占一个缓存行的原子计数
+type paddedInt64 struct {
+	atomic.Int64
+	_ [cacheLineSize - 8]byte
+}
+
+// Counter 是分片的原子计数器，零值可以直接使用。
+// 每次累加随机选择一个分片，多个 CPU 并发累加时不会争用同一个缓存行；读取时对所有分片求和。
+type Counter struct {
+	shards [shardCount]paddedInt64
+}
+
+// Add 累加 n
+func (c *Counter) Add(n int64) {
+	c.shards[shardIndex()].Add(n)
+}
+
+// Inc 累加 1
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Load 返回所有分片之和。与并发的累加之间不保证原子性，适用于指标读取
+func (c *Counter) Load() int64 {
+	var sum int64
+	for i := range c.shards {
+		sum += c.shards[i].Load()
+	}
+	return sum
+}
+
+// shardIndex 随机选择一个分片。math/rand/v2 的全局函数使用运行时按线程维护的随机数状态，无需加锁
+func shardIndex() int {
+	return int(rand.Uint32() & (shardCount - 1))
+}
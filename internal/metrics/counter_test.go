@@ -0,0 +1,77 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package metrics
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounter(t *testing.T) {
+	var c Counter
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 1000 {
+				c.Inc()
+			}
+		}()
+	}
+	wg.Wait()
+	c.Add(-10)
+	assert.Equal(t, int64(7990), c.Load())
+}
+
+func TestRuntimeSampler(t *testing.T) {
+	s := StartRuntimeSampler(time.Hour)
+	defer s.Stop()
+
+	stats := s.Stats()
+	assert.NotZero(t, stats.HeapObjectsBytes)
+	assert.NotZero(t, stats.HeapAllocsBytes)
+	assert.NotZero(t, stats.Goroutines)
+
+	var w Writer
+	s.Collect(&w)
+	assert.Contains(t, w.buf.String(), "# TYPE goga_go_heap_objects_bytes gauge")
+	s.Stop() // 重复调用不应 panic
+}
+
+// BenchmarkCounter 并发累加分片计数器
+func BenchmarkCounter(b *testing.B) {
+	var c Counter
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.Inc()
+		}
+	})
+}
+
+// BenchmarkAtomicInt64 作为对比，并发累加同一个原子变量
+func BenchmarkAtomicInt64(b *testing.B) {
+	var c atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.Add(1)
+		}
+	})
+}
+
+// BenchmarkHistogram 并发记录直方图
+func BenchmarkHistogram(b *testing.B) {
+	h := NewHistogram(LatencyBuckets)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			h.Observe(0.003)
+		}
+	})
+}
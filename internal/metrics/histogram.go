@@ -0,0 +1,93 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package metrics
+
+import (
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// LatencyBuckets 是耗时直方图的默认桶上界 (秒)，覆盖从亚毫秒级的解密到数秒的后端响应
+var LatencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Histogram 是一个无锁的固定桶直方图，可以被多个 goroutine 并发记录。
+// 与 Counter 一样按分片记录，并发观测时不会争用同一个缓存行。
+type Histogram struct {
+	bounds []float64 // 升序排列的桶上界
+	shards [shardCount]histogramShard
+}
+
+// histogramShard 是直方图的一个分片
+type histogramShard struct {
+	counts []atomic.Uint64 // 每个桶 (不累计) 的观测次数，最后一个是超出所有上界的观测
+	sum    atomic.Uint64   // 观测值之和，以 float64 的位模式保存
+	_      [cacheLineSize - 32]byte
+}
+
+// NewHistogram 以给定的桶上界创建直方图，上界会被复制并排序
+func NewHistogram(bounds []float64) *Histogram {
+	sorted := append([]float64(nil), bounds...)
+	sort.Float64s(sorted)
+	h := &Histogram{bounds: sorted}
+	for i := range h.shards {
+		h.shards[i].counts = make([]atomic.Uint64, len(sorted)+1)
+	}
+	return h
+}
+
+// Observe 记录一次观测值
+func (h *Histogram) Observe(v float64) {
+	shard := &h.shards[shardIndex()]
+	// 桶的上界是包含的 (le)，因此找第一个 >= v 的上界
+	shard.counts[sort.SearchFloat64s(h.bounds, v)].Add(1)
+	for {
+		old := shard.sum.Load()
+		if shard.sum.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// ObserveDuration 以秒为单位记录一次耗时
+func (h *Histogram) ObserveDuration(d time.Duration) {
+	h.Observe(d.Seconds())
+}
+
+// Snapshot 获取直方图快照。并发记录时各桶之间不保证严格一致，这与 Prometheus 客户端库的行为相同。
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	counts := make([]uint64, len(h.bounds)+1)
+	var sum float64
+	for i := range h.shards {
+		shard := &h.shards[i]
+		for j := range shard.counts {
+			counts[j] += shard.counts[j].Load()
+		}
+		sum += math.Float64frombits(shard.sum.Load())
+	}
+
+	s := HistogramSnapshot{
+		Bounds:  h.bounds,
+		Buckets: make([]uint64, len(h.bounds)),
+		Sum:     sum,
+	}
+	var cumulative uint64
+	for i := range h.bounds {
+		cumulative += counts[i]
+		s.Buckets[i] = cumulative
+	}
+	s.Count = cumulative + counts[len(h.bounds)]
+	return s
+}
+
+// HistogramSnapshot 直方图快照
+type HistogramSnapshot struct {
+	Bounds  []float64 `json:"bounds"`  // 桶上界
+	Buckets []uint64  `json:"buckets"` // 每个上界对应的累计观测次数
+	Count   uint64    `json:"count"`
+	Sum     float64   `json:"sum"`
+}
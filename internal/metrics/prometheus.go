@@ -0,0 +1,154 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+// Package metrics 以 Prometheus 文本格式 (0.0.4) 输出网关的运行指标。
+// 各模块继续使用自己的 Global*Metrics 计数，抓取时由 Collector 读取快照并写出，
+// 因此这里只提供输出格式和直方图，不依赖 Prometheus 客户端库。
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Path 是 Prometheus 指标接口的路径，仅允许本机或 admin 监听器访问。
+// admin 监听器上同时提供 Prometheus 默认的 /metrics 路径。
+const Path = "/goga/admin/metrics"
+
+// contentType 是 Prometheus 文本格式的 Content-Type
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Collector 在每次抓取时把指标写入 Writer
+type Collector func(w *Writer)
+
+// Label 是一个指标标签
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Labels 由交替出现的名称和取值构造标签列表，例如 Labels("route", "api", "code", "200")。
+func Labels(nameValues ...string) []Label {
+	labels := make([]Label, 0, len(nameValues)/2)
+	for i := 0; i+1 < len(nameValues); i += 2 {
+		labels = append(labels, Label{Name: nameValues[i], Value: nameValues[i+1]})
+	}
+	return labels
+}
+
+// Sample 是计数器或仪表盘指标的一个样本
+type Sample struct {
+	Labels []Label
+	Value  float64
+}
+
+// HistogramSample 是直方图指标的一个样本
+type HistogramSample struct {
+	Labels   []Label
+	Snapshot HistogramSnapshot
+}
+
+// Writer 以 Prometheus 文本格式输出指标。同一指标族的所有样本必须在一次调用中写出。
+type Writer struct {
+	buf bytes.Buffer
+}
+
+// Counter 写出一个计数器指标族。没有样本时不输出任何内容。
+func (w *Writer) Counter(name, help string, samples ...Sample) {
+	w.family(name, help, "counter", samples)
+}
+
+// Gauge 写出一个仪表盘指标族。没有样本时不输出任何内容。
+func (w *Writer) Gauge(name, help string, samples ...Sample) {
+	w.family(name, help, "gauge", samples)
+}
+
+// Histogram 写出一个直方图指标族，包括每个桶的累计计数、+Inf 桶、_sum 和 _count。
+func (w *Writer) Histogram(name, help string, samples ...HistogramSample) {
+	if len(samples) == 0 {
+		return
+	}
+	w.header(name, help, "histogram")
+	for _, s := range samples {
+		for i, bound := range s.Snapshot.Bounds {
+			w.sample(name+"_bucket", append(s.Labels, Label{"le", formatFloat(bound)}), float64(s.Snapshot.Buckets[i]))
+		}
+		w.sample(name+"_bucket", append(s.Labels, Label{"le", "+Inf"}), float64(s.Snapshot.Count))
+		w.sample(name+"_sum", s.Labels, s.Snapshot.Sum)
+		w.sample(name+"_count", s.Labels, float64(s.Snapshot.Count))
+	}
+}
+
+// family 写出一个计数器或仪表盘指标族
+func (w *Writer) family(name, help, typ string, samples []Sample) {
+	if len(samples) == 0 {
+		return
+	}
+	w.header(name, help, typ)
+	for _, s := range samples {
+		w.sample(name, s.Labels, s.Value)
+	}
+}
+
+// header 写出指标族的 HELP 和 TYPE 行
+func (w *Writer) header(name, help, typ string) {
+	w.buf.WriteString("# HELP " + name + " " + helpEscaper.Replace(help) + "\n")
+	w.buf.WriteString("# TYPE " + name + " " + typ + "\n")
+}
+
+// sample 写出一行样本
+func (w *Writer) sample(name string, labels []Label, value float64) {
+	w.buf.WriteString(name)
+	if len(labels) > 0 {
+		w.buf.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.buf.WriteByte(',')
+			}
+			w.buf.WriteString(l.Name + `="` + labelEscaper.Replace(l.Value) + `"`)
+		}
+		w.buf.WriteByte('}')
+	}
+	w.buf.WriteByte(' ')
+	w.buf.WriteString(formatFloat(value))
+	w.buf.WriteByte('\n')
+}
+
+var (
+	// helpEscaper 按文本格式的要求转义 HELP 中的反斜杠和换行
+	helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	// labelEscaper 转义标签取值中的反斜杠、双引号和换行
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+// formatFloat 按 Prometheus 文本格式输出浮点数
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Handler 返回按顺序调用所有 Collector 并以 Prometheus 文本格式输出的处理器。访问控制由调用方负责。
+func Handler(collectors ...Collector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var mw Writer
+		for _, collect := range collectors {
+			collect(&mw)
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Length", strconv.Itoa(mw.buf.Len()))
+		w.Write(mw.buf.Bytes())
+	})
+}
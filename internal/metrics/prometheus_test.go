@@ -0,0 +1,70 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram([]float64{1, 0.1, 0.5})
+	for _, v := range []float64{0.05, 0.1, 0.3, 0.7, 3} {
+		h.Observe(v)
+	}
+	h.ObserveDuration(200 * time.Millisecond)
+
+	s := h.Snapshot()
+	assert.Equal(t, []float64{0.1, 0.5, 1}, s.Bounds, "桶上界应按升序排列")
+	assert.Equal(t, []uint64{2, 4, 5}, s.Buckets, "桶计数应累计，且上界是包含的")
+	assert.Equal(t, uint64(6), s.Count)
+	assert.InDelta(t, 4.35, s.Sum, 1e-9)
+}
+
+func TestHandler(t *testing.T) {
+	h := NewHistogram([]float64{0.1, 1})
+	h.Observe(0.5)
+
+	handler := Handler(
+		func(w *Writer) {
+			w.Counter("test_requests_total", "请求数\n按路由区分",
+				Sample{Labels: Labels("route", `a"b\c`, "code", "200"), Value: 3},
+			)
+			w.Gauge("test_empty", "没有样本的指标族不输出")
+		},
+		func(w *Writer) {
+			w.Gauge("test_up", "是否可用", Sample{Value: 1})
+			w.Histogram("test_duration_seconds", "耗时", HistogramSample{Labels: Labels("route", "api"), Snapshot: h.Snapshot()})
+		},
+	)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, Path, nil))
+	body, err := io.ReadAll(rr.Result().Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.Equal(t, `# HELP test_requests_total 请求数\n按路由区分
+# TYPE test_requests_total counter
+test_requests_total{route="a\"b\\c",code="200"} 3
+# HELP test_up 是否可用
+# TYPE test_up gauge
+test_up 1
+# HELP test_duration_seconds 耗时
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{route="api",le="0.1"} 0
+test_duration_seconds_bucket{route="api",le="1"} 1
+test_duration_seconds_bucket{route="api",le="+Inf"} 1
+test_duration_seconds_sum{route="api"} 0.5
+test_duration_seconds_count{route="api"} 1
+`, string(body))
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package metrics
+
+import (
+	rtmetrics "runtime/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RuntimeSampleInterval 是运行时指标的默认采样间隔
+const RuntimeSampleInterval = 10 * time.Second
+
+// 采样的运行时指标。runtime/metrics 读取这些指标时不会暂停所有 goroutine，
+// 与 runtime.ReadMemStats 不同，可以安全地定期调用
+const (
+	heapObjectsMetric = "/memory/classes/heap/objects:bytes"
+	heapAllocsMetric  = "/gc/heap/allocs:bytes"
+	goroutinesMetric  = "/sched/goroutines:goroutines"
+	gcCyclesMetric    = "/gc/cycles/total:gc-cycles"
+)
+
+// RuntimeStats 是一次运行时指标采样的结果
+type RuntimeStats struct {
+	HeapObjectsBytes uint64 // 堆上存活和尚未回收的对象占用的字节数
+	HeapAllocsBytes  uint64 // 累计在堆上分配的字节数
+	Goroutines       uint64
+	GCCycles         uint64
+	SampleTime       time.Time
+}
+
+// RuntimeSampler 在后台定期采样运行时指标，请求处理路径只读取最近一次采样的结果
+type RuntimeSampler struct {
+	latest atomic.Pointer[RuntimeStats]
+	stop   chan struct{}
+	once   sync.Once
+}
+
+// StartRuntimeSampler 立即采样一次，然后按 interval 定期采样，使用完毕后需调用 Stop
+func StartRuntimeSampler(interval time.Duration) *RuntimeSampler {
+	s := &RuntimeSampler{stop: make(chan struct{})}
+	s.sample()
+	go s.loop(interval)
+	return s
+}
+
+// loop 定期采样直到 Stop 被调用
+func (s *RuntimeSampler) loop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.sample()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Stop 停止后台采样，可以重复调用
+func (s *RuntimeSampler) Stop() {
+	s.once.Do(func() { close(s.stop) })
+}
+
+// sample 读取一次运行时指标
+func (s *RuntimeSampler) sample() {
+	stats := ReadRuntimeStats()
+	s.latest.Store(&stats)
+}
+
+// Stats 返回最近一次采样的结果
+func (s *RuntimeSampler) Stats() RuntimeStats {
+	return *s.latest.Load()
+}
+
+// Collect 以 Prometheus 文本格式写出最近一次采样的运行时指标
+func (s *RuntimeSampler) Collect(w *Writer) {
+	stats := s.Stats()
+	w.Gauge("goga_go_heap_objects_bytes", "堆上对象占用的字节数", Sample{Value: float64(stats.HeapObjectsBytes)})
+	w.Counter("goga_go_heap_allocs_bytes_total", "累计在堆上分配的字节数", Sample{Value: float64(stats.HeapAllocsBytes)})
+	w.Gauge("goga_go_goroutines", "goroutine 数量", Sample{Value: float64(stats.Goroutines)})
+	w.Counter("goga_go_gc_cycles_total", "已完成的 GC 次数", Sample{Value: float64(stats.GCCycles)})
+}
+
+// ReadRuntimeStats 立即读取一次运行时指标
+func ReadRuntimeStats() RuntimeStats {
+	samples := []rtmetrics.Sample{
+		{Name: heapObjectsMetric},
+		{Name: heapAllocsMetric},
+		{Name: goroutinesMetric},
+		{Name: gcCyclesMetric},
+	}
+	rtmetrics.Read(samples)
+
+	stats := RuntimeStats{SampleTime: time.Now()}
+	for _, sample := range samples {
+		if sample.Value.Kind() != rtmetrics.KindUint64 {
+			continue // 当前 Go 版本不支持该指标
+		}
+		switch sample.Name {
+		case heapObjectsMetric:
+			stats.HeapObjectsBytes = sample.Value.Uint64()
+		case heapAllocsMetric:
+			stats.HeapAllocsBytes = sample.Value.Uint64()
+		case goroutinesMetric:
+			stats.Goroutines = sample.Value.Uint64()
+		case gcCyclesMetric:
+			stats.GCCycles = sample.Value.Uint64()
+		}
+	}
+	return stats
+}
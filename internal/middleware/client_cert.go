@@ -0,0 +1,106 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"goga/configs"
+	"net/http"
+	"strings"
+)
+
+// fingerprintPrefix 是 allowed_names 中按证书指纹匹配的前缀
+const fingerprintPrefix = "sha256:"
+
+// ClientCertIdentity 是客户端在 TLS 握手中出示并通过校验的证书身份。
+type ClientCertIdentity struct {
+	Subject     string // RFC 2253 形式的 Subject DN
+	CommonName  string
+	SANs        []string // 带类型前缀的 SAN，例如 "DNS:partner.example.com"、"email:ops@example.com"
+	Fingerprint string   // 证书 DER 编码的 SHA-256 指纹，小写十六进制
+}
+
+// ClientCert 返回请求所在连接上经过校验的客户端证书身份，未出示证书或未校验时返回 false。
+func ClientCert(r *http.Request) (*ClientCertIdentity, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+	leaf := r.TLS.VerifiedChains[0][0]
+	sum := sha256.Sum256(leaf.Raw)
+	id := &ClientCertIdentity{
+		Subject:     leaf.Subject.String(),
+		CommonName:  leaf.Subject.CommonName,
+		Fingerprint: hex.EncodeToString(sum[:]),
+	}
+	for _, name := range leaf.DNSNames {
+		id.SANs = append(id.SANs, "DNS:"+name)
+	}
+	for _, email := range leaf.EmailAddresses {
+		id.SANs = append(id.SANs, "email:"+email)
+	}
+	for _, uri := range leaf.URIs {
+		id.SANs = append(id.SANs, "URI:"+uri.String())
+	}
+	for _, ip := range leaf.IPAddresses {
+		id.SANs = append(id.SANs, "IP:"+ip.String())
+	}
+	return id, true
+}
+
+// Matches 判断证书身份是否匹配 allowed 中的任一项：CommonName、任一 SAN 的取值 (不含类型前缀)，
+// 或 "sha256:<指纹>" (指纹可以带冒号分隔)。比较不区分大小写。
+func (id *ClientCertIdentity) Matches(allowed []string) bool {
+	for _, name := range allowed {
+		if fp, ok := strings.CutPrefix(strings.ToLower(name), fingerprintPrefix); ok {
+			if strings.ReplaceAll(fp, ":", "") == id.Fingerprint {
+				return true
+			}
+			continue
+		}
+		if id.CommonName != "" && strings.EqualFold(id.CommonName, name) {
+			return true
+		}
+		for _, san := range id.SANs {
+			_, value, _ := strings.Cut(san, ":")
+			if strings.EqualFold(value, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// EnforceClientCert 检查请求是否满足客户端证书要求，不满足时写入 403 响应并返回 false。
+// 未要求客户端证书 (required 为 false 且未配置 allowed_names) 时始终返回 true。
+func EnforceClientCert(w http.ResponseWriter, r *http.Request, cfg configs.ClientCertConfig) bool {
+	if !cfg.Required && len(cfg.AllowedNames) == 0 {
+		return true
+	}
+	id, ok := ClientCert(r)
+	if !ok {
+		LogWarn(r, "请求未出示经过验证的客户端证书", "event_type", "security", "path", r.URL.Path)
+		WriteJSONError(w, r, http.StatusForbidden, "CLIENT_CERT_REQUIRED", "需要有效的客户端证书")
+		return false
+	}
+	if len(cfg.AllowedNames) > 0 && !id.Matches(cfg.AllowedNames) {
+		LogWarn(r, "客户端证书不在允许列表中", "event_type", "security", "subject", id.Subject, "fingerprint", id.Fingerprint, "path", r.URL.Path)
+		WriteJSONError(w, r, http.StatusForbidden, "CLIENT_CERT_NOT_ALLOWED", "客户端证书无权访问")
+		return false
+	}
+	return true
+}
+
+// RequireClientCert 返回一个按 cfg 要求客户端证书的中间件。
+func RequireClientCert(cfg configs.ClientCertConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if EnforceClientCert(w, r, cfg) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"goga/configs"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newPartnerCert 生成一个带有多种 SAN 的客户端证书。
+func newPartnerCert(t *testing.T) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	spiffe, _ := url.Parse("spiffe://example.com/partner")
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(7),
+		Subject:        pkix.Name{CommonName: "partner-a", Organization: []string{"Partner A"}},
+		DNSNames:       []string{"api.partner-a.com"},
+		EmailAddresses: []string{"ops@partner-a.com"},
+		URIs:           []*url.URL{spiffe},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert
+}
+
+func TestClientCert(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	_, ok := ClientCert(req)
+	assert.False(t, ok, "未出示证书")
+
+	cert := newPartnerCert(t)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	_, ok = ClientCert(req)
+	assert.False(t, ok, "未经校验的证书不应视为客户端身份")
+
+	req.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+	id, ok := ClientCert(req)
+	require.True(t, ok)
+	assert.Equal(t, "CN=partner-a,O=Partner A", id.Subject)
+	assert.Equal(t, []string{"DNS:api.partner-a.com", "email:ops@partner-a.com", "URI:spiffe://example.com/partner"}, id.SANs)
+	assert.Len(t, id.Fingerprint, 64)
+
+	assert.True(t, id.Matches([]string{"other", "Partner-A"}), "按 CommonName 匹配")
+	assert.True(t, id.Matches([]string{"API.partner-a.com"}), "按 SAN 匹配")
+	assert.True(t, id.Matches([]string{"spiffe://example.com/partner"}))
+	assert.True(t, id.Matches([]string{"SHA256:" + strings.ToUpper(id.Fingerprint)}), "按指纹匹配")
+	assert.False(t, id.Matches([]string{"partner-b", "sha256:00"}))
+}
+
+func TestEnforceClientCert(t *testing.T) {
+	cert := newPartnerCert(t)
+	withCert := func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		return req
+	}
+
+	testCases := []struct {
+		name   string
+		cfg    configs.ClientCertConfig
+		req    *http.Request
+		status int
+		code   string
+	}{
+		{"未要求证书", configs.ClientCertConfig{}, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusOK, ""},
+		{"要求证书但未出示", configs.ClientCertConfig{Required: true}, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusForbidden, "CLIENT_CERT_REQUIRED"},
+		{"要求证书并已出示", configs.ClientCertConfig{Required: true}, withCert(), http.StatusOK, ""},
+		{"allowed_names 隐含 required", configs.ClientCertConfig{AllowedNames: []string{"partner-a"}}, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusForbidden, "CLIENT_CERT_REQUIRED"},
+		{"证书在允许列表中", configs.ClientCertConfig{AllowedNames: []string{"partner-a"}}, withCert(), http.StatusOK, ""},
+		{"证书不在允许列表中", configs.ClientCertConfig{AllowedNames: []string{"partner-b"}}, withCert(), http.StatusForbidden, "CLIENT_CERT_NOT_ALLOWED"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			RequireClientCert(tc.cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})).ServeHTTP(rr, tc.req)
+			assert.Equal(t, tc.status, rr.Code)
+			if tc.code != "" {
+				assert.Contains(t, rr.Body.String(), tc.code)
+			}
+		})
+	}
+}
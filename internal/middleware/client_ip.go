@@ -0,0 +1,175 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// TrustedProxies 是受信任的反向代理地址集合，只有直接对端在集合中时才采信请求中的转发头。
+type TrustedProxies struct {
+	prefixes []netip.Prefix
+	unix     bool // 是否信任经 Unix 套接字连接的对端
+}
+
+// NewTrustedProxies 解析 trusted_proxies 配置，每一项可以是 CIDR、单个 IP 地址或表示 Unix 套接字对端的 "unix"。
+func NewTrustedProxies(cidrs []string) (*TrustedProxies, error) {
+	tp := &TrustedProxies{}
+	for _, s := range cidrs {
+		s = strings.TrimSpace(s)
+		if s == "unix" {
+			tp.unix = true
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("无效的 trusted_proxies 地址 %q: %w", s, err)
+			}
+			tp.prefixes = append(tp.prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("无效的 trusted_proxies CIDR %q: %w", s, err)
+		}
+		if prefix.Addr().Is4In6() {
+			// ::ffff:10.0.0.0/104 这类写法按对应的 IPv4 网段处理
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), max(prefix.Bits()-96, 0))
+		}
+		tp.prefixes = append(tp.prefixes, prefix.Masked())
+	}
+	return tp, nil
+}
+
+// Contains 判断地址是否属于受信任的代理。tp 为 nil 时不信任任何地址。
+func (tp *TrustedProxies) Contains(addr netip.Addr) bool {
+	if tp == nil || !addr.IsValid() {
+		return false
+	}
+	addr = addr.Unmap().WithZone("")
+	for _, prefix := range tp.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIPKey 是解析出的客户端地址在请求上下文中的键
+type clientIPKey struct{}
+
+// clientIPInfo 保存 ClientIP 中间件为请求解析出的地址信息
+type clientIPInfo struct {
+	ip          string // 真实客户端 IP
+	trustedPeer bool   // 直接对端是否为受信任的代理
+}
+
+// ClientIP 返回一个解析真实客户端 IP 的中间件，结果供 GetClientIP 和 FromTrustedProxy 使用。
+// 直接对端不是受信任的代理时，X-Forwarded-For 可以被客户端任意伪造，此时忽略该头部并使用对端地址；
+// 否则从右向左逐跳查看 X-Forwarded-For，跳过受信任的代理，第一个不受信任的地址即为客户端。
+func ClientIP(trusted *TrustedProxies) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip, trustedPeer := resolveClientIP(r, trusted)
+			ctx := context.WithValue(r.Context(), clientIPKey{}, clientIPInfo{ip: ip, trustedPeer: trustedPeer})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// resolveClientIP 按受信任的代理列表解析真实客户端 IP，并返回直接对端是否受信任。
+func resolveClientIP(r *http.Request, trusted *TrustedProxies) (string, bool) {
+	peer := RemoteIP(r)
+	if IsUnixSocket(r) {
+		// Unix 套接字的对端没有 IP 地址，只能整体信任或不信任
+		if trusted == nil || !trusted.unix {
+			return peer, false
+		}
+	} else if peerAddr, err := netip.ParseAddr(peer); err != nil || !trusted.Contains(peerAddr) {
+		return peer, false
+	}
+
+	client := peer
+	hops := forwardedForHops(r.Header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseForwardedAddr(hops[i])
+		if !ok {
+			// 无法解析的条目之前的内容都不可信，以最后一个受信任的跳为准
+			break
+		}
+		client = addr.String()
+		if !trusted.Contains(addr) {
+			break
+		}
+	}
+	return client, true
+}
+
+// forwardedForHops 按顺序返回所有 X-Forwarded-For 头部中的地址条目。
+func forwardedForHops(h http.Header) []string {
+	var hops []string
+	for _, value := range h.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	return hops
+}
+
+// parseForwardedAddr 解析 X-Forwarded-For 中的一个条目，兼容部分代理附带端口或方括号的写法。
+func parseForwardedAddr(hop string) (netip.Addr, bool) {
+	if addr, err := netip.ParseAddr(hop); err == nil {
+		return addr.Unmap(), true
+	}
+	if addrPort, err := netip.ParseAddrPort(hop); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	if addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]")); err == nil {
+		return addr.Unmap(), true
+	}
+	return netip.Addr{}, false
+}
+
+// RemoteIP 返回直接对端的 IP 地址，RemoteAddr 不含端口时原样返回。
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return addr.Unmap().String()
+	}
+	return host
+}
+
+// IsUnixSocket 判断请求是否经由 Unix 套接字监听器到达。
+func IsUnixSocket(r *http.Request) bool {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == "unix"
+}
+
+// GetClientIP 获取客户端 IP 地址。
+// 经过 ClientIP 中间件的请求返回按受信任代理解析出的地址，否则返回直接对端的地址。
+func GetClientIP(r *http.Request) string {
+	if info, ok := r.Context().Value(clientIPKey{}).(clientIPInfo); ok {
+		return info.ip
+	}
+	return RemoteIP(r)
+}
+
+// FromTrustedProxy 判断请求的直接对端是否为受信任的代理，即请求中的转发头是否可以采信。
+func FromTrustedProxy(r *http.Request) bool {
+	info, ok := r.Context().Value(clientIPKey{}).(clientIPInfo)
+	return ok && info.trustedPeer
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := NewTrustedProxies([]string{"10.0.0.0/8", "192.0.2.10", "fd00::/8"})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name        string
+		remoteAddr  string
+		xff         []string
+		expectedIP  string
+		trustedPeer bool
+	}{
+		{"没有转发头", "203.0.113.1:1234", nil, "203.0.113.1", false},
+		{"不受信任的对端伪造 X-Forwarded-For", "203.0.113.1:1234", []string{"1.2.3.4"}, "203.0.113.1", false},
+		{"受信任的代理转发", "10.0.0.1:1234", []string{"198.51.100.7"}, "198.51.100.7", true},
+		{"从右向左跳过受信任的代理", "10.0.0.1:1234", []string{"198.51.100.7, 192.0.2.10, 10.1.2.3"}, "198.51.100.7", true},
+		{"客户端在最左侧伪造的地址被忽略", "10.0.0.1:1234", []string{"1.2.3.4, 198.51.100.7"}, "198.51.100.7", true},
+		{"多个 X-Forwarded-For 头按顺序拼接", "10.0.0.1:1234", []string{"198.51.100.7", "192.0.2.10"}, "198.51.100.7", true},
+		{"无法解析的条目之前的内容不可信", "10.0.0.1:1234", []string{"198.51.100.7, garbage, 10.1.2.3"}, "10.1.2.3", true},
+		{"所有跳都受信任时取最左侧", "10.0.0.1:1234", []string{"10.9.9.9"}, "10.9.9.9", true},
+		{"带端口的条目", "10.0.0.1:1234", []string{"198.51.100.7:5555"}, "198.51.100.7", true},
+		{"IPv6 受信任的代理", "[fd00::1]:1234", []string{"2001:db8::7"}, "2001:db8::7", true},
+		{"IPv4 映射的 IPv6 对端", "[::ffff:10.0.0.1]:1234", []string{"198.51.100.7"}, "198.51.100.7", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tc.remoteAddr
+			for _, v := range tc.xff {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+
+			var ip string
+			var trustedPeer bool
+			ClientIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ip, trustedPeer = GetClientIP(r), FromTrustedProxy(r)
+			})).ServeHTTP(httptest.NewRecorder(), req)
+
+			assert.Equal(t, tc.expectedIP, ip)
+			assert.Equal(t, tc.trustedPeer, trustedPeer)
+		})
+	}
+}
+
+func TestGetClientIP_WithoutMiddleware(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "203.0.113.1:1234"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4")
+	assert.Equal(t, "203.0.113.1", GetClientIP(req), "未配置受信任代理时不应采信 X-Forwarded-For")
+}
+
+func TestNewTrustedProxies_Invalid(t *testing.T) {
+	for _, cidr := range []string{"10.0.0.0/33", "not-an-ip", ""} {
+		_, err := NewTrustedProxies([]string{cidr})
+		assert.Error(t, err, cidr)
+	}
+}
+
+func TestLocalOnly_BehindTrustedProxy(t *testing.T) {
+	trusted, err := NewTrustedProxies([]string{"127.0.0.1"})
+	require.NoError(t, err)
+	handler := ClientIP(trusted)(LocalOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.1")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code, "经本机代理转发的外部请求不应视为本地请求")
+}
+
+func TestClientIP_UnixSocket(t *testing.T) {
+	// 经 Unix 套接字监听器到达的请求，对端地址为空
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+		req.RemoteAddr = ""
+		ctx := context.WithValue(req.Context(), http.LocalAddrContextKey, &net.UnixAddr{Name: "/run/goga.sock", Net: "unix"})
+		return req.WithContext(ctx)
+	}
+	localOnly := LocalOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(GetClientIP(r)))
+	}))
+
+	t.Run("未信任 Unix 套接字对端", func(t *testing.T) {
+		req := newRequest()
+		req.Header.Set("X-Forwarded-For", "203.0.113.1")
+		rr := httptest.NewRecorder()
+		ClientIP(nil)(localOnly).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code, "Unix 套接字的对端必然在本机")
+		assert.Empty(t, rr.Body.String(), "不应采信 X-Forwarded-For")
+	})
+
+	trusted, err := NewTrustedProxies([]string{"unix"})
+	require.NoError(t, err)
+
+	t.Run("信任 Unix 套接字对端", func(t *testing.T) {
+		req := newRequest()
+		req.Header.Set("X-Forwarded-For", "203.0.113.1")
+		rr := httptest.NewRecorder()
+		ClientIP(trusted)(localOnly).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusForbidden, rr.Code, "经同机代理转发的外部请求不应视为本地请求")
+	})
+
+	t.Run("TCP 对端不受 unix 配置影响", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "198.51.100.1:1234"
+		req.Header.Set("X-Forwarded-For", "203.0.113.1")
+		ip, trustedPeer := resolveClientIP(req, trusted)
+		assert.Equal(t, "198.51.100.1", ip)
+		assert.False(t, trustedPeer)
+	})
+}
+
+func TestLocalOnly_AdminListener(t *testing.T) {
+	handler := AdminListener(LocalOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	req.RemoteAddr = "10.0.0.5:1234"
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code, "运维监听器的访问控制由监听地址负责")
+}
@@ -8,8 +8,10 @@ package middleware
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"goga/configs"
 	"goga/internal/security"
+	"goga/internal/tracing"
 	"io"
 	"log/slog"
 	"net/http"
@@ -24,6 +26,16 @@ type EncryptedPayload struct {
 	Encrypted string `json:"encrypted"`
 }
 
+// ValidateMustEncryptRoutes 检查强制加密路由的正则表达式是否都能编译
+func ValidateMustEncryptRoutes(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("无效的强制加密路由正则表达式 %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
 // DecryptionMiddleware 创建一个用于解密传入请求体的中间件。
 // 使用流式处理架构，大幅减少内存分配和 GC 压力。
 func DecryptionMiddleware(keyCache security.KeyCacher, cfg configs.EncryptionConfig) func(http.Handler) http.Handler {
@@ -39,6 +51,15 @@ func DecryptionMiddleware(keyCache security.KeyCacher, cfg configs.EncryptionCon
 		mustEncryptRegexes = append(mustEncryptRegexes, re)
 	}
 
+	// 需要解密处理的 HTTP 方法，未配置时默认仅处理 POST
+	encryptedMethods := make(map[string]struct{})
+	for _, method := range cfg.Methods {
+		encryptedMethods[strings.ToUpper(method)] = struct{}{}
+	}
+	if len(encryptedMethods) == 0 {
+		encryptedMethods[http.MethodPost] = struct{}{}
+	}
+
 	// isPathMandatoryEncryption 检查给定路径是否需要强制加密
 	isPathMandatoryEncryption := func(path string) bool {
 		for _, re := range mustEncryptRegexes {
@@ -71,8 +92,8 @@ func DecryptionMiddleware(keyCache security.KeyCacher, cfg configs.EncryptionCon
 			contentType := r.Header.Get("Content-Type")
 			isJSON := strings.Contains(contentType, "application/json")
 
-			// 解密逻辑仅对 POST 请求且 Content-Type 为 application/json 的请求应用
-			if r.Method != http.MethodPost || !isJSON {
+			// 解密逻辑仅对配置的方法 (默认 POST) 且 Content-Type 为 application/json 的请求应用
+			if _, ok := encryptedMethods[r.Method]; !ok || !isJSON {
 				handlePlainTextRequest()
 				return
 			}
@@ -91,13 +112,10 @@ func DecryptionMiddleware(keyCache security.KeyCacher, cfg configs.EncryptionCon
 			// 这确保了后续处理（无论是解密还是直接转发）都能从头读取请求体。
 			r.Body = peekReader
 
-			// 记录请求指标
-			GlobalDecryptMetrics.RecordRequest(false) // 先记录为普通请求
+			// 记录请求指标，每个请求只记录一次
+			GlobalDecryptMetrics.RecordRequest(isEncrypted)
 
-			// 如果是加密请求，更新指标
-			if isEncrypted {
-				GlobalDecryptMetrics.RecordRequest(true) // 更新为加密请求
-			} else {
+			if !isEncrypted {
 				// 对于明文请求，完全缓冲请求体以避免竞争条件
 				slog.Debug("请求为明文格式，正在缓冲请求体以确保安全转发", "uri", r.RequestURI)
 
@@ -113,6 +131,10 @@ func DecryptionMiddleware(keyCache security.KeyCacher, cfg configs.EncryptionCon
 
 				// 从缓冲的字节创建新的请求体。
 				r.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+				// 请求体已完整缓冲，提供 GetBody 以便转发失败时可以安全重放
+				r.GetBody = func() (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(bodyBytes)), nil
+				}
 				r.ContentLength = int64(len(bodyBytes))
 				r.Header.Set("Content-Length", strconv.Itoa(len(bodyBytes)))
 
@@ -123,6 +145,8 @@ func DecryptionMiddleware(keyCache security.KeyCacher, cfg configs.EncryptionCon
 			}
 
 			// --- 从这里开始，是处理确定为加密载荷的逻辑 ---
+			// 解密 span 覆盖解析载荷、查找密钥和解密首个数据块，在转发给下一个处理器之前结束
+			decryptCtx, span := tracing.Start(r.Context(), "goga.decrypt")
 			// 使用流式解密器进行解密
 
 			// 首先需要读取 JSON 头部获取 token
@@ -137,6 +161,7 @@ func DecryptionMiddleware(keyCache security.KeyCacher, cfg configs.EncryptionCon
 			peekData, err := peekReader.Peek(8192)
 			if err != nil && err != io.EOF {
 				peekReader.Close()
+				failDecryptSpan(span, "read")
 				LogError(r, "读取加密请求头部失败", "error", err)
 				WriteJSONError(w, r, http.StatusInternalServerError, "HEADER_READ_FAILED", "无法读取请求头部")
 				return
@@ -146,6 +171,8 @@ func DecryptionMiddleware(keyCache security.KeyCacher, cfg configs.EncryptionCon
 			jsonEnd := findJSONEnd(peekData)
 			if jsonEnd == -1 {
 				peekReader.Close()
+				GlobalDecryptMetrics.RecordDecryptFailure("format")
+				failDecryptSpan(span, "format")
 				LogWarn(r, "无法在加密请求中找到完整的 JSON 对象")
 				WriteJSONError(w, r, http.StatusBadRequest, "MALFORMED_PAYLOAD", "加密载荷格式错误")
 				return
@@ -155,6 +182,8 @@ func DecryptionMiddleware(keyCache security.KeyCacher, cfg configs.EncryptionCon
 			var payload EncryptedPayload
 			if err := json.Unmarshal(peekData[:jsonEnd+1], &payload); err != nil {
 				peekReader.Close()
+				GlobalDecryptMetrics.RecordDecryptFailure("format")
+				failDecryptSpan(span, "format")
 				LogWarn(r, "无法解析加密请求的 JSON 结构", "error", err)
 				WriteJSONError(w, r, http.StatusBadRequest, "MALFORMED_PAYLOAD", "加密载荷格式错误")
 				return
@@ -162,6 +191,8 @@ func DecryptionMiddleware(keyCache security.KeyCacher, cfg configs.EncryptionCon
 
 			if payload.Token == "" || payload.Encrypted == "" {
 				peekReader.Close()
+				GlobalDecryptMetrics.RecordDecryptFailure("format")
+				failDecryptSpan(span, "format")
 				LogWarn(r, "加密请求的 JSON 缺少 'token' 或 'encrypted' 字段")
 				WriteJSONError(w, r, http.StatusBadRequest, "INCOMPLETE_PAYLOAD", "加密载荷不完整")
 				return
@@ -169,10 +200,11 @@ func DecryptionMiddleware(keyCache security.KeyCacher, cfg configs.EncryptionCon
 
 			// 从缓存中获取密钥
 			slog.Debug("尝试从缓存获取解密密钥", "token", payload.Token)
-			key, found := keyCache.Get(payload.Token)
+			key, found := keyCache.Get(decryptCtx, payload.Token)
 			if !found {
 				peekReader.Close()
 				GlobalDecryptMetrics.RecordDecryptFailure("token")
+				failDecryptSpan(span, "token")
 				LogError(r, "安全事件：解密失败",
 					"event_type", "security",
 					"reason", "invalid_or_expired_token",
@@ -185,9 +217,6 @@ func DecryptionMiddleware(keyCache security.KeyCacher, cfg configs.EncryptionCon
 			// 创建性能计时器
 			timer := NewMetricsTimer(GlobalDecryptMetrics)
 
-			// 获取当前内存使用情况
-			allocBefore, _ := GetMemoryUsage()
-
 			// 创建流式解密器
 			decryptReader := newDecryptReader(peekReader, key)
 			defer decryptReader.Close()
@@ -198,6 +227,7 @@ func DecryptionMiddleware(keyCache security.KeyCacher, cfg configs.EncryptionCon
 			_, err = decryptReader.Read(tempBuf)
 			if err != nil && err != io.EOF {
 				GlobalDecryptMetrics.RecordDecryptFailure("decrypt")
+				failDecryptSpan(span, "decrypt")
 				LogError(r, "流式解密失败", "error", err, "token", payload.Token)
 				WriteJSONError(w, r, http.StatusBadRequest, "DECRYPTION_FAILED", "解密失败，数据可能已损坏或密钥不匹配")
 				return
@@ -208,10 +238,9 @@ func DecryptionMiddleware(keyCache security.KeyCacher, cfg configs.EncryptionCon
 				originalContentType = "application/json" // 默认值
 			}
 
-			// 计算内存使用量并停止计时
-			allocAfter, _ := GetMemoryUsage()
-			memoryUsed := int64(allocAfter - allocBefore)
-			timer.Stop(memoryUsed)
+			// 停止计时
+			timer.Stop()
+			span.End()
 
 			// 创建一个新的 reader，包含已读取的第一个字节和剩余数据
 			combinedReader := io.MultiReader(
@@ -7,6 +7,7 @@ package middleware
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"goga/internal/crypto"
@@ -32,8 +33,9 @@ func newMockKeyCacher() (security.KeyCacher, []byte) {
 	return &mockKeyCacher{key: key}, key
 }
 
-func (m *mockKeyCacher) Set(token string, key []byte, ttl time.Duration) {}
-func (m *mockKeyCacher) Get(token string) ([]byte, bool) {
+func (m *mockKeyCacher) Set(ctx context.Context, token string, key []byte, ttl time.Duration, meta security.KeyMetadata) {
+}
+func (m *mockKeyCacher) Get(ctx context.Context, token string) ([]byte, bool) {
 	if token == "test_token" {
 		return m.key, true
 	}
@@ -218,3 +220,64 @@ func TestDecryptionMiddleware(t *testing.T) {
 		})
 	}
 }
+
+// TestDecryptionMiddleware_Methods 验证只有配置的方法才会进入解密流程。
+func TestDecryptionMiddleware_Methods(t *testing.T) {
+	mockCache, _ := newMockKeyCacher()
+	middleware := DecryptionMiddleware(mockCache, configs.EncryptionConfig{Methods: []string{"post", "PUT"}})
+
+	// 使用无效 token 的加密载荷：进入解密流程时会返回 401，否则原样透传
+	body, _ := json.Marshal(EncryptedPayload{Token: "invalid_token", Encrypted: "some_data"})
+
+	testCases := []struct {
+		method             string
+		expectedStatusCode int
+	}{
+		{method: http.MethodPost, expectedStatusCode: http.StatusUnauthorized},
+		{method: http.MethodPut, expectedStatusCode: http.StatusUnauthorized},
+		{method: http.MethodPatch, expectedStatusCode: http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.method, func(t *testing.T) {
+			handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(tc.method, "/api", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tc.expectedStatusCode {
+				t.Errorf("方法 %s 期望状态码 %d, 实际得到 %d", tc.method, tc.expectedStatusCode, rr.Code)
+			}
+		})
+	}
+}
+
+// TestDecryptionMiddleware_PlaintextBodyIsReplayable 验证完整缓冲的明文 JSON 请求体可以重放，供代理在转发失败时重试。
+func TestDecryptionMiddleware_PlaintextBodyIsReplayable(t *testing.T) {
+	mockCache, _ := newMockKeyCacher()
+	middleware := DecryptionMiddleware(mockCache, configs.EncryptionConfig{Methods: []string{"POST"}})
+
+	const body = `{"username":"admin"}`
+	handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.GetBody == nil {
+			t.Fatal("明文请求体缓冲后应提供 GetBody")
+		}
+		first, _ := io.ReadAll(r.Body)
+		replay, err := r.GetBody()
+		if err != nil {
+			t.Fatalf("GetBody 返回错误: %v", err)
+		}
+		second, _ := io.ReadAll(replay)
+		if string(first) != body || string(second) != body {
+			t.Errorf("请求体不一致: 第一次 %q, 重放 %q", first, second)
+		}
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+}
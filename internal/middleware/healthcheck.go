@@ -1,36 +1,23 @@
 package middleware
 
 import (
+	"context"
 	"log/slog"
-	"net"
 	"net/http"
 )
 
 // HealthCheck 是一个中间件，用于处理来自本地主机的健康检查请求
 func HealthCheck(next http.Handler) http.Handler {
+	healthz := LocalOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slog.Debug("健康检查成功", "remote_addr", r.RemoteAddr)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}))
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// 仅处理 /healthz 路径
 		if r.URL.Path == "/healthz" {
-			// 尝试解析来源地址
-			host, _, err := net.SplitHostPort(r.RemoteAddr)
-			if err != nil {
-				// 如果无法解析，为安全起见记录错误并拒绝访问
-				LogWarn(r, "健康检查地址解析失败", "remote_addr", r.RemoteAddr, "error", err)
-				WriteJSONError(w, r, http.StatusForbidden, "FORBIDDEN", "禁止访问")
-				return
-			}
-
-			// 检查是否为本地回环地址
-			if host == "127.0.0.1" || host == "::1" {
-				slog.Debug("健康检查成功", "remote_addr", r.RemoteAddr)
-				w.WriteHeader(http.StatusOK)
-				w.Write([]byte("OK"))
-				return
-			}
-
-			// 如果不是来自本地主机，则拒绝访问
-			LogWarn(r, "拒绝了来自非本地主机的健康检查请求", "remote_host", host)
-			WriteJSONError(w, r, http.StatusForbidden, "FORBIDDEN", "禁止访问")
+			healthz.ServeHTTP(w, r)
 			return
 		}
 
@@ -38,3 +25,43 @@ func HealthCheck(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// adminListenerKey 标记请求来自 admin 角色的监听器
+type adminListenerKey struct{}
+
+// AdminListener 标记请求来自专用的运维监听器。LocalOnly 不再限制这些请求的来源地址，
+// 访问控制由监听地址 (例如只绑定内网地址) 负责。
+func AdminListener(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminListenerKey{}, true)))
+	})
+}
+
+// IsAdminListener 判断请求是否来自 admin 角色的监听器
+func IsAdminListener(r *http.Request) bool {
+	admin, _ := r.Context().Value(adminListenerKey{}).(bool)
+	return admin
+}
+
+// LocalOnly 包装一个只允许本地回环地址访问的处理器，用于健康检查和运维接口。来自运维监听器的请求不受限制。
+func LocalOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if IsAdminListener(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// 经过受信任代理转发的请求以解析出的客户端地址为准，避免代理与网关同机部署时所有请求都被视为本地请求
+		host := GetClientIP(r)
+
+		// 检查是否为本地回环地址；经 Unix 套接字直接连接的对端必然在本机
+		if host == "127.0.0.1" || host == "::1" || (IsUnixSocket(r) && host == RemoteIP(r)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// 如果不是来自本地主机，则拒绝访问
+		LogWarn(r, "拒绝了来自非本地主机的请求", "remote_host", host, "path", r.URL.Path)
+		WriteJSONError(w, r, http.StatusForbidden, "FORBIDDEN", "禁止访问")
+	})
+}
@@ -3,9 +3,7 @@ package middleware
 import (
 	"io"
 	"log/slog"
-	"net"
 	"net/http"
-	"strings"
 	"time"
 )
 
@@ -47,25 +45,16 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	return n, err
 }
 
-// GetClientIP 获取客户端 IP 地址。
-// 它会优先检查 X-Forwarded-For 头部，如果不存在则回退到 RemoteAddr。
-func GetClientIP(r *http.Request) string {
-	// 检查 X-Forwarded-For 头部，通常由代理服务器设置
-	forwardedFor := r.Header.Get("X-Forwarded-For")
-	if forwardedFor != "" {
-		// X-Forwarded-For 可能包含多个 IP 地址，通常第一个是真实的客户端 IP
-		ips := strings.Split(forwardedFor, ",")
-		return strings.TrimSpace(ips[0])
+// Flush 将缓冲的数据发送给客户端，使流式响应 (如 SSE、流式 SSR) 在经过日志中间件后仍能及时刷新。
+func (rw *responseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
 	}
+}
 
-	// 如果没有 X-Forwarded-For，则使用 RemoteAddr
-	// RemoteAddr 的格式可能是 "ip:port"，我们需要分离出 IP
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		// 如果解析失败（例如没有端口），则假定 RemoteAddr 就是 IP
-		return r.RemoteAddr
-	}
-	return ip
+// Unwrap 返回底层的 ResponseWriter，供 http.ResponseController 访问 Hijack、SetWriteDeadline 等能力。
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
 }
 
 // Logging 是一个中间件，用于记录 HTTP 请求的信息
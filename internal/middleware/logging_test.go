@@ -0,0 +1,27 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogging_PreservesFlusher(t *testing.T) {
+	handler := Logging(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("chunk"))
+		// ReverseProxy 通过 ResponseController 刷新，需要穿透日志中间件的包装
+		assert.NoError(t, http.NewResponseController(w).Flush())
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.True(t, rr.Flushed)
+	assert.Equal(t, "chunk", rr.Body.String())
+}
@@ -6,41 +6,44 @@
 package middleware
 
 import (
+	"goga/internal/metrics"
 	"log/slog"
-	"runtime"
-	"sync"
+	"math"
 	"sync/atomic"
 	"time"
 )
 
-// DecryptMetrics 解密性能指标
+// lastUpdateResolution 是最后更新时间的精度。每个请求都写同一个时间戳会让所有 CPU 争用同一个缓存行，
+// 因此只有与上次记录相差超过该精度时才更新
+const lastUpdateResolution = time.Millisecond
+
+// DecryptMetrics 解密性能指标。记录路径只使用分片计数器和原子操作，不加锁，也不读取 runtime.MemStats；
+// 内存等进程级指标由 metrics.RuntimeSampler 在后台定期采样
 type DecryptMetrics struct {
 	// 请求计数
-	TotalRequests     int64 // 总请求数
-	EncryptedRequests int64 // 加密请求数
-	DecryptedRequests int64 // 成功解密请求数
-	FailedRequests    int64 // 解密失败请求数
+	TotalRequests     metrics.Counter // 总请求数
+	EncryptedRequests metrics.Counter // 加密请求数
+	DecryptedRequests metrics.Counter // 成功解密请求数
+	FailedRequests    metrics.Counter // 解密失败请求数
 
 	// 性能指标
-	TotalDecryptTime int64 // 总解密时间（纳秒）
-	MinDecryptTime   int64 // 最小解密时间（纳秒）
-	MaxDecryptTime   int64 // 最大解密时间（纳秒）
+	TotalDecryptTime metrics.Counter    // 总解密时间（纳秒）
+	MinDecryptTime   atomic.Int64       // 最小解密时间（纳秒）
+	MaxDecryptTime   atomic.Int64       // 最大解密时间（纳秒）
+	DecryptDuration  *metrics.Histogram // 解密耗时分布
 
-	// 内存指标
-	TotalMemoryUsed  int64 // 总内存使用量（字节）
-	BufferPoolHits   int64 // 缓冲区池命中次数
-	BufferPoolMisses int64 // 缓冲区池未命中次数
+	// 缓冲区池指标
+	BufferPoolHits   metrics.Counter // 缓冲区池命中次数
+	BufferPoolMisses metrics.Counter // 缓冲区池未命中次数
 
 	// 错误统计
-	TokenErrors   int64 // Token 相关错误
-	DecryptErrors int64 // 解密错误
-	FormatErrors  int64 // 格式错误
+	TokenErrors   metrics.Counter // Token 相关错误
+	DecryptErrors metrics.Counter // 解密错误
+	FormatErrors  metrics.Counter // 格式错误
 
 	// 时间戳
 	StartTime      time.Time
-	LastUpdateTime time.Time
-
-	mutex sync.RWMutex
+	lastUpdateTime atomic.Int64 // Unix 纳秒
 }
 
 var (
@@ -51,50 +54,50 @@ var (
 // NewDecryptMetrics 创建新的解密指标实例
 func NewDecryptMetrics() *DecryptMetrics {
 	now := time.Now()
-	return &DecryptMetrics{
-		StartTime:      now,
-		LastUpdateTime: now,
-		MinDecryptTime: int64(^uint64(0) >> 1), // 最大 int64 值
+	dm := &DecryptMetrics{
+		StartTime:       now,
+		DecryptDuration: metrics.NewHistogram(metrics.LatencyBuckets),
 	}
+	dm.MinDecryptTime.Store(math.MaxInt64)
+	dm.lastUpdateTime.Store(now.UnixNano())
+	return dm
 }
 
-// RecordRequest 记录请求
+// RecordRequest 记录经过解密中间件的请求，每个请求只应记录一次
 func (dm *DecryptMetrics) RecordRequest(isEncrypted bool) {
-	atomic.AddInt64(&dm.TotalRequests, 1)
+	dm.TotalRequests.Inc()
 	if isEncrypted {
-		atomic.AddInt64(&dm.EncryptedRequests, 1)
+		dm.EncryptedRequests.Inc()
 	}
 	dm.updateLastTime()
 }
 
 // RecordDecryptSuccess 记录成功解密
-func (dm *DecryptMetrics) RecordDecryptSuccess(duration time.Duration, memoryUsed int64) {
-	atomic.AddInt64(&dm.DecryptedRequests, 1)
+func (dm *DecryptMetrics) RecordDecryptSuccess(duration time.Duration) {
+	dm.DecryptedRequests.Inc()
 
 	// 记录解密时间
 	durationNanos := duration.Nanoseconds()
-	atomic.AddInt64(&dm.TotalDecryptTime, durationNanos)
+	dm.TotalDecryptTime.Add(durationNanos)
+	dm.DecryptDuration.ObserveDuration(duration)
 
 	// 更新最小和最大时间
 	dm.updateMinMaxTime(durationNanos)
 
-	// 记录内存使用
-	atomic.AddInt64(&dm.TotalMemoryUsed, memoryUsed)
-
 	dm.updateLastTime()
 }
 
 // RecordDecryptFailure 记录解密失败
 func (dm *DecryptMetrics) RecordDecryptFailure(errorType string) {
-	atomic.AddInt64(&dm.FailedRequests, 1)
+	dm.FailedRequests.Inc()
 
 	switch errorType {
 	case "token":
-		atomic.AddInt64(&dm.TokenErrors, 1)
+		dm.TokenErrors.Inc()
 	case "decrypt":
-		atomic.AddInt64(&dm.DecryptErrors, 1)
+		dm.DecryptErrors.Inc()
 	case "format":
-		atomic.AddInt64(&dm.FormatErrors, 1)
+		dm.FormatErrors.Inc()
 	}
 
 	dm.updateLastTime()
@@ -102,87 +105,76 @@ func (dm *DecryptMetrics) RecordDecryptFailure(errorType string) {
 
 // RecordBufferPoolHit 记录缓冲区池命中
 func (dm *DecryptMetrics) RecordBufferPoolHit() {
-	atomic.AddInt64(&dm.BufferPoolHits, 1)
+	dm.BufferPoolHits.Inc()
 }
 
 // RecordBufferPoolMiss 记录缓冲区池未命中
 func (dm *DecryptMetrics) RecordBufferPoolMiss() {
-	atomic.AddInt64(&dm.BufferPoolMisses, 1)
+	dm.BufferPoolMisses.Inc()
 }
 
-// updateMinMaxTime 更新最小和最大解密时间
+// updateMinMaxTime 更新最小和最大解密时间。只有出现新的极值时才需要写入，绝大多数调用只有一次原子读
 func (dm *DecryptMetrics) updateMinMaxTime(duration int64) {
-	dm.mutex.Lock()
-	defer dm.mutex.Unlock()
-
-	if duration < dm.MinDecryptTime {
-		dm.MinDecryptTime = duration
+	for {
+		old := dm.MinDecryptTime.Load()
+		if duration >= old || dm.MinDecryptTime.CompareAndSwap(old, duration) {
+			break
+		}
 	}
-	if duration > dm.MaxDecryptTime {
-		dm.MaxDecryptTime = duration
+	for {
+		old := dm.MaxDecryptTime.Load()
+		if duration <= old || dm.MaxDecryptTime.CompareAndSwap(old, duration) {
+			break
+		}
 	}
 }
 
 // updateLastTime 更新最后更新时间
 func (dm *DecryptMetrics) updateLastTime() {
-	dm.mutex.Lock()
-	defer dm.mutex.Unlock()
-	dm.LastUpdateTime = time.Now()
+	now := time.Now().UnixNano()
+	if old := dm.lastUpdateTime.Load(); now-old >= int64(lastUpdateResolution) {
+		dm.lastUpdateTime.CompareAndSwap(old, now)
+	}
 }
 
 // GetSnapshot 获取指标快照
 func (dm *DecryptMetrics) GetSnapshot() DecryptMetricsSnapshot {
-	dm.mutex.RLock()
-	defer dm.mutex.RUnlock()
-
-	totalRequests := atomic.LoadInt64(&dm.TotalRequests)
-	encryptedRequests := atomic.LoadInt64(&dm.EncryptedRequests)
-	decryptedRequests := atomic.LoadInt64(&dm.DecryptedRequests)
-	failedRequests := atomic.LoadInt64(&dm.FailedRequests)
-	totalDecryptTime := atomic.LoadInt64(&dm.TotalDecryptTime)
-	totalMemoryUsed := atomic.LoadInt64(&dm.TotalMemoryUsed)
-	bufferPoolHits := atomic.LoadInt64(&dm.BufferPoolHits)
-	bufferPoolMisses := atomic.LoadInt64(&dm.BufferPoolMisses)
-	tokenErrors := atomic.LoadInt64(&dm.TokenErrors)
-	decryptErrors := atomic.LoadInt64(&dm.DecryptErrors)
-	formatErrors := atomic.LoadInt64(&dm.FormatErrors)
-
 	return DecryptMetricsSnapshot{
-		TotalRequests:     totalRequests,
-		EncryptedRequests: encryptedRequests,
-		DecryptedRequests: decryptedRequests,
-		FailedRequests:    failedRequests,
-		TotalDecryptTime:  totalDecryptTime,
-		MinDecryptTime:    dm.MinDecryptTime,
-		MaxDecryptTime:    dm.MaxDecryptTime,
-		TotalMemoryUsed:   totalMemoryUsed,
-		BufferPoolHits:    bufferPoolHits,
-		BufferPoolMisses:  bufferPoolMisses,
-		TokenErrors:       tokenErrors,
-		DecryptErrors:     decryptErrors,
-		FormatErrors:      formatErrors,
+		TotalRequests:     dm.TotalRequests.Load(),
+		EncryptedRequests: dm.EncryptedRequests.Load(),
+		DecryptedRequests: dm.DecryptedRequests.Load(),
+		FailedRequests:    dm.FailedRequests.Load(),
+		TotalDecryptTime:  dm.TotalDecryptTime.Load(),
+		MinDecryptTime:    dm.MinDecryptTime.Load(),
+		MaxDecryptTime:    dm.MaxDecryptTime.Load(),
+		DecryptDuration:   dm.DecryptDuration.Snapshot(),
+		BufferPoolHits:    dm.BufferPoolHits.Load(),
+		BufferPoolMisses:  dm.BufferPoolMisses.Load(),
+		TokenErrors:       dm.TokenErrors.Load(),
+		DecryptErrors:     dm.DecryptErrors.Load(),
+		FormatErrors:      dm.FormatErrors.Load(),
 		StartTime:         dm.StartTime,
-		LastUpdateTime:    dm.LastUpdateTime,
+		LastUpdateTime:    time.Unix(0, dm.lastUpdateTime.Load()),
 	}
 }
 
 // DecryptMetricsSnapshot 指标快照
 type DecryptMetricsSnapshot struct {
-	TotalRequests     int64
-	EncryptedRequests int64
-	DecryptedRequests int64
-	FailedRequests    int64
-	TotalDecryptTime  int64
-	MinDecryptTime    int64
-	MaxDecryptTime    int64
-	TotalMemoryUsed   int64
-	BufferPoolHits    int64
-	BufferPoolMisses  int64
-	TokenErrors       int64
-	DecryptErrors     int64
-	FormatErrors      int64
-	StartTime         time.Time
-	LastUpdateTime    time.Time
+	TotalRequests     int64                     `json:"total_requests"`
+	EncryptedRequests int64                     `json:"encrypted_requests"`
+	DecryptedRequests int64                     `json:"decrypted_requests"`
+	FailedRequests    int64                     `json:"failed_requests"`
+	TotalDecryptTime  int64                     `json:"total_decrypt_time_ns"`
+	MinDecryptTime    int64                     `json:"min_decrypt_time_ns"`
+	MaxDecryptTime    int64                     `json:"max_decrypt_time_ns"`
+	DecryptDuration   metrics.HistogramSnapshot `json:"decrypt_duration"`
+	BufferPoolHits    int64                     `json:"buffer_pool_hits"`
+	BufferPoolMisses  int64                     `json:"buffer_pool_misses"`
+	TokenErrors       int64                     `json:"token_errors"`
+	DecryptErrors     int64                     `json:"decrypt_errors"`
+	FormatErrors      int64                     `json:"format_errors"`
+	StartTime         time.Time                 `json:"start_time"`
+	LastUpdateTime    time.Time                 `json:"last_update_time"`
 }
 
 // GetAverageDecryptTime 获取平均解密时间
@@ -230,7 +222,6 @@ func (s DecryptMetricsSnapshot) LogMetrics() {
 		"平均解密时间", s.GetAverageDecryptTime(),
 		"最小解密时间", time.Duration(s.MinDecryptTime),
 		"最大解密时间", time.Duration(s.MaxDecryptTime),
-		"总内存使用", s.TotalMemoryUsed,
 		"缓冲区池命中率", s.GetBufferPoolHitRate(),
 		"Token错误", s.TokenErrors,
 		"解密错误", s.DecryptErrors,
@@ -239,6 +230,26 @@ func (s DecryptMetricsSnapshot) LogMetrics() {
 	)
 }
 
+// CollectMetrics 以 Prometheus 文本格式写出解密指标
+func CollectMetrics(w *metrics.Writer) {
+	s := GlobalDecryptMetrics.GetSnapshot()
+	w.Counter("goga_decrypt_requests_total", "经过解密中间件的请求数，按请求体是否加密区分",
+		metrics.Sample{Labels: metrics.Labels("type", "plain"), Value: float64(s.TotalRequests - s.EncryptedRequests)},
+		metrics.Sample{Labels: metrics.Labels("type", "encrypted"), Value: float64(s.EncryptedRequests)},
+	)
+	w.Counter("goga_decrypt_success_total", "成功解密的请求数",
+		metrics.Sample{Value: float64(s.DecryptedRequests)},
+	)
+	w.Counter("goga_decrypt_failures_total", "解密失败的请求数，按原因区分 (token: 令牌无效或已过期，decrypt: 密文或密钥错误，format: 载荷格式错误)",
+		metrics.Sample{Labels: metrics.Labels("reason", "token"), Value: float64(s.TokenErrors)},
+		metrics.Sample{Labels: metrics.Labels("reason", "decrypt"), Value: float64(s.DecryptErrors)},
+		metrics.Sample{Labels: metrics.Labels("reason", "format"), Value: float64(s.FormatErrors)},
+	)
+	w.Histogram("goga_decrypt_duration_seconds", "解密请求体首个数据块的耗时",
+		metrics.HistogramSample{Snapshot: s.DecryptDuration},
+	)
+}
+
 // MetricsTimer 性能计时器
 type MetricsTimer struct {
 	startTime time.Time
@@ -254,24 +265,23 @@ func NewMetricsTimer(metrics *DecryptMetrics) *MetricsTimer {
 }
 
 // Stop 停止计时并记录结果
-func (mt *MetricsTimer) Stop(memoryUsed int64) {
-	duration := time.Since(mt.startTime)
-	mt.metrics.RecordDecryptSuccess(duration, memoryUsed)
+func (mt *MetricsTimer) Stop() {
+	mt.metrics.RecordDecryptSuccess(time.Since(mt.startTime))
 }
 
-// GetMemoryUsage 获取当前内存使用情况
+// GetMemoryUsage 获取当前内存使用情况：堆上对象占用的字节数和累计分配的字节数。
+// 基于 runtime/metrics 读取，不会像 runtime.ReadMemStats 那样暂停所有 goroutine
 func GetMemoryUsage() (uint64, uint64) {
-	var m runtime.MemStats
-	runtime.ReadMemStats(&m)
-	return m.Alloc, m.TotalAlloc
+	stats := metrics.ReadRuntimeStats()
+	return stats.HeapObjectsBytes, stats.HeapAllocsBytes
 }
 
 // LogSystemMetrics 记录系统内存指标
 func LogSystemMetrics() {
-	alloc, totalAlloc := GetMemoryUsage()
+	stats := metrics.ReadRuntimeStats()
 	slog.Debug("系统内存指标",
-		"当前内存使用", alloc,
-		"累计内存分配", totalAlloc,
-		"协程数", runtime.NumGoroutine(),
+		"当前内存使用", stats.HeapObjectsBytes,
+		"累计内存分配", stats.HeapAllocsBytes,
+		"协程数", stats.Goroutines,
 	)
 }
@@ -0,0 +1,70 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package middleware
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecryptMetrics(t *testing.T) {
+	dm := NewDecryptMetrics()
+	var wg sync.WaitGroup
+	for i := 1; i <= 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dm.RecordRequest(i%2 == 0)
+			if i%2 == 0 {
+				dm.RecordDecryptSuccess(time.Duration(i) * time.Microsecond)
+			} else {
+				dm.RecordDecryptFailure("format")
+			}
+		}()
+	}
+	wg.Wait()
+
+	s := dm.GetSnapshot()
+	assert.Equal(t, int64(100), s.TotalRequests, "每个请求只计数一次")
+	assert.Equal(t, int64(50), s.EncryptedRequests)
+	assert.Equal(t, int64(50), s.DecryptedRequests)
+	assert.Equal(t, int64(50), s.FormatErrors)
+	assert.Equal(t, int64(2*time.Microsecond), s.MinDecryptTime)
+	assert.Equal(t, int64(100*time.Microsecond), s.MaxDecryptTime)
+	assert.Equal(t, uint64(50), s.DecryptDuration.Count)
+	assert.Equal(t, 51*time.Microsecond, s.GetAverageDecryptTime())
+}
+
+// BenchmarkDecryptMetrics_Record 测量当前每个加密请求在指标上的开销
+func BenchmarkDecryptMetrics_Record(b *testing.B) {
+	dm := NewDecryptMetrics()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			timer := NewMetricsTimer(dm)
+			dm.RecordRequest(true)
+			timer.Stop()
+		}
+	})
+}
+
+// BenchmarkDecryptMetrics_ReadMemStats 作为对比，模拟原先每个加密请求在解密前后各调用一次 runtime.ReadMemStats 的开销
+func BenchmarkDecryptMetrics_ReadMemStats(b *testing.B) {
+	dm := NewDecryptMetrics()
+	b.RunParallel(func(pb *testing.PB) {
+		var m runtime.MemStats
+		for pb.Next() {
+			timer := NewMetricsTimer(dm)
+			dm.RecordRequest(true)
+			runtime.ReadMemStats(&m)
+			runtime.ReadMemStats(&m)
+			timer.Stop()
+		}
+	})
+}
@@ -13,6 +13,9 @@ type contextKey string
 const (
 	// RequestIDKey is the key for storing the request ID in the context.
 	RequestIDKey contextKey = "requestID"
+
+	// RequestIDHeader is the header carrying the request ID from clients, to clients and to backends.
+	RequestIDHeader = "X-Request-ID"
 )
 
 // RequestID is a middleware that injects a request ID into the context of each request.
@@ -21,7 +24,7 @@ const (
 func RequestID(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Get request ID from header
-		requestID := r.Header.Get("X-Request-ID")
+		requestID := r.Header.Get(RequestIDHeader)
 
 		// If the header is empty, generate a new request ID
 		if requestID == "" {
@@ -29,7 +32,7 @@ func RequestID(next http.Handler) http.Handler {
 		}
 
 		// Set the request ID in the response header
-		w.Header().Set("X-Request-ID", requestID)
+		w.Header().Set(RequestIDHeader, requestID)
 
 		// Create a new context with the request ID and pass it to the next handler
 		ctx := context.WithValue(r.Context(), RequestIDKey, requestID)
@@ -0,0 +1,117 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package middleware
+
+import (
+	"bufio"
+	"goga/internal/tracing"
+	"net"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// decryptFailureAttr 是解密失败原因的 span 属性，取值与 goga_decrypt_failures_total 的 reason 标签一致
+const decryptFailureAttr = attribute.Key("goga.decrypt.failure")
+
+// Tracing 为每个请求创建服务端 span。受信任的代理转发的请求带有 traceparent 时，span 成为调用方 trace 的一部分
+// 并沿用其采样决定；其他客户端的 traceparent 可以任意伪造 (例如以 -01 强制采样)，此时 span 作为新的根 span
+// 按 tracing.sample_ratio 采样，客户端的 trace 只作为链接记录。
+// 之后网关创建的解密、密钥查找、转发和 WebSocket span 都是它的子 span。
+// 需要在 ClientIP 和 RequestID 之后、WebSocket 代理之前应用，span 上会记录请求 ID。
+func Tracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID, _ := r.Context().Value(RequestIDKey).(string)
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+
+		ctx := tracing.Extract(r.Context(), r.Header)
+		var opts []trace.SpanStartOption
+		if remote := trace.SpanContextFromContext(ctx); remote.IsValid() && !FromTrustedProxy(r) {
+			opts = append(opts, trace.WithNewRoot(), trace.WithLinks(trace.Link{SpanContext: remote}))
+		}
+		ctx, span := tracing.Start(ctx, r.Method, append(opts, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+			semconv.URLScheme(scheme),
+			semconv.ServerAddress(r.Host),
+			semconv.ClientAddress(GetClientIP(r)),
+			semconv.UserAgentOriginal(r.UserAgent()),
+			tracing.RequestIDKey.String(requestID),
+		))...)
+		defer span.End()
+
+		tw := &tracingResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(tw, r.WithContext(ctx))
+
+		if tw.hijacked {
+			return // 劫持后的响应由 WebSocket 代理直接写入连接，状态码记录在 WebSocket span 上
+		}
+		status := tw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
+
+// failDecryptSpan 以失败原因结束解密 span
+func failDecryptSpan(span trace.Span, reason string) {
+	span.SetAttributes(decryptFailureAttr.String(reason))
+	span.SetStatus(codes.Error, reason)
+	span.End()
+}
+
+// tracingResponseWriter 记录响应的状态码。与日志中间件的 responseWriter 不同，它支持 Hijack，
+// 因此可以包裹 WebSocket 代理
+type tracingResponseWriter struct {
+	http.ResponseWriter
+	status   int
+	hijacked bool
+}
+
+// WriteHeader 记录第一个最终状态码，忽略 1xx 信息性响应
+func (tw *tracingResponseWriter) WriteHeader(code int) {
+	if tw.status == 0 && code >= http.StatusOK {
+		tw.status = code
+	}
+	tw.ResponseWriter.WriteHeader(code)
+}
+
+// Write 在未显式写入状态码时按 200 记录
+func (tw *tracingResponseWriter) Write(b []byte) (int, error) {
+	if tw.status == 0 {
+		tw.status = http.StatusOK
+	}
+	return tw.ResponseWriter.Write(b)
+}
+
+// Flush 刷新底层的 ResponseWriter
+func (tw *tracingResponseWriter) Flush() {
+	if f, ok := tw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack 劫持底层连接，供 WebSocket 代理使用
+func (tw *tracingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(tw.ResponseWriter).Hijack()
+	tw.hijacked = err == nil
+	return conn, rw, err
+}
+
+// Unwrap 返回底层的 ResponseWriter，供 http.ResponseController 使用
+func (tw *tracingResponseWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
@@ -6,20 +6,60 @@
 package security
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"time"
 )
 
+// KeyMetadata 记录一次性密钥的签发对象，用于按客户端 IP 或会话批量吊销令牌。
+type KeyMetadata struct {
+	ClientIP string // 领取密钥的客户端 IP
+	Session  string // 会话标识的摘要 (见 SessionDigest)，未配置会话 Cookie 或请求未携带时为空
+}
+
 // KeyCacher 定义了密钥缓存的通用接口。
 // 任何密钥缓存实现（如内存缓存或 Redis 缓存）都必须实现此接口。
 type KeyCacher interface {
-	// Set 向缓存中添加一个带特定 TTL 的密钥。
-	Set(token string, key []byte, ttl time.Duration)
+	// Set 向缓存中添加一个带特定 TTL 的密钥，并记录其签发对象。ctx 用于取消远程操作和关联链路追踪的 span。
+	Set(ctx context.Context, token string, key []byte, ttl time.Duration, meta KeyMetadata)
 
 	// Get 从缓存中检索一个密钥。如果找到且未过期，则返回密钥和 true。
 	// 如果密钥未找到或已过期，则返回 nil 和 false。
-	Get(token string) ([]byte, bool)
+	Get(ctx context.Context, token string) ([]byte, bool)
 
 	// Stop 用于停止缓存的后台清理或释放资源，以实现优雅关闭。
 	// 对于内存缓存，这可能用于停止清理 goroutine；对于 Redis 缓存，这可能用于关闭连接池。
 	Stop()
 }
+
+// KeyStore 在 KeyCacher 的基础上提供 admin API 使用的查询和吊销操作。
+// 吊销后的令牌立即失效，使用它加密的请求会被解密中间件拒绝。
+type KeyStore interface {
+	KeyCacher
+
+	// Count 返回未过期的令牌数。
+	Count(ctx context.Context) (int64, error)
+
+	// Revoke 吊销单个令牌，令牌不存在或已过期时返回 false。
+	Revoke(ctx context.Context, token string) (bool, error)
+
+	// RevokeClientIP 吊销签发给指定客户端 IP 的所有令牌，返回吊销的数量。
+	RevokeClientIP(ctx context.Context, clientIP string) (int64, error)
+
+	// RevokeSession 吊销签发给指定会话的所有令牌，session 为会话标识的摘要，返回吊销的数量。
+	RevokeSession(ctx context.Context, session string) (int64, error)
+
+	// Flush 吊销所有令牌，返回吊销的数量。
+	Flush(ctx context.Context) (int64, error)
+
+	// Ping 检查密钥缓存是否可用。内存缓存始终可用，Redis 缓存发送 PING 命令。
+	Ping(ctx context.Context) error
+}
+
+// SessionDigest 返回会话标识的 SHA-256 摘要 (小写十六进制)。
+// 密钥缓存只保存摘要，Redis 中的数据泄露不会暴露可用于冒充用户的会话 Cookie。
+func SessionDigest(session string) string {
+	sum := sha256.Sum256([]byte(session))
+	return hex.EncodeToString(sum[:])
+}
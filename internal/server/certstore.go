@@ -0,0 +1,202 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"goga/configs"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// certReloadDelay 合并短时间内的多次文件变更，证书和私钥通常会被先后替换
+	certReloadDelay = 200 * time.Millisecond
+
+	// certExpiryWarning 证书的剩余有效期少于该值时，每次加载都会打印警告
+	certExpiryWarning = 14 * 24 * time.Hour
+)
+
+// CertStore 保存一个监听器的 TLS 证书，握手时按 SNI 选择证书，证书文件变化时原子地整体替换。
+// 重新加载失败时继续使用原有的证书，已建立的连接 (包括 WebSocket) 不受影响。
+type CertStore struct {
+	name  string // 监听器名称，用于日志和指标
+	pairs []configs.CertificateConfig
+
+	current atomic.Pointer[certSet]
+	watcher *fsnotify.Watcher
+	wg      sync.WaitGroup
+}
+
+// certSet 是一次加载得到的全部证书。
+type certSet struct {
+	certs    []*tls.Certificate          // 按配置顺序，第一个是没有匹配 SNI 时使用的默认证书
+	exact    map[string]*tls.Certificate // 证书中的完整域名
+	wildcard map[string]*tls.Certificate // 通配符证书，*.example.com 以 example.com 为键
+}
+
+// NewCertStore 加载证书，任一证书加载失败时返回错误。
+func NewCertStore(name string, pairs []configs.CertificateConfig) (*CertStore, error) {
+	if len(pairs) == 0 {
+		return nil, errors.New("未配置证书")
+	}
+	s := &CertStore{name: name, pairs: pairs}
+	set, err := loadCertSet(pairs)
+	if err != nil {
+		return nil, err
+	}
+	s.current.Store(set)
+	GlobalCertificateMetrics.update(name, pairs, set)
+	return s, nil
+}
+
+// loadCertSet 加载所有证书并建立域名索引，先配置的证书优先匹配。
+func loadCertSet(pairs []configs.CertificateConfig) (*certSet, error) {
+	set := &certSet{
+		exact:    make(map[string]*tls.Certificate),
+		wildcard: make(map[string]*tls.Certificate),
+	}
+	for _, pair := range pairs {
+		cert, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("无法加载证书 %s: %w", pair.CertFile, err)
+		}
+		if cert.Leaf == nil {
+			if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+				return nil, fmt.Errorf("无法解析证书 %s: %w", pair.CertFile, err)
+			}
+		}
+		if remaining := time.Until(cert.Leaf.NotAfter); remaining < certExpiryWarning {
+			slog.Warn("TLS 证书即将过期", "cert_file", pair.CertFile, "not_after", cert.Leaf.NotAfter.Format(time.RFC3339), "remaining", remaining.Round(time.Hour))
+		}
+
+		set.certs = append(set.certs, &cert)
+		for _, name := range certNames(cert.Leaf) {
+			index, key := set.exact, name
+			if parent, ok := strings.CutPrefix(name, "*."); ok {
+				index, key = set.wildcard, parent
+			}
+			if _, exists := index[key]; !exists {
+				index[key] = &cert
+			}
+		}
+	}
+	return set, nil
+}
+
+// certNames 返回证书适用的域名 (小写)，没有 SAN 的旧证书使用 CommonName。
+func certNames(leaf *x509.Certificate) []string {
+	names := leaf.DNSNames
+	if len(names) == 0 && leaf.Subject.CommonName != "" {
+		names = []string{leaf.Subject.CommonName}
+	}
+	lower := make([]string, len(names))
+	for i, name := range names {
+		lower[i] = strings.ToLower(name)
+	}
+	return lower
+}
+
+// GetCertificate 实现 tls.Config.GetCertificate：依次按完整域名、通配符匹配 SNI，都不匹配时返回默认证书。
+func (s *CertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	set := s.current.Load()
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if cert, ok := set.exact[name]; ok {
+		return cert, nil
+	}
+	if _, parent, ok := strings.Cut(name, "."); ok {
+		if cert, ok := set.wildcard[parent]; ok {
+			return cert, nil
+		}
+	}
+	return set.certs[0], nil
+}
+
+// Watch 监听证书文件所在的目录，文件变化后重新加载全部证书。
+// 监听目录而不是文件本身，兼容 certbot、Kubernetes Secret 等通过替换符号链接或重命名来更新证书的方式。
+func (s *CertStore) Watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("无法监听证书文件: %w", err)
+	}
+	dirs := make(map[string]bool)
+	for _, pair := range s.pairs {
+		for _, file := range []string{pair.CertFile, pair.KeyFile} {
+			dir := filepath.Dir(file)
+			if dirs[dir] {
+				continue
+			}
+			if err := watcher.Add(dir); err != nil {
+				watcher.Close()
+				return fmt.Errorf("无法监听证书目录 %s: %w", dir, err)
+			}
+			dirs[dir] = true
+		}
+	}
+
+	s.watcher = watcher
+	s.wg.Add(1)
+	go s.watchLoop()
+	return nil
+}
+
+// watchLoop 在文件变更平静 certReloadDelay 之后重新加载证书。
+func (s *CertStore) watchLoop() {
+	defer s.wg.Done()
+	timer := time.NewTimer(certReloadDelay)
+	timer.Stop()
+	for {
+		select {
+		case event, ok := <-s.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			timer.Reset(certReloadDelay)
+		case err, ok := <-s.watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Warn("监听证书文件出错", "listener", s.name, "error", err)
+		case <-timer.C:
+			s.reload()
+		}
+	}
+}
+
+// reload 重新加载全部证书，任一证书加载失败时保留原有的证书。
+func (s *CertStore) reload() {
+	set, err := loadCertSet(s.pairs)
+	if err != nil {
+		GlobalCertificateMetrics.recordReloadFailure(s.name, err)
+		slog.Error("重新加载 TLS 证书失败，继续使用原有证书", "listener", s.name, "error", err)
+		return
+	}
+	s.current.Store(set)
+	GlobalCertificateMetrics.update(s.name, s.pairs, set)
+	GlobalCertificateMetrics.recordReload()
+	slog.Info("TLS 证书已重新加载", "listener", s.name, "certificates", len(set.certs))
+}
+
+// Close 停止监听证书文件，并从指标中移除该监听器的证书。
+func (s *CertStore) Close() {
+	if s.watcher != nil {
+		s.watcher.Close()
+		s.wg.Wait()
+	}
+	GlobalCertificateMetrics.remove(s.name)
+}
@@ -0,0 +1,184 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"goga/configs"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCertificate 在 dir 下生成一对自签名证书和私钥文件 (<name>.pem 和 <name>-key.pem)，返回其配置。
+// 证书可同时用作服务端证书和客户端证书，自签名证书本身即可作为 CA 使用。
+func writeCertificate(t *testing.T, dir, name string, notAfter time.Time, dnsNames ...string) configs.CertificateConfig {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	pair := configs.CertificateConfig{
+		CertFile: filepath.Join(dir, name+".pem"),
+		KeyFile:  filepath.Join(dir, name+"-key.pem"),
+	}
+	require.NoError(t, os.WriteFile(pair.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(pair.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return pair
+}
+
+// servedNames 返回 CertStore 为指定 SNI 选择的证书中的域名。
+func servedNames(t *testing.T, store *CertStore, serverName string) []string {
+	t.Helper()
+	cert, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+	require.NoError(t, err)
+	return cert.Leaf.DNSNames
+}
+
+func TestCertStore_SNI(t *testing.T) {
+	dir := t.TempDir()
+	expiry := time.Now().Add(90 * 24 * time.Hour)
+	store, err := NewCertStore("sni", []configs.CertificateConfig{
+		writeCertificate(t, dir, "default", expiry, "example.com"),
+		writeCertificate(t, dir, "wildcard", expiry, "*.example.org", "api.example.com"),
+		writeCertificate(t, dir, "duplicate", expiry, "api.example.com"),
+	})
+	require.NoError(t, err)
+	defer store.Close()
+
+	testCases := map[string][]string{
+		"example.com":      {"example.com"},
+		"API.Example.com.": {"*.example.org", "api.example.com"}, // 大小写和末尾的点不影响匹配，先配置的证书优先
+		"www.example.org":  {"*.example.org", "api.example.com"},
+		"a.b.example.org":  {"example.com"}, // 通配符只匹配一级子域名
+		"":                 {"example.com"}, // 没有 SNI 时使用默认证书
+		"unknown.test":     {"example.com"},
+	}
+	for serverName, expected := range testCases {
+		assert.Equal(t, expected, servedNames(t, store, serverName), serverName)
+	}
+}
+
+func TestCertStore_Reload(t *testing.T) {
+	dir := t.TempDir()
+	pair := writeCertificate(t, dir, "site", time.Now().Add(24*time.Hour), "old.example.com")
+	store, err := NewCertStore("reload", []configs.CertificateConfig{pair})
+	require.NoError(t, err)
+	require.NoError(t, store.Watch())
+	defer store.Close()
+	before := GlobalCertificateMetrics.GetSnapshot()
+
+	writeCertificate(t, dir, "site", time.Now().Add(48*time.Hour), "new.example.com")
+	assert.Eventually(t, func() bool {
+		return servedNames(t, store, "")[0] == "new.example.com"
+	}, 5*time.Second, 20*time.Millisecond, "证书文件变化后应自动重新加载")
+
+	require.NoError(t, os.WriteFile(pair.KeyFile, []byte("not a key"), 0o600))
+	assert.Eventually(t, func() bool {
+		return GlobalCertificateMetrics.GetSnapshot().ReloadFailures > before.ReloadFailures
+	}, 5*time.Second, 20*time.Millisecond)
+	assert.Equal(t, "new.example.com", servedNames(t, store, "")[0], "重新加载失败时应继续使用原有证书")
+
+	snapshot := GlobalCertificateMetrics.GetSnapshot()
+	assert.Greater(t, snapshot.Reloads, before.Reloads)
+	var status *CertificateStatus
+	for i := range snapshot.Certificates {
+		if snapshot.Certificates[i].Listener == "reload" {
+			status = &snapshot.Certificates[i]
+		}
+	}
+	require.NotNil(t, status)
+	assert.Equal(t, []string{"new.example.com"}, status.Names)
+	assert.NotEmpty(t, status.LastReloadError)
+	assert.InDelta(t, (48 * time.Hour).Seconds(), status.ExpiresInSeconds, 60)
+}
+
+func TestNew_TLSListener(t *testing.T) {
+	dir := t.TempDir()
+	expiry := time.Now().Add(30 * 24 * time.Hour)
+	def := writeCertificate(t, dir, "default", expiry, "example.com")
+	api := writeCertificate(t, dir, "api", expiry, "api.example.com")
+
+	cfg := configs.ServerConfig{Listeners: []configs.ListenerConfig{{
+		Name:         "https",
+		Listen:       "127.0.0.1:0",
+		TLSCertPath:  def.CertFile,
+		TLSKeyPath:   def.KeyFile,
+		Certificates: []configs.CertificateConfig{api},
+	}}}
+	servers, err := New(cfg, Handlers{Serve: textHandler("serve")})
+	require.NoError(t, err)
+	srv := servers[0]
+	require.True(t, srv.TLS())
+	go srv.Serve()
+	defer srv.Shutdown(t.Context())
+
+	for _, serverName := range []string{"example.com", "api.example.com"} {
+		conn, err := tls.Dial("tcp", srv.Addr(), &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+		require.NoError(t, err)
+		assert.Equal(t, serverName, conn.ConnectionState().PeerCertificates[0].Subject.CommonName)
+		conn.Close()
+	}
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, CertificateStatusPath, nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	NewCertificateStatusHandler().ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var snapshot CertificateMetricsSnapshot
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &snapshot))
+	var files []string
+	for _, cert := range snapshot.Certificates {
+		if cert.Listener == "https" {
+			files = append(files, cert.CertFile)
+			assert.WithinDuration(t, expiry, cert.NotAfter, time.Second)
+		}
+	}
+	assert.Equal(t, []string{def.CertFile, api.CertFile}, files)
+}
+
+func TestNew_InvalidCertificate(t *testing.T) {
+	dir := t.TempDir()
+	pair := writeCertificate(t, dir, "site", time.Now().Add(time.Hour), "example.com")
+	testCases := map[string]configs.ListenerConfig{
+		"证书文件不存在": {Listen: "127.0.0.1:0", TLSCertPath: filepath.Join(dir, "missing.pem"), TLSKeyPath: pair.KeyFile},
+		"缺少私钥文件":  {Listen: "127.0.0.1:0", Certificates: []configs.CertificateConfig{{CertFile: pair.CertFile}}},
+	}
+	for name, lc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := New(configs.ServerConfig{Listeners: []configs.ListenerConfig{lc}}, Handlers{})
+			assert.Error(t, err)
+		})
+	}
+}
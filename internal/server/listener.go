@@ -0,0 +1,141 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"goga/configs"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// unixAddrPrefix 是 server.listen 中 Unix 套接字地址的前缀
+	unixAddrPrefix = "unix:"
+
+	defaultSocketMode os.FileMode = 0o660
+)
+
+// Listeners 返回需要启动的监听器配置。未配置 server.listeners 时，由 server 下的单监听器配置生成一个 serve 监听器。
+func Listeners(cfg configs.ServerConfig) []configs.ListenerConfig {
+	if len(cfg.Listeners) > 0 {
+		return cfg.Listeners
+	}
+	listen := cfg.Listen
+	if listen == "" {
+		listen = ":" + cfg.Port
+	}
+	return []configs.ListenerConfig{{
+		Listen:        listen,
+		Role:          RoleServe,
+		TLSCertPath:   cfg.TLSCertPath,
+		TLSKeyPath:    cfg.TLSKeyPath,
+		Certificates:  cfg.Certificates,
+		ClientAuth:    cfg.ClientAuth,
+		SocketMode:    cfg.SocketMode,
+		ProxyProtocol: cfg.ProxyProtocol,
+		H2C:           cfg.H2C,
+	}}
+}
+
+// Listen 按监听器配置创建监听器，启用 proxy_protocol 时在其外层解析 PROXY 头。
+// listen 为 unix: 地址时监听 Unix 套接字，否则监听 TCP 地址。
+// 由平滑升级或 systemd 套接字激活启动时，优先使用传入的监听器，不再设置 Unix 套接字的权限。
+func Listen(lc configs.ListenerConfig) (net.Listener, error) {
+	socket, isUnix := strings.CutPrefix(lc.Listen, unixAddrPrefix)
+	if isUnix && lc.ProxyProtocol.Enabled {
+		return nil, errors.New("proxy_protocol 仅支持 TCP 监听地址")
+	}
+
+	ln, err := takeInherited(lc.Name, lc.Listen)
+	if err != nil {
+		return nil, err
+	}
+	if ln == nil && isUnix {
+		return listenUnix(socket, lc.SocketMode)
+	}
+	if ln == nil {
+		ln, err = net.Listen("tcp", lc.Listen)
+		if err != nil {
+			return nil, fmt.Errorf("无法监听 %s: %w", lc.Listen, err)
+		}
+	}
+	if !lc.ProxyProtocol.Enabled {
+		return ln, nil
+	}
+
+	ppln, err := NewProxyProtocolListener(ln, lc.ProxyProtocol)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ppln, nil
+}
+
+// listenUnix 监听 Unix 套接字并设置文件权限，监听器关闭时会删除套接字文件。
+func listenUnix(socket, socketMode string) (net.Listener, error) {
+	mode := defaultSocketMode
+	if socketMode != "" {
+		m, err := strconv.ParseUint(socketMode, 8, 32)
+		if err != nil || m > 0o777 {
+			return nil, fmt.Errorf("无效的 socket_mode %q，应为八进制权限，例如 \"0660\"", socketMode)
+		}
+		mode = os.FileMode(m)
+	}
+	if err := removeStaleSocket(socket); err != nil {
+		return nil, err
+	}
+
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("无法监听 Unix 套接字 %s: %w", socket, err)
+	}
+	if err := os.Chmod(socket, mode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("无法设置 Unix 套接字 %s 的权限: %w", socket, err)
+	}
+	return ln, nil
+}
+
+// removeStaleSocket 删除上次异常退出时遗留的套接字文件。仍有进程在监听或路径不是套接字时返回错误，避免误删。
+func removeStaleSocket(socket string) error {
+	info, err := os.Lstat(socket)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("无法检查 Unix 套接字 %s: %w", socket, err)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s 已存在且不是 Unix 套接字", socket)
+	}
+	if conn, err := net.DialTimeout("unix", socket, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("Unix 套接字 %s 正在被其他进程监听", socket)
+	}
+	if err := os.Remove(socket); err != nil {
+		return fmt.Errorf("无法删除遗留的 Unix 套接字 %s: %w", socket, err)
+	}
+	return nil
+}
+
+// NewHTTPServer 创建网关的 HTTP 服务器。HTTPS 监听端口通过 ALPN 支持 HTTP/2，
+// 启用 h2c 时明文端口也接受 HTTP/2 (prior knowledge)，HTTP/1.1 始终可用。
+func NewHTTPServer(lc configs.ListenerConfig, handler http.Handler) *http.Server {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(lc.H2C)
+	return &http.Server{
+		Handler:   handler,
+		Protocols: protocols,
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package server
+
+import (
+	"context"
+	"goga/configs"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHTTPServer_H2C(t *testing.T) {
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	h2cClient := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+
+	for _, enabled := range []bool{true, false} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		srv := NewHTTPServer(configs.ListenerConfig{H2C: enabled}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, r.Proto)
+		}))
+		go srv.Serve(ln)
+		url := "http://" + ln.Addr().String()
+
+		resp, err := http.Get(url)
+		require.NoError(t, err, "HTTP/1.1 始终可用")
+		resp.Body.Close()
+
+		resp, err = h2cClient.Get(url)
+		if enabled {
+			require.NoError(t, err)
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			assert.Equal(t, "HTTP/2.0", string(body))
+		} else {
+			assert.Error(t, err, "未启用 h2c 时不接受明文 HTTP/2")
+		}
+		srv.Close()
+	}
+}
+
+func TestListen_UnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "goga.sock")
+	// 模拟上次异常退出遗留的套接字文件
+	stale, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	cfg := configs.ListenerConfig{Listen: "unix:" + socket, SocketMode: "0600"}
+	ln, err := Listen(cfg)
+	require.NoError(t, err, "应删除遗留的套接字文件后重新监听")
+	info, err := os.Stat(socket)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	_, err = Listen(cfg)
+	assert.Error(t, err, "不应删除仍在监听的套接字")
+
+	srv := NewHTTPServer(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Context().Value(http.LocalAddrContextKey).(net.Addr).Network())
+	}))
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return new(net.Dialer).DialContext(ctx, "unix", socket)
+		},
+	}}
+	resp, err := client.Get("http://goga/")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "unix", string(body))
+}
+
+func TestListen_InvalidUnixSocket(t *testing.T) {
+	dir := t.TempDir()
+	regular := filepath.Join(dir, "regular")
+	require.NoError(t, os.WriteFile(regular, nil, 0o600))
+
+	testCases := map[string]configs.ListenerConfig{
+		"路径不是套接字":           {Listen: "unix:" + regular},
+		"无效的权限":             {Listen: "unix:" + filepath.Join(dir, "a.sock"), SocketMode: "rw-rw----"},
+		"启用 proxy_protocol": {Listen: "unix:" + filepath.Join(dir, "b.sock"), ProxyProtocol: configs.ProxyProtocolConfig{Enabled: true, AllowedSources: []string{"127.0.0.1"}}},
+	}
+	for name, cfg := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := Listen(cfg)
+			assert.Error(t, err)
+		})
+	}
+	_, err := os.Stat(regular)
+	assert.NoError(t, err, "不应删除非套接字文件")
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package server
+
+import (
+	"encoding/json"
+	"goga/configs"
+	"goga/internal/metrics"
+	"goga/internal/middleware"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CertificateStatusPath 是证书状态接口的路径，仅允许本地回环地址或 admin 监听器访问。
+const CertificateStatusPath = "/goga/admin/certificates"
+
+// CertificateMetrics 证书指标：各监听器当前使用的证书及其有效期，以及重新加载的次数
+type CertificateMetrics struct {
+	Reloads        int64 // 证书文件变化后成功重新加载的次数
+	ReloadFailures int64 // 重新加载失败的次数
+
+	mutex     sync.Mutex
+	listeners map[string]*listenerCertificates
+}
+
+// listenerCertificates 是一个监听器的证书状态
+type listenerCertificates struct {
+	certs           []CertificateStatus
+	lastReloadTime  time.Time
+	lastReloadError string
+}
+
+var (
+	// 全局证书指标实例
+	GlobalCertificateMetrics = NewCertificateMetrics()
+)
+
+// NewCertificateMetrics 创建新的证书指标实例
+func NewCertificateMetrics() *CertificateMetrics {
+	return &CertificateMetrics{listeners: make(map[string]*listenerCertificates)}
+}
+
+// update 记录监听器最新加载的证书
+func (cm *CertificateMetrics) update(listener string, pairs []configs.CertificateConfig, set *certSet) {
+	certs := make([]CertificateStatus, len(set.certs))
+	for i, cert := range set.certs {
+		certs[i] = CertificateStatus{
+			Listener:  listener,
+			CertFile:  pairs[i].CertFile,
+			Names:     certNames(cert.Leaf),
+			NotBefore: cert.Leaf.NotBefore,
+			NotAfter:  cert.Leaf.NotAfter,
+		}
+	}
+
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	cm.listeners[listener] = &listenerCertificates{certs: certs, lastReloadTime: time.Now()}
+}
+
+// recordReload 记录一次成功的重新加载
+func (cm *CertificateMetrics) recordReload() {
+	atomic.AddInt64(&cm.Reloads, 1)
+}
+
+// recordReloadFailure 记录一次失败的重新加载，原有证书的状态保持不变
+func (cm *CertificateMetrics) recordReloadFailure(listener string, err error) {
+	atomic.AddInt64(&cm.ReloadFailures, 1)
+
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	if lc, ok := cm.listeners[listener]; ok {
+		lc.lastReloadError = err.Error()
+	}
+}
+
+// remove 移除已关闭的监听器的证书
+func (cm *CertificateMetrics) remove(listener string) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	delete(cm.listeners, listener)
+}
+
+// GetSnapshot 获取指标快照，证书按监听器名称和配置顺序排列
+func (cm *CertificateMetrics) GetSnapshot() CertificateMetricsSnapshot {
+	now := time.Now()
+	snapshot := CertificateMetricsSnapshot{
+		Reloads:        atomic.LoadInt64(&cm.Reloads),
+		ReloadFailures: atomic.LoadInt64(&cm.ReloadFailures),
+		Certificates:   []CertificateStatus{},
+	}
+
+	cm.mutex.Lock()
+	names := make([]string, 0, len(cm.listeners))
+	for name := range cm.listeners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		lc := cm.listeners[name]
+		for _, cert := range lc.certs {
+			cert.ExpiresInSeconds = int64(cert.NotAfter.Sub(now).Seconds())
+			cert.LastReloadTime = lc.lastReloadTime
+			cert.LastReloadError = lc.lastReloadError
+			snapshot.Certificates = append(snapshot.Certificates, cert)
+		}
+	}
+	cm.mutex.Unlock()
+
+	return snapshot
+}
+
+// CertificateMetricsSnapshot 证书指标快照
+type CertificateMetricsSnapshot struct {
+	Reloads        int64               `json:"reloads"`
+	ReloadFailures int64               `json:"reload_failures"`
+	Certificates   []CertificateStatus `json:"certificates"`
+}
+
+// CertificateStatus 是单个证书的状态
+type CertificateStatus struct {
+	Listener         string    `json:"listener"`
+	CertFile         string    `json:"cert_file"`
+	Names            []string  `json:"names"`
+	NotBefore        time.Time `json:"not_before"`
+	NotAfter         time.Time `json:"not_after"`
+	ExpiresInSeconds int64     `json:"expires_in_seconds"` // 已过期时为负数
+	LastReloadTime   time.Time `json:"last_reload_time"`
+	LastReloadError  string    `json:"last_reload_error,omitempty"` // 最近一次重新加载失败的原因，成功加载后清空
+}
+
+// LogMetrics 记录指标到日志
+func (s CertificateMetricsSnapshot) LogMetrics() {
+	for _, cert := range s.Certificates {
+		slog.Info("TLS 证书指标",
+			"监听器", cert.Listener,
+			"证书文件", cert.CertFile,
+			"域名", cert.Names,
+			"过期时间", cert.NotAfter.Format(time.RFC3339),
+			"剩余有效期", time.Duration(cert.ExpiresInSeconds)*time.Second,
+		)
+	}
+	slog.Info("TLS 证书重新加载指标", "成功次数", s.Reloads, "失败次数", s.ReloadFailures)
+}
+
+// NewCertificateStatusHandler 返回以 JSON 输出证书有效期和重新加载状态的处理器，仅允许本机访问。
+func NewCertificateStatusHandler() http.Handler {
+	return middleware.LocalOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(GlobalCertificateMetrics.GetSnapshot())
+	}))
+}
+
+// CollectMetrics 以 Prometheus 文本格式写出证书有效期和重新加载指标
+func CollectMetrics(w *metrics.Writer) {
+	s := GlobalCertificateMetrics.GetSnapshot()
+	expiry := make([]metrics.Sample, 0, len(s.Certificates))
+	for _, cert := range s.Certificates {
+		expiry = append(expiry, metrics.Sample{
+			Labels: metrics.Labels("listener", cert.Listener, "cert_file", cert.CertFile),
+			Value:  float64(cert.NotAfter.Unix()),
+		})
+	}
+	w.Gauge("goga_tls_certificate_not_after_timestamp_seconds", "TLS 证书的过期时间 (Unix 秒)", expiry...)
+	w.Counter("goga_tls_certificate_reloads_total", "证书文件变化后重新加载的次数，按结果区分",
+		metrics.Sample{Labels: metrics.Labels("result", "success"), Value: float64(s.Reloads)},
+		metrics.Sample{Labels: metrics.Labels("result", "failure"), Value: float64(s.ReloadFailures)},
+	)
+}
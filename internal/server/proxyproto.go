@@ -0,0 +1,404 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"goga/configs"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultProxyHeaderTimeout 是等待 PROXY 头的默认超时时间
+const defaultProxyHeaderTimeout = 5 * time.Second
+
+const (
+	proxyV1MaxLength = 107 // 规范规定的 v1 头最大长度，包括结尾的 CRLF
+	proxyV2HeaderLen = 16  // v2 头固定部分的长度：签名 12 字节、版本/命令、协议族、地址长度
+
+	proxyV2CommandLocal = 0x0
+	proxyV2CommandProxy = 0x1
+)
+
+// proxyV2Signature 是 PROXY protocol v2 头的固定签名
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// v2 头中常用的 TLV 类型
+const (
+	TLVTypeALPN      byte = 0x01
+	TLVTypeAuthority byte = 0x02
+	TLVTypeCRC32C    byte = 0x03
+	TLVTypeNoop      byte = 0x04
+	TLVTypeUniqueID  byte = 0x05
+	TLVTypeSSL       byte = 0x20
+	TLVTypeNetNS     byte = 0x30
+)
+
+// crc32cTable 是 CRC32C (Castagnoli) 校验表
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errMissingProxyHeader 表示允许的来源建立的连接没有以 PROXY 头开头
+var errMissingProxyHeader = errors.New("连接未发送 PROXY protocol 头")
+
+// TLV 是 PROXY protocol v2 头中携带的一项扩展信息。
+type TLV struct {
+	Type  byte
+	Value []byte
+}
+
+// ProxyHeader 是从连接开头解析出的 PROXY protocol 头。
+type ProxyHeader struct {
+	Version     int      // 1 或 2
+	Local       bool     // v2 LOCAL 命令或 v1 UNKNOWN，表示负载均衡器自身发起的连接 (例如健康检查)，应使用连接的真实地址
+	Source      net.Addr // 原始客户端地址，Local 为 true 时为 nil
+	Destination net.Addr // 客户端连接的原始目标地址，Local 为 true 时为 nil
+	TLVs        []TLV    // 仅 v2 头携带
+}
+
+// TLV 返回指定类型的第一项 TLV 的值。
+func (h *ProxyHeader) TLV(typ byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == typ {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// ProxyProtocolListener 包装一个监听器，从允许的来源建立的连接开头读取 PROXY protocol 头 (v1 或 v2)，
+// 并将连接的 RemoteAddr 替换为头中的原始客户端地址。其他来源的连接原样透传，不会解析 PROXY 头，
+// 避免任意客户端伪造地址。
+type ProxyProtocolListener struct {
+	net.Listener
+	allowed       []netip.Prefix
+	headerTimeout time.Duration
+}
+
+// NewProxyProtocolListener 按 server.proxy_protocol 配置包装监听器，allowed_sources 不能为空。
+func NewProxyProtocolListener(inner net.Listener, cfg configs.ProxyProtocolConfig) (*ProxyProtocolListener, error) {
+	if len(cfg.AllowedSources) == 0 {
+		return nil, errors.New("启用 proxy_protocol 时必须配置 allowed_sources")
+	}
+	allowed, err := parsePrefixes(cfg.AllowedSources)
+	if err != nil {
+		return nil, fmt.Errorf("无效的 proxy_protocol.allowed_sources: %w", err)
+	}
+	headerTimeout := cfg.HeaderTimeout
+	if headerTimeout <= 0 {
+		headerTimeout = defaultProxyHeaderTimeout
+	}
+	return &ProxyProtocolListener{Listener: inner, allowed: allowed, headerTimeout: headerTimeout}, nil
+}
+
+// Accept 实现 net.Listener 接口。
+// PROXY 头在连接首次被读取或查询 RemoteAddr 时才解析，慢速连接不会阻塞 Accept 循环。
+func (l *ProxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.allowedSource(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &ProxyProtocolConn{Conn: conn, reader: bufio.NewReader(conn), headerTimeout: l.headerTimeout}, nil
+}
+
+// allowedSource 判断连接的来源是否允许发送 PROXY 头。
+func (l *ProxyProtocolListener) allowedSource(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip, ok := netip.AddrFromSlice(tcpAddr.IP)
+	if !ok {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, prefix := range l.allowed {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ProxyProtocolConn 是来自允许来源的连接，读取数据前先消费连接开头的 PROXY 头。
+type ProxyProtocolConn struct {
+	net.Conn
+	reader        *bufio.Reader
+	headerTimeout time.Duration
+
+	once   sync.Once
+	header *ProxyHeader
+	err    error
+}
+
+// Read 实现 net.Conn 接口，PROXY 头解析失败时返回解析错误。
+func (c *ProxyProtocolConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr 返回 PROXY 头中的原始客户端地址。LOCAL 命令或解析失败时返回连接的真实地址。
+func (c *ProxyProtocolConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.header != nil && c.header.Source != nil {
+		return c.header.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// ProxyHeader 返回解析出的 PROXY 头，解析失败时返回错误。
+func (c *ProxyProtocolConn) ProxyHeader() (*ProxyHeader, error) {
+	c.once.Do(c.readHeader)
+	return c.header, c.err
+}
+
+// readHeader 在超时时间内读取并解析 PROXY 头，失败时关闭连接。
+func (c *ProxyProtocolConn) readHeader() {
+	c.Conn.SetReadDeadline(time.Now().Add(c.headerTimeout))
+	c.header, c.err = parseProxyHeader(c.reader)
+	if c.err != nil {
+		slog.Warn("PROXY protocol 头解析失败，已关闭连接", "remote_addr", c.Conn.RemoteAddr().String(), "error", c.err)
+		c.Conn.Close()
+		return
+	}
+	c.Conn.SetReadDeadline(time.Time{})
+}
+
+// parseProxyHeader 根据开头的签名解析 v1 或 v2 头。
+func parseProxyHeader(r *bufio.Reader) (*ProxyHeader, error) {
+	prefix, err := r.Peek(5)
+	if err != nil {
+		return nil, fmt.Errorf("读取 PROXY 头失败: %w", err)
+	}
+	if string(prefix) == "PROXY" {
+		return parseProxyHeaderV1(r)
+	}
+	if prefix[0] == proxyV2Signature[0] {
+		if sig, err := r.Peek(len(proxyV2Signature)); err == nil && bytes.Equal(sig, proxyV2Signature) {
+			return parseProxyHeaderV2(r)
+		}
+	}
+	return nil, errMissingProxyHeader
+}
+
+// parseProxyHeaderV1 解析文本格式的 v1 头，例如 "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n"。
+func parseProxyHeaderV1(r *bufio.Reader) (*ProxyHeader, error) {
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("读取 PROXY v1 头失败: %w", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= proxyV1MaxLength {
+			return nil, errors.New("PROXY v1 头超过最大长度")
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("PROXY v1 头必须以 CRLF 结尾")
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		// UNKNOWN 之后的内容应被忽略
+		return &ProxyHeader{Version: 1, Local: true}, nil
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("PROXY v1 头格式错误: %q", line)
+	}
+
+	src, err := netip.ParseAddr(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("PROXY v1 头的源地址无效: %w", err)
+	}
+	dst, err := netip.ParseAddr(fields[3])
+	if err != nil {
+		return nil, fmt.Errorf("PROXY v1 头的目标地址无效: %w", err)
+	}
+	switch fields[1] {
+	case "TCP4":
+		if !src.Is4() || !dst.Is4() {
+			return nil, errors.New("PROXY v1 头的 TCP4 地址不是 IPv4 地址")
+		}
+	case "TCP6":
+		if !src.Is6() || !dst.Is6() {
+			return nil, errors.New("PROXY v1 头的 TCP6 地址不是 IPv6 地址")
+		}
+	default:
+		return nil, fmt.Errorf("不支持的 PROXY v1 协议: %q", fields[1])
+	}
+	srcPort, err := parsePort(fields[4])
+	if err != nil {
+		return nil, err
+	}
+	dstPort, err := parsePort(fields[5])
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProxyHeader{
+		Version:     1,
+		Source:      net.TCPAddrFromAddrPort(netip.AddrPortFrom(src, srcPort)),
+		Destination: net.TCPAddrFromAddrPort(netip.AddrPortFrom(dst, dstPort)),
+	}, nil
+}
+
+// parsePort 解析 v1 头中的端口号，不允许前导零。
+func parsePort(s string) (uint16, error) {
+	port, err := strconv.ParseUint(s, 10, 16)
+	if err != nil || (len(s) > 1 && s[0] == '0') {
+		return 0, fmt.Errorf("PROXY v1 头的端口无效: %q", s)
+	}
+	return uint16(port), nil
+}
+
+// parseProxyHeaderV2 解析二进制格式的 v2 头及其后的 TLV，携带 CRC32C TLV 时校验整个头部。
+func parseProxyHeaderV2(r *bufio.Reader) (*ProxyHeader, error) {
+	fixed := make([]byte, proxyV2HeaderLen)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, fmt.Errorf("读取 PROXY v2 头失败: %w", err)
+	}
+	if version := fixed[12] >> 4; version != 2 {
+		return nil, fmt.Errorf("不支持的 PROXY 协议版本: %d", version)
+	}
+	command := fixed[12] & 0x0f
+	if command != proxyV2CommandLocal && command != proxyV2CommandProxy {
+		return nil, fmt.Errorf("不支持的 PROXY v2 命令: %d", command)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("读取 PROXY v2 地址失败: %w", err)
+	}
+
+	header := &ProxyHeader{Version: 2, Local: command == proxyV2CommandLocal}
+	var addrLen int
+	switch fixed[13] {
+	case 0x11: // TCP over IPv4
+		addrLen = 12
+		if len(payload) < addrLen {
+			return nil, errors.New("PROXY v2 头的 IPv4 地址长度不足")
+		}
+		header.Source, header.Destination = v2Addrs(payload[0:4], payload[4:8], payload[8:12])
+	case 0x21: // TCP over IPv6
+		addrLen = 36
+		if len(payload) < addrLen {
+			return nil, errors.New("PROXY v2 头的 IPv6 地址长度不足")
+		}
+		header.Source, header.Destination = v2Addrs(payload[0:16], payload[16:32], payload[32:36])
+	default:
+		// UNSPEC、UDP 和 UNIX 地址对 HTTP 没有意义，按 LOCAL 处理，但 PROXY 命令仍需跳过地址部分
+		if command == proxyV2CommandProxy && fixed[13] != 0x00 {
+			return nil, fmt.Errorf("不支持的 PROXY v2 地址族: %#x", fixed[13])
+		}
+		header.Local = true
+		addrLen = len(payload)
+	}
+	if header.Local {
+		header.Source, header.Destination = nil, nil
+	}
+
+	tlvs, err := parseTLVs(payload[addrLen:])
+	if err != nil {
+		return nil, err
+	}
+	header.TLVs = tlvs
+	if err := verifyCRC32C(fixed, payload, addrLen); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// v2Addrs 根据 v2 头中的源地址、目标地址和两个端口构造 TCP 地址。
+func v2Addrs(src, dst, ports []byte) (net.Addr, net.Addr) {
+	srcIP, _ := netip.AddrFromSlice(src)
+	dstIP, _ := netip.AddrFromSlice(dst)
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(srcIP, binary.BigEndian.Uint16(ports[0:2]))),
+		net.TCPAddrFromAddrPort(netip.AddrPortFrom(dstIP, binary.BigEndian.Uint16(ports[2:4])))
+}
+
+// parseTLVs 解析地址之后的 TLV 列表，每项由 1 字节类型、2 字节长度和值组成。
+func parseTLVs(b []byte) ([]TLV, error) {
+	var tlvs []TLV
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return nil, errors.New("PROXY v2 头的 TLV 被截断")
+		}
+		n := int(binary.BigEndian.Uint16(b[1:3]))
+		if len(b) < 3+n {
+			return nil, errors.New("PROXY v2 头的 TLV 长度超出头部")
+		}
+		tlvs = append(tlvs, TLV{Type: b[0], Value: b[3 : 3+n]})
+		b = b[3+n:]
+	}
+	return tlvs, nil
+}
+
+// verifyCRC32C 在头部携带 CRC32C TLV 时，以该字段置零后的完整头部计算校验和并比较。
+func verifyCRC32C(fixed, payload []byte, addrLen int) error {
+	b := payload[addrLen:]
+	for len(b) >= 3 {
+		n := int(binary.BigEndian.Uint16(b[1:3]))
+		if b[0] == TLVTypeCRC32C {
+			if n != 4 {
+				return errors.New("PROXY v2 头的 CRC32C TLV 长度无效")
+			}
+			value := b[3:7]
+			expected := binary.BigEndian.Uint32(value)
+			binary.BigEndian.PutUint32(value, 0)
+			crc := crc32.Update(crc32.Checksum(fixed, crc32cTable), crc32cTable, payload)
+			binary.BigEndian.PutUint32(value, expected)
+			if crc != expected {
+				return errors.New("PROXY v2 头的 CRC32C 校验失败")
+			}
+			return nil
+		}
+		b = b[3+n:]
+	}
+	return nil
+}
+
+// parsePrefixes 解析 CIDR 或单个 IP 地址列表。
+func parsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, s := range cidrs {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("%q: %w", s, err)
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", s, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
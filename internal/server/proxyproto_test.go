@@ -0,0 +1,197 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"goga/configs"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// buildV2Header 构造一个 TCP over IPv4 的 v2 PROXY 头，withCRC 为 true 时追加正确的 CRC32C TLV。
+func buildV2Header(command byte, src, dst string, srcPort, dstPort uint16, tlvs []TLV, withCRC bool) []byte {
+	payload := make([]byte, 12)
+	copy(payload[0:4], net.ParseIP(src).To4())
+	copy(payload[4:8], net.ParseIP(dst).To4())
+	binary.BigEndian.PutUint16(payload[8:10], srcPort)
+	binary.BigEndian.PutUint16(payload[10:12], dstPort)
+	for _, tlv := range tlvs {
+		payload = append(payload, tlv.Type, 0, 0)
+		binary.BigEndian.PutUint16(payload[len(payload)-2:], uint16(len(tlv.Value)))
+		payload = append(payload, tlv.Value...)
+	}
+	crcOffset := -1
+	if withCRC {
+		payload = append(payload, TLVTypeCRC32C, 0, 4, 0, 0, 0, 0)
+		crcOffset = len(payload) - 4
+	}
+
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, 0x20|command, 0x11, 0, 0)
+	binary.BigEndian.PutUint16(header[14:16], uint16(len(payload)))
+	header = append(header, payload...)
+	if crcOffset >= 0 {
+		binary.BigEndian.PutUint32(header[proxyV2HeaderLen+crcOffset:], crc32.Checksum(header, crc32cTable))
+	}
+	return header
+}
+
+func TestParseProxyHeader(t *testing.T) {
+	testCases := []struct {
+		name   string
+		input  string
+		source string
+		local  bool
+	}{
+		{"v1 TCP4", "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n", "192.0.2.1:56324", false},
+		{"v1 TCP6", "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n", "[2001:db8::1]:56324", false},
+		{"v1 UNKNOWN", "PROXY UNKNOWN\r\n", "", true},
+		{"v2 PROXY", string(buildV2Header(proxyV2CommandProxy, "198.51.100.7", "10.0.0.1", 4000, 443, nil, false)), "198.51.100.7:4000", false},
+		{"v2 LOCAL", string(buildV2Header(proxyV2CommandLocal, "0.0.0.0", "0.0.0.0", 0, 0, nil, false)), "", true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(tc.input + "GET / HTTP/1.1\r\n"))
+			header, err := parseProxyHeader(r)
+			require.NoError(t, err)
+			assert.Equal(t, tc.local, header.Local)
+			if tc.source != "" {
+				assert.Equal(t, tc.source, header.Source.String())
+			} else {
+				assert.Nil(t, header.Source)
+			}
+			rest, _ := io.ReadAll(r)
+			assert.Equal(t, "GET / HTTP/1.1\r\n", string(rest), "PROXY 头之后的数据应原样保留")
+		})
+	}
+}
+
+func TestParseProxyHeader_V2TLVs(t *testing.T) {
+	tlvs := []TLV{{Type: TLVTypeAuthority, Value: []byte("example.com")}, {Type: TLVTypeUniqueID, Value: []byte{1, 2, 3}}}
+
+	header, err := parseProxyHeader(bufio.NewReader(strings.NewReader(string(buildV2Header(proxyV2CommandProxy, "198.51.100.7", "10.0.0.1", 4000, 443, tlvs, true)))))
+	require.NoError(t, err)
+	authority, ok := header.TLV(TLVTypeAuthority)
+	assert.True(t, ok)
+	assert.Equal(t, "example.com", string(authority))
+	uniqueID, ok := header.TLV(TLVTypeUniqueID)
+	assert.True(t, ok)
+	assert.Equal(t, []byte{1, 2, 3}, uniqueID)
+
+	corrupted := buildV2Header(proxyV2CommandProxy, "198.51.100.7", "10.0.0.1", 4000, 443, tlvs, true)
+	corrupted[proxyV2HeaderLen] ^= 0xff // 篡改源地址
+	_, err = parseProxyHeader(bufio.NewReader(strings.NewReader(string(corrupted))))
+	assert.Error(t, err, "CRC32C 不匹配的头部应被拒绝")
+}
+
+func TestParseProxyHeader_Invalid(t *testing.T) {
+	testCases := map[string]string{
+		"没有 PROXY 头": "GET / HTTP/1.1\r\n\r\n",
+		"缺少 CRLF":    "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\n",
+		"地址族不匹配":     "PROXY TCP4 2001:db8::1 192.0.2.2 56324 443\r\n",
+		"端口越界":       "PROXY TCP4 192.0.2.1 192.0.2.2 65536 443\r\n",
+		"字段缺失":       "PROXY TCP4 192.0.2.1 192.0.2.2 56324\r\n",
+		"v1 头过长":     "PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n",
+		"v2 头被截断":    string(buildV2Header(proxyV2CommandProxy, "198.51.100.7", "10.0.0.1", 4000, 443, nil, false)[:20]),
+	}
+	// TLV 声明的长度超出头部
+	overflow := buildV2Header(proxyV2CommandProxy, "198.51.100.7", "10.0.0.1", 4000, 443, []TLV{{Type: TLVTypeNoop, Value: []byte{0}}}, false)
+	overflow[proxyV2HeaderLen+12+2] = 9
+	testCases["v2 TLV 长度越界"] = string(overflow)
+
+	for name, input := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseProxyHeader(bufio.NewReader(strings.NewReader(input)))
+			assert.Error(t, err)
+		})
+	}
+}
+
+// startProxyProtocolServer 启动一个使用 PROXY protocol 监听器的 HTTP 服务器，响应体为请求的 RemoteAddr。
+func startProxyProtocolServer(t *testing.T, allowed []string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ppln, err := NewProxyProtocolListener(ln, configs.ProxyProtocolConfig{AllowedSources: allowed, HeaderTimeout: time.Second})
+	require.NoError(t, err)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.RemoteAddr)
+	})}
+	go srv.Serve(ppln)
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String()
+}
+
+// sendRequest 建立连接，先写入 prefix 再发送一个 HTTP 请求，返回响应体；连接被关闭时返回错误。
+func sendRequest(t *testing.T, addr, prefix string) (string, error) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	io.WriteString(conn, prefix+"GET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	t.Run("允许的来源使用 PROXY 头中的客户端地址", func(t *testing.T) {
+		addr := startProxyProtocolServer(t, []string{"127.0.0.0/8"})
+		body, err := sendRequest(t, addr, "PROXY TCP4 198.51.100.7 10.0.0.1 4000 443\r\n")
+		require.NoError(t, err)
+		assert.Equal(t, "198.51.100.7:4000", body)
+
+		body, err = sendRequest(t, addr, string(buildV2Header(proxyV2CommandProxy, "203.0.113.9", "10.0.0.1", 5000, 443, nil, true)))
+		require.NoError(t, err)
+		assert.Equal(t, "203.0.113.9:5000", body)
+	})
+
+	t.Run("LOCAL 命令使用连接的真实地址", func(t *testing.T) {
+		addr := startProxyProtocolServer(t, []string{"127.0.0.1"})
+		body, err := sendRequest(t, addr, string(buildV2Header(proxyV2CommandLocal, "0.0.0.0", "0.0.0.0", 0, 0, nil, false)))
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(body, "127.0.0.1:"), body)
+	})
+
+	t.Run("允许的来源未发送 PROXY 头时关闭连接", func(t *testing.T) {
+		addr := startProxyProtocolServer(t, []string{"127.0.0.0/8"})
+		_, err := sendRequest(t, addr, "")
+		assert.Error(t, err)
+	})
+
+	t.Run("其他来源的连接不解析 PROXY 头", func(t *testing.T) {
+		addr := startProxyProtocolServer(t, []string{"10.0.0.0/8"})
+		body, err := sendRequest(t, addr, "")
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(body, "127.0.0.1:"), body)
+
+		// 不受信任的客户端发送的 PROXY 头不会被采信
+		body, _ = sendRequest(t, addr, "PROXY TCP4 198.51.100.7 10.0.0.1 4000 443\r\n")
+		assert.NotContains(t, body, "198.51.100.7")
+	})
+}
+
+func TestNewProxyProtocolListener_InvalidConfig(t *testing.T) {
+	_, err := NewProxyProtocolListener(nil, configs.ProxyProtocolConfig{Enabled: true})
+	assert.Error(t, err, "未配置 allowed_sources 时应拒绝启动")
+	_, err = NewProxyProtocolListener(nil, configs.ProxyProtocolConfig{Enabled: true, AllowedSources: []string{"not-a-cidr"}})
+	assert.Error(t, err)
+}
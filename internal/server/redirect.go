@@ -0,0 +1,51 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package server
+
+import (
+	"goga/internal/middleware"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// acmeChallengePrefix 是 ACME HTTP-01 验证请求的路径前缀，证书签发方只会通过 80 端口的明文 HTTP 访问它
+const acmeChallengePrefix = "/.well-known/acme-challenge/"
+
+// NewRedirectHandler 返回把请求重定向到 HTTPS 的处理器，路径以 passthrough 中任一前缀开头的请求交给 next 处理。
+// GET 和 HEAD 请求返回 301，其他方法返回 308 以保留请求方法和请求体。
+func NewRedirectHandler(httpsPort string, passthrough []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, prefix := range passthrough {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		} else {
+			host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		}
+		if host == "" {
+			middleware.WriteJSONError(w, r, http.StatusBadRequest, "MISSING_HOST", "请求缺少 Host")
+			return
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]" // IPv6 地址
+		}
+
+		code := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			code = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), code)
+	})
+}
@@ -0,0 +1,237 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"goga/configs"
+	"goga/internal/middleware"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// 监听器的角色，即 server.listeners[].role 可选的值
+const (
+	RoleServe    = "serve"    // 处理业务流量
+	RoleRedirect = "redirect" // 把请求重定向到 HTTPS，ACME 验证请求除外
+	RoleAdmin    = "admin"    // 只提供健康检查、上游状态等运维接口
+)
+
+// 客户端证书认证的模式，即 client_auth.mode 可选的值
+const (
+	clientAuthNone     = "none"
+	clientAuthOptional = "optional"
+	clientAuthRequire  = "require"
+)
+
+// Handlers 是各角色的监听器使用的处理器
+type Handlers struct {
+	Serve http.Handler // 网关的完整处理器链，serve 监听器和 redirect 监听器的透传路径使用
+	Admin http.Handler // 运维接口
+}
+
+// Server 是一个已创建的监听器及其 HTTP 服务器。
+type Server struct {
+	Config   configs.ListenerConfig
+	listener net.Listener
+	http     *http.Server
+	certs    *CertStore // 未启用 TLS 时为 nil
+}
+
+// New 按配置创建所有监听器及其 HTTP 服务器。监听器在启动服务前全部创建，
+// 任一监听器创建失败时关闭已创建的监听器并返回错误，端口被占用等问题可以在启动时立即发现。
+func New(cfg configs.ServerConfig, handlers Handlers) ([]*Server, error) {
+	listeners := Listeners(cfg)
+	for i := range listeners {
+		lc := &listeners[i]
+		lc.Role = strings.ToLower(lc.Role)
+		if lc.Role == "" {
+			lc.Role = RoleServe
+		}
+		if lc.Name == "" {
+			lc.Name = lc.Listen
+		}
+		if err := validateListener(*lc); err != nil {
+			return nil, err
+		}
+	}
+
+	servers := make([]*Server, 0, len(listeners))
+	for _, lc := range listeners {
+		var handler http.Handler
+		switch lc.Role {
+		case RoleServe:
+			handler = handlers.Serve
+		case RoleRedirect:
+			httpsPort := lc.HTTPSPort
+			if httpsPort == "" {
+				httpsPort = defaultHTTPSPort(listeners)
+			}
+			passthrough := lc.PassthroughPrefixes
+			if passthrough == nil {
+				passthrough = []string{acmeChallengePrefix}
+			}
+			handler = NewRedirectHandler(httpsPort, passthrough, handlers.Serve)
+		case RoleAdmin:
+			handler = middleware.AdminListener(handlers.Admin)
+		}
+
+		srv := &Server{Config: lc, http: NewHTTPServer(lc, handler)}
+		if pairs := certificatePairs(lc); len(pairs) > 0 {
+			certs, err := NewCertStore(lc.Name, pairs)
+			if err != nil {
+				closeServers(servers)
+				return nil, fmt.Errorf("监听器 %s: %w", lc.Name, err)
+			}
+			if err := certs.Watch(); err != nil {
+				slog.Warn("无法监听证书文件的变化，证书更新后需要重启", "listener", lc.Name, "error", err)
+			}
+			srv.certs = certs
+			srv.http.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate}
+			if err := configureClientAuth(srv.http.TLSConfig, lc.ClientAuth); err != nil {
+				certs.Close()
+				closeServers(servers)
+				return nil, fmt.Errorf("监听器 %s: %w", lc.Name, err)
+			}
+		}
+
+		ln, err := Listen(lc)
+		if err != nil {
+			if srv.certs != nil {
+				srv.certs.Close()
+			}
+			closeServers(servers)
+			return nil, fmt.Errorf("监听器 %s: %w", lc.Name, err)
+		}
+		srv.listener = ln
+		servers = append(servers, srv)
+	}
+	closeUnusedInherited()
+	return servers, nil
+}
+
+// configureClientAuth 按 client_auth 配置设置客户端证书校验。
+// optional 模式下未出示证书的客户端仍可连接，由路由或密钥分发策略决定是否需要证书。
+func configureClientAuth(tlsConfig *tls.Config, cfg configs.ClientAuthConfig) error {
+	mode := strings.ToLower(cfg.Mode)
+	if mode == "" || mode == clientAuthNone {
+		return nil
+	}
+	if cfg.CAFile == "" {
+		return errors.New("启用 client_auth 时必须配置 ca_file")
+	}
+	pem, err := os.ReadFile(cfg.CAFile)
+	if err != nil {
+		return fmt.Errorf("无法读取客户端 CA 证书 %s: %w", cfg.CAFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("客户端 CA 证书 %s 中没有有效的 PEM 证书", cfg.CAFile)
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	if mode == clientAuthRequire {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return nil
+}
+
+// closeServers 关闭尚未启动的服务器的监听器和证书。
+func closeServers(servers []*Server) {
+	for _, s := range servers {
+		s.listener.Close()
+		if s.certs != nil {
+			s.certs.Close()
+		}
+	}
+}
+
+// certificatePairs 返回监听器配置的全部证书，tls_cert_path 配置的证书在最前面作为默认证书。
+func certificatePairs(lc configs.ListenerConfig) []configs.CertificateConfig {
+	var pairs []configs.CertificateConfig
+	if lc.TLSCertPath != "" {
+		pairs = append(pairs, configs.CertificateConfig{CertFile: lc.TLSCertPath, KeyFile: lc.TLSKeyPath})
+	}
+	return append(pairs, lc.Certificates...)
+}
+
+// validateListener 校验单个监听器的配置。
+func validateListener(lc configs.ListenerConfig) error {
+	if lc.Listen == "" {
+		return fmt.Errorf("监听器 %s 缺少 listen", lc.Name)
+	}
+	switch lc.Role {
+	case RoleServe, RoleRedirect, RoleAdmin:
+	default:
+		return fmt.Errorf("监听器 %s 的 role 无效: %q，可选值为 serve、redirect、admin", lc.Name, lc.Role)
+	}
+	if (lc.TLSCertPath == "") != (lc.TLSKeyPath == "") {
+		return fmt.Errorf("监听器 %s 需要同时配置 tls_cert_path 和 tls_key_path", lc.Name)
+	}
+	switch strings.ToLower(lc.ClientAuth.Mode) {
+	case "", clientAuthNone:
+	case clientAuthOptional, clientAuthRequire:
+		if len(certificatePairs(lc)) == 0 {
+			return fmt.Errorf("监听器 %s 未启用 TLS，不能配置 client_auth", lc.Name)
+		}
+	default:
+		return fmt.Errorf("监听器 %s 的 client_auth.mode 无效: %q，可选值为 none、optional、require", lc.Name, lc.ClientAuth.Mode)
+	}
+	for _, pair := range lc.Certificates {
+		if pair.CertFile == "" || pair.KeyFile == "" {
+			return fmt.Errorf("监听器 %s 的 certificates 需要同时配置 cert_file 和 key_file", lc.Name)
+		}
+	}
+	return nil
+}
+
+// defaultHTTPSPort 返回第一个启用 TLS 的 serve 监听器的端口，没有时返回 443。
+func defaultHTTPSPort(listeners []configs.ListenerConfig) string {
+	for _, lc := range listeners {
+		if lc.Role != RoleServe || len(certificatePairs(lc)) == 0 || strings.HasPrefix(lc.Listen, unixAddrPrefix) {
+			continue
+		}
+		if _, port, err := net.SplitHostPort(lc.Listen); err == nil && port != "" {
+			return port
+		}
+	}
+	return "443"
+}
+
+// Addr 返回实际监听的地址。
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// TLS 判断监听器是否启用了 TLS。
+func (s *Server) TLS() bool {
+	return s.certs != nil
+}
+
+// Serve 开始处理请求，直到服务器被关闭。正常关闭时返回 http.ErrServerClosed。
+// TLS 证书由 CertStore 在每次握手时提供，因此这里不传入证书文件。
+func (s *Server) Serve() error {
+	if s.TLS() {
+		return s.http.ServeTLS(s.listener, "", "")
+	}
+	return s.http.Serve(s.listener)
+}
+
+// Shutdown 平滑关闭服务器，等待正在处理的请求完成，然后停止监听证书文件。
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.http.Shutdown(ctx)
+	if s.certs != nil {
+		s.certs.Close()
+	}
+	return err
+}
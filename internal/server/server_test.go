@@ -0,0 +1,209 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"goga/configs"
+	"goga/internal/middleware"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// textHandler 返回一个以固定文本响应的处理器。
+func textHandler(text string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, text)
+	})
+}
+
+func TestNew_ListenerRoles(t *testing.T) {
+	cfg := configs.ServerConfig{Listeners: []configs.ListenerConfig{
+		{Name: "public", Listen: "127.0.0.1:0"},
+		{Name: "http", Listen: "127.0.0.1:0", Role: "redirect", HTTPSPort: "8443"},
+		{Name: "internal", Listen: "127.0.0.1:0", Role: "ADMIN"},
+	}}
+	servers, err := New(cfg, Handlers{Serve: textHandler("serve"), Admin: textHandler("admin")})
+	require.NoError(t, err)
+	require.Len(t, servers, 3)
+	for _, srv := range servers {
+		go srv.Serve()
+		defer srv.Shutdown(context.Background())
+	}
+	assert.Equal(t, RoleServe, servers[0].Config.Role, "未配置 role 时默认为 serve")
+	assert.Equal(t, RoleAdmin, servers[2].Config.Role)
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	get := func(srv *Server, path string) (*http.Response, string) {
+		resp, err := client.Get("http://" + srv.Addr() + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	_, body := get(servers[0], "/")
+	assert.Equal(t, "serve", body)
+
+	resp, _ := get(servers[1], "/login?next=%2F")
+	assert.Equal(t, http.StatusMovedPermanently, resp.StatusCode)
+	assert.Equal(t, "https://127.0.0.1:8443/login?next=%2F", resp.Header.Get("Location"))
+	_, body = get(servers[1], "/.well-known/acme-challenge/token")
+	assert.Equal(t, "serve", body, "ACME 验证请求应透传给 serve 处理器")
+
+	_, body = get(servers[2], "/")
+	assert.Equal(t, "admin", body)
+}
+
+func TestNew_LegacyListener(t *testing.T) {
+	servers, err := New(configs.ServerConfig{Listen: "127.0.0.1:0", H2C: true}, Handlers{Serve: textHandler("serve")})
+	require.NoError(t, err)
+	require.Len(t, servers, 1)
+	defer servers[0].listener.Close()
+	assert.Equal(t, RoleServe, servers[0].Config.Role)
+	assert.True(t, servers[0].Config.H2C, "未配置 listeners 时沿用 server 下的单监听器配置")
+}
+
+func TestNew_InvalidListener(t *testing.T) {
+	testCases := map[string]configs.ListenerConfig{
+		"缺少 listen": {Role: "serve"},
+		"无效的 role":  {Listen: "127.0.0.1:0", Role: "proxy"},
+		"只配置了证书":    {Listen: "127.0.0.1:0", TLSCertPath: "cert.pem"},
+		"地址无法监听":    {Listen: "256.0.0.1:0"},
+	}
+	for name, lc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := New(configs.ServerConfig{Listeners: []configs.ListenerConfig{lc}}, Handlers{})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestDefaultHTTPSPort(t *testing.T) {
+	assert.Equal(t, "443", defaultHTTPSPort(nil))
+	assert.Equal(t, "8443", defaultHTTPSPort([]configs.ListenerConfig{
+		{Listen: ":8080", Role: RoleServe},
+		{Listen: ":9443", Role: RoleAdmin, TLSCertPath: "cert.pem"},
+		{Listen: ":8443", Role: RoleServe, TLSCertPath: "cert.pem"},
+	}))
+}
+
+func TestRedirectHandler(t *testing.T) {
+	testCases := []struct {
+		name      string
+		method    string
+		url       string
+		httpsPort string
+		status    int
+		location  string
+	}{
+		{"GET 返回 301", http.MethodGet, "http://example.com/a/b?c=d", "443", http.StatusMovedPermanently, "https://example.com/a/b?c=d"},
+		{"POST 返回 308", http.MethodPost, "http://example.com/form", "443", http.StatusPermanentRedirect, "https://example.com/form"},
+		{"去掉原端口并使用 HTTPS 端口", http.MethodGet, "http://example.com:8080/", "8443", http.StatusMovedPermanently, "https://example.com:8443/"},
+		{"IPv6 地址", http.MethodGet, "http://[::1]:80/", "443", http.StatusMovedPermanently, "https://[::1]/"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := NewRedirectHandler(tc.httpsPort, []string{acmeChallengePrefix}, textHandler("serve"))
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.url, nil))
+			assert.Equal(t, tc.status, rr.Code)
+			assert.Equal(t, tc.location, rr.Header().Get("Location"))
+		})
+	}
+
+	rr := httptest.NewRecorder()
+	handler := NewRedirectHandler("", []string{acmeChallengePrefix}, textHandler("serve"))
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://example.com/.well-known/acme-challenge/abc", nil))
+	assert.Equal(t, "serve", rr.Body.String())
+}
+
+func TestNew_ClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	expiry := time.Now().Add(time.Hour)
+	serverCert := writeCertificate(t, dir, "server", expiry, "gateway.example.com")
+	partner := writeCertificate(t, dir, "partner", expiry, "partner.example.com")
+	stranger := writeCertificate(t, dir, "stranger", expiry, "stranger.example.com")
+	partnerPair, err := tls.LoadX509KeyPair(partner.CertFile, partner.KeyFile)
+	require.NoError(t, err)
+	strangerPair, err := tls.LoadX509KeyPair(stranger.CertFile, stranger.KeyFile)
+	require.NoError(t, err)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, ok := middleware.ClientCert(r); ok {
+			io.WriteString(w, id.CommonName)
+		}
+	})
+
+	for _, mode := range []string{"optional", "require"} {
+		t.Run(mode, func(t *testing.T) {
+			cfg := configs.ServerConfig{Listeners: []configs.ListenerConfig{{
+				Listen:      "127.0.0.1:0",
+				TLSCertPath: serverCert.CertFile,
+				TLSKeyPath:  serverCert.KeyFile,
+				ClientAuth:  configs.ClientAuthConfig{Mode: mode, CAFile: partner.CertFile},
+			}}}
+			servers, err := New(cfg, Handlers{Serve: handler})
+			require.NoError(t, err)
+			srv := servers[0]
+			go srv.Serve()
+			defer srv.Shutdown(context.Background())
+
+			get := func(certs ...tls.Certificate) (string, error) {
+				client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true, Certificates: certs}}}
+				resp, err := client.Get("https://" + srv.Addr())
+				if err != nil {
+					return "", err
+				}
+				defer resp.Body.Close()
+				body, err := io.ReadAll(resp.Body)
+				return string(body), err
+			}
+
+			body, err := get(partnerPair)
+			require.NoError(t, err)
+			assert.Equal(t, "partner.example.com", body, "经过校验的客户端身份应传递给处理器")
+
+			// 客户端只会出示由服务端声明的 CA 签发的证书，其他证书等同于未出示
+			for _, certs := range [][]tls.Certificate{{strangerPair}, nil} {
+				body, err = get(certs...)
+				if mode == "require" {
+					assert.Error(t, err, "require 模式下必须出示受信任的证书")
+				} else {
+					require.NoError(t, err)
+					assert.Empty(t, body, "optional 模式下未出示受信任证书的客户端仍可连接，但没有身份")
+				}
+			}
+		})
+	}
+}
+
+func TestNew_InvalidClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	cert := writeCertificate(t, dir, "server", time.Now().Add(time.Hour), "example.com")
+	tlsListener := func(auth configs.ClientAuthConfig) configs.ListenerConfig {
+		return configs.ListenerConfig{Listen: "127.0.0.1:0", TLSCertPath: cert.CertFile, TLSKeyPath: cert.KeyFile, ClientAuth: auth}
+	}
+	testCases := map[string]configs.ListenerConfig{
+		"无效的模式":     tlsListener(configs.ClientAuthConfig{Mode: "always", CAFile: cert.CertFile}),
+		"缺少 CA":     tlsListener(configs.ClientAuthConfig{Mode: "require"}),
+		"CA 文件不是证书": tlsListener(configs.ClientAuthConfig{Mode: "require", CAFile: cert.KeyFile}),
+		"明文监听器":     {Listen: "127.0.0.1:0", ClientAuth: configs.ClientAuthConfig{Mode: "optional", CAFile: cert.CertFile}},
+	}
+	for name, lc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := New(configs.ServerConfig{Listeners: []configs.ListenerConfig{lc}}, Handlers{})
+			assert.Error(t, err)
+		})
+	}
+}
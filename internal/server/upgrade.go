@@ -0,0 +1,327 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goga/internal/systemd"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 平滑升级时旧进程通过环境变量告诉新进程继承的监听器和就绪通知的管道
+const (
+	// envInheritedListeners 是继承的监听器列表 (JSON)，第 i 个监听器的文件描述符为 3+i
+	envInheritedListeners = "GOGA_INHERITED_LISTENERS"
+	// envUpgradeReadyFD 是就绪通知管道写端的文件描述符，新进程所有监听器开始服务后写入一个字节
+	envUpgradeReadyFD = "GOGA_UPGRADE_READY_FD"
+)
+
+// inheritedListener 描述一个由旧进程传入的监听器
+type inheritedListener struct {
+	Name   string `json:"name"`
+	Listen string `json:"listen"`
+}
+
+// activatedListener 是 systemd 套接字激活传入的监听器
+type activatedListener struct {
+	name string // FileDescriptorName= 配置的名称
+	ln   net.Listener
+}
+
+var (
+	inheritOnce sync.Once
+	inheritMu   sync.Mutex
+	inherited   map[inheritedListener]*os.File // 尚未被使用的继承监听器
+	activated   []activatedListener            // 尚未被使用的套接字激活监听器
+	readyPipe   *os.File                       // 非平滑升级启动时为 nil
+)
+
+// loadInherited 读取旧进程或 systemd 传入的监听器和就绪通知管道，并清除相应的环境变量，避免被后续启动的子进程误用
+func loadInherited() {
+	inheritOnce.Do(func() {
+		for _, lf := range systemd.ListenFiles() {
+			ln, err := net.FileListener(lf.File)
+			lf.File.Close()
+			if err != nil {
+				slog.Warn("无法使用 systemd 传入的套接字", "name", lf.Name, "error", err)
+				continue
+			}
+			activated = append(activated, activatedListener{name: lf.Name, ln: ln})
+		}
+		if fd, err := strconv.Atoi(os.Getenv(envUpgradeReadyFD)); err == nil && fd > 2 {
+			readyPipe = os.NewFile(uintptr(fd), "goga-upgrade-ready")
+		}
+		var listeners []inheritedListener
+		if v := os.Getenv(envInheritedListeners); v != "" {
+			if err := json.Unmarshal([]byte(v), &listeners); err != nil {
+				slog.Warn("无法解析继承的监听器，重新创建监听器", "error", err)
+				listeners = nil
+			}
+		}
+		inherited = make(map[inheritedListener]*os.File, len(listeners))
+		for i, l := range listeners {
+			inherited[l] = os.NewFile(uintptr(3+i), "goga-listener-"+l.Name)
+		}
+		os.Unsetenv(envInheritedListeners)
+		os.Unsetenv(envUpgradeReadyFD)
+	})
+}
+
+// takeInherited 返回旧进程传入的、名称和监听地址都与 lc 相同的监听器，
+// 或者 systemd 传入的、FileDescriptorName= 与名称相同或地址与监听地址相同的监听器。没有时返回 nil
+func takeInherited(name, listen string) (net.Listener, error) {
+	loadInherited()
+	inheritMu.Lock()
+	defer inheritMu.Unlock()
+	key := inheritedListener{Name: name, Listen: listen}
+	f, ok := inherited[key]
+	if !ok {
+		return takeActivated(name, listen), nil
+	}
+	delete(inherited, key)
+	defer f.Close() // FileListener 复制了文件描述符
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("无法使用继承的监听器 %s: %w", listen, err)
+	}
+	return ln, nil
+}
+
+// takeActivated 返回与监听器名称或监听地址匹配的套接字激活监听器，调用方需持有 inheritMu
+func takeActivated(name, listen string) net.Listener {
+	i := slices.IndexFunc(activated, func(a activatedListener) bool { return a.name == name })
+	if i < 0 {
+		i = slices.IndexFunc(activated, func(a activatedListener) bool { return addrMatches(a.ln.Addr(), listen) })
+	}
+	if i < 0 {
+		return nil
+	}
+	ln := activated[i].ln
+	activated = slices.Delete(activated, i, i+1)
+	return ln
+}
+
+// addrMatches 判断监听器的实际地址是否就是 listen 配置的地址。未指定主机的 TCP 地址匹配任意地址 (0.0.0.0 或 ::)
+func addrMatches(addr net.Addr, listen string) bool {
+	if socket, ok := strings.CutPrefix(listen, unixAddrPrefix); ok {
+		return addr.Network() == "unix" && addr.String() == socket
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	want, err := net.ResolveTCPAddr("tcp", listen)
+	if err != nil || want.Port != tcpAddr.Port {
+		return false
+	}
+	if want.IP == nil {
+		return tcpAddr.IP.IsUnspecified()
+	}
+	return want.IP.Equal(tcpAddr.IP)
+}
+
+// closeUnusedInherited 关闭新配置中已不存在的继承监听器和没有对应配置的套接字激活监听器
+func closeUnusedInherited() {
+	loadInherited()
+	inheritMu.Lock()
+	defer inheritMu.Unlock()
+	for l, f := range inherited {
+		slog.Info("新配置中不再包含继承的监听器，已关闭", "listener", l.Name, "address", l.Listen)
+		f.Close()
+		delete(inherited, l)
+	}
+	for _, a := range activated {
+		slog.Warn("systemd 传入的套接字没有对应的监听器配置，已关闭", "name", a.name, "address", a.ln.Addr().String())
+		a.ln.Close()
+	}
+	activated = nil
+}
+
+// NotifyReady 在所有监听器开始服务后调用。由平滑升级启动时通知旧进程退出，否则什么也不做
+func NotifyReady() error {
+	loadInherited()
+	if readyPipe == nil {
+		return nil
+	}
+	defer func() { readyPipe = nil }()
+	_, err := readyPipe.Write([]byte{1})
+	if cerr := readyPipe.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// rawListener 返回去掉 PROXY protocol 包装后的监听器
+func rawListener(ln net.Listener) net.Listener {
+	if pp, ok := ln.(*ProxyProtocolListener); ok {
+		return pp.Listener
+	}
+	return ln
+}
+
+// dupListener 复制监听器的文件描述符。这里不使用 TCPListener.File()：通过 exec.Cmd 传递 *os.File 时会调用 Fd()，
+// 把与当前进程共享的套接字切换为阻塞模式，之后关闭监听器会卡在 accept 上
+func dupListener(ln net.Listener) (int, error) {
+	sc, ok := rawListener(ln).(syscall.Conn)
+	if !ok {
+		return -1, errors.New("不支持传递给新进程")
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	dup, dupErr := -1, error(nil)
+	// 与 os/exec 相同，持有 ForkLock 直到设置 close-on-exec，避免描述符泄漏给同时启动的其他子进程
+	syscall.ForkLock.RLock()
+	defer syscall.ForkLock.RUnlock()
+	if err := rc.Control(func(fd uintptr) {
+		if dup, dupErr = syscall.Dup(int(fd)); dupErr == nil {
+			syscall.CloseOnExec(dup)
+		}
+	}); err != nil {
+		return -1, err
+	}
+	return dup, dupErr
+}
+
+// Upgrade 以相同的参数启动当前可执行文件的新版本，把 servers 的监听器交给新进程，
+// 并等待新进程在 readyTimeout 内报告就绪。监听套接字在两个进程间共享，升级期间不会拒绝连接。
+// 返回 nil 后调用方应平滑关闭 servers 并退出；返回错误时新进程已被终止，当前进程继续服务。
+func Upgrade(servers []*Server, readyTimeout time.Duration) (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("无法确定可执行文件路径: %w", err)
+	}
+
+	listeners := make([]inheritedListener, 0, len(servers))
+	var fds []int
+	defer func() {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+	}()
+	for _, s := range servers {
+		fd, err := dupListener(s.listener)
+		if err != nil {
+			return 0, fmt.Errorf("无法复制监听器 %s: %w", s.Config.Name, err)
+		}
+		fds = append(fds, fd)
+		listeners = append(listeners, inheritedListener{Name: s.Config.Name, Listen: s.Config.Listen})
+	}
+	encoded, err := json.Marshal(listeners)
+	if err != nil {
+		return 0, err
+	}
+
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer ready.Close()
+
+	files := []uintptr{os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd()}
+	for _, fd := range fds {
+		files = append(files, uintptr(fd))
+	}
+	files = append(files, readyW.Fd())
+	env := append(upgradeEnviron(),
+		envInheritedListeners+"="+string(encoded),
+		envUpgradeReadyFD+"="+strconv.Itoa(3+len(listeners)),
+	)
+	pid, err := syscall.ForkExec(exe, os.Args, &syscall.ProcAttr{Env: env, Files: files})
+	// 关闭当前进程持有的管道写端，新进程退出时读端会读到 EOF
+	readyW.Close()
+	if err != nil {
+		return 0, fmt.Errorf("无法启动新进程: %w", err)
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return 0, err
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		state, err := process.Wait()
+		if err == nil && !state.Success() {
+			err = errors.New(state.String())
+		}
+		exited <- err
+	}()
+	if err := waitReady(ready, readyTimeout); err != nil {
+		process.Kill()
+		if werr := <-exited; werr != nil {
+			err = fmt.Errorf("%w (%v)", err, werr)
+		}
+		return 0, err
+	}
+
+	// 新进程已经接管监听器，当前进程关闭时不能删除仍在使用的 Unix 套接字文件
+	for _, s := range servers {
+		if ul, ok := rawListener(s.listener).(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	return pid, nil
+}
+
+// waitReady 等待新进程通过管道报告就绪
+func waitReady(ready *os.File, timeout time.Duration) error {
+	if err := ready.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	buf := make([]byte, 1)
+	n, err := ready.Read(buf)
+	switch {
+	case n == 1:
+		return nil
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return fmt.Errorf("新进程未在 %s 内就绪", timeout)
+	default:
+		return errors.New("新进程在就绪前退出")
+	}
+}
+
+// upgradeEnviron 返回去掉上一次升级遗留变量后的当前环境变量。
+// WATCHDOG_PID 指向当前进程，去掉后新进程会把 WATCHDOG_USEC 视为自己的看门狗超时，接替发送心跳
+func upgradeEnviron() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if name == envInheritedListeners || name == envUpgradeReadyFD || name == "WATCHDOG_PID" {
+			continue
+		}
+		env = append(env, kv)
+	}
+	return env
+}
+
+// WritePIDFile 把当前进程的 PID 写入 path。先写入临时文件再重命名，读取方不会读到不完整的内容
+func WritePIDFile(path string) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// RemovePIDFile 在 path 仍记录着当前进程的 PID 时删除它。平滑升级后文件已由新进程改写，不会被删除
+func RemovePIDFile(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil || strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	os.Remove(path)
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package server
+
+import (
+	"context"
+	"goga/configs"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// envUpgradeTestChild 指定测试二进制作为升级后的新进程运行时的行为: "serve" 或 "fail"
+const envUpgradeTestChild = "GOGA_TEST_UPGRADE_CHILD"
+
+// TestMain 在 Upgrade 重新执行测试二进制时扮演新进程
+func TestMain(m *testing.M) {
+	if mode := os.Getenv(envUpgradeTestChild); mode != "" && os.Getenv(envInheritedListeners) != "" {
+		runUpgradeChild(mode)
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// runUpgradeChild 使用继承的监听器提供服务，就绪后通知旧进程，直到被终止
+func runUpgradeChild(mode string) {
+	if mode == "fail" {
+		os.Exit(1)
+	}
+	loadInherited()
+	var cfg configs.ServerConfig
+	for l := range inherited {
+		cfg.Listeners = append(cfg.Listeners, configs.ListenerConfig{Name: l.Name, Listen: l.Listen})
+	}
+	servers, err := New(cfg, Handlers{Serve: textHandler("child")})
+	if err != nil {
+		os.Exit(2)
+	}
+	for _, srv := range servers {
+		go srv.Serve()
+	}
+	if err := NotifyReady(); err != nil {
+		os.Exit(3)
+	}
+	time.Sleep(time.Minute)
+	os.Exit(0)
+}
+
+// unixClient 返回通过 Unix 套接字连接网关的 HTTP 客户端
+func unixClient(socket string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return new(net.Dialer).DialContext(ctx, "unix", socket)
+		},
+	}}
+}
+
+// body 请求 url 并返回响应体
+func body(t *testing.T, client *http.Client, url string) string {
+	t.Helper()
+	resp, err := client.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestUpgrade_HandsOffListeners(t *testing.T) {
+	t.Setenv(envUpgradeTestChild, "serve")
+	socket := filepath.Join(t.TempDir(), "goga.sock")
+	cfg := configs.ServerConfig{Listeners: []configs.ListenerConfig{
+		{Name: "tcp", Listen: "127.0.0.1:0"},
+		{Name: "unix", Listen: unixAddrPrefix + socket},
+	}}
+	servers, err := New(cfg, Handlers{Serve: textHandler("parent")})
+	require.NoError(t, err)
+	for _, srv := range servers {
+		go srv.Serve()
+	}
+	tcpURL := "http://" + servers[0].Addr()
+	assert.Equal(t, "parent", body(t, http.DefaultClient, tcpURL))
+
+	pid, err := Upgrade(servers, 10*time.Second)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		if p, err := os.FindProcess(pid); err == nil {
+			p.Kill()
+		}
+	})
+
+	// 旧进程关闭后，同一个地址上的连接全部由新进程处理，Unix 套接字文件保留
+	for _, srv := range servers {
+		require.NoError(t, srv.Shutdown(t.Context()))
+	}
+	assert.Equal(t, "child", body(t, &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}, tcpURL))
+	assert.FileExists(t, socket)
+	assert.Equal(t, "child", body(t, unixClient(socket), "http://goga/"))
+}
+
+func TestUpgrade_ChildFails(t *testing.T) {
+	t.Setenv(envUpgradeTestChild, "fail")
+	servers, err := New(configs.ServerConfig{Listen: "127.0.0.1:0"}, Handlers{Serve: textHandler("parent")})
+	require.NoError(t, err)
+	go servers[0].Serve()
+	defer servers[0].Shutdown(context.Background())
+
+	_, err = Upgrade(servers, 10*time.Second)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "就绪前退出")
+	assert.Equal(t, "parent", body(t, http.DefaultClient, "http://"+servers[0].Addr()), "升级失败时旧进程继续服务")
+}
+
+func TestPIDFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "goga.pid")
+	require.NoError(t, WritePIDFile(file))
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(os.Getpid()), strings.TrimSpace(string(data)))
+
+	// 已被新进程改写的 PID 文件不会被删除
+	require.NoError(t, os.WriteFile(file, []byte("1\n"), 0o644))
+	RemovePIDFile(file)
+	assert.FileExists(t, file)
+
+	require.NoError(t, WritePIDFile(file))
+	RemovePIDFile(file)
+	assert.NoFileExists(t, file)
+}
+
+func TestAddrMatches(t *testing.T) {
+	tcp := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8443}
+	wildcard := &net.TCPAddr{IP: net.IPv6unspecified, Port: 443}
+	unix := &net.UnixAddr{Name: "/run/goga.sock", Net: "unix"}
+
+	assert.True(t, addrMatches(tcp, "127.0.0.1:8443"))
+	assert.False(t, addrMatches(tcp, "127.0.0.1:443"))
+	assert.False(t, addrMatches(tcp, ":8443"), "未指定主机的地址只匹配通配地址")
+	assert.True(t, addrMatches(wildcard, ":443"))
+	assert.True(t, addrMatches(wildcard, ":https"))
+	assert.True(t, addrMatches(unix, "unix:/run/goga.sock"))
+	assert.False(t, addrMatches(unix, "unix:/run/other.sock"))
+	assert.False(t, addrMatches(tcp, "unix:/run/goga.sock"))
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+// Package systemd 实现与 systemd 集成所需的协议：通过 $NOTIFY_SOCKET 发送就绪、停止和看门狗通知 (sd_notify)，
+// 以及接收套接字激活 (socket activation) 传入的监听器 (sd_listen_fds)。不在 systemd 下运行时这些函数什么也不做。
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sd_notify 的状态，可以用换行符组合多个
+const (
+	Ready    = "READY=1"    // 服务已经就绪
+	Stopping = "STOPPING=1" // 服务开始退出
+	Watchdog = "WATCHDOG=1" // 看门狗心跳
+)
+
+// listenFDsStart 是套接字激活传入的第一个文件描述符 (SD_LISTEN_FDS_START)
+const listenFDsStart = 3
+
+// Notify 向 $NOTIFY_SOCKET 发送状态。未设置 $NOTIFY_SOCKET (不是由 systemd 以 Type=notify 启动) 时返回 false 和 nil
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	// 以 @ 开头的是 Linux 抽象命名空间的套接字
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// MainPID 返回把当前进程声明为服务主进程的状态。平滑升级后由新进程发送，需要在 unit 中配置 NotifyAccess=all
+func MainPID() string {
+	return "MAINPID=" + strconv.Itoa(os.Getpid())
+}
+
+// WatchdogInterval 返回 systemd 要求的看门狗超时 (WatchdogSec=)。未启用看门狗，或看门狗针对的是其他进程时返回 0
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// ListenFile 是套接字激活传入的一个监听套接字
+type ListenFile struct {
+	Name string // FileDescriptorName= 配置的名称，未配置时为 "unknown"
+	File *os.File
+}
+
+// ListenFiles 返回套接字激活传入的监听套接字，并清除相应的环境变量，避免被子进程误用。
+// 没有传入套接字，或传入的套接字针对的是其他进程时返回 nil
+func ListenFiles() []ListenFile {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	fds := listenFDs(os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES"), os.Getpid())
+	files := make([]ListenFile, 0, len(fds))
+	for _, fd := range fds {
+		files = append(files, ListenFile{Name: fd.name, File: os.NewFile(uintptr(fd.fd), fd.name)})
+	}
+	return files
+}
+
+// listenFD 是一个套接字激活传入的文件描述符及其名称
+type listenFD struct {
+	fd   int
+	name string
+}
+
+// listenFDs 按 sd_listen_fds 的约定解析 LISTEN_PID、LISTEN_FDS 和 LISTEN_FDNAMES
+func listenFDs(listenPID, listenFDCount, listenFDNames string, pid int) []listenFD {
+	if p, err := strconv.Atoi(listenPID); err != nil || p != pid {
+		return nil
+	}
+	count, err := strconv.Atoi(listenFDCount)
+	if err != nil || count <= 0 {
+		return nil
+	}
+	names := strings.Split(listenFDNames, ":")
+	fds := make([]listenFD, count)
+	for i := range fds {
+		fds[i] = listenFD{fd: listenFDsStart + i, name: "unknown"}
+		if i < len(names) && names[i] != "" {
+			fds[i].name = names[i]
+		}
+	}
+	return fds
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	sent, err := Notify(Ready)
+	assert.False(t, sent, "未设置 NOTIFY_SOCKET 时不发送")
+	assert.NoError(t, err)
+
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", socket)
+
+	sent, err = Notify(Ready + "\n" + MainPID())
+	require.NoError(t, err)
+	assert.True(t, sent)
+	buf := make([]byte, 256)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "READY=1\nMAINPID="+strconv.Itoa(os.Getpid()), string(buf[:n]))
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", "")
+	assert.Equal(t, 30*time.Second, WatchdogInterval())
+
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	assert.Equal(t, 30*time.Second, WatchdogInterval())
+
+	t.Setenv("WATCHDOG_PID", "1")
+	assert.Zero(t, WatchdogInterval(), "看门狗针对其他进程")
+
+	t.Setenv("WATCHDOG_USEC", "")
+	t.Setenv("WATCHDOG_PID", "")
+	assert.Zero(t, WatchdogInterval())
+}
+
+func TestListenFDs(t *testing.T) {
+	assert.Equal(t, []listenFD{{3, "https"}, {4, "unknown"}, {5, "admin"}}, listenFDs("42", "3", "https::admin", 42))
+	assert.Equal(t, []listenFD{{3, "unknown"}}, listenFDs("42", "1", "", 42))
+	assert.Nil(t, listenFDs("41", "1", "", 42), "传给其他进程的套接字")
+	assert.Nil(t, listenFDs("", "1", "", 42))
+	assert.Nil(t, listenFDs("42", "0", "", 42))
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+// Package tracing 配置 OpenTelemetry 链路追踪，并提供网关各处创建 span 和传播 W3C traceparent 的工具函数。
+// 未启用追踪时使用 OpenTelemetry 的空实现：不记录任何 span，但客户端传入的 traceparent 仍会原样传播给后端。
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"goga/configs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 默认配置
+const (
+	defaultServiceName = "goga"
+	defaultTracesPath  = "/v1/traces"     // OTLP/HTTP 的标准路径
+	exportTimeout      = 10 * time.Second // 单次导出的超时时间
+)
+
+// tracerName 是网关创建 span 时使用的 instrumentation scope 名称
+const tracerName = "goga"
+
+// RequestIDKey 是 span 上记录网关请求 ID 的属性
+const RequestIDKey = attribute.Key("goga.request_id")
+
+func init() {
+	// 无论是否启用追踪都解析和传播 W3C traceparent / tracestate 以及 baggage
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// Setup 按配置初始化全局的 TracerProvider，span 以 OTLP/HTTP 批量导出。
+// 返回的函数在退出时调用，会导出尚未发送的 span 并关闭导出器；未启用追踪时返回空操作。
+func Setup(ctx context.Context, cfg configs.TracingConfig) (func(context.Context) error, error) {
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	endpoint, err := parseEndpoint(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		return nil, fmt.Errorf("tracing.sample_ratio 必须在 0 到 1 之间: %v", cfg.SampleRatio)
+	}
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+
+	exporter, err := otlptracehttp.New(ctx,
+		otlptracehttp.WithEndpointURL(endpoint),
+		otlptracehttp.WithHeaders(cfg.Headers),
+		otlptracehttp.WithTimeout(exportTimeout),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("无法创建 OTLP 导出器: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, fmt.Errorf("无法创建追踪资源: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		// 受信任的前置代理已经做出采样决定时沿用该决定，否则按比例采样。
+		// 不受信任的客户端传入的 trace 由 middleware.Tracing 以新的根 span 开始，不会影响采样
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	slog.Info("OpenTelemetry 链路追踪已启用", "endpoint", endpoint, "service_name", serviceName, "sample_ratio", cfg.SampleRatio)
+
+	return provider.Shutdown, nil
+}
+
+// parseEndpoint 校验 OTLP/HTTP 接收端地址，未指定路径时使用标准的 /v1/traces
+func parseEndpoint(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("无效的 tracing.endpoint，应为 http(s)://host:port 形式: %q", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = defaultTracesPath
+	}
+	return u.String(), nil
+}
+
+// Tracer 返回网关使用的 Tracer。每次调用都从全局 TracerProvider 获取，因此 Setup 之后创建的 span 会被导出
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// Start 创建一个子 span，是 Tracer().Start 的简写
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, opts...)
+}
+
+// Extract 从请求头中解析客户端或前置代理传入的 trace 上下文
+func Extract(ctx context.Context, header http.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// Inject 将 ctx 中当前 span 的 trace 上下文写入转发给后端的请求头 (traceparent、tracestate)
+func Inject(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// EndWithError 在 err 不为空时记录错误并将 span 标记为失败，然后结束 span
+func EndWithError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2025 wangke <464829928@qq.com>
+//
+// This software is released under the AGPL-3.0 license.
+// For more details, see the LICENSE file in the root directory.
+
+package tracing
+
+import (
+	"goga/configs"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestParseEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+		wantErr  bool
+	}{
+		{endpoint: "http://localhost:4318", want: "http://localhost:4318/v1/traces"},
+		{endpoint: "https://otel.example.com/", want: "https://otel.example.com/v1/traces"},
+		{endpoint: "http://collector:4318/custom/traces", want: "http://collector:4318/custom/traces"},
+		{endpoint: "localhost:4318", wantErr: true},
+		{endpoint: "grpc://collector:4317", wantErr: true},
+		{endpoint: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseEndpoint(tt.endpoint)
+		if tt.wantErr {
+			assert.Error(t, err, tt.endpoint)
+			continue
+		}
+		require.NoError(t, err, tt.endpoint)
+		assert.Equal(t, tt.want, got)
+	}
+}
+
+func TestSetup_InvalidConfig(t *testing.T) {
+	_, err := Setup(t.Context(), configs.TracingConfig{Enabled: true, Endpoint: "http://localhost:4318", SampleRatio: 1.5})
+	assert.Error(t, err)
+
+	shutdown, err := Setup(t.Context(), configs.TracingConfig{Enabled: false, Endpoint: "invalid"})
+	require.NoError(t, err, "未启用时不校验配置")
+	assert.NoError(t, shutdown(t.Context()))
+}
+
+func TestSetup_ExportsSpans(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		names    []string
+		service  string
+		apiToken string
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		var req collectortrace.ExportTraceServiceRequest
+		require.NoError(t, proto.Unmarshal(body, &req))
+
+		mu.Lock()
+		defer mu.Unlock()
+		apiToken = r.Header.Get("X-Api-Token")
+		for _, rs := range req.ResourceSpans {
+			for _, attr := range rs.Resource.Attributes {
+				if attr.Key == "service.name" {
+					service = attr.Value.GetStringValue()
+				}
+			}
+			for _, ss := range rs.ScopeSpans {
+				for _, span := range ss.Spans {
+					names = append(names, span.Name)
+				}
+			}
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	defer collector.Close()
+
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	shutdown, err := Setup(t.Context(), configs.TracingConfig{
+		Enabled:     true,
+		Endpoint:    collector.URL,
+		Headers:     map[string]string{"X-Api-Token": "secret"},
+		ServiceName: "goga-test",
+		SampleRatio: 1,
+	})
+	require.NoError(t, err)
+
+	_, span := Start(t.Context(), "goga.test")
+	assert.True(t, span.SpanContext().IsSampled())
+	span.End()
+	// 关闭时导出尚未发送的 span
+	require.NoError(t, shutdown(t.Context()))
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"goga.test"}, names)
+	assert.Equal(t, "goga-test", service)
+	assert.Equal(t, "secret", apiToken)
+}
+
+func TestInjectExtract(t *testing.T) {
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	in := http.Header{}
+	in.Set("traceparent", traceparent)
+
+	// 未启用追踪时，传入的 trace 上下文仍会原样传播给后端
+	out := http.Header{}
+	Inject(Extract(t.Context(), in), out)
+	assert.Equal(t, traceparent, out.Get("traceparent"))
+}
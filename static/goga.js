@@ -8,29 +8,106 @@
 (function() {
     'use strict';
 
-    // 全局配置，允许用户从外部定义要排除的URL
+    /**
+     * 读取网关注入的 JSON 配置块 (<script type="application/json" id="goga-config">)。
+     * 该配置由服务端根据 configs.Config 生成，保证客户端策略与服务端一致。
+     * @returns {object} 解析后的配置，读取失败时返回空对象。
+     */
+    function loadServerConfig() {
+        const el = document.getElementById('goga-config');
+        if (!el) {
+            return {};
+        }
+        try {
+            return JSON.parse(el.textContent) || {};
+        } catch (e) {
+            console.warn('GoGa: 无法解析服务端下发的配置，将使用默认配置。', e);
+            return {};
+        }
+    }
+
+    /**
+     * 将服务端下发的正则字符串编译为 RegExp，无法编译的规则会被忽略。
+     * @param {string[]} patterns 正则字符串列表。
+     * @returns {RegExp[]}
+     */
+    function toRegExps(patterns) {
+        const result = [];
+        for (const pattern of patterns || []) {
+            try {
+                result.push(new RegExp(pattern));
+            } catch (e) {
+                console.warn(`GoGa: 忽略无效的 URL 规则 "${pattern}"。`, e);
+            }
+        }
+        return result;
+    }
+
+    // 全局配置：以网关注入的配置为准，同时兼容页面手写的 window.gogaCryptoConfig
     // 示例: window.gogaCryptoConfig = { excludeUrls: ['/api/login', /^\/auth\//] };
+    const serverConfig = loadServerConfig();
+    const pageConfig = window.gogaCryptoConfig || {};
     const gogaCryptoConfig = {
-        excludeUrls: (window.gogaCryptoConfig && window.gogaCryptoConfig.excludeUrls) || [],
+        keyEndpoint: serverConfig.keyEndpoint || '/goga/api/v1/key',
+        methods: (serverConfig.methods || ['POST']).map(m => m.toUpperCase()),
+        excludeUrls: toRegExps(serverConfig.excludeUrls).concat(pageConfig.excludeUrls || []),
+        includeUrls: toRegExps(serverConfig.includeUrls).concat(pageConfig.includeUrls || []),
+        mustEncryptRoutes: toRegExps(serverConfig.mustEncryptRoutes),
     };
 
     /**
-     * 检查给定的URL是否应该被排除在加密之外。
+     * 检查 URL 是否匹配规则列表中的任意一项。
+     * 字符串规则按子串匹配；正则规则同时尝试匹配完整 URL 和路径 (服务端规则按路径编写)。
      * @param {string} url 要检查的URL。
-     * @returns {boolean} 如果URL应该被排除，则返回true。
+     * @param {Array<string|RegExp>} patterns 规则列表。
+     * @returns {boolean}
      */
-    function isUrlExcluded(url) {
-        for (const pattern of gogaCryptoConfig.excludeUrls) {
+    function matchesAny(url, patterns) {
+        let path = url;
+        try {
+            path = new URL(url, window.location.href).pathname;
+        } catch (e) {
+            // 无法解析时退化为使用原始 URL
+        }
+        for (const pattern of patterns) {
             if (typeof pattern === 'string' && url.includes(pattern)) {
                 return true;
             }
-            if (pattern instanceof RegExp && pattern.test(url)) {
+            if (pattern instanceof RegExp && (pattern.test(path) || pattern.test(url))) {
                 return true;
             }
         }
         return false;
     }
 
+    /**
+     * 判断对给定 URL 的请求是否应该加密。
+     * 服务端强制加密的路由总是加密；其余路由依次应用排除列表和包含列表。
+     * @param {string} method 请求方法。
+     * @param {string} url 请求的URL。
+     * @returns {boolean}
+     */
+    function shouldEncrypt(method, url) {
+        if (!method || !gogaCryptoConfig.methods.includes(method.toUpperCase())) {
+            return false;
+        }
+        if (url.includes(gogaCryptoConfig.keyEndpoint)) {
+            return false;
+        }
+        if (matchesAny(url, gogaCryptoConfig.mustEncryptRoutes)) {
+            return true;
+        }
+        if (matchesAny(url, gogaCryptoConfig.excludeUrls)) {
+            console.log(`GoGa: URL "${url}" 在排除列表中，跳过加密。`);
+            return false;
+        }
+        if (gogaCryptoConfig.includeUrls.length > 0 && !matchesAny(url, gogaCryptoConfig.includeUrls)) {
+            console.log(`GoGa: URL "${url}" 不在包含列表中，跳过加密。`);
+            return false;
+        }
+        return true;
+    }
+
     // First, check for a secure context.
     if (!window.crypto || !window.crypto.subtle) {
         console.error(
@@ -126,7 +203,7 @@
 
         console.log('GoGa: 缓存为空或已过期。正在获取新密钥...');
         // Use originalFetch to avoid interception loop
-        const keyResponse = await originalFetch('/goga/api/v1/key');
+        const keyResponse = await originalFetch(gogaCryptoConfig.keyEndpoint);
         if (!keyResponse.ok) {
             keyCache = { key: null, token: null, expires: 0 };
             throw new Error('goganokey');
@@ -177,20 +254,14 @@
     window.fetch = async function(...args) {
         const [url, options] = args;
 
-        const isApiPost = options && options.method && options.method.toUpperCase() === 'POST' &&
-                          options.body && typeof options.body === 'string' &&
-                          !url.toString().includes('/goga/api/v1/key');
-
-        if (isApiPost) {
-            // 检查URL是否在排除列表中
-            if (isUrlExcluded(url.toString())) {
-                console.log(`GoGa: URL "${url}" 在排除列表中，跳过加密。`);
-                return originalFetch(...args);
-            }
+        const method = (options && options.method) || 'GET';
+        const shouldIntercept = options && options.body && typeof options.body === 'string' &&
+                                shouldEncrypt(method, url.toString());
 
+        if (shouldIntercept) {
             try {
                 const originalContentType = (options.headers && (options.headers['Content-Type'] || options.headers['content-type'])) || 'application/json';
-                console.log(`GoGa: 拦截到对 "${url}" 的 fetch POST 请求。尝试加密。`);
+                console.log(`GoGa: 拦截到对 "${url}" 的 fetch ${method} 请求。尝试加密。`);
                 
                 const gogaPayload = await buildEncryptedPayload(options.body, originalContentType);
                 console.log('GoGa: fetch 请求体已加密。');
@@ -228,25 +299,17 @@
         const self = this;
         const url = self._goga_url;
 
-        const isApiPost = self._goga_method && self._goga_method.toUpperCase() === 'POST' &&
-            body && typeof body === 'string' &&
-            !url.toString().includes('/goga/api/v1/key');
+        const shouldIntercept = body && typeof body === 'string' &&
+            shouldEncrypt(self._goga_method, url.toString());
 
-        if (!isApiPost) {
+        if (!shouldIntercept) {
             return originalXhrSend.apply(self, arguments);
         }
 
-        // 检查URL是否在排除列表中
-        if (isUrlExcluded(url.toString())) {
-            console.log(`GoGa: URL "${url}" 在排除列表中，跳过加密。`);
-            originalXhrSend.apply(self, arguments);
-            return;
-        }
-
         (async function() {
             try {
                 const originalContentType = self._goga_headers['content-type'] || 'application/json';
-                console.log(`GoGa: 拦截到对 "${url}" 的 XHR POST 请求。尝试加密。`);
+                console.log(`GoGa: 拦截到对 "${url}" 的 XHR ${self._goga_method} 请求。尝试加密。`);
                 
                 const gogaPayload = await buildEncryptedPayload(body, originalContentType);
                 console.log('GoGa: XHR 请求体已加密。');
@@ -1 +1 @@
-!function(){"use strict";const t=window.gogaCryptoConfig&&window.gogaCryptoConfig.excludeUrls||[];function e(e){for(const n of t){if("string"==typeof n&&e.includes(n))return!0;if(n instanceof RegExp&&n.test(e))return!0}return!1}if(!window.crypto||!window.crypto.subtle)return;let n={key:null,token:null,expires:0};const o=window.fetch,r=XMLHttpRequest.prototype.open,a=XMLHttpRequest.prototype.send,i=XMLHttpRequest.prototype.setRequestHeader;async function s(t,e){const n=function(t){const e=window.atob(t),n=e.length,o=new Uint8Array(n);for(let t=0;t<n;t++)o[t]=e.charCodeAt(t);return o.buffer}(t),o=await window.crypto.subtle.importKey("raw",n,{name:"AES-GCM"},!1,["encrypt"]),r=window.crypto.getRandomValues(new Uint8Array(12)),a=await window.crypto.subtle.encrypt({name:"AES-GCM",iv:r},o,e),i=new Uint8Array(r.length+a.byteLength);return i.set(r,0),i.set(new Uint8Array(a),r.length),function(t){let e="";const n=new Uint8Array(t),o=n.byteLength;for(let t=0;t<o;t++)e+=String.fromCharCode(n[t]);return window.btoa(e)}(i.buffer)}async function c(){const t=Date.now();if(n.key&&n.token&&t<n.expires)return n;const e=await o("/goga/api/v1/key");if(!e.ok)throw n={key:null,token:null,expires:0},new Error("goganokey");const{key:r,token:a,ttl:i}=await e.json(),s=1e3*i*.8||24e4;return n={key:r,token:a,expires:Date.now()+s},n}async function p(t,e){const n=new TextEncoder,o=n.encode(e),r=n.encode(t);if(o.length>255)throw new Error("Content-Type header is too long (max 255 bytes).");const a=new Uint8Array(1+o.length+r.length);a[0]=o.length,a.set(o,1),a.set(r,1+o.length);const{key:i,token:p}=await c();return{token:p,encrypted:await s(i,a.buffer)}}window.fetch=async function(...t){const[n,r]=t;if(r&&r.method&&"POST"===r.method.toUpperCase()&&r.body&&"string"==typeof r.body&&!n.toString().includes("/goga/api/v1/key")){if(e(n.toString()))return o(...t);try{const t=r.headers&&(r.headers["Content-Type"]||r.headers["content-type"])||"application/json",e=await p(r.body,t),a={...r};return a.body=JSON.stringify(e),a.headers={...a.headers,"Content-Type":"application/json;charset=UTF-8"},o(n,a)}catch(e){return o(...t)}}return o(...t)},XMLHttpRequest.prototype.open=function(t,e,...n){return this._goga_method=t,this._goga_url=e,this._goga_headers={},r.apply(this,[t,e,...n])},XMLHttpRequest.prototype.setRequestHeader=function(t,e){return this._goga_headers[t.toLowerCase()]=e,i.apply(this,arguments)},XMLHttpRequest.prototype.send=function(t){const n=this,o=n._goga_url;if(!(n._goga_method&&"POST"===n._goga_method.toUpperCase()&&t&&"string"==typeof t&&!o.toString().includes("/goga/api/v1/key")))return a.apply(n,arguments);e(o.toString())?a.apply(n,arguments):async function(){try{const e=n._goga_headers["content-type"]||"application/json",o=await p(t,e),r=JSON.stringify(o);i.call(n,"Content-Type","application/json;charset=UTF-8"),a.call(n,r)}catch(t){a.apply(n,arguments)}}()},document.addEventListener("DOMContentLoaded",()=>{c().catch(t=>{})})}();
\ No newline at end of file
+!function(){"use strict";function t(t){const e=[];for(const n of t||[])try{e.push(new RegExp(n))}catch(t){}return e}const e=function(){const t=document.getElementById("goga-config");if(!t)return{};try{return JSON.parse(t.textContent)||{}}catch(t){return{}}}(),l=window.gogaCryptoConfig||{},u={keyEndpoint:e.keyEndpoint||"/goga/api/v1/key",methods:(e.methods||["POST"]).map(t=>t.toUpperCase()),excludeUrls:t(e.excludeUrls).concat(l.excludeUrls||[]),includeUrls:t(e.includeUrls).concat(l.includeUrls||[]),mustEncryptRoutes:t(e.mustEncryptRoutes)};function d(t,e){let n=t;try{n=new URL(t,window.location.href).pathname}catch(t){}for(const o of e){if("string"==typeof o&&t.includes(o))return!0;if(o instanceof RegExp&&(o.test(n)||o.test(t)))return!0}return!1}function f(t,e){return!(!t||!u.methods.includes(t.toUpperCase()))&&!e.includes(u.keyEndpoint)&&(!!d(e,u.mustEncryptRoutes)||!d(e,u.excludeUrls)&&!(u.includeUrls.length>0&&!d(e,u.includeUrls)))}if(!window.crypto||!window.crypto.subtle)return;let n={key:null,token:null,expires:0};const o=window.fetch,r=XMLHttpRequest.prototype.open,a=XMLHttpRequest.prototype.send,i=XMLHttpRequest.prototype.setRequestHeader;async function s(t,e){const n=function(t){const e=window.atob(t),n=e.length,o=new Uint8Array(n);for(let t=0;t<n;t++)o[t]=e.charCodeAt(t);return o.buffer}(t),o=await window.crypto.subtle.importKey("raw",n,{name:"AES-GCM"},!1,["encrypt"]),r=window.crypto.getRandomValues(new Uint8Array(12)),a=await window.crypto.subtle.encrypt({name:"AES-GCM",iv:r},o,e),i=new Uint8Array(r.length+a.byteLength);return i.set(r,0),i.set(new Uint8Array(a),r.length),function(t){let e="";const n=new Uint8Array(t),o=n.byteLength;for(let t=0;t<o;t++)e+=String.fromCharCode(n[t]);return window.btoa(e)}(i.buffer)}async function c(){const t=Date.now();if(n.key&&n.token&&t<n.expires)return n;const e=await o(u.keyEndpoint);if(!e.ok)throw n={key:null,token:null,expires:0},new Error("goganokey");const{key:r,token:a,ttl:i}=await e.json(),s=1e3*i*.8||24e4;return n={key:r,token:a,expires:Date.now()+s},n}async function p(t,e){const n=new TextEncoder,o=n.encode(e),r=n.encode(t);if(o.length>255)throw new Error("Content-Type header is too long (max 255 bytes).");const a=new Uint8Array(1+o.length+r.length);a[0]=o.length,a.set(o,1),a.set(r,1+o.length);const{key:i,token:p}=await c();return{token:p,encrypted:await s(i,a.buffer)}}window.fetch=async function(...t){const[e,n]=t,r=n&&n.method||"GET";if(n&&n.body&&"string"==typeof n.body&&f(r,e.toString()))try{const t=n.headers&&(n.headers["Content-Type"]||n.headers["content-type"])||"application/json",r=await p(n.body,t),a={...n};return a.body=JSON.stringify(r),a.headers={...a.headers,"Content-Type":"application/json;charset=UTF-8"},o(e,a)}catch(e){return o(...t)}return o(...t)},XMLHttpRequest.prototype.open=function(t,e,...n){return this._goga_method=t,this._goga_url=e,this._goga_headers={},r.apply(this,[t,e,...n])},XMLHttpRequest.prototype.setRequestHeader=function(t,e){return this._goga_headers[t.toLowerCase()]=e,i.apply(this,arguments)},XMLHttpRequest.prototype.send=function(t){const e=this,n=e._goga_url;if(!(t&&"string"==typeof t&&f(e._goga_method,n.toString())))return a.apply(e,arguments);(async function(){try{const n=e._goga_headers["content-type"]||"application/json",o=await p(t,n),r=JSON.stringify(o);i.call(e,"Content-Type","application/json;charset=UTF-8"),a.call(e,r)}catch(t){a.apply(e,arguments)}})()},document.addEventListener("DOMContentLoaded",()=>{c().catch(t=>{})})}();
\ No newline at end of file
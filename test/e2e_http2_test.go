@@ -0,0 +1,104 @@
+package test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"goga/configs"
+	"goga/internal/crypto"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newH2CClient 创建一个只使用 HTTP/2 明文 (prior knowledge) 的客户端。
+func newH2CClient() *http.Client {
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Client{Transport: &http.Transport{Protocols: protocols}}
+}
+
+// TestEncryptionFlowOverHTTP2 验证客户端到网关、网关到后端都使用 HTTP/2 明文时，脚本注入和请求解密仍然正常。
+func TestEncryptionFlowOverHTTP2(t *testing.T) {
+	backend := StartMockH2CBackendServer()
+	defer backend.StopFunc()
+
+	cfg := &configs.Config{
+		Server: configs.ServerConfig{
+			Port: "0",
+			H2C:  true,
+		},
+		Upstream: configs.UpstreamConfig{
+			Backends: []configs.BackendConfig{{Name: "app", URL: backend.URL, Protocol: "h2c"}},
+		},
+		Encryption: configs.EncryptionConfig{
+			Enabled: true,
+		},
+		KeyCache: configs.KeyCacheConfig{
+			Type:       "in-memory",
+			TTLSeconds: 300,
+		},
+		ScriptInjection: configs.ScriptInjectionConfig{
+			ScriptContent: `<script src="/goga.min.js" defer></script>`,
+		},
+	}
+
+	goga, err := StartGoGaServer(cfg)
+	require.NoError(t, err, "启动 goga 服务器失败")
+	defer goga.StopFunc()
+
+	client := newH2CClient()
+
+	t.Run("HTTP/2 响应中注入脚本", func(t *testing.T) {
+		resp, err := client.Get(goga.URL + "/some-html")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, 2, resp.ProtoMajor)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Contains(t, string(body), `<script src="/goga.min.js" defer></script></body>`)
+	})
+
+	t.Run("HTTP/2 请求体被解密后以 HTTP/2 转发给后端", func(t *testing.T) {
+		resp, err := client.Get(goga.URL + "/goga/api/v1/key")
+		require.NoError(t, err)
+		var keyResp struct {
+			Key   string `json:"key"`
+			Token string `json:"token"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&keyResp))
+		resp.Body.Close()
+
+		aesKey, err := base64.StdEncoding.DecodeString(keyResp.Key)
+		require.NoError(t, err)
+		contentType := "application/json"
+		plaintext := append([]byte{byte(len(contentType))}, contentType...)
+		plaintext = append(plaintext, `{"username":"admin","password":"password"}`...)
+		encrypted, err := crypto.EncryptAES256GCM(aesKey, plaintext)
+		require.NoError(t, err)
+		payload, err := json.Marshal(struct {
+			Token     string `json:"token"`
+			Encrypted string `json:"encrypted"`
+		}{keyResp.Token, base64.StdEncoding.EncodeToString(encrypted)})
+		require.NoError(t, err)
+
+		// 不设置 Content-Length，请求体以 HTTP/2 DATA 帧流式发送
+		req, err := http.NewRequest(http.MethodPost, goga.URL+"/api/login", io.MultiReader(bytes.NewReader(payload)))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err = client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		backend.LastRequest.RLock()
+		defer backend.LastRequest.RUnlock()
+		assert.Equal(t, "HTTP/2.0", backend.LastRequest.Proto)
+		assert.Equal(t, "application/json", backend.LastRequest.Header.Get("Content-Type"))
+		assert.JSONEq(t, `{"username":"admin","password":"password"}`, string(backend.LastRequest.Body))
+	})
+}
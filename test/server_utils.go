@@ -9,6 +9,7 @@ import (
 	"goga/internal/gateway"
 	"goga/internal/middleware"
 	"goga/internal/security"
+	goserver "goga/internal/server"
 	"io"
 	"log/slog"
 	"net"
@@ -65,9 +66,15 @@ func StartGoGaServer(cfg *configs.Config) (*GoGaTestServer, error) {
 		return nil, fmt.Errorf("创建 API 路由失败: %w", err)
 	}
 
-	// 2. 创建反向代理处理器
-	proxyHandler, err := gateway.NewProxy(cfg)
+	// 2. 构建路由表并创建反向代理处理器 (解密中间件按路由在代理内部应用)
+	routes, err := gateway.NewRouteTable(cfg)
 	if err != nil {
+		keyCacher.Stop()
+		return nil, fmt.Errorf("构建路由表失败: %w", err)
+	}
+	proxyHandler, err := gateway.NewProxy(cfg, routes, keyCacher)
+	if err != nil {
+		routes.Stop()
 		keyCacher.Stop()
 		return nil, fmt.Errorf("创建反向代理失败: %w", err)
 	}
@@ -76,32 +83,34 @@ func StartGoGaServer(cfg *configs.Config) (*GoGaTestServer, error) {
 	mainMux := http.NewServeMux()
 	mainMux.Handle("/goga/", apiRouter)
 	mainMux.Handle("/goga.min.js", apiRouter)
+	mainMux.Handle(gateway.UpstreamStatusPath, gateway.NewUpstreamStatusHandler(routes))
 	mainMux.Handle("/", proxyHandler)
 
 	// 4. 应用中间件
 	var coreHandler http.Handler = mainMux
-	if cfg.Encryption.Enabled {
-		decryptionHandler := middleware.DecryptionMiddleware(keyCacher, cfg.Encryption)
-		coreHandler = decryptionHandler(coreHandler)
-	}
-
 	handler := middleware.Recovery(middleware.SecurityHeadersMiddleware(middleware.Logging(middleware.HealthCheck(coreHandler))))
 	
-	// 5. 包裹 WebSocket 代理
-	wsHandler := gateway.NewWebsocketProxy(handler, cfg)
+	// 5. 包裹 WebSocket 代理，并在最外层解析真实客户端 IP
+	wsHandler := gateway.NewWebsocketProxy(handler, cfg, routes)
+	trustedProxies, err := middleware.NewTrustedProxies(cfg.Server.TrustedProxies)
+	if err != nil {
+		routes.Stop()
+		keyCacher.Stop()
+		return nil, fmt.Errorf("解析受信任的代理列表失败: %w", err)
+	}
+	rootHandler := middleware.ClientIP(trustedProxies)(wsHandler)
 
 	// --- 服务器创建和启动 ---
 	// 为测试服务器使用一个随机的空闲端口
 	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
+		routes.Stop()
 		keyCacher.Stop()
 		return nil, fmt.Errorf("查找空闲端口失败: %w", err)
 	}
 
-	server := &http.Server{
-		Handler: wsHandler, // 使用最终的处理器链
-		Addr:    listener.Addr().String(),
-	}
+	server := goserver.NewHTTPServer(goserver.Listeners(cfg.Server)[0], rootHandler) // 使用最终的处理器链
+	server.Addr = listener.Addr().String()
 
 	// 用于在服务器就绪时发出信号的通道
 	serverReady := make(chan error, 1)
@@ -119,6 +128,7 @@ func StartGoGaServer(cfg *configs.Config) (*GoGaTestServer, error) {
 	// 在实际测试中，您可能需要一个更健壮的就绪检查（例如，访问 /health 接口）
 	select {
 	case err := <-serverReady:
+		routes.Stop()
 		keyCacher.Stop()
 		return nil, err
 	case <-time.After(200 * time.Millisecond): // 给服务器一个短暂的启动时间
@@ -136,6 +146,7 @@ func StartGoGaServer(cfg *configs.Config) (*GoGaTestServer, error) {
 		if err := server.Shutdown(ctx); err != nil {
 			slog.Error("关闭 goga 测试服务器失败", "error", err)
 		}
+		routes.Stop()    // 停止上游健康检查
 		keyCacher.Stop() // 确保缓存器停止
 		<-serverReady    // 等待服务器 goroutine 结束
 	}
@@ -180,6 +191,7 @@ type MockBackendServer struct {
 // 这用于测试断言。
 type LastRequestInfo struct {
 	sync.RWMutex
+	Proto  string
 	Header http.Header
 	Body   []byte
 }
@@ -187,6 +199,16 @@ type LastRequestInfo struct {
 // StartMockBackendServer 启动一个简单的模拟后端服务器用于测试。
 // 它处理 JSON 和表单编码的 /api/login 请求，并提供静态文件。
 func StartMockBackendServer() *MockBackendServer {
+	return startMockBackendServer(false)
+}
+
+// StartMockH2CBackendServer 与 StartMockBackendServer 相同，但同时接受 HTTP/2 明文 (h2c) 连接。
+func StartMockH2CBackendServer() *MockBackendServer {
+	return startMockBackendServer(true)
+}
+
+// startMockBackendServer 启动模拟后端服务器，h2c 为 true 时接受 HTTP/2 明文连接。
+func startMockBackendServer(h2c bool) *MockBackendServer {
 	lastRequest := &LastRequestInfo{}
 	mux := http.NewServeMux()
 
@@ -203,7 +225,13 @@ func StartMockBackendServer() *MockBackendServer {
 		fmt.Fprintln(w, "这是一个纯文本内容。")
 	})
 
-	server := httptest.NewServer(loggingMiddleware(mux)) // 使用与 test-backend 相同的日志中间件
+	server := httptest.NewUnstartedServer(loggingMiddleware(mux)) // 使用与 test-backend 相同的日志中间件
+	if h2c {
+		server.Config.Protocols = new(http.Protocols)
+		server.Config.Protocols.SetHTTP1(true)
+		server.Config.Protocols.SetUnencryptedHTTP2(true)
+	}
+	server.Start()
 
 	return &MockBackendServer{
 		URL:         server.URL,
@@ -220,6 +248,7 @@ func mockLoginHandler(w http.ResponseWriter, r *http.Request, lastRequest *LastR
 	r.Body = io.NopCloser(bytes.NewReader(body)) // 恢复请求体以便解析
 
 	lastRequest.Lock()
+	lastRequest.Proto = r.Proto
 	lastRequest.Header = r.Header.Clone()
 	lastRequest.Body = body
 	lastRequest.Unlock()